@@ -18,13 +18,17 @@ import (
 	"io/ioutil"
 	"net/http"
 	"net/url"
+	"os"
+	"path/filepath"
 	"reflect"
 	"sort"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/nats-io/jwt"
+	"github.com/nats-io/nkeys"
 )
 
 // For backwards compatibility with NATS < 2.0, users who are not explicitly defined into an
@@ -34,6 +38,10 @@ const globalAccountName = "$G"
 // Account are subject namespace definitions. By default no messages are shared between accounts.
 // You can share via Exports and Imports of Streams and Services.
 type Account struct {
+	// Here first because of use of atomics, and memory alignment.
+	// Only traffic from and to clients and leafnodes is accounted for,
+	// since routes and gateways carry messages of all accounts.
+	stats
 	Name       string
 	Nkey       string
 	Issuer     string
@@ -58,6 +66,15 @@ type Account struct {
 	pruning     bool
 	expired     bool
 	signingKeys []string
+	revoked     map[string]int64 // user revocations from the account JWT
+	mappings    []*mapping
+	mapped      int32
+	qstrats     map[string]*QueueStrategy
+	qstrat      int32
+	qlocality   QueueLocality
+	dedupe      *msgDedupe
+	rlim        *rateLimiter
+	rlimited    int32
 	srv         *Server // server this account is registered with (possibly nil)
 }
 
@@ -69,6 +86,7 @@ type limits struct {
 	mleafs   int32
 	maxnae   int32
 	maxaettl time.Duration
+	msched   int32
 }
 
 // Used to track remote clients and leafnodes per remote server.
@@ -102,6 +120,7 @@ type serviceImport struct {
 type exportAuth struct {
 	tokenReq bool
 	approved map[string]*Account
+	revoked  map[string]int64 // activation revocations from the account JWT
 }
 
 // importMap tracks the imported streams and services.
@@ -121,7 +140,7 @@ func NewAccount(name string) *Account {
 	a := &Account{
 		Name:   name,
 		sl:     NewSublist(),
-		limits: limits{-1, -1, -1, -1, 0, 0},
+		limits: limits{-1, -1, -1, -1, 0, 0, DEFAULT_MAX_SCHEDULED_MSGS},
 	}
 	return a
 }
@@ -134,6 +153,12 @@ func (a *Account) shallowCopy() *Account {
 	na.Issuer = a.Issuer
 	na.imports = a.imports
 	na.exports = a.exports
+	na.setMappings(a.mappings)
+	na.setQueueStrategies(a.qstrats)
+	na.qlocality = a.qlocality
+	na.dedupe = a.dedupe
+	na.setRateLimiter(a.rlim)
+	na.msched = a.msched
 	return na
 }
 
@@ -664,7 +689,10 @@ func (a *Account) streamActivationExpired(subject string) {
 	}
 	a.mu.RUnlock()
 
-	if si.acc.checkActivation(a, si.claim, false) {
+	si.acc.mu.RLock()
+	ok := si.acc.checkActivation(a, si.claim, false)
+	si.acc.mu.RUnlock()
+	if ok {
 		// The token has been updated most likely and we are good to go.
 		return
 	}
@@ -696,7 +724,10 @@ func (a *Account) serviceActivationExpired(subject string) {
 	}
 	a.mu.RUnlock()
 
-	if si.acc.checkActivation(a, si.claim, false) {
+	si.acc.mu.RLock()
+	ok := si.acc.checkActivation(a, si.claim, false)
+	si.acc.mu.RUnlock()
+	if ok {
 		// The token has been updated most likely and we are good to go.
 		return
 	}
@@ -718,6 +749,7 @@ func (a *Account) activationExpired(subject string, kind jwt.ExportType) {
 }
 
 // checkActivation will check the activation token for validity.
+// Lock should be held.
 func (a *Account) checkActivation(acc *Account, claim *jwt.Import, expTimer bool) bool {
 	if claim == nil || claim.Token == "" {
 		return false
@@ -746,6 +778,9 @@ func (a *Account) checkActivation(acc *Account, claim *jwt.Import, expTimer bool
 	if !a.isIssuerClaimTrusted(act) {
 		return false
 	}
+	if a.isActivationRevoked(act, claim.Type) {
+		return false
+	}
 	if act.Expires != 0 {
 		tn := time.Now().Unix()
 		if act.Expires <= tn {
@@ -762,6 +797,35 @@ func (a *Account) checkActivation(acc *Account, claim *jwt.Import, expTimer bool
 	return true
 }
 
+// Returns true if the activation was revoked by the export it is for.
+// Lock should be held.
+func (a *Account) isActivationRevoked(act *jwt.ActivationClaims, kind jwt.ExportType) bool {
+	m := a.exports.streams
+	if kind == jwt.Service {
+		m = a.exports.services
+	}
+	subject := string(act.ImportSubject)
+	ea, ok := m[subject]
+	if !ok {
+		tokens := strings.Split(subject, tsep)
+		for subj, sea := range m {
+			if isSubsetMatch(tokens, subj) {
+				ea = sea
+				break
+			}
+		}
+	}
+	return ea != nil && isRevoked(ea.revoked, act.Subject, act.IssuedAt)
+}
+
+// checkUserRevoked returns true if the user JWT issued at the given time
+// to the user with the given public key has been revoked.
+func (a *Account) checkUserRevoked(nkey string, issuedAt int64) bool {
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+	return isRevoked(a.revoked, nkey, issuedAt)
+}
+
 // Returns true if the activation claim is trusted. That is the issuer matches
 // the account or is an entry in the signing keys.
 func (a *Account) isIssuerClaimTrusted(claims *jwt.ActivationClaims) bool {
@@ -967,6 +1031,45 @@ func (s *Server) updateAccountClaims(a *Account, ac *jwt.AccountClaims) {
 	s.Debugf("Updating account claims: %s", a.Name)
 	a.checkExpiration(ac.Claims())
 
+	// Revocations are only known if these claims are the account's JWT.
+	revs := decodeAccountRevocations(a.claimJWT, ac.ID)
+
+	// Same for subject mappings.
+	var mappings []*mapping
+	if ms := decodeAccountMappings(a.claimJWT, ac.ID); ms != nil {
+		for src, dests := range ms.Nats.Mappings {
+			// A single destination without weight gets all messages.
+			if len(dests) == 1 && dests[0].Weight == 0 {
+				dests[0].Weight = 100
+			}
+			m, err := newMapping(src, dests)
+			if err != nil {
+				s.Debugf("Error adding mapping to account [%s]: %v", a.Name, err)
+				continue
+			}
+			mappings = append(mappings, m)
+		}
+		sort.Slice(mappings, func(i, j int) bool { return mappings[i].src < mappings[j].src })
+	}
+
+	// And rate limits.
+	var rlimits []*RateLimit
+	if rls := decodeAccountRateLimits(a.claimJWT, ac.ID); rls != nil {
+		if l := rls.Nats.Limits; l.MsgsPerSec > 0 || l.BytesPerSec > 0 {
+			rlimits = append(rlimits, &RateLimit{MsgsPerSec: l.MsgsPerSec, BytesPerSec: l.BytesPerSec})
+		}
+		for _, rl := range rls.Nats.RateLimits {
+			if rl == nil {
+				continue
+			}
+			if err := rl.validate(); err != nil {
+				s.Debugf("Error adding rate limit to account [%s]: %v", a.Name, err)
+				continue
+			}
+			rlimits = append(rlimits, rl)
+		}
+	}
+
 	a.mu.Lock()
 	// Clone to update, only select certain fields.
 	old := &Account{Name: a.Name, imports: a.imports, exports: a.exports, limits: a.limits, signingKeys: a.signingKeys}
@@ -1043,6 +1146,20 @@ func (s *Server) updateAccountClaims(a *Account, ac *jwt.AccountClaims) {
 			}
 		}
 	}
+	// Set the activation revocations of the exports requiring a token.
+	if revs != nil {
+		a.mu.Lock()
+		for _, e := range revs.Nats.Exports {
+			m := a.exports.streams
+			if e.Type == jwt.Service {
+				m = a.exports.services
+			}
+			if ea := m[e.Subject]; ea != nil && len(e.Revocations) > 0 {
+				ea.revoked = e.Revocations
+			}
+		}
+		a.mu.Unlock()
+	}
 	// Now let's apply any needed changes from import/export changes.
 	if !a.checkStreamImportsEqual(old) {
 		awcsti := map[string]struct{}{a.Name: {}}
@@ -1098,6 +1215,12 @@ func (s *Server) updateAccountClaims(a *Account, ac *jwt.AccountClaims) {
 	a.mpay = int32(ac.Limits.Payload)
 	a.mconns = int32(ac.Limits.Conn)
 	a.mleafs = int32(ac.Limits.LeafNodeConn)
+	a.revoked = nil
+	if revs != nil {
+		a.revoked = revs.Nats.Revocations
+	}
+	a.setMappings(mappings)
+	a.setRateLimiter(newRateLimiter(rlimits))
 	a.mu.Unlock()
 
 	clients := gatherClients()
@@ -1128,12 +1251,38 @@ func (s *Server) updateAccountClaims(a *Account, ac *jwt.AccountClaims) {
 			}
 		}
 	}
+
+	// Disconnect users whose JWT has been revoked. This is done from a go
+	// routine since the server lock may be held.
+	if revs != nil && len(revs.Nats.Revocations) > 0 {
+		var revoked []*client
+		for _, c := range clients {
+			c.mu.Lock()
+			ujwt := c.opts.JWT
+			c.mu.Unlock()
+			if ujwt == _EMPTY_ {
+				continue
+			}
+			if uc, err := jwt.DecodeUserClaims(ujwt); err == nil && isRevoked(revs.Nats.Revocations, uc.Subject, uc.IssuedAt) {
+				revoked = append(revoked, c)
+			}
+		}
+		if len(revoked) > 0 {
+			go func() {
+				for _, c := range revoked {
+					c.authRevoked()
+				}
+			}()
+		}
+	}
 }
 
-// Helper to build an internal account structure from a jwt.AccountClaims.
-func (s *Server) buildInternalAccount(ac *jwt.AccountClaims) *Account {
+// Helper to build an internal account structure from a jwt.AccountClaims
+// and the JWT it was decoded from.
+func (s *Server) buildInternalAccount(ac *jwt.AccountClaims, claimJWT string) *Account {
 	acc := NewAccount(ac.Subject)
 	acc.Issuer = ac.Issuer
+	acc.claimJWT = claimJWT
 	s.updateAccountClaims(acc, ac)
 	return acc
 }
@@ -1241,3 +1390,295 @@ func (ur *URLAccResolver) Fetch(name string) (string, error) {
 func (ur *URLAccResolver) Store(name, jwt string) error {
 	return fmt.Errorf("Store operation not supported for URL Resolver")
 }
+
+// DirAccResolver implements a resolver storing account JWTs in a directory,
+// one file per account. JWTs pushed to a server through the system account
+// are replicated to all servers of the system, and a server missing a JWT
+// asks the other servers for it.
+type DirAccResolver struct {
+	mu  sync.Mutex
+	dir string
+}
+
+// NewDirAccResolver returns a new resolver storing JWTs in the given
+// directory, which is created if needed.
+func NewDirAccResolver(dir string) (*DirAccResolver, error) {
+	if dir == _EMPTY_ {
+		return nil, fmt.Errorf("directory for account resolver is missing")
+	}
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, fmt.Errorf("could not create directory %q: %v", dir, err)
+	}
+	return &DirAccResolver{dir: dir}, nil
+}
+
+// Returns the file holding the JWT of the given account. Names that are not
+// account public keys are rejected so that they can't be used as a path.
+func (dr *DirAccResolver) jwtFile(name string) (string, error) {
+	if !nkeys.IsValidPublicAccountKey(name) {
+		return _EMPTY_, ErrMissingAccount
+	}
+	return filepath.Join(dr.dir, name+".jwt"), nil
+}
+
+// Fetch will fetch the account jwt claims from the account's file.
+func (dr *DirAccResolver) Fetch(name string) (string, error) {
+	fn, err := dr.jwtFile(name)
+	if err != nil {
+		return _EMPTY_, err
+	}
+	dr.mu.Lock()
+	defer dr.mu.Unlock()
+	b, err := ioutil.ReadFile(fn)
+	if os.IsNotExist(err) {
+		return _EMPTY_, ErrMissingAccount
+	} else if err != nil {
+		return _EMPTY_, err
+	}
+	return string(b), nil
+}
+
+// Store will store the account jwt claims in the account's file. A stored
+// JWT is never replaced by one that was issued earlier.
+func (dr *DirAccResolver) Store(name, jwt string) error {
+	fn, err := dr.jwtFile(name)
+	if err != nil {
+		return err
+	}
+	dr.mu.Lock()
+	defer dr.mu.Unlock()
+	if b, err := ioutil.ReadFile(fn); err == nil {
+		if string(b) == jwt {
+			return nil
+		}
+		if isNewerClaim(string(b), jwt) {
+			return ErrAccountResolverOlderClaims
+		}
+	}
+	// Write to a temporary file first so that the JWT is replaced atomically.
+	tmp := fn + ".tmp"
+	if err := ioutil.WriteFile(tmp, []byte(jwt), 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, fn)
+}
+
+// Returns true if the current JWT was issued after the update.
+func isNewerClaim(current, update string) bool {
+	cc, err := jwt.DecodeGeneric(current)
+	if err != nil {
+		return false
+	}
+	uc, err := jwt.DecodeGeneric(update)
+	if err != nil {
+		return false
+	}
+	return cc.IssuedAt > uc.IssuedAt
+}
+
+// CacheAccResolver caches the account jwt claims fetched through another
+// resolver. Cached claims are served past their TTL while being fetched
+// again in the background, and are kept if that fetch fails, so that
+// accounts can still be resolved while the other resolver is unavailable.
+// Fetch errors are cached too, for a shorter time.
+type CacheAccResolver struct {
+	// Here first because of use of atomics, and memory alignment.
+	hits        uint64
+	staleHits   uint64
+	misses      uint64
+	fetchErrors uint64
+
+	mu      sync.Mutex
+	ar      AccountResolver
+	ttl     time.Duration
+	negTTL  time.Duration
+	refresh time.Duration
+	entries map[string]*accJWTEntry
+}
+
+// A cached result of a fetch from the underlying resolver.
+type accJWTEntry struct {
+	jwt        string
+	err        error
+	fetched    time.Time
+	failed     time.Time
+	refreshing bool
+}
+
+// CacheAccResolverStats are the statistics of a CacheAccResolver.
+type CacheAccResolverStats struct {
+	Hits        uint64 `json:"hits"`
+	StaleHits   uint64 `json:"stale_hits"`
+	Misses      uint64 `json:"misses"`
+	FetchErrors uint64 `json:"fetch_errors"`
+	Entries     int    `json:"entries"`
+}
+
+// NewCacheAccResolver returns a resolver caching the claims fetched through
+// the given resolver. Claims are fetched again after ttl, and fetch errors
+// are cached for negTTL. The claims of active accounts are fetched every
+// refresh interval. Defaults are used for zero durations.
+func NewCacheAccResolver(ar AccountResolver, ttl, negTTL, refresh time.Duration) *CacheAccResolver {
+	if ttl <= 0 {
+		ttl = DEFAULT_ACCOUNT_RESOLVER_CACHE_TTL
+	}
+	if negTTL <= 0 {
+		negTTL = DEFAULT_ACCOUNT_RESOLVER_CACHE_NEGATIVE_TTL
+	}
+	if refresh <= 0 {
+		refresh = DEFAULT_ACCOUNT_RESOLVER_REFRESH
+	}
+	return &CacheAccResolver{
+		ar:      ar,
+		ttl:     ttl,
+		negTTL:  negTTL,
+		refresh: refresh,
+		entries: make(map[string]*accJWTEntry),
+	}
+}
+
+// Fetch will return the cached account jwt claims, fetching them through
+// the underlying resolver if not cached.
+func (cr *CacheAccResolver) Fetch(name string) (string, error) {
+	cr.mu.Lock()
+	if e := cr.entries[name]; e != nil {
+		if e.err == nil {
+			if time.Since(e.fetched) < cr.ttl {
+				cr.mu.Unlock()
+				atomic.AddUint64(&cr.hits, 1)
+				return e.jwt, nil
+			}
+			// Serve the stale claims while fetching them again, unless
+			// the last attempt failed recently.
+			if !e.refreshing && time.Since(e.failed) >= cr.negTTL {
+				e.refreshing = true
+				go cr.fetch(name)
+			}
+			cr.mu.Unlock()
+			atomic.AddUint64(&cr.staleHits, 1)
+			return e.jwt, nil
+		}
+		if time.Since(e.fetched) < cr.negTTL {
+			cr.mu.Unlock()
+			atomic.AddUint64(&cr.hits, 1)
+			return _EMPTY_, e.err
+		}
+	}
+	cr.mu.Unlock()
+	atomic.AddUint64(&cr.misses, 1)
+	return cr.fetch(name)
+}
+
+// Fetches the claims through the underlying resolver and caches the result.
+// On error, previously fetched claims are kept.
+func (cr *CacheAccResolver) fetch(name string) (string, error) {
+	claimJWT, err := cr.ar.Fetch(name)
+	cr.mu.Lock()
+	defer cr.mu.Unlock()
+	now := time.Now()
+	if err != nil {
+		atomic.AddUint64(&cr.fetchErrors, 1)
+		if e := cr.entries[name]; e != nil && e.err == nil {
+			e.failed = now
+			e.refreshing = false
+		} else {
+			cr.entries[name] = &accJWTEntry{err: err, fetched: now}
+		}
+		return _EMPTY_, err
+	}
+	cr.entries[name] = &accJWTEntry{jwt: claimJWT, fetched: now}
+	return claimJWT, nil
+}
+
+// Store will store the account jwt claims through the underlying resolver,
+// and cache them if successful.
+func (cr *CacheAccResolver) Store(name, jwt string) error {
+	if err := cr.ar.Store(name, jwt); err != nil {
+		return err
+	}
+	cr.mu.Lock()
+	cr.entries[name] = &accJWTEntry{jwt: jwt, fetched: time.Now()}
+	cr.mu.Unlock()
+	return nil
+}
+
+// Removes the cached fetch errors that have expired.
+func (cr *CacheAccResolver) prune() {
+	cr.mu.Lock()
+	defer cr.mu.Unlock()
+	for name, e := range cr.entries {
+		if e.err != nil && time.Since(e.fetched) >= cr.negTTL {
+			delete(cr.entries, name)
+		}
+	}
+}
+
+// Stats returns the statistics of the cache.
+func (cr *CacheAccResolver) Stats() *CacheAccResolverStats {
+	cr.mu.Lock()
+	entries := len(cr.entries)
+	cr.mu.Unlock()
+	return &CacheAccResolverStats{
+		Hits:        atomic.LoadUint64(&cr.hits),
+		StaleHits:   atomic.LoadUint64(&cr.staleHits),
+		Misses:      atomic.LoadUint64(&cr.misses),
+		FetchErrors: atomic.LoadUint64(&cr.fetchErrors),
+		Entries:     entries,
+	}
+}
+
+// Starts the go routine refreshing the claims of the accounts with local
+// connections, so that they stay cached and changes are applied even
+// though those accounts are not looked up again.
+func (s *Server) startAccountResolverRefresh(cr *CacheAccResolver) {
+	s.startGoRoutine(func() {
+		defer s.grWG.Done()
+
+		t := time.NewTicker(cr.refresh)
+		defer t.Stop()
+		for {
+			select {
+			case <-t.C:
+				s.refreshActiveAccounts(cr)
+			case <-s.quitCh:
+				return
+			}
+		}
+	})
+}
+
+// Fetches the claims of the accounts with local connections through the
+// cache's resolver and applies the ones that changed.
+func (s *Server) refreshActiveAccounts(cr *CacheAccResolver) {
+	cr.prune()
+
+	var accs []*Account
+	s.mu.Lock()
+	s.accounts.Range(func(_, v interface{}) bool {
+		acc := v.(*Account)
+		acc.mu.RLock()
+		active := acc.numLocalConnections()+acc.numLocalLeafNodes() > 0
+		acc.mu.RUnlock()
+		if acc.claimJWT != _EMPTY_ && active {
+			accs = append(accs, acc)
+		}
+		return true
+	})
+	s.mu.Unlock()
+
+	for _, acc := range accs {
+		claimJWT, err := cr.fetch(acc.Name)
+		if err != nil {
+			s.Debugf("Account refresh for [%s] failed: %v", acc.Name, err)
+			continue
+		}
+		s.mu.Lock()
+		// Claims may have been updated by other means in the meantime.
+		if claimJWT != acc.claimJWT && !isNewerClaim(acc.claimJWT, claimJWT) {
+			if err := s.updateAccountWithClaimJWT(acc, claimJWT); err != nil {
+				s.Debugf("Account refresh for [%s] not applied: %v", acc.Name, err)
+			}
+		}
+		s.mu.Unlock()
+	}
+}
@@ -18,6 +18,7 @@ import (
 	"encoding/base64"
 	"net"
 	"strings"
+	"time"
 
 	"github.com/nats-io/jwt"
 	"github.com/nats-io/nkeys"
@@ -48,6 +49,7 @@ type NkeyUser struct {
 	Permissions *Permissions `json:"permissions,omitempty"`
 	Account     *Account     `json:"account,omitempty"`
 	SigningKey  string       `json:"signing_key,omitempty"`
+	RateLimits  []*RateLimit `json:"rate_limits,omitempty"`
 }
 
 // User is for multiple accounts/users.
@@ -56,6 +58,7 @@ type User struct {
 	Password    string       `json:"password"`
 	Permissions *Permissions `json:"permissions,omitempty"`
 	Account     *Account     `json:"account,omitempty"`
+	RateLimits  []*RateLimit `json:"rate_limits,omitempty"`
 }
 
 // clone performs a deep copy of the User struct, returning a new clone with
@@ -92,8 +95,17 @@ type SubjectPermission struct {
 // Permissions are the allowed subjects on a per
 // publish or subscribe basis.
 type Permissions struct {
-	Publish   *SubjectPermission `json:"publish"`
-	Subscribe *SubjectPermission `json:"subscribe"`
+	Publish   *SubjectPermission  `json:"publish"`
+	Subscribe *SubjectPermission  `json:"subscribe"`
+	Response  *ResponsePermission `json:"responses,omitempty"`
+}
+
+// ResponsePermission allows publishing to the reply subjects of the
+// messages delivered to a client, for up to MaxMsgs responses and
+// until Expires has elapsed since the delivery.
+type ResponsePermission struct {
+	MaxMsgs int           `json:"max"`
+	Expires time.Duration `json:"ttl"`
 }
 
 // RoutePermissions are similar to user permissions
@@ -134,9 +146,45 @@ func (p *Permissions) clone() *Permissions {
 	if p.Subscribe != nil {
 		clone.Subscribe = p.Subscribe.clone()
 	}
+	if p.Response != nil {
+		clone.Response = &ResponsePermission{
+			MaxMsgs: p.Response.MaxMsgs,
+			Expires: p.Response.Expires,
+		}
+	}
 	return clone
 }
 
+// splitPermSubjectQueue splits a permission subject, which for subscriptions
+// may be followed by the name of a queue group, e.g. "orders.* workers".
+func splitPermSubjectQueue(sq string) (string, string) {
+	if fields := strings.Fields(sq); len(fields) == 2 {
+		return fields[0], fields[1]
+	}
+	return sq, _EMPTY_
+}
+
+// validateUserClaims validates the user claims. The jwt library does not
+// know about queue groups in subscribe permissions, so these are checked
+// here and only the subjects are left for the claims validation.
+func validateUserClaims(juc *jwt.UserClaims, vr *jwt.ValidationResults) {
+	subjects := func(sl jwt.StringList) jwt.StringList {
+		var subjects jwt.StringList
+		for _, sq := range sl {
+			if len(strings.Fields(sq)) > 2 {
+				vr.AddError("subject %q has more than a queue group", sq)
+			}
+			subject, _ := splitPermSubjectQueue(sq)
+			subjects = append(subjects, subject)
+		}
+		return subjects
+	}
+	uc := *juc
+	uc.Sub.Allow = subjects(juc.Sub.Allow)
+	uc.Sub.Deny = subjects(juc.Sub.Deny)
+	uc.Validate(vr)
+}
+
 // checkAuthforWarnings will look for insecure settings and log concerns.
 // Lock is assumed held.
 func (s *Server) checkAuthforWarnings() {
@@ -228,6 +276,11 @@ func (s *Server) configureAuthorization() {
 		s.users = nil
 		s.info.AuthRequired = false
 	}
+
+	// The authorization callout applies to all but the configured users.
+	if opts.AuthCallout != nil {
+		s.info.AuthRequired = true
+	}
 }
 
 // checkAuthentication will check based on client type and
@@ -253,6 +306,7 @@ func (s *Server) isClientAuthorized(c *client) bool {
 	// Snapshot server options by hand and only grab what we really need.
 	s.optsMu.RLock()
 	customClientAuthentication := s.opts.CustomClientAuthentication
+	authCallout := s.opts.AuthCallout
 	authorization := s.opts.Authorization
 	username := s.opts.Username
 	password := s.opts.Password
@@ -266,6 +320,11 @@ func (s *Server) isClientAuthorized(c *client) bool {
 		return customClientAuthentication.Check(c)
 	}
 
+	// Clients other than the authorization service are authorized by it.
+	if authCallout != nil && !isAuthCalloutUser(c, authCallout) {
+		return s.processAuthCallout(c, authCallout)
+	}
+
 	// Grab under lock but process after.
 	var (
 		nkey *NkeyUser
@@ -299,7 +358,7 @@ func (s *Server) isClientAuthorized(c *client) bool {
 			return false
 		}
 		vr := jwt.CreateValidationResults()
-		juc.Validate(vr)
+		validateUserClaims(juc, vr)
 		if vr.IsBlocking(true) {
 			s.mu.Unlock()
 			c.Debugf("User JWT no longer valid: %+v", vr)
@@ -373,6 +432,10 @@ func (s *Server) isClientAuthorized(c *client) bool {
 			c.Debugf("Account JWT has expired")
 			return false
 		}
+		if acc.checkUserRevoked(juc.Subject, juc.IssuedAt) {
+			c.Debugf("User authentication revoked")
+			return false
+		}
 		// Verify the signature against the nonce.
 		if c.opts.Sig == "" {
 			c.Debugf("Signature missing")
@@ -583,7 +646,7 @@ func (s *Server) isLeafNodeAuthorized(c *client) bool {
 			return false
 		}
 		vr := jwt.CreateValidationResults()
-		juc.Validate(vr)
+		validateUserClaims(juc, vr)
 		if vr.IsBlocking(true) {
 			s.mu.Unlock()
 			c.Debugf("User JWT no longer valid: %+v", vr)
@@ -607,27 +670,12 @@ func (s *Server) isLeafNodeAuthorized(c *client) bool {
 			c.Debugf("Account JWT has expired")
 			return false
 		}
-		// Verify the signature against the nonce.
-		if c.opts.Sig == "" {
-			c.Debugf("Signature missing")
+		if acc.checkUserRevoked(juc.Subject, juc.IssuedAt) {
+			c.Debugf("User authentication revoked")
 			return false
 		}
-		sig, err := base64.RawURLEncoding.DecodeString(c.opts.Sig)
-		if err != nil {
-			// Allow fallback to normal base64.
-			sig, err = base64.StdEncoding.DecodeString(c.opts.Sig)
-			if err != nil {
-				c.Debugf("Signature not valid base64")
-				return false
-			}
-		}
-		pub, err := nkeys.FromPublicKey(juc.Subject)
-		if err != nil {
-			c.Debugf("User nkey not valid: %v", err)
-			return false
-		}
-		if err := pub.Verify(c.nonce, sig); err != nil {
-			c.Debugf("Signature not verified")
+		// Verify the signature against the nonce.
+		if !c.verifyNonceSignature(juc.Subject) {
 			return false
 		}
 
@@ -635,25 +683,160 @@ func (s *Server) isLeafNodeAuthorized(c *client) bool {
 		if err := c.RegisterNkeyUser(nkey); err != nil {
 			return false
 		}
+		if nkey.Permissions == nil {
+			c.setLeafNodePermissions(s.getOpts().LeafNode.Permissions)
+		}
 
 		// Check if we need to set an auth timer if the user jwt expires.
 		c.checkExpiration(juc.Claims())
 		return true
 	}
 
-	// For now this means we are binding the leafnode to the global account.
-	c.registerWithAccount(s.globalAccount())
-
 	// Snapshot server options.
 	opts := s.getOpts()
 
-	if opts.LeafNode.Username == "" {
+	// With multiple users, the leafnode is bound to the account and permissions
+	// of the user it is identified as.
+	if len(opts.LeafNode.Users) > 0 || len(opts.LeafNode.Nkeys) > 0 {
+		return s.isLeafNodeUserAuthorized(c, &opts.LeafNode)
+	}
+
+	if opts.LeafNode.Username != "" {
+		if opts.LeafNode.TLSMap {
+			if !checkClientTLSCertSubject(c, func(user string) bool {
+				return opts.LeafNode.Username == user
+			}) {
+				return false
+			}
+		} else if opts.LeafNode.Username != c.opts.Username ||
+			!comparePasswords(opts.LeafNode.Password, c.opts.Password) {
+			return false
+		}
+	}
+	return s.registerLeafNodeUser(c, opts.LeafNode.Account, nil)
+}
+
+// isLeafNodeUserAuthorized checks an inbound leaf node connection against the
+// users of the leafnode authorization. The leaf node is identified by its
+// certificate if TLS map is enabled, then by the nkey of its user JWT, such as
+// sent when the remote uses a credentials file, then by username/password.
+func (s *Server) isLeafNodeUserAuthorized(c *client, lo *LeafNodeOpts) bool {
+	var user *User
+	if lo.TLSMap {
+		checkClientTLSCertSubject(c, func(u string) bool {
+			for _, lu := range lo.Users {
+				if lu.Username == u {
+					user = lu
+					return true
+				}
+			}
+			return false
+		})
+		if user != nil {
+			return s.registerLeafNodeUser(c, leafUserAccountName(user.Account), user.Permissions)
+		}
+	}
+
+	if c.opts.JWT != "" {
+		juc, err := jwt.DecodeUserClaims(c.opts.JWT)
+		if err != nil {
+			c.Debugf("User JWT not valid: %v", err)
+			return false
+		}
+		vr := jwt.CreateValidationResults()
+		validateUserClaims(juc, vr)
+		if vr.IsBlocking(true) {
+			c.Debugf("User JWT no longer valid: %+v", vr)
+			return false
+		}
+		for _, nkey := range lo.Nkeys {
+			if nkey.Nkey != juc.Subject {
+				continue
+			}
+			if !c.verifyNonceSignature(juc.Subject) {
+				return false
+			}
+			if !s.registerLeafNodeUser(c, leafUserAccountName(nkey.Account), nkey.Permissions) {
+				return false
+			}
+			// Check if we need to set an auth timer if the user jwt expires.
+			c.checkExpiration(juc.Claims())
+			return true
+		}
+		c.Debugf("User nkey %q not authorized", juc.Subject)
+		return false
+	}
+
+	for _, lu := range lo.Users {
+		if lu.Username == c.opts.Username {
+			user = lu
+			break
+		}
+	}
+	if user == nil || !comparePasswords(user.Password, c.opts.Password) {
+		return false
+	}
+	return s.registerLeafNodeUser(c, leafUserAccountName(user.Account), user.Permissions)
+}
+
+// Returns the name of the account a leafnode user is bound to, if any.
+func leafUserAccountName(acc *Account) string {
+	if acc == nil {
+		return _EMPTY_
+	}
+	return acc.Name
+}
+
+// registerLeafNodeUser binds the leaf node to the named account, or to the
+// global account if none is given, and sets its permissions.
+func (s *Server) registerLeafNodeUser(c *client, accName string, perms *Permissions) bool {
+	acc := s.globalAccount()
+	if accName != _EMPTY_ {
+		var err error
+		if acc, err = s.LookupAccount(accName); err != nil {
+			c.Debugf("Leafnode account %q can not be found", accName)
+			return false
+		}
+	}
+	if err := c.registerWithAccount(acc); err != nil {
+		c.reportErrRegisterAccount(acc, err)
+		return false
+	}
+	// Without user permissions, the import/export permissions of the leafnodes apply.
+	if perms == nil {
+		c.setLeafNodePermissions(s.getOpts().LeafNode.Permissions)
 		return true
 	}
-	if opts.LeafNode.Username != c.opts.Username {
+	c.RegisterUser(&User{Permissions: perms})
+	return true
+}
+
+// verifyNonceSignature checks that the signature of the CONNECT was made over
+// the nonce sent to the client by the given public nkey.
+func (c *client) verifyNonceSignature(pubKey string) bool {
+	if c.opts.Sig == "" {
+		c.Debugf("Signature missing")
+		return false
+	}
+	sig, err := base64.RawURLEncoding.DecodeString(c.opts.Sig)
+	if err != nil {
+		// Allow fallback to normal base64.
+		sig, err = base64.StdEncoding.DecodeString(c.opts.Sig)
+		if err != nil {
+			c.Debugf("Signature not valid base64")
+			return false
+		}
+	}
+	pub, err := nkeys.FromPublicKey(pubKey)
+	if err != nil {
+		c.Debugf("User nkey not valid: %v", err)
 		return false
 	}
-	return comparePasswords(opts.LeafNode.Password, c.opts.Password)
+	if err := pub.Verify(c.nonce, sig); err != nil {
+		c.Debugf("Signature not verified")
+		return false
+	}
+	return true
 }
 
 // Support for bcrypt stored passwords and tokens.
@@ -0,0 +1,429 @@
+// Copyright 2019 The NATS Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package server
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/nats-io/jwt"
+	"github.com/nats-io/nkeys"
+	"github.com/nats-io/nuid"
+)
+
+// AuthorizationRequest is sent to the authorization service for each
+// connecting client that is not one of the callout's auth users. The nkey
+// is only set once the client proved it owns it by signing the nonce.
+type AuthorizationRequest struct {
+	ServerID string            `json:"server_id"`
+	ClientID uint64            `json:"client_id"`
+	IP       string            `json:"ip"`
+	Port     int               `json:"port"`
+	Name     string            `json:"name,omitempty"`
+	Lang     string            `json:"lang,omitempty"`
+	Version  string            `json:"version,omitempty"`
+	User     string            `json:"user,omitempty"`
+	Pass     string            `json:"pass,omitempty"`
+	Token    string            `json:"auth_token,omitempty"`
+	Nkey     string            `json:"nkey,omitempty"`
+	JWT      string            `json:"jwt,omitempty"`
+	TLS      *AuthorizationTLS `json:"tls,omitempty"`
+}
+
+// AuthorizationTLS holds the certificates presented by a client, in PEM
+// format, along with the identities found in the first one.
+type AuthorizationTLS struct {
+	Subject  string   `json:"subject,omitempty"`
+	DNSNames []string `json:"dns_names,omitempty"`
+	Emails   []string `json:"emails,omitempty"`
+	Certs    []string `json:"certs,omitempty"`
+}
+
+// AuthorizationResponse is the reply of the authorization service. The
+// user JWT must be issued by the callout's issuer, its audience being the
+// name of the account the client is bound to.
+type AuthorizationResponse struct {
+	JWT   string `json:"jwt,omitempty"`
+	Error string `json:"error,omitempty"`
+}
+
+// authCallout sends the authorization requests with an internal client
+// of the callout account, and caches the responses if configured.
+type authCallout struct {
+	mu      sync.Mutex
+	pmu     sync.Mutex // serializes the publishing of the internal client
+	client  *client
+	prefix  string
+	seq     uint64
+	pending map[string]chan []byte
+	cache   map[string]*authCalloutEntry
+	pruned  time.Time
+}
+
+// A cached user JWT returned by the authorization service.
+type authCalloutEntry struct {
+	jwt     string
+	expires time.Time
+}
+
+// Sets up the internal client of the callout account and the subscription
+// receiving the responses of the authorization service.
+func (s *Server) startAuthCallout(opts *AuthCallout) error {
+	acc, err := s.LookupAccount(opts.Account)
+	if err != nil {
+		return err
+	}
+	c := &client{srv: s, kind: SYSTEM, opts: internalOpts, msubs: -1, mpay: -1, start: time.Now(), last: time.Now()}
+	c.initClient()
+	c.echo = false
+	ac := &authCallout{
+		client:  c,
+		prefix:  "_INBOX." + nuid.Next() + ".",
+		pending: make(map[string]chan []byte),
+		cache:   make(map[string]*authCalloutEntry),
+	}
+	if err := c.registerWithAccount(acc); err != nil {
+		return err
+	}
+	if err := c.processSub([]byte(ac.prefix + "* 1")); err != nil {
+		return err
+	}
+	c.mu.Lock()
+	sub := c.subs["1"]
+	if sub != nil {
+		sub.icb = ac.processResponse
+	}
+	c.mu.Unlock()
+	if sub == nil {
+		return fmt.Errorf("could not subscribe to authorization responses")
+	}
+	s.mu.Lock()
+	s.authCallout = ac
+	s.mu.Unlock()
+	return nil
+}
+
+// Hands a response over to the pending request it is for.
+func (ac *authCallout) processResponse(sub *subscription, subject, reply string, msg []byte) {
+	ac.mu.Lock()
+	ch := ac.pending[subject]
+	delete(ac.pending, subject)
+	ac.mu.Unlock()
+	if ch != nil {
+		ch <- append([]byte(nil), msg...)
+	}
+}
+
+// Sends a request to the authorization service and waits for its response.
+func (ac *authCallout) request(subject string, req []byte, timeout time.Duration) ([]byte, error) {
+	ch := make(chan []byte, 1)
+	ac.mu.Lock()
+	ac.seq++
+	reply := ac.prefix + strconv.FormatUint(ac.seq, 10)
+	ac.pending[reply] = ch
+	ac.mu.Unlock()
+
+	ac.pmu.Lock()
+	c := ac.client
+	c.pa.subject = []byte(subject)
+	c.pa.reply = []byte(reply)
+	c.pa.size = len(req)
+	c.pa.szb = []byte(strconv.Itoa(len(req)))
+	c.processInboundClientMsg(append(req, _CRLF_...))
+	c.flushClients(0)
+	ac.pmu.Unlock()
+
+	select {
+	case resp := <-ch:
+		return resp, nil
+	case <-time.After(timeout):
+		ac.mu.Lock()
+		delete(ac.pending, reply)
+		ac.mu.Unlock()
+		return nil, ErrAuthCalloutTimeout
+	}
+}
+
+// Returns the cached user JWT for the given credentials, if any.
+func (ac *authCallout) cached(key string) string {
+	ac.mu.Lock()
+	defer ac.mu.Unlock()
+	e := ac.cache[key]
+	if e == nil {
+		return _EMPTY_
+	}
+	if time.Now().After(e.expires) {
+		delete(ac.cache, key)
+		return _EMPTY_
+	}
+	return e.jwt
+}
+
+// Caches the user JWT for the given credentials, removing expired entries
+// at most once per ttl.
+func (ac *authCallout) store(key, ujwt string, ttl time.Duration) {
+	ac.mu.Lock()
+	defer ac.mu.Unlock()
+	now := time.Now()
+	if now.Sub(ac.pruned) >= ttl {
+		for k, e := range ac.cache {
+			if now.After(e.expires) {
+				delete(ac.cache, k)
+			}
+		}
+		ac.pruned = now
+	}
+	ac.cache[key] = &authCalloutEntry{jwt: ujwt, expires: now.Add(ttl)}
+}
+
+// Drops the cached user JWT for the given credentials.
+func (ac *authCallout) evict(key string) {
+	ac.mu.Lock()
+	delete(ac.cache, key)
+	ac.mu.Unlock()
+}
+
+// Returns true if the client is one of the users the callout is not used for.
+func isAuthCalloutUser(c *client, opts *AuthCallout) bool {
+	for _, u := range opts.AuthUsers {
+		if (c.opts.Username != _EMPTY_ && u == c.opts.Username) || (c.opts.Nkey != _EMPTY_ && u == c.opts.Nkey) {
+			return true
+		}
+	}
+	return false
+}
+
+// Builds the authorization request for the client. A nkey is only part of
+// the request if the client signed the nonce with it.
+func (s *Server) authorizationRequest(c *client) (*AuthorizationRequest, bool) {
+	c.mu.Lock()
+	req := &AuthorizationRequest{
+		ClientID: c.cid,
+		IP:       c.host,
+		Port:     int(c.port),
+		Name:     c.opts.Name,
+		Lang:     c.opts.Lang,
+		Version:  c.opts.Version,
+		User:     c.opts.Username,
+		Pass:     c.opts.Password,
+		Token:    c.opts.Authorization,
+		JWT:      c.opts.JWT,
+	}
+	nkey, sig, nonce := c.opts.Nkey, c.opts.Sig, c.nonce
+	c.mu.Unlock()
+
+	if nkey != _EMPTY_ {
+		if len(nonce) == 0 || sig == _EMPTY_ {
+			c.Debugf("Signature missing")
+			return nil, false
+		}
+		rawSig, err := base64.RawURLEncoding.DecodeString(sig)
+		if err != nil {
+			// Allow fallback to normal base64.
+			rawSig, err = base64.StdEncoding.DecodeString(sig)
+			if err != nil {
+				c.Debugf("Signature not valid base64")
+				return nil, false
+			}
+		}
+		pub, err := nkeys.FromPublicKey(nkey)
+		if err != nil {
+			c.Debugf("User nkey not valid: %v", err)
+			return nil, false
+		}
+		if err := pub.Verify(nonce, rawSig); err != nil {
+			c.Debugf("Signature not verified")
+			return nil, false
+		}
+		req.Nkey = nkey
+	}
+
+	if tlsState := c.GetTLSConnectionState(); tlsState != nil && len(tlsState.PeerCertificates) > 0 {
+		cert := tlsState.PeerCertificates[0]
+		req.TLS = &AuthorizationTLS{
+			Subject:  cert.Subject.String(),
+			DNSNames: cert.DNSNames,
+			Emails:   cert.EmailAddresses,
+		}
+		for _, cert := range tlsState.PeerCertificates {
+			req.TLS.Certs = append(req.TLS.Certs, string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: cert.Raw})))
+		}
+	}
+
+	s.mu.Lock()
+	req.ServerID = s.info.ID
+	s.mu.Unlock()
+	return req, true
+}
+
+// Returns the key under which the response for the credentials of
+// the request is cached.
+func authCalloutCacheKey(req *AuthorizationRequest) string {
+	creds := *req
+	creds.ServerID, creds.ClientID, creds.IP, creds.Port = _EMPTY_, 0, _EMPTY_, 0
+	creds.Name, creds.Lang, creds.Version = _EMPTY_, _EMPTY_, _EMPTY_
+	b, _ := json.Marshal(&creds)
+	sum := sha256.Sum256(b)
+	return string(sum[:])
+}
+
+// processAuthCallout authorizes the client through the authorization
+// service. The user JWT it returns determines the account and the
+// permissions of the client.
+func (s *Server) processAuthCallout(c *client, opts *AuthCallout) bool {
+	s.mu.Lock()
+	ac := s.authCallout
+	s.mu.Unlock()
+	if ac == nil {
+		c.Debugf("Authorization callout not started")
+		return false
+	}
+	req, ok := s.authorizationRequest(c)
+	if !ok {
+		return false
+	}
+	var key, ujwt string
+	if opts.CacheTTL > 0 {
+		key = authCalloutCacheKey(req)
+		if ujwt = ac.cached(key); ujwt != _EMPTY_ {
+			if s.registerAuthCalloutUser(c, opts, ujwt) {
+				return true
+			}
+			// The user JWT may have expired, ask the service again.
+			ac.evict(key)
+		}
+	}
+
+	subject := opts.Subject
+	if subject == _EMPTY_ {
+		subject = DEFAULT_AUTH_CALLOUT_SUBJECT
+	}
+	timeout := opts.Timeout
+	if timeout <= 0 {
+		timeout = DEFAULT_AUTH_CALLOUT_TIMEOUT
+	}
+	b, _ := json.Marshal(req)
+	msg, err := ac.request(subject, b, timeout)
+	if err != nil {
+		c.Errorf("Authorization callout failed: %v", err)
+		return false
+	}
+	var resp AuthorizationResponse
+	if err := json.Unmarshal(msg, &resp); err != nil {
+		c.Errorf("Authorization callout response not valid: %v", err)
+		return false
+	}
+	if resp.Error != _EMPTY_ {
+		c.Debugf("Authorization callout denied: %s", resp.Error)
+		return false
+	}
+	if !s.registerAuthCalloutUser(c, opts, resp.JWT) {
+		return false
+	}
+	if key != _EMPTY_ {
+		ac.store(key, resp.JWT, opts.CacheTTL)
+	}
+	return true
+}
+
+// Checks the user JWT returned by the authorization service and registers
+// the client with the account and permissions it specifies.
+func (s *Server) registerAuthCalloutUser(c *client, opts *AuthCallout, ujwt string) bool {
+	juc, err := jwt.DecodeUserClaims(ujwt)
+	if err != nil {
+		c.Debugf("Authorization callout user JWT not valid: %v", err)
+		return false
+	}
+	vr := jwt.CreateValidationResults()
+	validateUserClaims(juc, vr)
+	if vr.IsBlocking(true) {
+		c.Debugf("Authorization callout user JWT no longer valid: %+v", vr)
+		return false
+	}
+	if juc.Issuer != opts.Issuer {
+		c.Debugf("Authorization callout user JWT not issued by %q", opts.Issuer)
+		return false
+	}
+	if juc.Audience == _EMPTY_ {
+		c.Debugf("Authorization callout user JWT has no account")
+		return false
+	}
+	acc, err := s.LookupAccount(juc.Audience)
+	if err != nil {
+		c.Debugf("Authorization callout account %q not found", juc.Audience)
+		return false
+	}
+	nkey := buildInternalNkeyUser(juc, acc)
+	if err := c.RegisterNkeyUser(nkey); err != nil {
+		return false
+	}
+	// Check if we need to set an auth timer if the user jwt expires.
+	c.checkExpiration(juc.Claims())
+	return true
+}
+
+// Checks the authorization callout options.
+func validateAuthCallout(o *Options) error {
+	ac := o.AuthCallout
+	if ac == nil {
+		return nil
+	}
+	if len(o.TrustedKeys) > 0 || len(o.TrustedOperators) > 0 {
+		return fmt.Errorf("authorization callout not supported with trusted operators")
+	}
+	if !nkeys.IsValidPublicAccountKey(ac.Issuer) {
+		return fmt.Errorf("authorization callout issuer %q is not a valid public account key", ac.Issuer)
+	}
+	if ac.Account == _EMPTY_ {
+		return fmt.Errorf("authorization callout account is missing")
+	}
+	found := false
+	for _, acc := range o.Accounts {
+		if acc.Name == ac.Account {
+			found = true
+			break
+		}
+	}
+	if !found {
+		return fmt.Errorf("authorization callout account %q is not defined", ac.Account)
+	}
+	if len(ac.AuthUsers) == 0 {
+		return fmt.Errorf("authorization callout requires auth users")
+	}
+	for _, au := range ac.AuthUsers {
+		found = false
+		for _, u := range o.Users {
+			if u.Username == au {
+				found = true
+				break
+			}
+		}
+		for _, u := range o.Nkeys {
+			if u.Nkey == au {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return fmt.Errorf("authorization callout auth user %q is not defined", au)
+		}
+	}
+	return nil
+}
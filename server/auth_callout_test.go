@@ -0,0 +1,269 @@
+// Copyright 2019 The NATS Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/nats-io/jwt"
+	"github.com/nats-io/nats.go"
+	"github.com/nats-io/nkeys"
+)
+
+const authCalloutConf = `
+	listen: 127.0.0.1:-1
+	accounts {
+		AUTH { users [ {user: auth, password: pwd} ] }
+		APP {}
+	}
+	authorization {
+		auth_callout {
+			issuer: %s
+			account: AUTH
+			auth_users: [ auth ]
+			timeout: "%s"
+			cache_ttl: "%s"
+		}
+	}
+`
+
+func runAuthCalloutServer(t *testing.T, akp nkeys.KeyPair, timeout, ttl string) (*Server, string) {
+	t.Helper()
+	apub, _ := akp.PublicKey()
+	conf := createConfFile(t, []byte(fmt.Sprintf(authCalloutConf, apub, timeout, ttl)))
+	s, _ := RunServerWithConfig(conf)
+	return s, conf
+}
+
+// Starts an authorization service allowing user "app" to publish and
+// subscribe on "foo" in account APP, and denying anybody else.
+func startAuthCalloutService(t *testing.T, s *Server, akp nkeys.KeyPair, requests *int32) *nats.Conn {
+	t.Helper()
+	url := fmt.Sprintf("nats://auth:pwd@%s:%d", s.getOpts().Host, s.getOpts().Port)
+	nc := natsConnect(t, url)
+	natsSub(t, nc, DEFAULT_AUTH_CALLOUT_SUBJECT, func(m *nats.Msg) {
+		atomic.AddInt32(requests, 1)
+		var req AuthorizationRequest
+		if err := json.Unmarshal(m.Data, &req); err != nil {
+			t.Fatalf("Error unmarshaling request: %v", err)
+		}
+		var resp AuthorizationResponse
+		if req.User != "app" || req.Pass != "pwd" {
+			resp.Error = "not authorized"
+		} else {
+			ukp, _ := nkeys.CreateUser()
+			upub, _ := ukp.PublicKey()
+			uc := jwt.NewUserClaims(upub)
+			uc.Audience = "APP"
+			uc.Pub.Allow.Add("foo")
+			uc.Sub.Allow.Add("foo")
+			ujwt, err := uc.Encode(akp)
+			if err != nil {
+				t.Fatalf("Error encoding user jwt: %v", err)
+			}
+			resp.JWT = ujwt
+		}
+		b, _ := json.Marshal(&resp)
+		nc.Publish(m.Reply, b)
+	})
+	natsFlush(t, nc)
+	return nc
+}
+
+func TestAuthCallout(t *testing.T) {
+	akp, _ := nkeys.CreateAccount()
+	s, conf := runAuthCalloutServer(t, akp, "1s", "0s")
+	defer os.Remove(conf)
+	defer s.Shutdown()
+
+	var requests int32
+	snc := startAuthCalloutService(t, s, akp, &requests)
+	defer snc.Close()
+
+	url := fmt.Sprintf("nats://%s:%d", s.getOpts().Host, s.getOpts().Port)
+	if _, err := nats.Connect(url, nats.UserInfo("app", "bad")); err == nil {
+		t.Fatal("Expected connection to be denied")
+	}
+	if _, err := nats.Connect(url); err == nil {
+		t.Fatal("Expected connection without credentials to be denied")
+	}
+
+	errCh := make(chan error, 1)
+	nc, err := nats.Connect(url, nats.UserInfo("app", "pwd"),
+		nats.ErrorHandler(func(_ *nats.Conn, _ *nats.Subscription, err error) {
+			errCh <- err
+		}))
+	if err != nil {
+		t.Fatalf("Error on connect: %v", err)
+	}
+	defer nc.Close()
+
+	acc, _ := s.LookupAccount("APP")
+	if n := acc.NumLocalConnections(); n != 1 {
+		t.Fatalf("Expected client to be bound to account APP, got %v connections", n)
+	}
+
+	sub := natsSubSync(t, nc, "foo")
+	natsPub(t, nc, "foo", []byte("hello"))
+	natsNexMsg(t, sub, time.Second)
+
+	natsSubSync(t, nc, "bar")
+	select {
+	case err := <-errCh:
+		if !strings.Contains(err.Error(), "Permissions Violation") {
+			t.Fatalf("Expected a permissions violation, got %v", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Expected a permissions violation")
+	}
+	if n := atomic.LoadInt32(&requests); n != 3 {
+		t.Fatalf("Expected 3 requests, got %v", n)
+	}
+}
+
+func TestAuthCalloutTimeout(t *testing.T) {
+	akp, _ := nkeys.CreateAccount()
+	s, conf := runAuthCalloutServer(t, akp, "100ms", "0s")
+	defer os.Remove(conf)
+	defer s.Shutdown()
+
+	url := fmt.Sprintf("nats://%s:%d", s.getOpts().Host, s.getOpts().Port)
+	// No service is running, so the request times out.
+	start := time.Now()
+	if _, err := nats.Connect(url, nats.UserInfo("app", "pwd")); err == nil {
+		t.Fatal("Expected connection to be denied")
+	}
+	if dur := time.Since(start); dur < 100*time.Millisecond {
+		t.Fatalf("Expected connection to be denied after the timeout, took %v", dur)
+	}
+	// The service users are not subject to the callout.
+	nc := natsConnect(t, fmt.Sprintf("nats://auth:pwd@%s:%d", s.getOpts().Host, s.getOpts().Port))
+	nc.Close()
+}
+
+func TestAuthCalloutCache(t *testing.T) {
+	akp, _ := nkeys.CreateAccount()
+	s, conf := runAuthCalloutServer(t, akp, "1s", "1m")
+	defer os.Remove(conf)
+	defer s.Shutdown()
+
+	var requests int32
+	snc := startAuthCalloutService(t, s, akp, &requests)
+	defer snc.Close()
+
+	url := fmt.Sprintf("nats://app:pwd@%s:%d", s.getOpts().Host, s.getOpts().Port)
+	for i := 0; i < 3; i++ {
+		nc := natsConnect(t, url)
+		nc.Close()
+	}
+	if n := atomic.LoadInt32(&requests); n != 1 {
+		t.Fatalf("Expected 1 request, got %v", n)
+	}
+	// Denials are not cached.
+	url = fmt.Sprintf("nats://app:bad@%s:%d", s.getOpts().Host, s.getOpts().Port)
+	for i := 0; i < 2; i++ {
+		if _, err := nats.Connect(url); err == nil {
+			t.Fatal("Expected connection to be denied")
+		}
+	}
+	if n := atomic.LoadInt32(&requests); n != 3 {
+		t.Fatalf("Expected 3 requests, got %v", n)
+	}
+}
+
+func TestAuthCalloutWrongIssuer(t *testing.T) {
+	akp, _ := nkeys.CreateAccount()
+	s, conf := runAuthCalloutServer(t, akp, "1s", "0s")
+	defer os.Remove(conf)
+	defer s.Shutdown()
+
+	// The service signs with a key other than the configured issuer.
+	okp, _ := nkeys.CreateAccount()
+	var requests int32
+	snc := startAuthCalloutService(t, s, okp, &requests)
+	defer snc.Close()
+
+	url := fmt.Sprintf("nats://app:pwd@%s:%d", s.getOpts().Host, s.getOpts().Port)
+	if _, err := nats.Connect(url); err == nil {
+		t.Fatal("Expected connection to be denied")
+	}
+	if n := atomic.LoadInt32(&requests); n != 1 {
+		t.Fatalf("Expected 1 request, got %v", n)
+	}
+}
+
+func TestAuthCalloutConfig(t *testing.T) {
+	akp, _ := nkeys.CreateAccount()
+	apub, _ := akp.PublicKey()
+
+	conf := createConfFile(t, []byte(fmt.Sprintf(authCalloutConf, apub, "500ms", "30s")))
+	defer os.Remove(conf)
+	opts, err := ProcessConfigFile(conf)
+	if err != nil {
+		t.Fatalf("Error processing config: %v", err)
+	}
+	ac := opts.AuthCallout
+	if ac == nil {
+		t.Fatal("Expected auth callout to be set")
+	}
+	if ac.Issuer != apub || ac.Account != "AUTH" || len(ac.AuthUsers) != 1 || ac.AuthUsers[0] != "auth" {
+		t.Fatalf("Unexpected auth callout: %+v", ac)
+	}
+	if ac.Timeout != 500*time.Millisecond || ac.CacheTTL != 30*time.Second {
+		t.Fatalf("Unexpected auth callout durations: %+v", ac)
+	}
+	if err := validateOptions(opts); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	for _, test := range []struct {
+		name    string
+		callout string
+		err     string
+	}{
+		{"bad issuer", `issuer: foo, account: AUTH, auth_users: [auth]`, "not a valid public account key"},
+		{"missing account", fmt.Sprintf(`issuer: %s, auth_users: [auth]`, apub), "account is missing"},
+		{"unknown account", fmt.Sprintf(`issuer: %s, account: FOO, auth_users: [auth]`, apub), "is not defined"},
+		{"missing auth users", fmt.Sprintf(`issuer: %s, account: AUTH`, apub), "requires auth users"},
+		{"unknown auth user", fmt.Sprintf(`issuer: %s, account: AUTH, auth_users: [foo]`, apub), "auth user \"foo\" is not defined"},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			conf := createConfFile(t, []byte(fmt.Sprintf(`
+				accounts { AUTH { users [ {user: auth, password: pwd} ] } }
+				authorization { auth_callout { %s } }
+			`, test.callout)))
+			defer os.Remove(conf)
+			opts, err := ProcessConfigFile(conf)
+			if err != nil {
+				t.Fatalf("Error processing config: %v", err)
+			}
+			if err := validateOptions(opts); err == nil || !strings.Contains(err.Error(), test.err) {
+				t.Fatalf("Expected error %q, got %v", test.err, err)
+			}
+		})
+	}
+
+	conf = createConfFile(t, []byte(`authorization { auth_callout { timeout: "abc", foo: bar } }`))
+	defer os.Remove(conf)
+	if _, err := ProcessConfigFile(conf); err == nil ||
+		!strings.Contains(err.Error(), "error parsing timeout") || !strings.Contains(err.Error(), "unknown field \"foo\"") {
+		t.Fatalf("Expected parse errors, got %v", err)
+	}
+}
@@ -23,6 +23,7 @@ import (
 	"net"
 	"regexp"
 	"runtime"
+	"strconv"
 	"strings"
 	"sync"
 	"sync/atomic"
@@ -142,6 +143,8 @@ const (
 	AuthenticationExpired
 	WrongGateway
 	MissingAccount
+	Revocation
+	RateLimitExceeded
 )
 
 // Some flags passed to processMsgResultsEx
@@ -154,33 +157,38 @@ const (
 type client struct {
 	// Here first because of use of atomics, and memory alignment.
 	stats
-	mpay   int32
-	msubs  int32
-	mcl    int32
-	mu     sync.Mutex
-	kind   int
-	cid    uint64
-	opts   clientOpts
-	start  time.Time
-	nonce  []byte
-	nc     net.Conn
-	ncs    string
-	out    outbound
-	srv    *Server
-	acc    *Account
-	user   *NkeyUser
-	host   string
-	port   uint16
-	subs   map[string]*subscription
-	perms  *permissions
-	mperms *msgDeny
-	darray []string
-	in     readCache
-	pcd    map[*client]struct{}
-	atmr   *time.Timer
-	ping   pinfo
-	msgb   [msgScratchSize]byte
-	last   time.Time
+	mpay    int32
+	msubs   int32
+	mcl     int32
+	mu      sync.Mutex
+	kind    int
+	cid     uint64
+	opts    clientOpts
+	start   time.Time
+	nonce   []byte
+	nc      net.Conn
+	ncs     string
+	out     outbound
+	srv     *Server
+	acc     *Account
+	user    *NkeyUser
+	host    string
+	port    uint16
+	subs    map[string]*subscription
+	perms   *permissions
+	replies map[string]*resp
+	mperms  *msgDeny
+	rlim    *rateLimiter
+	rdrops  int64
+	rdelays int64
+	rdch    chan struct{}
+	darray  []string
+	in      readCache
+	pcd     map[*client]struct{}
+	atmr    *time.Timer
+	ping    pinfo
+	msgb    [msgScratchSize]byte
+	last    time.Time
 	parseState
 
 	rtt      time.Duration
@@ -190,9 +198,12 @@ type client struct {
 	gw    *gateway
 	leaf  *leaf
 
-	debug bool
-	trace bool
-	echo  bool
+	debug   bool
+	trace   bool
+	echo    bool
+	headers bool
+	ws      bool
+	mqtt    bool
 
 	flags clientFlag // Compact booleans into a single field. Size will be increased when needed.
 }
@@ -229,9 +240,16 @@ type perm struct {
 type permissions struct {
 	sub    perm
 	pub    perm
+	resp   *ResponsePermission
 	pcache map[string]bool
 }
 
+// resp tracks a reply subject the client is allowed to respond to.
+type resp struct {
+	t time.Time
+	n int
+}
+
 // msgDeny is used when a user permission for subscriptions has a deny
 // clause but a subscription could be made that is of broader scope.
 // e.g. deny = "foo", but user subscribes to "*". That subscription should
@@ -254,6 +272,7 @@ const (
 	maxDenyPermCacheSize = 256
 	maxPermCacheSize     = 128
 	pruneSize            = 32
+	replyPermLimit       = 4096
 	routeTargetInit      = 8
 )
 
@@ -320,6 +339,8 @@ type subscription struct {
 	client  *client
 	im      *streamImport   // This is for import stream support.
 	shadow  []*subscription // This is to track shadowed accounts.
+	icb     msgHandler      // This is for internal subscriptions outside the system account.
+	hicb    msgHdrHandler   // Same as icb, but for callbacks that need the headers.
 	subject []byte
 	queue   []byte
 	sid     []byte
@@ -345,6 +366,8 @@ type clientOpts struct {
 	Protocol      int    `json:"protocol"`
 	Account       string `json:"account,omitempty"`
 	AccountNew    bool   `json:"new_account,omitempty"`
+	Headers       bool   `json:"headers,omitempty"`
+	QueueWeights  bool   `json:"queue_weights,omitempty"`
 
 	// Routes only
 	Import *SubjectPermission `json:"import,omitempty"`
@@ -527,11 +550,14 @@ func (c *client) RegisterUser(user *User) {
 	c.mu.Lock()
 	defer c.mu.Unlock()
 
+	c.rlim = newRateLimiter(user.RateLimits)
+
 	// Assign permissions.
 	if user.Permissions == nil {
 		// Reset perms to nil in case client previously had them.
 		c.perms = nil
 		c.mperms = nil
+		c.replies = nil
 		return
 	}
 	c.setPermissions(user.Permissions)
@@ -551,11 +577,13 @@ func (c *client) RegisterNkeyUser(user *NkeyUser) error {
 
 	c.mu.Lock()
 	c.user = user
+	c.rlim = newRateLimiter(user.RateLimits)
 	// Assign permissions.
 	if user.Permissions == nil {
 		// Reset perms to nil in case client previously had them.
 		c.perms = nil
 		c.mperms = nil
+		c.replies = nil
 	} else {
 		c.setPermissions(user.Permissions)
 	}
@@ -590,27 +618,50 @@ func (c *client) setPermissions(perms *Permissions) {
 		}
 	}
 
+	// Responses are the only publishes allowed on top of the allow list,
+	// so an empty one denies anything else.
+	c.replies = nil
+	if perms.Response != nil {
+		rp := *perms.Response
+		c.perms.resp = &rp
+		c.replies = make(map[string]*resp)
+		if c.perms.pub.allow == nil {
+			c.perms.pub.allow = NewSublist()
+		}
+	}
+
 	// Loop over subscribe permissions
 	if perms.Subscribe != nil {
 		if len(perms.Subscribe.Allow) > 0 {
 			c.perms.sub.allow = NewSublist()
 		}
 		for _, subSubject := range perms.Subscribe.Allow {
-			sub := &subscription{subject: []byte(subSubject)}
-			c.perms.sub.allow.Insert(sub)
+			c.perms.sub.allow.Insert(newPermSubscription(subSubject))
 		}
 		if len(perms.Subscribe.Deny) > 0 {
 			c.perms.sub.deny = NewSublist()
-			// Also hold onto this array for later.
-			c.darray = perms.Subscribe.Deny
+			c.darray = nil
 		}
 		for _, subSubject := range perms.Subscribe.Deny {
-			sub := &subscription{subject: []byte(subSubject)}
+			sub := newPermSubscription(subSubject)
 			c.perms.sub.deny.Insert(sub)
+			// Also hold onto this for later, including the queue group if any.
+			c.darray = append(c.darray, subSubject)
 		}
 	}
 }
 
+// Creates the subscription for a subscribe permission subject, which
+// is a queue subscription if the permission names a queue group.
+func newPermSubscription(sq string) *subscription {
+	subject, queue := splitPermSubjectQueue(sq)
+	sub := &subscription{subject: []byte(subject)}
+	if queue != _EMPTY_ {
+		sub.queue = []byte(queue)
+	}
+	return sub
+}
+
 // Check to see if we have an expiration for the user JWT via base claims.
 // FIXME(dlc) - Clear on connect with new JWT.
 func (c *client) checkExpiration(claims *jwt.ClaimsData) {
@@ -631,7 +682,7 @@ func (c *client) checkExpiration(claims *jwt.ClaimsData) {
 func (c *client) loadMsgDenyFilter() {
 	c.mperms = &msgDeny{NewSublist(), make(map[string]bool)}
 	for _, sub := range c.darray {
-		c.mperms.deny.Insert(&subscription{subject: []byte(sub)})
+		c.mperms.deny.Insert(newPermSubscription(sub))
 	}
 }
 
@@ -765,6 +816,10 @@ func (c *client) readLoop() {
 			atomic.AddInt64(&c.inBytes, int64(c.in.bytes))
 			atomic.AddInt64(&s.inMsgs, int64(c.in.msgs))
 			atomic.AddInt64(&s.inBytes, int64(c.in.bytes))
+			if acc := c.acc; acc != nil && (c.kind == CLIENT || c.kind == LEAF) {
+				atomic.AddInt64(&acc.inMsgs, int64(c.in.msgs))
+				atomic.AddInt64(&acc.inBytes, int64(c.in.bytes))
+			}
 		}
 
 		// Budget to spend in place flushing outbound data.
@@ -1123,6 +1178,7 @@ func (c *client) processConnect(arg []byte) error {
 	c.flags.set(connectReceived)
 	// Capture these under lock
 	c.echo = c.opts.Echo
+	c.headers = c.opts.Headers
 	proto := c.opts.Protocol
 	verbose := c.opts.Verbose
 	lang := c.opts.Lang
@@ -1242,6 +1298,11 @@ func (c *client) accountAuthExpired() {
 	c.closeConnection(AuthenticationExpired)
 }
 
+func (c *client) authRevoked() {
+	c.sendErrAndDebug("User Authentication Revoked")
+	c.closeConnection(Revocation)
+}
+
 func (c *client) authViolation() {
 	var s *Server
 	var hasTrustedNkeys, hasNkeys, hasUsers bool
@@ -1565,6 +1626,83 @@ func (c *client) processPub(trace bool, arg []byte) error {
 	return nil
 }
 
+func (c *client) processHeaderPub(trace bool, arg []byte) error {
+	if !c.headers {
+		return ErrMsgHeadersNotSupported
+	}
+	if trace {
+		c.traceInOp("HPUB", arg)
+	}
+
+	// Unroll splitArgs to avoid runtime/heap issues
+	a := [MAX_HPUB_ARGS][]byte{}
+	args := a[:0]
+	start := -1
+	for i, b := range arg {
+		switch b {
+		case ' ', '\t':
+			if start >= 0 {
+				args = append(args, arg[start:i])
+				start = -1
+			}
+		default:
+			if start < 0 {
+				start = i
+			}
+		}
+	}
+	if start >= 0 {
+		args = append(args, arg[start:])
+	}
+
+	c.pa.arg = arg
+	args, err := c.processHeaderSizeArg(args)
+	if err != nil {
+		return fmt.Errorf("processHeaderPub %v: '%s'", err, arg)
+	}
+	switch len(args) {
+	case 2:
+		c.pa.subject = args[0]
+		c.pa.reply = nil
+		c.pa.size = parseSize(args[1])
+		c.pa.szb = args[1]
+	case 3:
+		c.pa.subject = args[0]
+		c.pa.reply = args[1]
+		c.pa.size = parseSize(args[2])
+		c.pa.szb = args[2]
+	default:
+		return fmt.Errorf("processHeaderPub Parse Error: '%s'", arg)
+	}
+	maxPayload := atomic.LoadInt32(&c.mpay)
+	if maxPayload != jwt.NoLimit && int32(c.pa.size) > maxPayload {
+		c.maxPayloadViolation(c.pa.size, maxPayload)
+		return ErrMaxPayload
+	}
+
+	if c.opts.Pedantic && !IsValidLiteralSubject(string(c.pa.subject)) {
+		c.sendErr("Invalid Publish Subject")
+	}
+	return nil
+}
+
+// processHeaderSizeArg pulls the header size out of the arguments of a
+// header-aware message protocol (HPUB or HMSG). The header size always
+// directly precedes the total size, which is left as the last argument.
+func (c *client) processHeaderSizeArg(args [][]byte) ([][]byte, error) {
+	n := len(args)
+	if n < 3 {
+		return nil, fmt.Errorf("Parse Error")
+	}
+	c.pa.hdb = args[n-2]
+	c.pa.hdr = parseSize(c.pa.hdb)
+	if c.pa.hdr < 0 || c.pa.hdr > parseSize(args[n-1]) {
+		return nil, fmt.Errorf("Bad or Missing Header Size")
+	}
+	args[n-2] = args[n-1]
+	return args[:n-1], nil
+}
+
 func splitArg(arg []byte) [][]byte {
 	a := [MAX_MSG_ARGS][]byte{}
 	args := a[:0]
@@ -1609,6 +1747,19 @@ func (c *client) processSub(argo []byte) (err error) {
 		sub.subject = args[0]
 		sub.queue = args[1]
 		sub.sid = args[2]
+	case 4:
+		// Queue subscription with a weight, if the client supports them.
+		if !c.opts.QueueWeights {
+			return fmt.Errorf("processSub Parse Error: '%s'", arg)
+		}
+		sub.subject = args[0]
+		sub.queue = args[1]
+		sub.sid = args[2]
+		w := parseSize(args[3])
+		if w <= 0 || w > MAX_QUEUE_WEIGHT {
+			return fmt.Errorf("processSub Bad or Missing Queue Weight: '%s'", arg)
+		}
+		sub.qw = int32(w)
 	default:
 		return fmt.Errorf("processSub Parse Error: '%s'", arg)
 	}
@@ -1628,7 +1779,7 @@ func (c *client) processSub(argo []byte) (err error) {
 	}
 
 	// Check permissions if applicable.
-	if kind == CLIENT && !c.canSubscribe(string(sub.subject)) {
+	if kind == CLIENT && !c.canSubscribe(string(sub.subject), string(sub.queue)) {
 		c.mu.Unlock()
 		c.sendErr(fmt.Sprintf("Permissions Violation for Subscription to %q", sub.subject))
 		c.Errorf("Subscription Violation - %s, Subject %q, SID %s",
@@ -1677,7 +1828,7 @@ func (c *client) processSub(argo []byte) (err error) {
 	}
 	// If we are routing and this is a local sub, add to the route map for the associated account.
 	if kind == CLIENT || kind == SYSTEM {
-		srv.updateRouteSubscriptionMap(acc, sub, 1)
+		srv.updateRouteSubscriptionMap(acc, sub, sub.queueWeight())
 		if updateGWs {
 			srv.gatewayUpdateSubInterest(acc.Name, sub, 1)
 		}
@@ -1808,8 +1959,9 @@ func (c *client) addShadowSub(sub *subscription, im *streamImport, useFrom bool)
 }
 
 // canSubscribe determines if the client is authorized to subscribe to the
-// given subject. Assumes caller is holding lock.
-func (c *client) canSubscribe(subject string) bool {
+// given subject, with the given queue group if not empty.
+// Assumes caller is holding lock.
+func (c *client) canSubscribe(subject, queue string) bool {
 	if c.perms == nil {
 		return true
 	}
@@ -1820,11 +1972,19 @@ func (c *client) canSubscribe(subject string) bool {
 	if c.perms.sub.allow != nil {
 		r := c.perms.sub.allow.Match(subject)
 		allowed = len(r.psubs) != 0
+		// If queue groups are allowed for the subject, a queue
+		// subscription has to be in one of them.
+		if queue != _EMPTY_ && len(r.qsubs) > 0 {
+			allowed = queueMatches(queue, r.qsubs)
+		}
 	}
 	// If we have a deny list and we think we are allowed, check that as well.
 	if allowed && c.perms.sub.deny != nil {
 		r := c.perms.sub.deny.Match(subject)
 		allowed = len(r.psubs) == 0
+		if allowed && queue != _EMPTY_ && len(r.qsubs) > 0 {
+			allowed = !queueMatches(queue, r.qsubs)
+		}
 
 		// We use the actual subscription to signal us to spin up the deny mperms
 		// and cache. We check if the subject is a wildcard that contains any of
@@ -1844,6 +2004,18 @@ func (c *client) canSubscribe(subject string) bool {
 	return allowed
 }
 
+// queueMatches returns true if the queue group is one of the queue groups
+// of the permissions, which may contain wildcards.
+func queueMatches(queue string, qsubs [][]*subscription) bool {
+	for _, qsub := range qsubs {
+		qname := string(qsub[0].queue)
+		if queue == qname || (subjectHasWildcard(qname) && matchLiteral(queue, qname)) {
+			return true
+		}
+	}
+	return false
+}
+
 // Low level unsubscribe for a given client.
 func (c *client) unsubscribe(acc *Account, sub *subscription, force bool) {
 	c.mu.Lock()
@@ -1934,7 +2106,7 @@ func (c *client) processUnsub(arg []byte) error {
 	if unsub {
 		c.unsubscribe(acc, sub, false)
 		if acc != nil && kind == CLIENT || kind == SYSTEM {
-			srv.updateRouteSubscriptionMap(acc, sub, -1)
+			srv.updateRouteSubscriptionMap(acc, sub, -sub.queueWeight())
 			if updateGWs {
 				srv.gatewayUpdateSubInterest(acc.Name, sub, -1)
 			}
@@ -1949,15 +2121,21 @@ func (c *client) processUnsub(arg []byte) error {
 // checkDenySub will check if we are allowed to deliver this message in the
 // presence of deny clauses for subscriptions. Deny clauses will not prevent
 // larger scoped wildcard subscriptions, so we need to check at delivery time.
+// Deny clauses with a queue group only apply to members of that group.
 // Lock should be held.
-func (c *client) checkDenySub(subject string) bool {
-	if denied, ok := c.mperms.dcache[subject]; ok {
+func (c *client) checkDenySub(subject string, queue []byte) bool {
+	key := subject
+	if len(queue) > 0 {
+		key = subject + " " + string(queue)
+	}
+	if denied, ok := c.mperms.dcache[key]; ok {
 		return denied
-	} else if r := c.mperms.deny.Match(subject); len(r.psubs) != 0 {
-		c.mperms.dcache[subject] = true
+	} else if r := c.mperms.deny.Match(subject); len(r.psubs) != 0 ||
+		(len(queue) > 0 && len(r.qsubs) > 0 && queueMatches(string(queue), r.qsubs)) {
+		c.mperms.dcache[key] = true
 		return true
 	} else {
-		c.mperms.dcache[subject] = false
+		c.mperms.dcache[key] = false
 	}
 	if len(c.mperms.dcache) > maxDenyPermCacheSize {
 		c.pruneDenyCache()
@@ -1966,6 +2144,12 @@ func (c *client) checkDenySub(subject string) bool {
 }
 
 func (c *client) msgHeader(mh []byte, sub *subscription, reply []byte) []byte {
+	if c.pa.hdr > 0 && sub.client != nil && sub.client.headers {
+		// mh always starts at c.msgb[1:], so extend back over
+		// the first byte of the scratch buffer for HMSG.
+		mh = c.msgb[:len(mh)+1]
+		mh[0] = 'H'
+	}
 	if len(sub.sid) > 0 {
 		mh = append(mh, sub.sid...)
 		mh = append(mh, ' ')
@@ -1974,11 +2158,26 @@ func (c *client) msgHeader(mh []byte, sub *subscription, reply []byte) []byte {
 		mh = append(mh, reply...)
 		mh = append(mh, ' ')
 	}
-	mh = append(mh, c.pa.szb...)
+	mh = c.appendMsgSizes(mh, sub.client != nil && sub.client.headers)
 	mh = append(mh, _CRLF_...)
 	return mh
 }
 
+// appendMsgSizes appends the size arguments for a message being delivered.
+// If the message has headers, both the header and total sizes are used when
+// the receiving connection supports headers, otherwise the headers will be
+// stripped from the message and only the payload size is used.
+func (c *client) appendMsgSizes(mh []byte, headers bool) []byte {
+	if c.pa.hdr > 0 {
+		if !headers {
+			return strconv.AppendInt(mh, int64(c.pa.size-c.pa.hdr), 10)
+		}
+		mh = append(mh, c.pa.hdb...)
+		mh = append(mh, ' ')
+	}
+	return append(mh, c.pa.szb...)
+}
+
 func (c *client) stalledWait(producer *client) {
 	stall := c.out.stc
 	c.mu.Unlock()
@@ -2012,7 +2211,7 @@ func (c *client) deliverMsg(sub *subscription, mh, msg []byte) bool {
 
 	// Check if we have a subscribe deny clause. This will trigger us to check the subject
 	// for a match against the denied subjects.
-	if client.mperms != nil && client.checkDenySub(string(c.pa.subject)) {
+	if client.mperms != nil && client.checkDenySub(string(c.pa.subject), sub.queue) {
 		client.mu.Unlock()
 		return false
 	}
@@ -2037,7 +2236,7 @@ func (c *client) deliverMsg(sub *subscription, mh, msg []byte) bool {
 				// Due to defer, reverse the code order so that execution
 				// is consistent with other cases where we unsubscribe.
 				if shouldForward {
-					defer srv.updateRouteSubscriptionMap(client.acc, sub, -1)
+					defer srv.updateRouteSubscriptionMap(client.acc, sub, -sub.queueWeight())
 				}
 				defer client.unsubscribe(client.acc, sub, true)
 			} else if sub.nm > sub.max {
@@ -2045,13 +2244,26 @@ func (c *client) deliverMsg(sub *subscription, mh, msg []byte) bool {
 				client.mu.Unlock()
 				client.unsubscribe(client.acc, sub, true)
 				if shouldForward {
-					srv.updateRouteSubscriptionMap(client.acc, sub, -1)
+					srv.updateRouteSubscriptionMap(client.acc, sub, -sub.queueWeight())
 				}
 				return false
 			}
 		}
 	}
 
+	// Track the reply subject if the client is allowed to respond to it.
+	if client.replies != nil && len(c.pa.reply) > 0 {
+		client.replies[string(c.pa.reply)] = &resp{time.Now(), 0}
+		if len(client.replies) > replyPermLimit {
+			client.pruneReplyPerms()
+		}
+	}
+
+	// Strip the headers if the receiving connection does not support them.
+	if c.pa.hdr > 0 && !client.headers {
+		msg = msg[c.pa.hdr:]
+	}
+
 	// Update statistics
 
 	// The msg includes the CR_LF, so pull back out for accounting.
@@ -2064,12 +2276,29 @@ func (c *client) deliverMsg(sub *subscription, mh, msg []byte) bool {
 
 	atomic.AddInt64(&srv.outMsgs, 1)
 	atomic.AddInt64(&srv.outBytes, msgSize)
+	if acc := client.acc; acc != nil && (client.kind == CLIENT || client.kind == LEAF) {
+		atomic.AddInt64(&acc.outMsgs, 1)
+		atomic.AddInt64(&acc.outBytes, msgSize)
+	}
 
 	// Check for internal subscription.
 	if client.kind == SYSTEM {
 		s := client.srv
+		icb, hicb := sub.icb, sub.hicb
 		client.mu.Unlock()
-		s.deliverInternalMsg(sub, c.pa.subject, c.pa.reply, msg[:msgSize])
+		// Internal clients that support headers get them split from the payload.
+		var hdr []byte
+		msg = msg[:msgSize]
+		if c.pa.hdr > 0 && client.headers {
+			hdr, msg = msg[:c.pa.hdr], msg[c.pa.hdr:]
+		}
+		if hicb != nil {
+			hicb(sub, string(c.pa.subject), string(c.pa.reply), hdr, msg)
+		} else if icb != nil {
+			icb(sub, string(c.pa.subject), string(c.pa.reply), msg)
+		} else {
+			s.deliverInternalMsg(sub, c.pa.subject, c.pa.reply, msg)
+		}
 		return true
 	}
 
@@ -2143,8 +2372,31 @@ func (c *client) prunePubPermsCache() {
 	}
 }
 
+// Removes the reply subjects the client can no longer respond to.
+// Lock is held on entry.
+func (c *client) pruneReplyPerms() {
+	rp := c.perms.resp
+	if rp == nil {
+		return
+	}
+	now := time.Now()
+	for k, r := range c.replies {
+		if (rp.MaxMsgs > 0 && r.n >= rp.MaxMsgs) || (rp.Expires > 0 && now.Sub(r.t) > rp.Expires) {
+			delete(c.replies, k)
+		}
+	}
+}
+
 // pubAllowed checks on publish permissioning.
+// Lock should not be held.
 func (c *client) pubAllowed(subject string) bool {
+	return c.pubAllowedFullCheck(subject, true)
+}
+
+// pubAllowedFullCheck checks on publish permissioning, including the reply
+// subjects the client may respond to if fullCheck is true, in which case
+// the lock should not be held.
+func (c *client) pubAllowedFullCheck(subject string, fullCheck bool) bool {
 	if c.perms == nil || (c.perms.pub.allow == nil && c.perms.pub.deny == nil) {
 		return true
 	}
@@ -2166,6 +2418,25 @@ func (c *client) pubAllowed(subject string) bool {
 		r := c.perms.pub.deny.Match(subject)
 		allowed = len(r.psubs) == 0
 	}
+	// If not allowed, this may be a response to a message delivered
+	// to the client. Those are not cached since they are consumed.
+	if !allowed && fullCheck && c.perms.resp != nil {
+		c.mu.Lock()
+		if r := c.replies[subject]; r != nil {
+			rp := c.perms.resp
+			r.n++
+			if rp.Expires > 0 && time.Since(r.t) > rp.Expires {
+				delete(c.replies, subject)
+			} else {
+				allowed = true
+				if rp.MaxMsgs > 0 && r.n >= rp.MaxMsgs {
+					delete(c.replies, subject)
+				}
+			}
+		}
+		c.mu.Unlock()
+		return allowed
+	}
 	// Update our cache here.
 	c.perms.pcache[string(subject)] = allowed
 	// Prune if needed.
@@ -2252,6 +2523,34 @@ func (c *client) processInboundClientMsg(msg []byte) {
 		return
 	}
 
+	// Enforce the rate limits of the client and its account. Internal
+	// clients, such as the system, stream and authorization callout
+	// clients, are never limited.
+	if c.kind == CLIENT && !c.checkRateLimits(c.acc) {
+		return
+	}
+
+	// Hold messages to be delivered later. They are processed again when
+	// due, by an internal client of the account.
+	if c.kind == CLIENT && c.pa.hdr > 0 && c.scheduleMsg(msg) {
+		return
+	}
+
+	// Drop messages with an ID already seen within the dedupe window.
+	if c.pa.hdr > 0 && c.acc.isDuplicateMsg(c.pa.subject, msg[:c.pa.hdr]) {
+		atomic.AddInt64(&c.acc.dupMsgs, 1)
+		atomic.AddInt64(&c.srv.dupMsgs, 1)
+		c.Debugf("Dropping duplicate message on %q", c.pa.subject)
+		return
+	}
+
+	// Apply the subject mappings of the account before matching.
+	if c.acc.hasMappings() {
+		if subject, ok := c.acc.selectMappedSubject(string(c.pa.subject)); ok {
+			c.pa.subject = []byte(subject)
+		}
+	}
+
 	// Match the subscriptions. We will use our own L1 map if
 	// it's still valid, avoiding contention on the shared sublist.
 	var r *SublistResult
@@ -2494,8 +2793,9 @@ func (c *client) processMsgResults(acc *Account, r *SublistResult, msg, subject,
 		var rsub *subscription
 
 		// Find a subscription that is able to deliver this message
-		// starting at a random index.
-		startIndex := c.in.prand.Intn(len(qsubs))
+		// starting at the index selected by the queue group strategy,
+		// random by default.
+		startIndex := c.queueStartIndex(acc, qsubs, subject)
 		for i := 0; i < len(qsubs); i++ {
 			index := (startIndex + i) % len(qsubs)
 			sub := qsubs[index]
@@ -2564,15 +2864,25 @@ sendToRoutesOrLeafs:
 	for i := range c.in.rts {
 		rt := &c.in.rts[i]
 		kind := rt.sub.client.kind
+		headers := c.pa.hdr > 0 && rt.sub.client.headers
 		mh := c.msgb[:msgHeadProtoLen]
 		if kind == ROUTER {
 			// Router (and Gateway) nodes are RMSG. Set here since leafnodes may rewrite.
-			mh[0] = 'R'
+			// Messages with headers are HMSG if the route supports them.
+			if headers {
+				mh[0] = 'H'
+			} else {
+				mh[0] = 'R'
+			}
 			mh = append(mh, acc.Name...)
 			mh = append(mh, ' ')
 		} else {
-			// Leaf nodes are LMSG
-			mh[0] = 'L'
+			// Leaf nodes are LMSG, or HMSG for messages with headers.
+			if headers {
+				mh[0] = 'H'
+			} else {
+				mh[0] = 'L'
+			}
 			// Remap subject if its a shadow subscription, treat like a normal client.
 			if rt.sub.im != nil && rt.sub.im.prefix != "" {
 				mh = append(mh, rt.sub.im.prefix...)
@@ -2594,7 +2904,7 @@ sendToRoutesOrLeafs:
 			mh = append(mh, reply...)
 			mh = append(mh, ' ')
 		}
-		mh = append(mh, c.pa.szb...)
+		mh = c.appendMsgSizes(mh, headers)
 		mh = append(mh, _CRLF_...)
 		c.deliverMsg(rt.sub, mh, msg)
 	}
@@ -2780,7 +3090,7 @@ func (c *client) processSubsOnConfigReload(awcsti map[string]struct{}) {
 	for _, sub := range c.subs {
 		// Just checking to rebuild mperms under the lock, will collect removed though here.
 		// Only collect under subs array of canSubscribe and checkAcc true.
-		if !c.canSubscribe(string(sub.subject)) {
+		if !c.canSubscribe(string(sub.subject), string(sub.queue)) {
 			removed = append(removed, sub)
 		} else if checkAcc {
 			subs = append(subs, sub)
@@ -2814,6 +3124,8 @@ func (c *client) processSubsOnConfigReload(awcsti map[string]struct{}) {
 type qsub struct {
 	sub *subscription
 	n   int32
+	// Sum of the weights, for the routes.
+	w int32
 }
 
 func (c *client) closeConnection(reason ClosedState) {
@@ -2835,6 +3147,11 @@ func (c *client) closeConnection(reason ClosedState) {
 	c.clearPingTimer()
 	c.clearConnection(reason)
 	c.nc = nil
+	// Interrupt a publish delayed by a rate limit.
+	if c.rdch != nil {
+		close(c.rdch)
+		c.rdch = nil
+	}
 
 	var (
 		retryImplicit bool
@@ -2908,8 +3225,9 @@ func (c *client) closeConnection(reason ClosedState) {
 					key := string(sub.subject) + " " + string(sub.queue)
 					if esub, ok := qsubs[key]; ok {
 						esub.n++
+						esub.w += sub.queueWeight()
 					} else {
-						qsubs[key] = &qsub{sub, 1}
+						qsubs[key] = &qsub{sub, 1, sub.queueWeight()}
 					}
 				}
 				if srv.gateway.enabled {
@@ -2920,7 +3238,7 @@ func (c *client) closeConnection(reason ClosedState) {
 			}
 			// Process any qsubs here.
 			for _, esub := range qsubs {
-				srv.updateRouteSubscriptionMap(acc, esub.sub, -(esub.n))
+				srv.updateRouteSubscriptionMap(acc, esub.sub, -(esub.w))
 				srv.updateLeafNodes(acc, esub.sub, -(esub.n))
 			}
 			if prev := acc.removeClient(c); prev == 1 && srv != nil {
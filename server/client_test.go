@@ -418,6 +418,61 @@ func TestClientNoBodyPubSubWithReply(t *testing.T) {
 	}
 }
 
+func TestClientHeaderPubSub(t *testing.T) {
+	s, c, cr := setupClient()
+	defer s.Shutdown()
+
+	// Publishing with headers is refused unless negotiated in CONNECT.
+	if err := c.parse([]byte("HPUB foo 12 17\r\nNATS/1.0\r\n\r\nhello\r\n")); err != ErrMsgHeadersNotSupported {
+		t.Fatalf("Expected error %v, got %v", ErrMsgHeadersNotSupported, err)
+	}
+	c.state = OP_START
+
+	if err := c.parse([]byte("CONNECT {\"headers\":true,\"verbose\":false}\r\n")); err != nil {
+		t.Fatalf("Received error: %v\n", err)
+	}
+	go c.parse([]byte("SUB foo 1\r\nHPUB foo bar 12 17\r\nNATS/1.0\r\n\r\nhello\r\nPING\r\n"))
+	l, err := cr.ReadString('\n')
+	if err != nil {
+		t.Fatalf("Error receiving msg from server: %v\n", err)
+	}
+	if l != "HMSG foo 1 bar 12 17\r\n" {
+		t.Fatalf("Unexpected HMSG: %q", l)
+	}
+	checkPayload(cr, []byte("NATS/1.0\r\n\r\nhello\r\n"), t)
+}
+
+func TestClientHeadersStrippedForNonHeaderClient(t *testing.T) {
+	s, c, _ := setupClient()
+	defer s.Shutdown()
+
+	if err := c.parse([]byte("CONNECT {\"headers\":true,\"verbose\":false}\r\n")); err != nil {
+		t.Fatalf("Received error: %v\n", err)
+	}
+
+	// Subscriber that did not opt in to headers.
+	sc, scr, _ := newClientForServer(s)
+	defer sc.nc.Close()
+	go sc.parse([]byte("SUB foo 1\r\nPING\r\n"))
+	if l, _ := scr.ReadString('\n'); l != "PONG\r\n" {
+		t.Fatalf("Expected PONG, got %q", l)
+	}
+
+	go c.parseAndFlush([]byte("HPUB foo 12 17\r\nNATS/1.0\r\n\r\nhello\r\n"))
+	l, err := scr.ReadString('\n')
+	if err != nil {
+		t.Fatalf("Error receiving msg from server: %v\n", err)
+	}
+	matches := msgPat.FindAllStringSubmatch(l, -1)[0]
+	if matches[SUB_INDEX] != "foo" {
+		t.Fatalf("Did not get correct subject: '%s'\n", matches[SUB_INDEX])
+	}
+	if matches[LEN_INDEX] != "5" {
+		t.Fatalf("Did not get correct msg length: '%s'\n", matches[LEN_INDEX])
+	}
+	checkPayload(scr, []byte("hello\r\n"), t)
+}
+
 // This needs to clear any flushOutbound flags since writeLoop not running.
 func (c *client) parseAndFlush(op []byte) {
 	c.parse(op)
@@ -124,6 +124,17 @@ const (
 	// MAX_PUB_ARGS Maximum possible number of arguments from PUB proto.
 	MAX_PUB_ARGS = 3
 
+	// MAX_HPUB_ARGS Maximum possible number of arguments from HPUB proto.
+	MAX_HPUB_ARGS = 4
+
+	// MAX_QUEUE_WEIGHT is the maximum weight of a queue subscription. Remote
+	// servers repeat a queue subscription as many times as its weight.
+	MAX_QUEUE_WEIGHT = 100
+
+	// DEFAULT_DEDUPE_MAX_IDS is the default maximum number of message IDs
+	// tracked per account for deduplication.
+	DEFAULT_DEDUPE_MAX_IDS = 100000
+
 	// DEFAULT_MAX_CLOSED_CLIENTS is the maximum number of closed connections we hold onto.
 	DEFAULT_MAX_CLOSED_CLIENTS = 10000
 
@@ -144,4 +155,64 @@ const (
 	// repeated failed route, gateway or leaf node connection is reported. The default
 	// corresponds to a report every hour.
 	DEFAULT_CONNECTION_ERROR_REPORT_ATTEMPTS = 3600
+
+	// DEFAULT_WEBSOCKET_HANDSHAKE_TIMEOUT is the time allowed for a websocket
+	// client to complete the HTTP upgrade.
+	DEFAULT_WEBSOCKET_HANDSHAKE_TIMEOUT = 2 * time.Second
+
+	// DEFAULT_STREAMS_STORE_DIR is the directory, relative to the working
+	// directory, where streams are stored if not configured.
+	DEFAULT_STREAMS_STORE_DIR = "nats-streams"
+
+	// DEFAULT_STREAM_ACK_WAIT is the time a consumer waits for an ack
+	// before redelivering a message.
+	DEFAULT_STREAM_ACK_WAIT = 30 * time.Second
+
+	// DEFAULT_MQTT_ACK_WAIT is the time an MQTT QoS 1 message waits for
+	// a PUBACK before being redelivered.
+	DEFAULT_MQTT_ACK_WAIT = 30 * time.Second
+
+	// DEFAULT_MQTT_MAX_ACK_PENDING is the maximum number of QoS 1 messages
+	// waiting for a PUBACK per session. Past that, messages are sent with QoS 0.
+	DEFAULT_MQTT_MAX_ACK_PENDING = 1024
+
+	// DEFAULT_AUTH_CALLOUT_SUBJECT is the subject authorization requests
+	// are sent on if not configured.
+	DEFAULT_AUTH_CALLOUT_SUBJECT = "$SYS.REQ.USER.AUTH"
+
+	// DEFAULT_AUTH_CALLOUT_TIMEOUT is the time the server waits for the
+	// response of the authorization service.
+	DEFAULT_AUTH_CALLOUT_TIMEOUT = 2 * time.Second
+
+	// DEFAULT_ACCOUNT_RESOLVER_CACHE_TTL is the time after which cached
+	// account claims are fetched again from the account resolver.
+	DEFAULT_ACCOUNT_RESOLVER_CACHE_TTL = 2 * time.Minute
+
+	// DEFAULT_ACCOUNT_RESOLVER_CACHE_NEGATIVE_TTL is the time a failure to
+	// fetch account claims from the account resolver is cached.
+	DEFAULT_ACCOUNT_RESOLVER_CACHE_NEGATIVE_TTL = 5 * time.Second
+
+	// DEFAULT_ACCOUNT_RESOLVER_REFRESH is how often the claims of accounts
+	// with connections are fetched from a caching account resolver.
+	DEFAULT_ACCOUNT_RESOLVER_REFRESH = time.Minute
+
+	// DEFAULT_ACCOUNT_CLAIMS_LOOKUP_WAIT is the time a server with a directory
+	// account resolver waits for other servers to send claims it is missing.
+	DEFAULT_ACCOUNT_CLAIMS_LOOKUP_WAIT = time.Second
+
+	// DEFAULT_ALLOW_RESPONSE_MAX_MSGS is the number of responses allowed
+	// to a reply subject if not configured.
+	DEFAULT_ALLOW_RESPONSE_MAX_MSGS = 1
+
+	// DEFAULT_ALLOW_RESPONSE_EXPIRATION is how long responses are allowed
+	// to a reply subject if not configured.
+	DEFAULT_ALLOW_RESPONSE_EXPIRATION = 2 * time.Minute
+
+	// DEFAULT_MAX_SCHEDULED_MSGS is the maximum number of messages waiting
+	// for delivery per account if not configured.
+	DEFAULT_MAX_SCHEDULED_MSGS = 10000
+
+	// DEFAULT_SCHEDULE_TICK is the resolution of the delivery time of
+	// scheduled messages.
+	DEFAULT_SCHEDULE_TICK = 100 * time.Millisecond
 )
@@ -0,0 +1,758 @@
+// Copyright 2019 The NATS Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package server
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// DeliverPolicy determines where in the stream a consumer starts.
+type DeliverPolicy string
+
+const (
+	// DeliverAll starts with the first message in the stream.
+	DeliverAll = DeliverPolicy("all")
+	// DeliverLast starts with the last message in the stream.
+	DeliverLast = DeliverPolicy("last")
+	// DeliverNew starts with messages stored after the consumer is created.
+	DeliverNew = DeliverPolicy("new")
+	// DeliverByStartSequence starts with the message at OptStartSeq.
+	DeliverByStartSequence = DeliverPolicy("by_start_sequence")
+	// DeliverByStartTime starts with the first message stored at or after OptStartTime.
+	DeliverByStartTime = DeliverPolicy("by_start_time")
+)
+
+// AckPolicy determines how a consumer acknowledges messages.
+type AckPolicy string
+
+const (
+	// AckNone requires no acks, messages are never redelivered.
+	AckNone = AckPolicy("none")
+	// AckAll acks a message and all messages delivered before it.
+	AckAll = AckPolicy("all")
+	// AckExplicit requires each message to be acked individually.
+	AckExplicit = AckPolicy("explicit")
+)
+
+const (
+	// Ack payloads. An empty payload is also treated as an ack.
+	streamAckAck = "+ACK"
+	streamAckNak = "-NAK"
+
+	// Name of the file holding the delivery state of a consumer.
+	consumerStateFile = "state.json"
+	// How long state changes can be buffered before being written.
+	consumerStateFlushInterval = 100 * time.Millisecond
+)
+
+var (
+	// ErrConsumerNotFound is returned when a consumer does not exist.
+	ErrConsumerNotFound = errors.New("consumer not found")
+	// ErrConsumerExists is returned when creating a consumer that already
+	// exists with a different configuration.
+	ErrConsumerExists = errors.New("consumer name already in use")
+	// ErrConsumerNotPull is returned when requesting messages from a push consumer.
+	ErrConsumerNotPull = errors.New("consumer is push based")
+)
+
+// ConsumerConfig determines how messages of a stream are delivered. When
+// DeliverSubject is set the consumer is push based, otherwise messages are
+// pulled with requests to the next message API subject.
+type ConsumerConfig struct {
+	Durable        string        `json:"durable_name"`
+	DeliverSubject string        `json:"deliver_subject,omitempty"`
+	DeliverPolicy  DeliverPolicy `json:"deliver_policy,omitempty"`
+	OptStartSeq    uint64        `json:"opt_start_seq,omitempty"`
+	OptStartTime   *time.Time    `json:"opt_start_time,omitempty"`
+	AckPolicy      AckPolicy     `json:"ack_policy,omitempty"`
+	AckWait        time.Duration `json:"ack_wait,omitempty"`
+	MaxDeliver     int           `json:"max_deliver,omitempty"`
+	FilterSubject  string        `json:"filter_subject,omitempty"`
+}
+
+// SequencePair has both the consumer and the stream sequence.
+type SequencePair struct {
+	ConsumerSeq uint64 `json:"consumer_seq"`
+	StreamSeq   uint64 `json:"stream_seq"`
+}
+
+// ConsumerInfo is returned by consumer create and info requests.
+type ConsumerInfo struct {
+	Stream         string         `json:"stream_name"`
+	Name           string         `json:"name"`
+	Config         ConsumerConfig `json:"config"`
+	Created        time.Time      `json:"created"`
+	Delivered      SequencePair   `json:"delivered"`
+	AckFloor       SequencePair   `json:"ack_floor"`
+	NumPending     int            `json:"num_ack_pending"`
+	NumRedelivered int            `json:"num_redelivered"`
+	NumWaiting     int            `json:"num_waiting"`
+}
+
+// A delivered message awaiting an ack.
+type pendingMsg struct {
+	Seq uint64 `json:"seq"`
+	Ts  int64  `json:"ts"`
+}
+
+// Persisted delivery state of a consumer.
+type consumerState struct {
+	Delivered   SequencePair           `json:"delivered"`
+	AckFloor    SequencePair           `json:"ack_floor"`
+	Pending     map[uint64]*pendingMsg `json:"pending,omitempty"`
+	Redelivered map[uint64]uint64      `json:"redelivered,omitempty"`
+}
+
+// Persisted configuration of a consumer.
+type consumerMeta struct {
+	Config  ConsumerConfig `json:"config"`
+	Created time.Time      `json:"created"`
+}
+
+// A pull request waiting for messages.
+type nextMsgReq struct {
+	reply string
+	n     int
+}
+
+// Consumer tracks the delivery of messages of a stream.
+type Consumer struct {
+	mu      sync.Mutex
+	mset    *Stream
+	stream  string
+	name    string
+	config  ConsumerConfig
+	created time.Time
+	dir     string
+	sseq    uint64
+	dseq    uint64
+	adflr   uint64
+	asflr   uint64
+	pending map[uint64]*pendingMsg
+	rdc     map[uint64]uint64
+	rdq     []uint64
+	waiting []*nextMsgReq
+	sigch   chan struct{}
+	qch     chan struct{}
+	ptmr    *time.Timer
+	stmr    *time.Timer
+	closed  bool
+}
+
+// Checks and sets defaults for a consumer configuration.
+func checkConsumerConfig(config *ConsumerConfig, scfg *StreamConfig) (ConsumerConfig, error) {
+	cfg := *config
+	if !validStreamName(cfg.Durable) {
+		return cfg, ErrStreamNameInvalid
+	}
+	if ds := cfg.DeliverSubject; ds != _EMPTY_ {
+		if !IsValidLiteralSubject(ds) {
+			return cfg, fmt.Errorf("invalid deliver subject %q", ds)
+		}
+		if strings.HasPrefix(ds, streamReservedPrefix) {
+			return cfg, fmt.Errorf("deliver subject %q is reserved", ds)
+		}
+	}
+	if fs := cfg.FilterSubject; fs != _EMPTY_ {
+		if !IsValidSubject(fs) {
+			return cfg, fmt.Errorf("invalid filter subject %q", fs)
+		}
+		match := false
+		for _, subj := range scfg.Subjects {
+			if subjectIsSubsetMatch(fs, subj) {
+				match = true
+				break
+			}
+		}
+		if !match {
+			return cfg, fmt.Errorf("filter subject %q does not match any stream subject", fs)
+		}
+	}
+	switch cfg.DeliverPolicy {
+	case _EMPTY_:
+		cfg.DeliverPolicy = DeliverAll
+	case DeliverAll, DeliverLast, DeliverNew:
+	case DeliverByStartSequence:
+		if cfg.OptStartSeq == 0 {
+			return cfg, fmt.Errorf("start sequence required for deliver policy %q", cfg.DeliverPolicy)
+		}
+	case DeliverByStartTime:
+		if cfg.OptStartTime == nil {
+			return cfg, fmt.Errorf("start time required for deliver policy %q", cfg.DeliverPolicy)
+		}
+	default:
+		return cfg, fmt.Errorf("unknown deliver policy %q", cfg.DeliverPolicy)
+	}
+	if cfg.DeliverPolicy != DeliverByStartSequence && cfg.OptStartSeq != 0 {
+		return cfg, fmt.Errorf("start sequence only valid for deliver policy %q", DeliverByStartSequence)
+	}
+	if cfg.DeliverPolicy != DeliverByStartTime && cfg.OptStartTime != nil {
+		return cfg, fmt.Errorf("start time only valid for deliver policy %q", DeliverByStartTime)
+	}
+	switch cfg.AckPolicy {
+	case _EMPTY_:
+		cfg.AckPolicy = AckExplicit
+	case AckNone, AckAll, AckExplicit:
+	default:
+		return cfg, fmt.Errorf("unknown ack policy %q", cfg.AckPolicy)
+	}
+	if cfg.AckWait < 0 || cfg.MaxDeliver < 0 {
+		return cfg, fmt.Errorf("ack wait and max deliver can not be negative")
+	}
+	if cfg.AckWait == 0 {
+		cfg.AckWait = DEFAULT_STREAM_ACK_WAIT
+	}
+	return cfg, nil
+}
+
+// AddConsumer will create a consumer for the stream. Creating a consumer
+// that already exists with the same configuration is not an error.
+func (mset *Stream) AddConsumer(config *ConsumerConfig) (*Consumer, error) {
+	if config == nil {
+		return nil, fmt.Errorf("consumer configuration missing")
+	}
+	mset.mu.Lock()
+	scfg := mset.config
+	mset.mu.Unlock()
+
+	cfg, err := checkConsumerConfig(config, &scfg)
+	if err != nil {
+		return nil, err
+	}
+
+	// Deliver subjects can not be captured by any stream.
+	as := mset.as
+	as.mu.Lock()
+	defer as.mu.Unlock()
+	if cfg.DeliverSubject != _EMPTY_ {
+		mset.mu.Lock()
+		o := mset.consumers[cfg.Durable]
+		mset.mu.Unlock()
+		if o == nil && as.subjectInUse(cfg.DeliverSubject, nil) {
+			return nil, ErrStreamSubjectOverlap
+		}
+	}
+
+	mset.mu.Lock()
+	defer mset.mu.Unlock()
+	if mset.closed {
+		return nil, ErrStreamNotFound
+	}
+	if o := mset.consumers[cfg.Durable]; o != nil {
+		if o.config.sameAs(&cfg) {
+			return o, nil
+		}
+		return nil, ErrConsumerExists
+	}
+
+	o := mset.newConsumer(&cfg, time.Now().UTC())
+	state := mset.store.state()
+	switch cfg.DeliverPolicy {
+	case DeliverAll:
+		o.sseq = state.FirstSeq
+	case DeliverLast:
+		o.sseq = state.LastSeq
+		if o.sseq < state.FirstSeq {
+			o.sseq = state.FirstSeq
+		}
+	case DeliverNew:
+		o.sseq = state.LastSeq + 1
+	case DeliverByStartSequence:
+		o.sseq = cfg.OptStartSeq
+	case DeliverByStartTime:
+		o.sseq = mset.store.seqForTime(*cfg.OptStartTime)
+	}
+	o.asflr = o.sseq - 1
+	if err := writeStreamMeta(o.dir, &consumerMeta{Config: cfg, Created: o.created}); err != nil {
+		return nil, err
+	}
+	if err := o.writeState(); err != nil {
+		os.RemoveAll(o.dir)
+		return nil, err
+	}
+	mset.consumers[cfg.Durable] = o
+	o.start()
+	return o, nil
+}
+
+// Create the consumer structure.
+// Lock should be held.
+func (mset *Stream) newConsumer(cfg *ConsumerConfig, created time.Time) *Consumer {
+	return &Consumer{
+		mset:    mset,
+		stream:  mset.config.Name,
+		name:    cfg.Durable,
+		config:  *cfg,
+		created: created,
+		dir:     filepath.Join(mset.store.dir, streamConsumersDir, cfg.Durable),
+		sseq:    1,
+		dseq:    1,
+		pending: make(map[uint64]*pendingMsg),
+		rdc:     make(map[uint64]uint64),
+		sigch:   make(chan struct{}, 1),
+		qch:     make(chan struct{}),
+	}
+}
+
+// Restore all consumers of the stream from the store directory.
+func (mset *Stream) restoreConsumers() {
+	s := mset.as.sm.srv
+	cdir := filepath.Join(mset.store.dir, streamConsumersDir)
+	fis, _ := ioutil.ReadDir(cdir)
+
+	mset.mu.Lock()
+	defer mset.mu.Unlock()
+	for _, fi := range fis {
+		if !fi.IsDir() {
+			continue
+		}
+		dir := filepath.Join(cdir, fi.Name())
+		var meta consumerMeta
+		if err := readStreamMeta(dir, &meta); err != nil {
+			s.Warnf("Could not restore consumer from %q: %v", dir, err)
+			continue
+		}
+		o := mset.newConsumer(&meta.Config, meta.Created)
+		if err := o.readState(); err != nil {
+			s.Warnf("Could not restore state of consumer %q: %v", o.name, err)
+			continue
+		}
+		mset.consumers[o.name] = o
+		o.start()
+	}
+}
+
+// LookupConsumer returns the consumer with the given name.
+func (mset *Stream) LookupConsumer(name string) (*Consumer, error) {
+	mset.mu.Lock()
+	o := mset.consumers[name]
+	mset.mu.Unlock()
+	if o == nil {
+		return nil, ErrConsumerNotFound
+	}
+	return o, nil
+}
+
+// Returns the sorted names of all consumers of the stream.
+func (mset *Stream) consumerNames() []string {
+	mset.mu.Lock()
+	names := make([]string, 0, len(mset.consumers))
+	for name := range mset.consumers {
+		names = append(names, name)
+	}
+	mset.mu.Unlock()
+	sort.Strings(names)
+	return names
+}
+
+func (cfg *ConsumerConfig) sameAs(ocfg *ConsumerConfig) bool {
+	if (cfg.OptStartTime == nil) != (ocfg.OptStartTime == nil) {
+		return false
+	}
+	if cfg.OptStartTime != nil && !cfg.OptStartTime.Equal(*ocfg.OptStartTime) {
+		return false
+	}
+	c1, c2 := *cfg, *ocfg
+	c1.OptStartTime, c2.OptStartTime = nil, nil
+	return c1 == c2
+}
+
+// Name returns the name of the consumer.
+func (o *Consumer) Name() string {
+	return o.name
+}
+
+// Config returns the configuration of the consumer.
+func (o *Consumer) Config() ConsumerConfig {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	return o.config
+}
+
+// Info returns the configuration and delivery state of the consumer.
+func (o *Consumer) Info() *ConsumerInfo {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	return &ConsumerInfo{
+		Stream:         o.stream,
+		Name:           o.name,
+		Config:         o.config,
+		Created:        o.created,
+		Delivered:      SequencePair{ConsumerSeq: o.dseq - 1, StreamSeq: o.sseq - 1},
+		AckFloor:       SequencePair{ConsumerSeq: o.adflr, StreamSeq: o.asflr},
+		NumPending:     len(o.pending),
+		NumRedelivered: len(o.rdc),
+		NumWaiting:     len(o.waiting),
+	}
+}
+
+// Delete will remove the consumer and its state.
+func (o *Consumer) Delete() error {
+	mset := o.mset
+	mset.mu.Lock()
+	if mset.consumers[o.name] == o {
+		delete(mset.consumers, o.name)
+	}
+	mset.mu.Unlock()
+	return o.stop(true)
+}
+
+// Start the delivery loop.
+// Lock of the stream should be held.
+func (o *Consumer) start() {
+	go o.loop()
+	o.mu.Lock()
+	if len(o.pending) > 0 {
+		o.ptmr = time.AfterFunc(o.config.AckWait, o.checkPending)
+	}
+	o.mu.Unlock()
+	o.signal()
+}
+
+// Stop the delivery loop, and remove all state if requested.
+func (o *Consumer) stop(remove bool) error {
+	o.mu.Lock()
+	if o.closed {
+		o.mu.Unlock()
+		return nil
+	}
+	o.closed = true
+	close(o.qch)
+	clearTimer(&o.ptmr)
+	flush := o.stmr != nil
+	clearTimer(&o.stmr)
+	var err error
+	if remove {
+		err = os.RemoveAll(o.dir)
+	} else if flush {
+		err = o.writeState()
+	}
+	o.mu.Unlock()
+	return err
+}
+
+// Signal the delivery loop that there may be work to do.
+func (o *Consumer) signal() {
+	select {
+	case o.sigch <- struct{}{}:
+	default:
+	}
+}
+
+func (o *Consumer) loop() {
+	for {
+		select {
+		case <-o.qch:
+			return
+		case <-o.sigch:
+			o.deliverMsgs()
+		}
+	}
+}
+
+// Deliver as many messages as we can. For pull consumers this is bound
+// by the number of messages requested.
+func (o *Consumer) deliverMsgs() {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	for !o.closed {
+		subj := o.config.DeliverSubject
+		if subj == _EMPTY_ {
+			if len(o.waiting) == 0 {
+				return
+			}
+			subj = o.waiting[0].reply
+		}
+		sm, dc := o.nextMsg()
+		if sm == nil {
+			return
+		}
+		o.deliverMsg(subj, sm, dc)
+		if o.config.DeliverSubject == _EMPTY_ {
+			wr := o.waiting[0]
+			if wr.n--; wr.n <= 0 {
+				o.waiting = o.waiting[1:]
+			}
+		}
+	}
+}
+
+// Returns the next message to deliver along with its delivery count.
+// Redeliveries take precedence over new messages.
+// Lock should be held.
+func (o *Consumer) nextMsg() (*storedMsg, uint64) {
+	store := o.mset.store
+	for len(o.rdq) > 0 {
+		seq := o.rdq[0]
+		o.rdq = o.rdq[1:]
+		if o.pending[seq] == nil {
+			continue
+		}
+		sm, err := store.loadMsg(seq)
+		if err != nil {
+			o.removePending(seq)
+			continue
+		}
+		o.rdc[seq]++
+		return sm, o.rdc[seq] + 1
+	}
+	state := store.state()
+	if o.sseq < state.FirstSeq {
+		o.sseq = state.FirstSeq
+	}
+	for o.sseq <= state.LastSeq {
+		seq := o.sseq
+		o.sseq++
+		sm, err := store.loadMsg(seq)
+		if err == ErrStoreMsgNotFound {
+			continue
+		} else if err != nil {
+			return nil, 0
+		}
+		if fs := o.config.FilterSubject; fs != _EMPTY_ && !subjectIsSubsetMatch(sm.subject, fs) {
+			o.skipped(seq)
+			continue
+		}
+		return sm, 1
+	}
+	return nil, 0
+}
+
+// Send the message with the ack reply subject.
+// Lock should be held.
+func (o *Consumer) deliverMsg(subj string, sm *storedMsg, dc uint64) {
+	dseq := o.dseq
+	o.dseq++
+	var reply string
+	if o.config.AckPolicy == AckNone {
+		o.adflr, o.asflr = dseq, sm.seq
+	} else {
+		o.pending[sm.seq] = &pendingMsg{Seq: dseq, Ts: time.Now().UnixNano()}
+		reply = fmt.Sprintf(streamAckT, o.stream, o.name, dc, sm.seq, dseq)
+		if o.ptmr == nil {
+			o.ptmr = time.AfterFunc(o.config.AckWait, o.checkPending)
+		}
+	}
+	o.mset.as.sendInternalMsg(subj, reply, deliveryHeader(sm), sm.msg)
+	o.stateChanged()
+}
+
+// Returns the headers of a delivered message, which are the headers it was
+// published with, if any, and the subject it was stored under.
+func deliveryHeader(sm *storedMsg) []byte {
+	if !bytes.HasSuffix(sm.hdr, []byte(CR_LF+CR_LF)) {
+		return []byte(fmt.Sprintf(streamSubjectHdr, sm.subject))
+	}
+	hdr := append([]byte(nil), sm.hdr[:len(sm.hdr)-LEN_CR_LF]...)
+	hdr = append(hdr, fmt.Sprintf(streamSubjectHdrLine, sm.subject)...)
+	return append(hdr, CR_LF...)
+}
+
+// A message was filtered out, move the ack floor if nothing is pending.
+// Lock should be held.
+func (o *Consumer) skipped(seq uint64) {
+	if len(o.pending) == 0 {
+		o.asflr = seq
+	}
+}
+
+// processNextMsgRequest queues a pull request for batch messages.
+func (o *Consumer) processNextMsgRequest(reply string, batch int) error {
+	o.mu.Lock()
+	if o.config.DeliverSubject != _EMPTY_ {
+		o.mu.Unlock()
+		return ErrConsumerNotPull
+	}
+	o.waiting = append(o.waiting, &nextMsgReq{reply: reply, n: batch})
+	o.mu.Unlock()
+	o.signal()
+	return nil
+}
+
+// processAck handles an ack or nak for the given stream sequence.
+func (o *Consumer) processAck(sseq uint64, msg []byte) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	if o.closed {
+		return
+	}
+	switch string(bytes.TrimSpace(msg)) {
+	case _EMPTY_, streamAckAck:
+		switch o.config.AckPolicy {
+		case AckExplicit:
+			o.removePending(sseq)
+		case AckAll:
+			for seq := range o.pending {
+				if seq <= sseq {
+					o.removePending(seq)
+				}
+			}
+		}
+	case streamAckNak:
+		if o.pending[sseq] != nil {
+			o.rdq = append(o.rdq, sseq)
+			o.signal()
+		}
+	}
+}
+
+// Remove a pending message and move the ack floors.
+// Lock should be held.
+func (o *Consumer) removePending(sseq uint64) {
+	p := o.pending[sseq]
+	if p == nil {
+		return
+	}
+	delete(o.pending, sseq)
+	delete(o.rdc, sseq)
+	if len(o.pending) == 0 {
+		o.asflr, o.adflr = o.sseq-1, o.dseq-1
+		clearTimer(&o.ptmr)
+	} else {
+		var min *pendingMsg
+		var minSeq uint64
+		for seq, p := range o.pending {
+			if min == nil || seq < minSeq {
+				min, minSeq = p, seq
+			}
+		}
+		o.asflr, o.adflr = minSeq-1, min.Seq-1
+	}
+	o.stateChanged()
+}
+
+// Timer callback to queue messages whose ack wait expired for redelivery.
+func (o *Consumer) checkPending() {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	if o.closed {
+		return
+	}
+	now := time.Now().UnixNano()
+	ttl := int64(o.config.AckWait)
+	next := ttl
+	var expired []uint64
+	for seq, p := range o.pending {
+		if elapsed := now - p.Ts; elapsed >= ttl {
+			expired = append(expired, seq)
+		} else if ttl-elapsed < next {
+			next = ttl - elapsed
+		}
+	}
+	sort.Slice(expired, func(i, j int) bool { return expired[i] < expired[j] })
+	for _, seq := range expired {
+		// Give up on messages that reached the maximum deliveries.
+		if md := o.config.MaxDeliver; md > 0 && o.rdc[seq]+1 >= uint64(md) {
+			o.removePending(seq)
+			continue
+		}
+		o.pending[seq].Ts = now
+		o.rdq = append(o.rdq, seq)
+	}
+	if len(o.pending) > 0 {
+		if o.ptmr != nil {
+			o.ptmr.Reset(time.Duration(next))
+		} else {
+			o.ptmr = time.AfterFunc(time.Duration(next), o.checkPending)
+		}
+	} else {
+		clearTimer(&o.ptmr)
+	}
+	if len(o.rdq) > 0 {
+		o.signal()
+	}
+}
+
+// Called when the stream was purged.
+// Lock of the stream should be held.
+func (o *Consumer) purged() {
+	o.mu.Lock()
+	for seq := range o.pending {
+		o.removePending(seq)
+	}
+	o.rdq = nil
+	o.mu.Unlock()
+}
+
+// Schedule a write of the consumer state.
+// Lock should be held.
+func (o *Consumer) stateChanged() {
+	if o.stmr == nil && !o.closed {
+		o.stmr = time.AfterFunc(consumerStateFlushInterval, o.flushState)
+	}
+}
+
+// Timer callback to write the consumer state.
+func (o *Consumer) flushState() {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	if o.closed {
+		return
+	}
+	o.stmr = nil
+	if err := o.writeState(); err != nil {
+		o.mset.as.sm.srv.Warnf("Error writing state of consumer %q: %v", o.name, err)
+	}
+}
+
+// Lock should be held.
+func (o *Consumer) writeState() error {
+	state := &consumerState{
+		Delivered:   SequencePair{ConsumerSeq: o.dseq - 1, StreamSeq: o.sseq - 1},
+		AckFloor:    SequencePair{ConsumerSeq: o.adflr, StreamSeq: o.asflr},
+		Pending:     o.pending,
+		Redelivered: o.rdc,
+	}
+	b, err := json.Marshal(state)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(o.dir, 0755); err != nil {
+		return err
+	}
+	return ioutil.WriteFile(filepath.Join(o.dir, consumerStateFile), b, 0644)
+}
+
+// Lock should be held.
+func (o *Consumer) readState() error {
+	b, err := ioutil.ReadFile(filepath.Join(o.dir, consumerStateFile))
+	if err != nil {
+		return err
+	}
+	var state consumerState
+	if err := json.Unmarshal(b, &state); err != nil {
+		return err
+	}
+	o.dseq = state.Delivered.ConsumerSeq + 1
+	o.sseq = state.Delivered.StreamSeq + 1
+	o.adflr, o.asflr = state.AckFloor.ConsumerSeq, state.AckFloor.StreamSeq
+	if state.Pending != nil {
+		o.pending = state.Pending
+	}
+	if state.Redelivered != nil {
+		o.rdc = state.Redelivered
+	}
+	// Anything pending will be redelivered once the ack wait expires.
+	return nil
+}
@@ -0,0 +1,151 @@
+// Copyright 2019 The NATS Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package server
+
+import (
+	"bytes"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// MsgIdHdr is the header carrying the ID of a published message, used to
+// drop duplicates within the dedupe window of the account.
+const MsgIdHdr = "Nats-Msg-Id"
+
+// msgDedupe tracks the IDs of the messages published to an account within
+// a window, bounded by a maximum number of IDs. The IDs are kept in the
+// order they were seen, which is also the order they expire in.
+type msgDedupe struct {
+	mu     sync.Mutex
+	window time.Duration
+	max    int
+	ids    map[string]struct{}
+	fifo   []dedupeEntry
+	head   int
+}
+
+type dedupeEntry struct {
+	key string
+	ts  int64
+}
+
+func newMsgDedupe(window time.Duration, max int) *msgDedupe {
+	return &msgDedupe{window: window, max: max, ids: make(map[string]struct{})}
+}
+
+// SetDedupe sets the window within which messages published to the account
+// with the same ID and subject are dropped, keeping track of at most maxIDs
+// IDs. A window of 0 disables deduplication.
+func (a *Account) SetDedupe(window time.Duration, maxIDs int) error {
+	if window < 0 {
+		return fmt.Errorf("invalid dedupe window %v", window)
+	}
+	if maxIDs < 0 {
+		return fmt.Errorf("invalid dedupe maximum of %d IDs", maxIDs)
+	}
+	if maxIDs == 0 {
+		maxIDs = DEFAULT_DEDUPE_MAX_IDS
+	}
+	a.mu.Lock()
+	if window == 0 {
+		a.dedupe = nil
+	} else {
+		a.dedupe = newMsgDedupe(window, maxIDs)
+	}
+	a.mu.Unlock()
+	return nil
+}
+
+// NumDuplicateMsgs returns the number of messages published to the account
+// that were dropped as duplicates.
+func (a *Account) NumDuplicateMsgs() int64 {
+	return atomic.LoadInt64(&a.dupMsgs)
+}
+
+// Returns true if the message, given its headers, carries an ID that was
+// already seen for the subject within the dedupe window of the account.
+func (a *Account) isDuplicateMsg(subject, hdr []byte) bool {
+	a.mu.RLock()
+	d := a.dedupe
+	a.mu.RUnlock()
+	if d == nil {
+		return false
+	}
+	id := getHeader(MsgIdHdr, hdr)
+	if len(id) == 0 {
+		return false
+	}
+	return d.isDuplicate(subject, id, time.Now().UnixNano())
+}
+
+// Records the ID for the subject and returns true if it was already seen
+// within the window.
+func (d *msgDedupe) isDuplicate(subject, id []byte, now int64) bool {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	// Expire the IDs that are out of the window.
+	for limit := now - int64(d.window); d.head < len(d.fifo) && d.fifo[d.head].ts <= limit; {
+		d.pop()
+	}
+	key := string(subject) + " " + string(id)
+	if _, ok := d.ids[key]; ok {
+		return true
+	}
+	// Make room by forgetting the oldest IDs.
+	for len(d.ids) >= d.max {
+		d.pop()
+	}
+	d.ids[key] = struct{}{}
+	d.fifo = append(d.fifo, dedupeEntry{key, now})
+	return false
+}
+
+// Removes the oldest ID.
+// Lock should be held.
+func (d *msgDedupe) pop() {
+	delete(d.ids, d.fifo[d.head].key)
+	d.fifo[d.head] = dedupeEntry{}
+	d.head++
+	// Reclaim the space of the removed entries once they are the majority.
+	if d.head > 1024 && d.head > len(d.fifo)/2 {
+		n := copy(d.fifo, d.fifo[d.head:])
+		d.fifo = d.fifo[:n]
+		d.head = 0
+	}
+}
+
+// Returns the value of the header with the given key, or nil if not found.
+// Keys are case insensitive.
+func getHeader(key string, hdr []byte) []byte {
+	// Skip the status line.
+	i := bytes.Index(hdr, []byte(CR_LF))
+	if i < 0 {
+		return nil
+	}
+	for hdr = hdr[i+LEN_CR_LF:]; len(hdr) > 0; {
+		var line []byte
+		if i = bytes.Index(hdr, []byte(CR_LF)); i < 0 {
+			line, hdr = hdr, nil
+		} else {
+			line, hdr = hdr[:i], hdr[i+LEN_CR_LF:]
+		}
+		if c := bytes.IndexByte(line, ':'); c > 0 && bytes.EqualFold(bytes.TrimSpace(line[:c]), []byte(key)) {
+			return bytes.TrimSpace(line[c+1:])
+		}
+	}
+	return nil
+}
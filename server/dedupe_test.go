@@ -0,0 +1,176 @@
+// Copyright 2019 The NATS Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package server
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestGetHeader(t *testing.T) {
+	hdr := []byte("NATS/1.0\r\nFoo: bar\r\nnats-msg-id:  abc \r\n\r\n")
+	if v := string(getHeader(MsgIdHdr, hdr)); v != "abc" {
+		t.Fatalf("Expected %q, got %q", "abc", v)
+	}
+	if v := string(getHeader("Foo", hdr)); v != "bar" {
+		t.Fatalf("Expected %q, got %q", "bar", v)
+	}
+	if v := getHeader("Baz", hdr); v != nil {
+		t.Fatalf("Expected no value, got %q", v)
+	}
+	if v := getHeader(MsgIdHdr, []byte("NATS/1.0")); v != nil {
+		t.Fatalf("Expected no value, got %q", v)
+	}
+}
+
+func TestMsgDedupe(t *testing.T) {
+	d := newMsgDedupe(time.Second, 3)
+	now := time.Now().UnixNano()
+	check := func(subject, id string, now int64, expected bool) {
+		t.Helper()
+		if dup := d.isDuplicate([]byte(subject), []byte(id), now); dup != expected {
+			t.Fatalf("Expected duplicate of %q on %q to be %v", id, subject, expected)
+		}
+	}
+	check("foo", "1", now, false)
+	check("foo", "1", now, true)
+	// IDs are per subject.
+	check("bar", "1", now, false)
+	check("foo", "2", now+1, false)
+	check("foo", "2", now+int64(time.Second), true)
+	// Out of the window.
+	check("foo", "1", now+int64(time.Second), false)
+
+	// The number of IDs is bounded, the oldest are forgotten.
+	d = newMsgDedupe(time.Hour, 3)
+	for i := 0; i < 10; i++ {
+		check("foo", fmt.Sprintf("%d", i), now, false)
+	}
+	if n := len(d.ids); n != 3 {
+		t.Fatalf("Expected 3 IDs, got %d", n)
+	}
+	check("foo", "9", now, true)
+	check("foo", "0", now, false)
+
+	// Space of expired entries is reclaimed.
+	d = newMsgDedupe(time.Second, 100000)
+	for i := 0; i < 5000; i++ {
+		check("foo", fmt.Sprintf("%d", i), now+int64(i)*int64(time.Millisecond), false)
+	}
+	if n := len(d.fifo) - d.head; n != len(d.ids) || n > 1001 {
+		t.Fatalf("Unexpected tracked IDs: %d entries and %d IDs", n, len(d.ids))
+	}
+	if len(d.fifo) > 2500 {
+		t.Fatalf("Expected space to be reclaimed, got %d entries", len(d.fifo))
+	}
+}
+
+func TestAccountDedupe(t *testing.T) {
+	s := RunServer(DefaultOptions())
+	defer s.Shutdown()
+
+	acc := s.globalAccount()
+	if err := acc.SetDedupe(time.Minute, 0); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	c, cr, _ := newClientForServer(s)
+	defer c.nc.Close()
+	hpub := func(subject, id string) string {
+		hdr := fmt.Sprintf("NATS/1.0\r\n%s: %s\r\n\r\n", MsgIdHdr, id)
+		return fmt.Sprintf("HPUB %s %d %d\r\n%sok\r\n", subject, len(hdr), len(hdr)+2, hdr)
+	}
+	go c.parse([]byte("CONNECT {\"headers\":true,\"verbose\":false}\r\nSUB foo 1\r\n" +
+		hpub("foo", "1") + hpub("foo", "1") + hpub("foo", "2") +
+		"HPUB foo 12 14\r\nNATS/1.0\r\n\r\nok\r\n" + "PUB foo 2\r\nok\r\nPING\r\n"))
+	var msgs int
+	for {
+		l, err := cr.ReadString('\n')
+		if err != nil {
+			t.Fatalf("Error reading: %v", err)
+		}
+		if strings.HasPrefix(l, "PONG") {
+			break
+		}
+		if strings.HasPrefix(l, "HMSG") || strings.HasPrefix(l, "MSG") {
+			msgs++
+		}
+	}
+	if msgs != 4 {
+		t.Fatalf("Expected 4 messages, got %d", msgs)
+	}
+	if n := acc.NumDuplicateMsgs(); n != 1 {
+		t.Fatalf("Expected 1 duplicate, got %d", n)
+	}
+	v, err := s.Varz(nil)
+	if err != nil {
+		t.Fatalf("Error getting varz: %v", err)
+	}
+	if v.DuplicateMsgs != 1 {
+		t.Fatalf("Expected 1 duplicate in varz, got %d", v.DuplicateMsgs)
+	}
+}
+
+func TestAccountDedupeConfig(t *testing.T) {
+	conf := createConfFile(t, []byte(`
+		accounts {
+			A { dedupe: "2m" }
+			B { dedupe { window: "10s", max_ids: 50 } }
+			C {}
+		}
+	`))
+	defer os.Remove(conf)
+	opts, err := ProcessConfigFile(conf)
+	if err != nil {
+		t.Fatalf("Error processing config: %v", err)
+	}
+	for _, acc := range opts.Accounts {
+		d := acc.dedupe
+		switch acc.Name {
+		case "A":
+			if d == nil || d.window != 2*time.Minute || d.max != DEFAULT_DEDUPE_MAX_IDS {
+				t.Fatalf("Unexpected dedupe for A: %+v", d)
+			}
+		case "B":
+			if d == nil || d.window != 10*time.Second || d.max != 50 {
+				t.Fatalf("Unexpected dedupe for B: %+v", d)
+			}
+		case "C":
+			if d != nil {
+				t.Fatalf("Expected no dedupe for C, got %+v", d)
+			}
+		}
+	}
+
+	for _, test := range []struct {
+		dedupe string
+		err    string
+	}{
+		{`dedupe: 10`, "Expected dedupe to be a duration or a map"},
+		{`dedupe: "abc"`, "error parsing dedupe window"},
+		{`dedupe: "-1s"`, "invalid dedupe window"},
+		{`dedupe { window: 10 }`, "dedupe window should be a duration"},
+		{`dedupe { window: "1s", max_ids: -1 }`, "positive number"},
+		{`dedupe { window: "1s", foo: 1 }`, "unknown field"},
+	} {
+		conf := createConfFile(t, []byte(fmt.Sprintf(`accounts { A { %s } }`, test.dedupe)))
+		defer os.Remove(conf)
+		if _, err := ProcessConfigFile(conf); err == nil || !strings.Contains(err.Error(), test.err) {
+			t.Fatalf("Expected error %q for %s, got %v", test.err, test.dedupe, err)
+		}
+	}
+}
@@ -91,6 +91,15 @@ var (
 	// ErrAccountResolverSameClaims is returned when same claims have been fetched.
 	ErrAccountResolverSameClaims = errors.New("account resolver no new claims")
 
+	// ErrAuthCalloutTimeout is returned when the authorization service did not respond in time.
+	ErrAuthCalloutTimeout = errors.New("authorization callout timeout")
+
+	// ErrAccountResolverOlderClaims is returned when storing claims issued before the ones already stored.
+	ErrAccountResolverOlderClaims = errors.New("account resolver has newer claims")
+
+	// ErrAccountClaimsNotTrusted is returned when account claims are not issued by a trusted operator.
+	ErrAccountClaimsNotTrusted = errors.New("account claims issuer not trusted")
+
 	// ErrStreamImportAuthorization is returned when a stream import is not authorized.
 	ErrStreamImportAuthorization = errors.New("stream import not authorized")
 
@@ -109,6 +118,10 @@ var (
 	// ErrNoSysAccount is returned when an attempt to publish or subscribe is made
 	// when there is no internal system account defined.
 	ErrNoSysAccount = errors.New("system account not setup")
+
+	// ErrMsgHeadersNotSupported signals the parser detected a message header
+	// protocol from a connection that did not negotiate headers.
+	ErrMsgHeadersNotSupported = errors.New("message headers not supported")
 )
 
 // configErr is a configuration error.
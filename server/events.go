@@ -24,26 +24,39 @@ import (
 
 	"github.com/nats-io/jwt"
 	"github.com/nats-io/nats-server/server/pse"
+	"github.com/nats-io/nkeys"
+	"github.com/nats-io/nuid"
 )
 
 const (
 	connectEventSubj         = "$SYS.ACCOUNT.%s.CONNECT"
 	disconnectEventSubj      = "$SYS.ACCOUNT.%s.DISCONNECT"
 	accConnsReqSubj          = "$SYS.REQ.ACCOUNT.%s.CONNS"
+	accInfoReqSubj           = "$SYS.REQ.ACCOUNT.%s.INFO"
+	accSchedReqSubj          = "$SYS.REQ.ACCOUNT.%s.SCHEDULED"
+	accSchedCancelReqSubj    = "$SYS.REQ.ACCOUNT.%s.SCHEDULED.CANCEL"
 	accUpdateEventSubj       = "$SYS.ACCOUNT.%s.CLAIMS.UPDATE"
+	accClaimsReqSubj         = "$SYS.REQ.CLAIMS.UPDATE"
+	accClaimsLookupReqSubj   = "$SYS.REQ.ACCOUNT.%s.CLAIMS.LOOKUP"
 	connsRespSubj            = "$SYS._INBOX_.%s"
+	claimsLookupRespSubj     = "$SYS._INBOX_.%s.%s"
 	accConnsEventSubj        = "$SYS.SERVER.ACCOUNT.%s.CONNS"
+	accStatszEventSubj       = "$SYS.ACCOUNT.%s.STATSZ"
 	shutdownEventSubj        = "$SYS.SERVER.%s.SHUTDOWN"
 	authErrorEventSubj       = "$SYS.SERVER.%s.CLIENT.AUTH.ERR"
 	serverStatsSubj          = "$SYS.SERVER.%s.STATSZ"
 	serverStatsReqSubj       = "$SYS.REQ.SERVER.%s.STATSZ"
 	serverStatsPingReqSubj   = "$SYS.REQ.SERVER.PING"
+	serverDirectReqSubj      = "$SYS.REQ.SERVER.%s.%s"
+	serverPingReqSubj        = "$SYS.REQ.SERVER.PING.%s"
 	leafNodeConnectEventSubj = "$SYS.ACCOUNT.%s.LEAFNODE.CONNECT"
 
 	shutdownEventTokens = 4
 	serverSubjectIndex  = 2
 	accUpdateTokens     = 5
 	accUpdateAccIndex   = 2
+	accReqTokens        = 5
+	accReqAccIndex      = 3
 )
 
 // Used to send and receive messages from inside the server.
@@ -96,6 +109,19 @@ type AccountNumConns struct {
 	TotalConns int        `json:"total_conns"`
 }
 
+// AccountStatz is sent periodically by each server with the statistics of
+// an account that has local connections. Sent and Received only account for
+// traffic to and from the account's clients and leafnodes on that server.
+type AccountStatz struct {
+	Server        ServerInfo `json:"server"`
+	Account       string     `json:"acc"`
+	Conns         int        `json:"conns"`
+	LeafNodes     int        `json:"leafnodes"`
+	Subscriptions uint32     `json:"subscriptions"`
+	Sent          DataStats  `json:"sent"`
+	Received      DataStats  `json:"received"`
+}
+
 // accNumConnsReq is sent when we are starting to track an account for the first
 // time. We will request others send info to us about their local state.
 type accNumConnsReq struct {
@@ -103,6 +129,14 @@ type accNumConnsReq struct {
 	Account string     `json:"acc"`
 }
 
+// ServerAPIResponse is the response type for the server API requests
+// sent on the system account, such as account info requests.
+type ServerAPIResponse struct {
+	Server *ServerInfo `json:"server"`
+	Data   interface{} `json:"data,omitempty"`
+	Error  string      `json:"error,omitempty"`
+}
+
 // ServerInfo identifies remote servers.
 type ServerInfo struct {
 	Host    string    `json:"host"`
@@ -217,8 +251,13 @@ func (s *Server) internalSendLoop(wg *sync.WaitGroup) {
 				pm.si.Time = time.Now()
 			}
 			var b []byte
-			if pm.msg != nil {
-				b, _ = json.MarshalIndent(pm.msg, _EMPTY_, "  ")
+			switch msg := pm.msg.(type) {
+			case nil:
+			case []byte:
+				// Raw payload, such as JWTs, are sent as is.
+				b = append(b, msg...)
+			default:
+				b, _ = json.MarshalIndent(msg, _EMPTY_, "  ")
 			}
 			// Prep internal structures needed to send message.
 			c.pa.subject = []byte(pm.sub)
@@ -397,6 +436,50 @@ func (s *Server) heartbeatStatsz() {
 		s.sys.stmr.Reset(s.sys.statsz)
 	}
 	s.sendStatsz(fmt.Sprintf(serverStatsSubj, s.info.ID))
+	s.sendAccountStatsz()
+}
+
+// Send out the statz update of each account with local connections.
+// Lock should be held.
+func (s *Server) sendAccountStatsz() {
+	// The lock may have been released by a previous send, in which case
+	// the server could be shutting down.
+	if !s.eventsEnabled() {
+		return
+	}
+	var accs []*Account
+	s.accounts.Range(func(_, v interface{}) bool {
+		if acc := v.(*Account); acc != s.sys.account {
+			accs = append(accs, acc)
+		}
+		return true
+	})
+	for _, acc := range accs {
+		acc.mu.RLock()
+		m := AccountStatz{
+			Account:       acc.Name,
+			Conns:         acc.numLocalConnections(),
+			LeafNodes:     acc.numLocalLeafNodes(),
+			Subscriptions: acc.sl.Count(),
+			Sent: DataStats{
+				Msgs:  atomic.LoadInt64(&acc.outMsgs),
+				Bytes: atomic.LoadInt64(&acc.outBytes),
+			},
+			Received: DataStats{
+				Msgs:  atomic.LoadInt64(&acc.inMsgs),
+				Bytes: atomic.LoadInt64(&acc.inBytes),
+			},
+		}
+		acc.mu.RUnlock()
+		if m.Conns == 0 && m.LeafNodes == 0 {
+			continue
+		}
+		s.sendInternalMsg(fmt.Sprintf(accStatszEventSubj, acc.Name), _EMPTY_, &m.Server, &m)
+		// The lock was released while sending, make sure we are still running.
+		if !s.eventsEnabled() {
+			return
+		}
+	}
 }
 
 // This should be wrapChk() to setup common locking.
@@ -434,6 +517,31 @@ func (s *Server) initEventTracking() {
 	if _, err := s.sysSubscribe(subject, s.connsRequest); err != nil {
 		s.Errorf("Error setting up internal tracking: %v", err)
 	}
+	// Listen for requests for account info.
+	subject = fmt.Sprintf(accInfoReqSubj, "*")
+	if _, err := s.sysSubscribe(subject, s.accInfoReq); err != nil {
+		s.Errorf("Error setting up internal tracking: %v", err)
+	}
+	// Listen for requests to list and cancel scheduled messages.
+	subject = fmt.Sprintf(accSchedReqSubj, "*")
+	if _, err := s.sysSubscribe(subject, s.schedReq); err != nil {
+		s.Errorf("Error setting up internal tracking: %v", err)
+	}
+	subject = fmt.Sprintf(accSchedCancelReqSubj, "*")
+	if _, err := s.sysSubscribe(subject, s.schedCancelReq); err != nil {
+		s.Errorf("Error setting up internal tracking: %v", err)
+	}
+	// Listen for claims updates to store with our directory resolver, and
+	// for other servers looking up claims they are missing.
+	if _, ok := s.AccountResolver().(*DirAccResolver); ok {
+		if _, err := s.sysSubscribe(accClaimsReqSubj, s.claimsUpdateReq); err != nil {
+			s.Errorf("Error setting up internal tracking: %v", err)
+		}
+		subject = fmt.Sprintf(accClaimsLookupReqSubj, "*")
+		if _, err := s.sysSubscribe(subject, s.claimsLookupReq); err != nil {
+			s.Errorf("Error setting up internal tracking: %v", err)
+		}
+	}
 	// Listen for all server shutdowns.
 	subject = fmt.Sprintf(shutdownEventSubj, "*")
 	if _, err := s.sysSubscribe(subject, s.remoteServerShutdown); err != nil {
@@ -453,6 +561,52 @@ func (s *Server) initEventTracking() {
 	if _, err := s.sysSubscribe(serverStatsPingReqSubj, s.statszReq); err != nil {
 		s.Errorf("Error setting up internal tracking: %v", err)
 	}
+	subject = fmt.Sprintf(serverPingReqSubj, "STATSZ")
+	if _, err := s.sysSubscribe(subject, s.statszReq); err != nil {
+		s.Errorf("Error setting up internal tracking: %v", err)
+	}
+	// Listen for requests for our monitoring endpoints, directed at us or
+	// sent to all servers.
+	monSrvc := map[string]msgHandler{
+		"VARZ": func(sub *subscription, subject, reply string, msg []byte) {
+			optz := &VarzOptions{}
+			s.zReq(reply, msg, optz, func() (interface{}, error) { return s.Varz(optz) })
+		},
+		"CONNZ": func(sub *subscription, subject, reply string, msg []byte) {
+			optz := &ConnzOptions{}
+			s.zReq(reply, msg, optz, func() (interface{}, error) { return s.Connz(optz) })
+		},
+		"ROUTEZ": func(sub *subscription, subject, reply string, msg []byte) {
+			optz := &RoutezOptions{}
+			s.zReq(reply, msg, optz, func() (interface{}, error) { return s.Routez(optz) })
+		},
+		"SUBSZ": func(sub *subscription, subject, reply string, msg []byte) {
+			optz := &SubszOptions{}
+			s.zReq(reply, msg, optz, func() (interface{}, error) { return s.Subsz(optz) })
+		},
+		"GATEWAYZ": func(sub *subscription, subject, reply string, msg []byte) {
+			optz := &GatewayzOptions{}
+			s.zReq(reply, msg, optz, func() (interface{}, error) { return s.Gatewayz(optz) })
+		},
+		"LEAFZ": func(sub *subscription, subject, reply string, msg []byte) {
+			optz := &LeafzOptions{}
+			s.zReq(reply, msg, optz, func() (interface{}, error) { return s.Leafz(optz) })
+		},
+		"ACCOUNTZ": func(sub *subscription, subject, reply string, msg []byte) {
+			optz := &AccountzOptions{}
+			s.zReq(reply, msg, optz, func() (interface{}, error) { return s.Accountz(optz) })
+		},
+	}
+	for name, req := range monSrvc {
+		subject = fmt.Sprintf(serverDirectReqSubj, s.info.ID, name)
+		if _, err := s.sysSubscribe(subject, req); err != nil {
+			s.Errorf("Error setting up internal tracking: %v", err)
+		}
+		subject = fmt.Sprintf(serverPingReqSubj, name)
+		if _, err := s.sysSubscribe(subject, req); err != nil {
+			s.Errorf("Error setting up internal tracking: %v", err)
+		}
+	}
 	// Listen for updates when leaf nodes connect for a given account. This will
 	// force any gateway connections to move to `modeInterestOnly`
 	subject = fmt.Sprintf(leafNodeConnectEventSubj, "*")
@@ -464,18 +618,157 @@ func (s *Server) initEventTracking() {
 // accountClaimUpdate will receive claim updates for accounts.
 func (s *Server) accountClaimUpdate(sub *subscription, subject, reply string, msg []byte) {
 	s.mu.Lock()
-	defer s.mu.Unlock()
 	if !s.eventsEnabled() {
+		s.mu.Unlock()
 		return
 	}
 	toks := strings.Split(subject, tsep)
 	if len(toks) < accUpdateTokens {
 		s.Debugf("Received account claims update on bad subject %q", subject)
+		s.mu.Unlock()
 		return
 	}
-	if v, ok := s.accounts.Load(toks[accUpdateAccIndex]); ok {
+	name := toks[accUpdateAccIndex]
+	// With a directory resolver, store the claims, which may have been pushed
+	// to another server. This will also update the account if registered.
+	if _, ok := s.accResolver.(*DirAccResolver); ok {
+		s.mu.Unlock()
+		if _, err := s.storeAccountClaims(name, string(msg)); err != nil {
+			s.Debugf("Account claims update for [%s] not stored: %v", name, err)
+		}
+		return
+	}
+	if v, ok := s.accounts.Load(name); ok {
 		s.updateAccountWithClaimJWT(v.(*Account), string(msg))
 	}
+	s.mu.Unlock()
+}
+
+// ClaimUpdateStatus is returned as the data of the response to
+// a claims update request.
+type ClaimUpdateStatus struct {
+	Account string `json:"account"`
+	Message string `json:"message"`
+}
+
+// claimsUpdateReq is a request to store new account claims with our directory
+// resolver. The claims are then sent to all servers, which store them too.
+func (s *Server) claimsUpdateReq(sub *subscription, subject, reply string, msg []byte) {
+	if !s.eventsRunning() {
+		return
+	}
+	claimJWT := string(msg)
+	resp := &ServerAPIResponse{Server: &ServerInfo{}}
+	ac, err := s.storeAccountClaims(_EMPTY_, claimJWT)
+	if err != nil {
+		resp.Error = err.Error()
+	} else {
+		resp.Data = &ClaimUpdateStatus{Account: ac.Subject, Message: "jwt updated"}
+	}
+	s.mu.Lock()
+	if err == nil {
+		s.sendInternalMsg(fmt.Sprintf(accUpdateEventSubj, ac.Subject), _EMPTY_, nil, []byte(claimJWT))
+	}
+	if reply != _EMPTY_ {
+		s.sendInternalMsg(reply, _EMPTY_, resp.Server, resp)
+	}
+	s.mu.Unlock()
+}
+
+// claimsLookupReq is a request from another server for the claims of an
+// account. We only respond if the claims are in our directory resolver.
+func (s *Server) claimsLookupReq(sub *subscription, subject, reply string, msg []byte) {
+	if reply == _EMPTY_ || !s.eventsRunning() {
+		return
+	}
+	dr, ok := s.AccountResolver().(*DirAccResolver)
+	if !ok {
+		return
+	}
+	toks := strings.Split(subject, tsep)
+	if len(toks) < accReqTokens {
+		s.Debugf("Received account claims lookup on bad subject %q", subject)
+		return
+	}
+	claimJWT, err := dr.Fetch(toks[accReqAccIndex])
+	if err != nil {
+		return
+	}
+	s.mu.Lock()
+	s.sendInternalMsg(reply, _EMPTY_, nil, []byte(claimJWT))
+	s.mu.Unlock()
+}
+
+// lookupAccountClaims asks the other servers for the claims of an account
+// missing from our directory resolver, which may have been pushed while we
+// were not running. Claims received are stored with our resolver.
+// Lock should not be held.
+func (s *Server) lookupAccountClaims(name string) (string, error) {
+	if !nkeys.IsValidPublicAccountKey(name) || !s.eventsRunning() {
+		return _EMPTY_, ErrMissingAccount
+	}
+	s.mu.Lock()
+	reply := fmt.Sprintf(claimsLookupRespSubj, s.info.ID, nuid.Next())
+	s.mu.Unlock()
+
+	respCh := make(chan string, 1)
+	sub, err := s.sysSubscribe(reply, func(sub *subscription, subject, reply string, msg []byte) {
+		// Only the first response is used.
+		select {
+		case respCh <- string(msg):
+		default:
+		}
+	})
+	if err != nil {
+		return _EMPTY_, ErrMissingAccount
+	}
+	defer s.sysUnsubscribe(sub)
+
+	s.mu.Lock()
+	s.sendInternalMsg(fmt.Sprintf(accClaimsLookupReqSubj, name), reply, nil, nil)
+	s.mu.Unlock()
+
+	select {
+	case claimJWT := <-respCh:
+		if _, err := s.storeAccountClaims(name, claimJWT); err != nil {
+			s.Debugf("Account claims lookup for [%s] not stored: %v", name, err)
+			return _EMPTY_, ErrMissingAccount
+		}
+		return claimJWT, nil
+	case <-time.After(DEFAULT_ACCOUNT_CLAIMS_LOOKUP_WAIT):
+	case <-s.quitCh:
+	}
+	return _EMPTY_, ErrMissingAccount
+}
+
+// storeAccountClaims will validate the account claims and store them with
+// our directory resolver. If name is specified, the claims must be for this
+// account. The account is updated if it is registered.
+// Lock should not be held.
+func (s *Server) storeAccountClaims(name, claimJWT string) (*jwt.AccountClaims, error) {
+	dr, ok := s.AccountResolver().(*DirAccResolver)
+	if !ok {
+		return nil, ErrNoAccountResolver
+	}
+	ac, _, err := s.verifyAccountClaims(claimJWT)
+	if err != nil {
+		return nil, err
+	}
+	if name != _EMPTY_ && ac.Subject != name {
+		return nil, fmt.Errorf("claims for account %q received for account %q", ac.Subject, name)
+	}
+	if !s.isTrustedIssuer(ac.Issuer) {
+		return nil, ErrAccountClaimsNotTrusted
+	}
+	if err := dr.Store(ac.Subject, claimJWT); err != nil {
+		return nil, err
+	}
+	if v, ok := s.accounts.Load(ac.Subject); ok {
+		s.mu.Lock()
+		s.updateAccountWithClaimJWT(v.(*Account), claimJWT)
+		s.mu.Unlock()
+	}
+	return ac, nil
 }
 
 // processRemoteServerShutdown will update any affected accounts.
@@ -589,6 +882,27 @@ func (s *Server) connsRequest(sub *subscription, subject, reply string, msg []by
 	}
 }
 
+// accInfoReq is a request for us to respond with the details of an account.
+// We will only respond if the account is known to this server.
+func (s *Server) accInfoReq(sub *subscription, subject, reply string, msg []byte) {
+	if !s.eventsRunning() || reply == _EMPTY_ {
+		return
+	}
+	toks := strings.Split(subject, tsep)
+	if len(toks) != accReqTokens {
+		s.sys.client.Errorf("Received account info request on wrong subject: %q", subject)
+		return
+	}
+	az, err := s.Accountz(&AccountzOptions{Account: toks[accReqAccIndex]})
+	if err != nil {
+		return
+	}
+	m := ServerAPIResponse{Server: &ServerInfo{}, Data: az.Account}
+	s.mu.Lock()
+	s.sendInternalMsg(reply, _EMPTY_, m.Server, &m)
+	s.mu.Unlock()
+}
+
 // leafNodeConnected is an event we will receive when a leaf node for a given account
 // connects.
 func (s *Server) leafNodeConnected(sub *subscription, subject, reply string, msg []byte) {
@@ -611,6 +925,28 @@ func (s *Server) leafNodeConnected(sub *subscription, subject, reply string, msg
 	}
 }
 
+// zReq is a request for one of our monitoring endpoints. The request
+// payload, if any, holds the endpoint's options as JSON.
+func (s *Server) zReq(reply string, msg []byte, optz interface{}, respf func() (interface{}, error)) {
+	if !s.eventsRunning() || reply == _EMPTY_ {
+		return
+	}
+	response := &ServerAPIResponse{Server: &ServerInfo{}}
+	var err error
+	if len(msg) != 0 {
+		err = json.Unmarshal(msg, optz)
+	}
+	if err == nil {
+		response.Data, err = respf()
+	}
+	if err != nil {
+		response.Error = err.Error()
+	}
+	s.mu.Lock()
+	s.sendInternalMsg(reply, _EMPTY_, response.Server, response)
+	s.mu.Unlock()
+}
+
 // statszReq is a request for us to respond with current statz.
 func (s *Server) statszReq(sub *subscription, subject, reply string, msg []byte) {
 	s.mu.Lock()
@@ -886,6 +1222,10 @@ func (s *Server) sendAuthErrorEvent(c *client) {
 // required to be copied.
 type msgHandler func(sub *subscription, subject, reply string, msg []byte)
 
+// Internal message callback that also receives the message headers, which
+// are nil if the message has none. Same copy rules as msgHandler apply.
+type msgHdrHandler func(sub *subscription, subject, reply string, hdr, msg []byte)
+
 func (s *Server) deliverInternalMsg(sub *subscription, subject, reply, msg []byte) {
 	s.mu.Lock()
 	if !s.eventsEnabled() || s.sys.subs == nil {
@@ -1013,7 +1013,7 @@ func TestSystemAccountWithGateways(t *testing.T) {
 	nca.Flush()
 	// If this tests fails with wrong number after 10 seconds we may have
 	// added a new inititial subscription for the eventing system.
-	checkExpectedSubs(t, 9, sa)
+	checkExpectedSubs(t, 27, sa)
 
 	// Create a client on B and see if we receive the event
 	urlb := fmt.Sprintf("nats://%s:%d", ob.Host, ob.Port)
@@ -1162,6 +1162,80 @@ func TestServerEventsStatsZ(t *testing.T) {
 	}
 }
 
+func TestServerEventsAccountStatsZ(t *testing.T) {
+	sa, optsA, sb, _, akp := runTrustedCluster(t)
+	defer sa.Shutdown()
+	defer sb.Shutdown()
+
+	acc, accKP := createAccount(sa)
+	url := fmt.Sprintf("nats://%s:%d", optsA.Host, optsA.Port)
+	ncs, err := nats.Connect(url, createUserCreds(t, sa, akp))
+	if err != nil {
+		t.Fatalf("Error on connect: %v", err)
+	}
+	defer ncs.Close()
+	sub, _ := ncs.SubscribeSync(fmt.Sprintf(accStatszEventSubj, acc.Name))
+	ncs.Flush()
+
+	nc1, err := nats.Connect(url, createUserCreds(t, sa, accKP))
+	if err != nil {
+		t.Fatalf("Error on connect: %v", err)
+	}
+	defer nc1.Close()
+	natsSubSync(t, nc1, "foo")
+	natsFlush(t, nc1)
+
+	nc2, err := nats.Connect(url, createUserCreds(t, sa, accKP))
+	if err != nil {
+		t.Fatalf("Error on connect: %v", err)
+	}
+	defer nc2.Close()
+	natsPub(t, nc2, "foo", []byte("hello"))
+	natsPub(t, nc2, "foo", []byte("world"))
+	natsFlush(t, nc2)
+	natsFlush(t, nc1)
+
+	// Let's speed up the checking process.
+	sa.mu.Lock()
+	sa.sys.statsz = 10 * time.Millisecond
+	sa.sys.stmr.Reset(sa.sys.statsz)
+	sa.mu.Unlock()
+
+	msg, err := sub.NextMsg(time.Second)
+	if err != nil {
+		t.Fatalf("Error receiving msg: %v", err)
+	}
+	m := AccountStatz{}
+	if err := json.Unmarshal(msg.Data, &m); err != nil {
+		t.Fatalf("Error unmarshalling the account statz json: %v", err)
+	}
+	if m.Server.ID != sa.ID() || m.Account != acc.Name {
+		t.Fatalf("Unexpected server or account: %+v", m)
+	}
+	if m.Conns != 2 || m.LeafNodes != 0 || m.Subscriptions != 1 {
+		t.Fatalf("Unexpected connections or subscriptions: %+v", m)
+	}
+	expected := DataStats{Msgs: 2, Bytes: 10}
+	if m.Sent != expected || m.Received != expected {
+		t.Fatalf("Expected sent and received to be %+v, got %+v", expected, m)
+	}
+
+	// No update for accounts without local connections.
+	nc1.Close()
+	nc2.Close()
+	checkFor(t, time.Second, 15*time.Millisecond, func() error {
+		if n := acc.NumLocalConnections(); n != 0 {
+			return fmt.Errorf("Expected no connection, got %v", n)
+		}
+		return nil
+	})
+	// Drain an update that may have been sent before that.
+	sub.NextMsg(50 * time.Millisecond)
+	if msg, err := sub.NextMsg(100 * time.Millisecond); err == nil {
+		t.Fatalf("Unexpected account statz: %q", msg.Data)
+	}
+}
+
 func TestServerEventsPingStatsZ(t *testing.T) {
 	sa, _, sb, optsB, akp := runTrustedCluster(t)
 	defer sa.Shutdown()
@@ -1199,6 +1273,173 @@ func TestServerEventsPingStatsZ(t *testing.T) {
 	}
 }
 
+func TestServerEventsAccountInfo(t *testing.T) {
+	sa, optsA, sb, optsB, akp := runTrustedCluster(t)
+	defer sa.Shutdown()
+	defer sb.Shutdown()
+
+	acc, accKP := createAccount(sa)
+	ncu, err := nats.Connect(fmt.Sprintf("nats://%s:%d", optsA.Host, optsA.Port), createUserCreds(t, sa, accKP))
+	if err != nil {
+		t.Fatalf("Error on connect: %v", err)
+	}
+	defer ncu.Close()
+
+	nc, err := nats.Connect(fmt.Sprintf("nats://%s:%d", optsB.Host, optsB.Port), createUserCreds(t, sb, akp))
+	if err != nil {
+		t.Fatalf("Error on connect: %v", err)
+	}
+	defer nc.Close()
+
+	reply := nc.NewRespInbox()
+	sub, _ := nc.SubscribeSync(reply)
+
+	nc.PublishRequest(fmt.Sprintf(accInfoReqSubj, acc.Name), reply, nil)
+
+	// Both servers know about the account and should respond.
+	conns := 0
+	for i := 0; i < 2; i++ {
+		msg, err := sub.NextMsg(time.Second)
+		if err != nil {
+			t.Fatalf("Error receiving msg: %v", err)
+		}
+		ai := &AccountInfo{}
+		m := ServerAPIResponse{Data: ai}
+		if err := json.Unmarshal(msg.Data, &m); err != nil {
+			t.Fatalf("Error unmarshalling the account info json: %v", err)
+		}
+		if m.Server == nil || m.Error != _EMPTY_ {
+			t.Fatalf("Unexpected response: %+v", m)
+		}
+		if ai.AccountName != acc.Name || ai.JWT == _EMPTY_ || ai.Issuer == _EMPTY_ {
+			t.Fatalf("Unexpected account info: %+v", ai)
+		}
+		if m.Server.ID == sa.ID() {
+			conns = ai.Conns
+		}
+	}
+	if conns != 1 {
+		t.Fatalf("Expected 1 connection reported by server A, got %v", conns)
+	}
+	// Make sure we don't get any more.
+	if msg, err := sub.NextMsg(100 * time.Millisecond); err == nil {
+		t.Fatalf("Unexpected response: %q", msg.Data)
+	}
+}
+
+func TestServerEventsPingMonitorz(t *testing.T) {
+	sa, _, sb, optsB, akp := runTrustedCluster(t)
+	defer sa.Shutdown()
+	defer sb.Shutdown()
+
+	nc, err := nats.Connect(fmt.Sprintf("nats://%s:%d", optsB.Host, optsB.Port), createUserCreds(t, sb, akp))
+	if err != nil {
+		t.Fatalf("Error on connect: %v", err)
+	}
+	defer nc.Close()
+
+	tests := []struct {
+		endpoint  string
+		opt       interface{}
+		resp      interface{}
+		respField []string
+	}{
+		{"VARZ", nil, &Varz{}, []string{"now", "cpu"}},
+		{"SUBSZ", nil, &Subsz{}, []string{"num_subscriptions", "num_cache"}},
+		{"CONNZ", nil, &Connz{}, []string{"now", "connections"}},
+		{"ROUTEZ", nil, &Routez{}, []string{"now", "routes"}},
+		{"GATEWAYZ", nil, &Gatewayz{}, []string{"now", "outbound_gateways", "inbound_gateways"}},
+		{"LEAFZ", nil, &Leafz{}, []string{"now", "leafs"}},
+		{"ACCOUNTZ", nil, &Accountz{}, []string{"now", "accounts"}},
+
+		{"SUBSZ", &SubszOptions{Limit: 5}, &Subsz{}, []string{"num_subscriptions", "num_cache"}},
+		{"CONNZ", &ConnzOptions{Limit: 5}, &Connz{}, []string{"now", "connections"}},
+		{"ROUTEZ", &RoutezOptions{Subscriptions: true}, &Routez{}, []string{"now", "routes"}},
+		{"ACCOUNTZ", &AccountzOptions{Account: sa.SystemAccount().Name}, &Accountz{}, []string{"now", "account_detail"}},
+	}
+
+	for i, test := range tests {
+		t.Run(fmt.Sprintf("%s-%d", test.endpoint, i), func(t *testing.T) {
+			var opt []byte
+			if test.opt != nil {
+				opt, err = json.Marshal(test.opt)
+				if err != nil {
+					t.Fatalf("Error marshaling opts: %v", err)
+				}
+			}
+			reply := nc.NewRespInbox()
+			replySubj, _ := nc.SubscribeSync(reply)
+			defer replySubj.Unsubscribe()
+
+			// Ping all servers, then only server A.
+			nc.PublishRequest(fmt.Sprintf(serverPingReqSubj, test.endpoint), reply, opt)
+			nc.PublishRequest(fmt.Sprintf(serverDirectReqSubj, sa.ID(), test.endpoint), reply, opt)
+
+			ids := map[string]int{}
+			for i := 0; i < 3; i++ {
+				msg, err := replySubj.NextMsg(time.Second)
+				if err != nil {
+					t.Fatalf("Error receiving msg: %v", err)
+				}
+				response1 := make(map[string]map[string]interface{})
+				if err := json.Unmarshal(msg.Data, &response1); err != nil {
+					t.Fatalf("Error unmarshalling response1 json: %v", err)
+				}
+				serverID := response1["server"]["id"].(string)
+				ids[serverID]++
+				for _, respField := range test.respField {
+					if _, ok := response1["data"][respField]; !ok {
+						t.Fatalf("Error finding: %s in %v", respField, response1["data"])
+					}
+				}
+				response2 := ServerAPIResponse{Data: test.resp}
+				if err := json.Unmarshal(msg.Data, &response2); err != nil {
+					t.Fatalf("Error unmarshalling the response2 json: %v", err)
+				}
+				if response2.Error != _EMPTY_ {
+					t.Fatalf("Unexpected error: %v", response2.Error)
+				}
+			}
+			if ids[sa.ID()] != 2 || ids[sb.ID()] != 1 {
+				t.Fatalf("Unexpected responders: %v", ids)
+			}
+			// Make sure we don't get any more.
+			if msg, err := replySubj.NextMsg(100 * time.Millisecond); err == nil {
+				t.Fatalf("Unexpected response: %q", msg.Data)
+			}
+		})
+	}
+
+	// Invalid options are reported back.
+	reply := nc.NewRespInbox()
+	sub, _ := nc.SubscribeSync(reply)
+	nc.PublishRequest(fmt.Sprintf(serverDirectReqSubj, sb.ID(), "CONNZ"), reply, []byte("{bad"))
+	msg, err := sub.NextMsg(time.Second)
+	if err != nil {
+		t.Fatalf("Error receiving msg: %v", err)
+	}
+	response := ServerAPIResponse{}
+	if err := json.Unmarshal(msg.Data, &response); err != nil {
+		t.Fatalf("Error unmarshalling the response json: %v", err)
+	}
+	if response.Error == _EMPTY_ || response.Data != nil || response.Server.ID != sb.ID() {
+		t.Fatalf("Expected an error, got %+v", response)
+	}
+
+	// The statsz is also available through the ping variant.
+	nc.PublishRequest(fmt.Sprintf(serverPingReqSubj, "STATSZ"), reply, nil)
+	for i := 0; i < 2; i++ {
+		msg, err := sub.NextMsg(time.Second)
+		if err != nil {
+			t.Fatalf("Error receiving msg: %v", err)
+		}
+		m := ServerStatsMsg{}
+		if err := json.Unmarshal(msg.Data, &m); err != nil || m.Server.ID == _EMPTY_ {
+			t.Fatalf("Unexpected statsz response: %q - %v", msg.Data, err)
+		}
+	}
+}
+
 func TestGatewayNameClientInfo(t *testing.T) {
 	sa, _, sb, _, _ := runTrustedCluster(t)
 	defer sa.Shutdown()
@@ -0,0 +1,478 @@
+// Copyright 2019 The NATS Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package server
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+const (
+	// Name of the file holding the messages of a stream.
+	msgsFileName = "msgs.dat"
+	// Name of the temporary file used when compacting.
+	msgsCompactFileName = "msgs.cmp"
+
+	// Record types in the messages file.
+	recMsg   = byte(0)
+	recFirst = byte(1)
+
+	// Record header is the record length (4), type (1), sequence (8)
+	// and timestamp (8). A message record follows with the subject
+	// length (2), the subject, the headers length (4), the headers and
+	// the payload.
+	recHdrSize = 4 + 1 + 8 + 8
+
+	// We compact the messages file when the space used by removed
+	// messages is above this threshold and above the live bytes.
+	compactMinBytes = 1024 * 1024
+)
+
+var (
+	// ErrStoreClosed is returned when the store has been stopped.
+	ErrStoreClosed = errors.New("stream store closed")
+	// ErrStoreMsgNotFound is returned when a message is no longer in the store.
+	ErrStoreMsgNotFound = errors.New("stream message not found")
+	// ErrStoreCorrupt is returned when the messages file can not be decoded.
+	ErrStoreCorrupt = errors.New("stream store corrupt")
+)
+
+// Position and metadata of a message in the messages file.
+type msgIndex struct {
+	off int64
+	len uint32
+	ts  int64
+}
+
+// storedMsg is a message loaded from the store.
+type storedMsg struct {
+	subject string
+	hdr     []byte
+	msg     []byte
+	seq     uint64
+	ts      int64
+}
+
+// fileStore is an append-only, file backed store for a stream. All messages
+// are written to a single file. Removing messages, either due to retention
+// limits or a purge, appends a marker record with the new first sequence.
+// The file is rewritten once enough space is used by removed messages.
+type fileStore struct {
+	mu     sync.RWMutex
+	dir    string
+	cfg    StreamConfig
+	fd     *os.File
+	size   int64
+	dead   int64
+	idx    map[uint64]*msgIndex
+	first  uint64
+	last   uint64
+	bytes  uint64
+	ageChk *time.Timer
+	closed bool
+}
+
+// newFileStore opens, or creates, the store in the given directory and
+// recovers any messages from a previous run.
+func newFileStore(dir string, cfg StreamConfig) (*fileStore, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, fmt.Errorf("could not create store directory %q: %v", dir, err)
+	}
+	fs := &fileStore{
+		dir:   dir,
+		cfg:   cfg,
+		idx:   make(map[uint64]*msgIndex),
+		first: 1,
+	}
+	fd, err := os.OpenFile(filepath.Join(dir, msgsFileName), os.O_CREATE|os.O_RDWR, 0644)
+	if err != nil {
+		return nil, err
+	}
+	fs.fd = fd
+	if err := fs.recover(); err != nil {
+		fd.Close()
+		return nil, err
+	}
+	fs.mu.Lock()
+	fs.enforceLimits()
+	fs.expireMsgs()
+	fs.mu.Unlock()
+	return fs, nil
+}
+
+// Rebuilds the index from the messages file.
+// Lock does not need to be held, called on creation only.
+func (fs *fileStore) recover() error {
+	if _, err := fs.fd.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+	r := bufio.NewReader(fs.fd)
+	var hdr [recHdrSize]byte
+	var off int64
+	for {
+		if _, err := io.ReadFull(r, hdr[:]); err != nil {
+			if err == io.EOF {
+				break
+			}
+			// Partial write at the end, truncate.
+			if err == io.ErrUnexpectedEOF {
+				break
+			}
+			return err
+		}
+		rl := binary.LittleEndian.Uint32(hdr[0:])
+		if rl < recHdrSize {
+			return ErrStoreCorrupt
+		}
+		seq := binary.LittleEndian.Uint64(hdr[5:])
+		ts := int64(binary.LittleEndian.Uint64(hdr[13:]))
+		if _, err := r.Discard(int(rl) - recHdrSize); err != nil {
+			break
+		}
+		switch hdr[4] {
+		case recMsg:
+			fs.idx[seq] = &msgIndex{off: off, len: rl, ts: ts}
+			fs.bytes += uint64(rl)
+			if seq > fs.last {
+				fs.last = seq
+			}
+		case recFirst:
+			fs.dead += int64(rl)
+			fs.removeUpTo(seq)
+			if seq-1 > fs.last {
+				fs.last = seq - 1
+			}
+		default:
+			return ErrStoreCorrupt
+		}
+		off += int64(rl)
+	}
+	// Drop anything after the last complete record.
+	if err := fs.fd.Truncate(off); err != nil {
+		return err
+	}
+	fs.size = off
+	return nil
+}
+
+// Drops all index entries below seq and moves the first sequence.
+// Lock should be held.
+func (fs *fileStore) removeUpTo(seq uint64) {
+	for s := fs.first; s < seq; s++ {
+		if mi := fs.idx[s]; mi != nil {
+			fs.bytes -= uint64(mi.len)
+			fs.dead += int64(mi.len)
+			delete(fs.idx, s)
+		}
+	}
+	if seq > fs.first {
+		fs.first = seq
+	}
+}
+
+// storeMsg will append the message to the store and return the sequence.
+// The headers, if any, are stored and returned separately from the payload.
+func (fs *fileStore) storeMsg(subject string, hdr, msg []byte) (uint64, error) {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+	if fs.closed {
+		return 0, ErrStoreClosed
+	}
+	seq := fs.last + 1
+	ts := time.Now().UnixNano()
+	rl := recHdrSize + 2 + len(subject) + 4 + len(hdr) + len(msg)
+	buf := make([]byte, rl)
+	binary.LittleEndian.PutUint32(buf[0:], uint32(rl))
+	buf[4] = recMsg
+	binary.LittleEndian.PutUint64(buf[5:], seq)
+	binary.LittleEndian.PutUint64(buf[13:], uint64(ts))
+	off := recHdrSize
+	binary.LittleEndian.PutUint16(buf[off:], uint16(len(subject)))
+	off += 2 + copy(buf[off+2:], subject)
+	binary.LittleEndian.PutUint32(buf[off:], uint32(len(hdr)))
+	off += 4 + copy(buf[off+4:], hdr)
+	copy(buf[off:], msg)
+	if _, err := fs.fd.WriteAt(buf, fs.size); err != nil {
+		return 0, err
+	}
+	fs.idx[seq] = &msgIndex{off: fs.size, len: uint32(rl), ts: ts}
+	fs.size += int64(rl)
+	fs.bytes += uint64(rl)
+	fs.last = seq
+	if fs.first == 0 {
+		fs.first = seq
+	}
+	fs.enforceLimits()
+	if fs.ageChk == nil && fs.cfg.MaxAge > 0 {
+		fs.ageChk = time.AfterFunc(fs.cfg.MaxAge, fs.expireMsgsLocked)
+	}
+	return seq, nil
+}
+
+// Writes a marker moving the first sequence to seq.
+// Lock should be held.
+func (fs *fileStore) writeFirst(seq uint64) error {
+	var buf [recHdrSize]byte
+	binary.LittleEndian.PutUint32(buf[0:], recHdrSize)
+	buf[4] = recFirst
+	binary.LittleEndian.PutUint64(buf[5:], seq)
+	binary.LittleEndian.PutUint64(buf[13:], uint64(time.Now().UnixNano()))
+	if _, err := fs.fd.WriteAt(buf[:], fs.size); err != nil {
+		return err
+	}
+	fs.size += recHdrSize
+	fs.dead += recHdrSize
+	fs.removeUpTo(seq)
+	return nil
+}
+
+// Removes messages from the front until the store is within its limits.
+// Lock should be held.
+func (fs *fileStore) enforceLimits() {
+	nf := fs.first
+	bytes := fs.bytes
+	msgs := uint64(len(fs.idx))
+	for nf <= fs.last {
+		overMsgs := fs.cfg.MaxMsgs > 0 && msgs > uint64(fs.cfg.MaxMsgs)
+		overBytes := fs.cfg.MaxBytes > 0 && bytes > uint64(fs.cfg.MaxBytes)
+		if !overMsgs && !overBytes {
+			break
+		}
+		if mi := fs.idx[nf]; mi != nil {
+			bytes -= uint64(mi.len)
+			msgs--
+		}
+		nf++
+	}
+	if nf != fs.first {
+		fs.writeFirst(nf)
+		fs.compactIfNeeded()
+	}
+}
+
+// Timer callback to expire old messages.
+func (fs *fileStore) expireMsgsLocked() {
+	fs.mu.Lock()
+	fs.expireMsgs()
+	fs.mu.Unlock()
+}
+
+// Removes all messages older than the max age and resets the timer
+// based on the oldest remaining message.
+// Lock should be held.
+func (fs *fileStore) expireMsgs() {
+	fs.ageChk = nil
+	if fs.closed || fs.cfg.MaxAge <= 0 {
+		return
+	}
+	minTs := time.Now().UnixNano() - int64(fs.cfg.MaxAge)
+	nf := fs.first
+	for ; nf <= fs.last; nf++ {
+		if mi := fs.idx[nf]; mi != nil && mi.ts > minTs {
+			break
+		}
+	}
+	if nf != fs.first {
+		fs.writeFirst(nf)
+		fs.compactIfNeeded()
+	}
+	if mi := fs.idx[fs.first]; mi != nil {
+		fs.ageChk = time.AfterFunc(time.Duration(mi.ts-minTs), fs.expireMsgsLocked)
+	}
+}
+
+// Rewrites the messages file without removed messages if warranted.
+// Lock should be held.
+func (fs *fileStore) compactIfNeeded() {
+	if fs.dead < compactMinBytes || fs.dead < int64(fs.bytes) {
+		return
+	}
+	if err := fs.compact(); err != nil {
+		// Not fatal, the file will just keep removed messages around.
+		return
+	}
+}
+
+// Lock should be held.
+func (fs *fileStore) compact() error {
+	tmp := filepath.Join(fs.dir, msgsCompactFileName)
+	nfd, err := os.OpenFile(tmp, os.O_CREATE|os.O_RDWR|os.O_TRUNC, 0644)
+	if err != nil {
+		return err
+	}
+	w := bufio.NewWriter(nfd)
+	nidx := make(map[uint64]*msgIndex, len(fs.idx))
+	var off int64
+	// Preserve the first sequence if the store ends up empty.
+	var hdr [recHdrSize]byte
+	binary.LittleEndian.PutUint32(hdr[0:], recHdrSize)
+	hdr[4] = recFirst
+	binary.LittleEndian.PutUint64(hdr[5:], fs.first)
+	binary.LittleEndian.PutUint64(hdr[13:], uint64(time.Now().UnixNano()))
+	w.Write(hdr[:])
+	off += recHdrSize
+	for seq := fs.first; seq <= fs.last; seq++ {
+		mi := fs.idx[seq]
+		if mi == nil {
+			continue
+		}
+		buf := make([]byte, mi.len)
+		if _, err := fs.fd.ReadAt(buf, mi.off); err != nil {
+			nfd.Close()
+			os.Remove(tmp)
+			return err
+		}
+		w.Write(buf)
+		nidx[seq] = &msgIndex{off: off, len: mi.len, ts: mi.ts}
+		off += int64(mi.len)
+	}
+	if err := w.Flush(); err != nil {
+		nfd.Close()
+		os.Remove(tmp)
+		return err
+	}
+	if err := os.Rename(tmp, filepath.Join(fs.dir, msgsFileName)); err != nil {
+		nfd.Close()
+		os.Remove(tmp)
+		return err
+	}
+	fs.fd.Close()
+	fs.fd = nfd
+	fs.idx = nidx
+	fs.size = off
+	fs.dead = recHdrSize
+	return nil
+}
+
+// loadMsg will return the message with the given sequence.
+func (fs *fileStore) loadMsg(seq uint64) (*storedMsg, error) {
+	fs.mu.RLock()
+	defer fs.mu.RUnlock()
+	if fs.closed {
+		return nil, ErrStoreClosed
+	}
+	mi := fs.idx[seq]
+	if mi == nil {
+		return nil, ErrStoreMsgNotFound
+	}
+	buf := make([]byte, mi.len)
+	if _, err := fs.fd.ReadAt(buf, mi.off); err != nil {
+		return nil, err
+	}
+	off := recHdrSize
+	sl := int(binary.LittleEndian.Uint16(buf[off:]))
+	off += 2
+	if off+sl+4 > len(buf) {
+		return nil, ErrStoreCorrupt
+	}
+	subject := string(buf[off : off+sl])
+	off += sl
+	hl := int(binary.LittleEndian.Uint32(buf[off:]))
+	off += 4
+	if off+hl > len(buf) {
+		return nil, ErrStoreCorrupt
+	}
+	sm := &storedMsg{
+		subject: subject,
+		msg:     buf[off+hl:],
+		seq:     seq,
+		ts:      mi.ts,
+	}
+	if hl > 0 {
+		sm.hdr = buf[off : off+hl]
+	}
+	return sm, nil
+}
+
+// seqForTime returns the first sequence of a message stored at or after t.
+// If there is none, the next sequence to be assigned is returned.
+func (fs *fileStore) seqForTime(t time.Time) uint64 {
+	fs.mu.RLock()
+	defer fs.mu.RUnlock()
+	ts := t.UnixNano()
+	for seq := fs.first; seq <= fs.last; seq++ {
+		if mi := fs.idx[seq]; mi != nil && mi.ts >= ts {
+			return seq
+		}
+	}
+	return fs.last + 1
+}
+
+// purge removes all messages from the store.
+func (fs *fileStore) purge() (uint64, error) {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+	if fs.closed {
+		return 0, ErrStoreClosed
+	}
+	purged := uint64(len(fs.idx))
+	if err := fs.writeFirst(fs.last + 1); err != nil {
+		return 0, err
+	}
+	fs.compactIfNeeded()
+	return purged, nil
+}
+
+// state returns the current state of the store.
+func (fs *fileStore) state() StreamState {
+	fs.mu.RLock()
+	defer fs.mu.RUnlock()
+	state := StreamState{
+		Msgs:     uint64(len(fs.idx)),
+		Bytes:    fs.bytes,
+		FirstSeq: fs.first,
+		LastSeq:  fs.last,
+	}
+	if mi := fs.idx[fs.first]; mi != nil {
+		state.FirstTime = time.Unix(0, mi.ts).UTC()
+	}
+	if mi := fs.idx[fs.last]; mi != nil {
+		state.LastTime = time.Unix(0, mi.ts).UTC()
+	}
+	return state
+}
+
+// lastSeq returns the sequence of the last message stored.
+func (fs *fileStore) lastSeq() uint64 {
+	fs.mu.RLock()
+	last := fs.last
+	fs.mu.RUnlock()
+	return last
+}
+
+// stop will sync and close the messages file.
+func (fs *fileStore) stop() error {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+	if fs.closed {
+		return nil
+	}
+	fs.closed = true
+	clearTimer(&fs.ageChk)
+	fs.fd.Sync()
+	return fs.fd.Close()
+}
+
+// delete will stop the store and remove all of its files.
+func (fs *fileStore) delete() error {
+	fs.stop()
+	return os.RemoveAll(fs.dir)
+}
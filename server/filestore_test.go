@@ -0,0 +1,188 @@
+// Copyright 2019 The NATS Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package server
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func createStoreDir(t *testing.T) string {
+	t.Helper()
+	dir, err := ioutil.TempDir("", "fstore")
+	if err != nil {
+		t.Fatalf("Error creating store dir: %v", err)
+	}
+	return dir
+}
+
+func TestFileStoreStoreAndLoad(t *testing.T) {
+	dir := createStoreDir(t)
+	defer os.RemoveAll(dir)
+
+	fs, err := newFileStore(dir, StreamConfig{Name: "foo"})
+	if err != nil {
+		t.Fatalf("Error creating store: %v", err)
+	}
+	defer fs.stop()
+
+	for i := 1; i <= 10; i++ {
+		seq, err := fs.storeMsg("foo", nil, []byte(fmt.Sprintf("msg-%d", i)))
+		if err != nil {
+			t.Fatalf("Error storing msg: %v", err)
+		}
+		if seq != uint64(i) {
+			t.Fatalf("Expected sequence %d, got %d", i, seq)
+		}
+	}
+	state := fs.state()
+	if state.Msgs != 10 || state.FirstSeq != 1 || state.LastSeq != 10 {
+		t.Fatalf("Unexpected state: %+v", state)
+	}
+	sm, err := fs.loadMsg(5)
+	if err != nil {
+		t.Fatalf("Error loading msg: %v", err)
+	}
+	if sm.subject != "foo" || string(sm.msg) != "msg-5" || sm.seq != 5 {
+		t.Fatalf("Unexpected msg: %+v", sm)
+	}
+	if _, err := fs.loadMsg(11); err != ErrStoreMsgNotFound {
+		t.Fatalf("Expected not found error, got %v", err)
+	}
+}
+
+func TestFileStoreRecover(t *testing.T) {
+	dir := createStoreDir(t)
+	defer os.RemoveAll(dir)
+
+	cfg := StreamConfig{Name: "foo", MaxMsgs: 5}
+	fs, err := newFileStore(dir, cfg)
+	if err != nil {
+		t.Fatalf("Error creating store: %v", err)
+	}
+	for i := 0; i < 8; i++ {
+		fs.storeMsg("foo", nil, []byte("hello"))
+	}
+	fs.stop()
+
+	// Simulate a partial write at the end of the file.
+	fd, err := os.OpenFile(filepath.Join(dir, msgsFileName), os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		t.Fatalf("Error opening file: %v", err)
+	}
+	fd.Write([]byte{100, 0, 0, 0, 0, 1})
+	fd.Close()
+
+	fs, err = newFileStore(dir, cfg)
+	if err != nil {
+		t.Fatalf("Error recovering store: %v", err)
+	}
+	defer fs.stop()
+	state := fs.state()
+	if state.Msgs != 5 || state.FirstSeq != 4 || state.LastSeq != 8 {
+		t.Fatalf("Unexpected state after recovery: %+v", state)
+	}
+	if seq, _ := fs.storeMsg("foo", nil, []byte("hello")); seq != 9 {
+		t.Fatalf("Expected sequence 9, got %d", seq)
+	}
+	if _, err := fs.loadMsg(9); err != nil {
+		t.Fatalf("Error loading msg: %v", err)
+	}
+}
+
+func TestFileStoreLimits(t *testing.T) {
+	dir := createStoreDir(t)
+	defer os.RemoveAll(dir)
+
+	msg := make([]byte, 100)
+	fs, err := newFileStore(dir, StreamConfig{Name: "foo", MaxBytes: 1000})
+	if err != nil {
+		t.Fatalf("Error creating store: %v", err)
+	}
+	for i := 0; i < 20; i++ {
+		fs.storeMsg("foo", nil, msg)
+	}
+	state := fs.state()
+	if state.Bytes > 1000 {
+		t.Fatalf("Expected bytes to be limited, got %+v", state)
+	}
+	if state.LastSeq != 20 || state.FirstSeq != 20-state.Msgs+1 {
+		t.Fatalf("Unexpected state: %+v", state)
+	}
+	fs.stop()
+	os.RemoveAll(dir)
+
+	fs, err = newFileStore(dir, StreamConfig{Name: "foo", MaxAge: 50 * time.Millisecond})
+	if err != nil {
+		t.Fatalf("Error creating store: %v", err)
+	}
+	defer fs.stop()
+	for i := 0; i < 10; i++ {
+		fs.storeMsg("foo", nil, msg)
+	}
+	checkFor(t, time.Second, 10*time.Millisecond, func() error {
+		if state := fs.state(); state.Msgs != 0 || state.FirstSeq != 11 {
+			return fmt.Errorf("Expected messages to expire, got %+v", state)
+		}
+		return nil
+	})
+}
+
+func TestFileStorePurgeAndCompact(t *testing.T) {
+	dir := createStoreDir(t)
+	defer os.RemoveAll(dir)
+
+	fs, err := newFileStore(dir, StreamConfig{Name: "foo"})
+	if err != nil {
+		t.Fatalf("Error creating store: %v", err)
+	}
+	msg := make([]byte, 64*1024)
+	for i := 0; i < 32; i++ {
+		fs.storeMsg("foo", nil, msg)
+	}
+	purged, err := fs.purge()
+	if err != nil {
+		t.Fatalf("Error on purge: %v", err)
+	}
+	if purged != 32 {
+		t.Fatalf("Expected 32 purged messages, got %d", purged)
+	}
+	// Purging that much should have compacted the file.
+	fi, err := os.Stat(filepath.Join(dir, msgsFileName))
+	if err != nil {
+		t.Fatalf("Error on stat: %v", err)
+	}
+	if fi.Size() > recHdrSize {
+		t.Fatalf("Expected file to be compacted, size is %d", fi.Size())
+	}
+	fs.storeMsg("foo", nil, []byte("hello"))
+	fs.stop()
+
+	fs, err = newFileStore(dir, StreamConfig{Name: "foo"})
+	if err != nil {
+		t.Fatalf("Error recovering store: %v", err)
+	}
+	defer fs.stop()
+	state := fs.state()
+	if state.Msgs != 1 || state.FirstSeq != 33 || state.LastSeq != 33 {
+		t.Fatalf("Unexpected state after recovery: %+v", state)
+	}
+	if sm, err := fs.loadMsg(33); err != nil || string(sm.msg) != "hello" {
+		t.Fatalf("Unexpected msg %+v, err %v", sm, err)
+	}
+}
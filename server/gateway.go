@@ -403,6 +403,7 @@ func (s *Server) gatewayAcceptLoop(ch chan struct{}) {
 		TLSVerify:    tlsReq,
 		MaxPayload:   s.info.MaxPayload,
 		Gateway:      opts.Gateway.Name,
+		Headers:      true,
 	}
 	// If we have selected a random port...
 	if port == 0 {
@@ -863,6 +864,7 @@ func (c *client) processGatewayInfo(info *Info) {
 	}
 	if isFirstINFO {
 		c.opts.Name = info.ID
+		c.headers = info.Headers
 	}
 	c.mu.Unlock()
 
@@ -2209,14 +2211,12 @@ func (c *client) sendMsgToGateways(acc *Account, msg, subject, reply []byte, qgr
 		mreply     []byte
 		dstPfx     []byte
 		checkReply = reply != nil
+		qpicks     []queueGatewayPick
 	)
 
 	// Get a subscription from the pool
 	sub := subPool.Get().(*subscription)
 
-	// Make sure we are an 'R' proto
-	c.msgb[0] = 'R'
-
 	// Check if the subject is on "$GR.<cluster hash>.",
 	// and if so, send to that GW regardless of its
 	// interest on the real subject (that is, skip the
@@ -2225,7 +2225,7 @@ func (c *client) sendMsgToGateways(acc *Account, msg, subject, reply []byte, qgr
 		dstPfx = subject[:gwReplyStart]
 	}
 	for i := 0; i < len(gws); i++ {
-		gwc := gws[i]
+		gwc, rgws := gws[i], gws[i:]
 		if dstPfx != nil {
 			gwc.mu.Lock()
 			ok := gwc.gw.cfg != nil && bytes.Equal(dstPfx, gwc.gw.cfg.replyPfx)
@@ -2253,6 +2253,16 @@ func (c *client) sendMsgToGateways(acc *Account, msg, subject, reply []byte, qgr
 							}
 						}
 						if add {
+							switch acc.queueLocality(queue) {
+							case QueueLocalityLocalOnly:
+								// Mark as handled so that no gateway gets it.
+								qgroups = append(qgroups, queue)
+								continue
+							case QueueLocalityPreferLocal:
+								if c.pickQueueGateway(rgws, &qpicks, accName, subj, queue) != gwc {
+									continue
+								}
+							}
 							qgroups = append(qgroups, queue)
 							queues = append(queues, queue...)
 							queues = append(queues, ' ')
@@ -2277,7 +2287,15 @@ func (c *client) sendMsgToGateways(acc *Account, msg, subject, reply []byte, qgr
 				mreply = append(mreply, reply...)
 			}
 		}
+		// Make sure we are an 'R' proto, or 'H' if the message has
+		// headers and the remote gateway supports them.
+		headers := c.pa.hdr > 0 && gwc.headers
 		mh := c.msgb[:msgHeadProtoLen]
+		if headers {
+			mh[0] = 'H'
+		} else {
+			mh[0] = 'R'
+		}
 		mh = append(mh, accName...)
 		mh = append(mh, ' ')
 		mh = append(mh, subject...)
@@ -2295,7 +2313,7 @@ func (c *client) sendMsgToGateways(acc *Account, msg, subject, reply []byte, qgr
 			mh = append(mh, mreply...)
 			mh = append(mh, ' ')
 		}
-		mh = append(mh, c.pa.szb...)
+		mh = c.appendMsgSizes(mh, headers)
 		mh = append(mh, CR_LF...)
 
 		// We reuse the subscription object that we pass to deliverMsg.
@@ -2310,6 +2328,44 @@ func (c *client) sendMsgToGateways(acc *Account, msg, subject, reply []byte, qgr
 	subPool.Put(sub)
 }
 
+// Gateway picked for a queue group that prefers local delivery.
+type queueGatewayPick struct {
+	queue []byte
+	gwc   *client
+}
+
+// Returns the gateway, among the given ones, that a message is sent to for
+// a queue group without local members that prefers local delivery. It is
+// picked at random among the ones with interest, once per message.
+func (c *client) pickQueueGateway(gws []*client, picks *[]queueGatewayPick, accName, subj string, queue []byte) *client {
+	for _, p := range *picks {
+		if bytes.Equal(p.queue, queue) {
+			return p.gwc
+		}
+	}
+	var candsa [16]*client
+	cands := candsa[:0]
+	for _, gwc := range gws {
+		if _, qr := gwc.gatewayInterest(accName, subj); qr != nil {
+			for _, qsubs := range qr.qsubs {
+				if len(qsubs) > 0 && bytes.Equal(qsubs[0].queue, queue) {
+					cands = append(cands, gwc)
+					break
+				}
+			}
+		}
+	}
+	var gwc *client
+	if len(cands) > 0 {
+		if c.in.prand == nil {
+			c.in.prand = rand.New(rand.NewSource(time.Now().UnixNano()))
+		}
+		gwc = cands[c.in.prand.Intn(len(cands))]
+	}
+	*picks = append(*picks, queueGatewayPick{queue, gwc})
+	return gwc
+}
+
 func (s *Server) gatewayHandleServiceImport(acc *Account, subject []byte, c *client, change int32) {
 	sid := make([]byte, 0, len(acc.Name)+len(subject)+1)
 	sid = append(sid, acc.Name...)
@@ -1996,6 +1996,107 @@ func TestGatewayQueueSub(t *testing.T) {
 	check(t, &count3, total)
 }
 
+func TestGatewayQueueLocality(t *testing.T) {
+	o2 := testDefaultOptionsForGateway("B")
+	s2 := runGatewayServer(o2)
+	defer s2.Shutdown()
+
+	o1 := testGatewayOptionsFromToWithServers(t, "A", "B", s2)
+	s1 := runGatewayServer(o1)
+	defer s1.Shutdown()
+
+	o3 := testGatewayOptionsFromToWithServers(t, "C", "B", s2)
+	s3 := runGatewayServer(o3)
+	defer s3.Shutdown()
+
+	waitForOutboundGateways(t, s1, 2, time.Second)
+	waitForOutboundGateways(t, s2, 2, time.Second)
+	waitForOutboundGateways(t, s3, 2, time.Second)
+
+	var countA, countB, countC, countAll int32
+	counter := func(count *int32) nats.MsgHandler {
+		return func(_ *nats.Msg) { atomic.AddInt32(count, 1) }
+	}
+	ncB := natsConnect(t, fmt.Sprintf("nats://127.0.0.1:%d", o2.Port))
+	defer ncB.Close()
+	natsQueueSub(t, ncB, "foo", "bar", counter(&countB))
+	// A plain subscription to know when all messages went through.
+	natsSub(t, ncB, "foo", counter(&countAll))
+	natsFlush(t, ncB)
+
+	ncC := natsConnect(t, fmt.Sprintf("nats://127.0.0.1:%d", o3.Port))
+	defer ncC.Close()
+	natsQueueSub(t, ncC, "foo", "bar", counter(&countC))
+	natsFlush(t, ncC)
+
+	checkForRegisteredQSubInterest(t, s1, "B", globalAccountName, "foo", 1, time.Second)
+	checkForRegisteredQSubInterest(t, s1, "C", globalAccountName, "foo", 1, time.Second)
+
+	// Make C the farthest cluster from A.
+	gwcC := s1.getOutboundGatewayConnection("C")
+	gwcC.mu.Lock()
+	gwcC.rtt = 10 * time.Second
+	gwcC.mu.Unlock()
+	s1.gateway.orderOutboundConnections()
+
+	ncA := natsConnect(t, fmt.Sprintf("nats://127.0.0.1:%d", o1.Port))
+	defer ncA.Close()
+
+	total := 100
+	send := func(locality QueueLocality, expectedA, expectedB, expectedC int32) {
+		t.Helper()
+		atomic.StoreInt32(&countA, 0)
+		atomic.StoreInt32(&countB, 0)
+		atomic.StoreInt32(&countC, 0)
+		atomic.StoreInt32(&countAll, 0)
+		s1.globalAccount().SetQueueLocality(locality)
+		for i := 0; i < total; i++ {
+			natsPub(t, ncA, "foo", []byte("msg"))
+		}
+		natsFlush(t, ncA)
+		checkFor(t, 2*time.Second, 15*time.Millisecond, func() error {
+			if n := atomic.LoadInt32(&countAll); n != int32(total) {
+				return fmt.Errorf("Expected %v messages, got %v", total, n)
+			}
+			return nil
+		})
+		// Messages to C may still be in flight.
+		time.Sleep(100 * time.Millisecond)
+		// A negative expectation means any share of all messages.
+		sum := expectedA + expectedB + expectedC
+		if expectedA < 0 || expectedB < 0 || expectedC < 0 {
+			sum = int32(total)
+		}
+		a, b, c := atomic.LoadInt32(&countA), atomic.LoadInt32(&countB), atomic.LoadInt32(&countC)
+		if (expectedA >= 0 && a != expectedA) || (expectedB >= 0 && b != expectedB) ||
+			(expectedC >= 0 && c != expectedC) || a+b+c != sum {
+			t.Fatalf("Unexpected distribution for %v: A=%v B=%v C=%v", locality, a, b, c)
+		}
+	}
+	// By default, the nearest cluster gets all messages.
+	send(QueueLocalityDefault, 0, int32(total), 0)
+	send(QueueLocalityNearest, 0, int32(total), 0)
+	send(QueueLocalityLocalOnly, 0, 0, 0)
+
+	// Clusters with members share messages, whatever their RTT.
+	send(QueueLocalityPreferLocal, 0, -1, -1)
+	if b, c := atomic.LoadInt32(&countB), atomic.LoadInt32(&countC); b == 0 || c == 0 {
+		t.Fatalf("Expected messages to be spread, got B=%v C=%v", b, c)
+	}
+
+	// A queue group can override the locality of the account.
+	s1.globalAccount().SetQueueStrategy("bar", &QueueStrategy{Locality: QueueLocalityLocalOnly})
+	send(QueueLocalityPreferLocal, 0, 0, 0)
+	s1.globalAccount().SetQueueStrategy("bar", nil)
+
+	// Local members are always preferred.
+	natsQueueSub(t, ncA, "foo", "bar", counter(&countA))
+	natsFlush(t, ncA)
+	for _, l := range []QueueLocality{QueueLocalityNearest, QueueLocalityPreferLocal, QueueLocalityLocalOnly} {
+		send(l, int32(total), 0, 0)
+	}
+}
+
 func TestGatewayTotalQSubs(t *testing.T) {
 	ob1 := testDefaultOptionsForGateway("B")
 	sb1 := runGatewayServer(ob1)
@@ -14,9 +14,12 @@
 package server
 
 import (
+	"encoding/base64"
+	"encoding/json"
 	"fmt"
 	"io/ioutil"
 	"regexp"
+	"strings"
 
 	"github.com/nats-io/jwt"
 	"github.com/nats-io/nkeys"
@@ -97,3 +100,101 @@ func validateTrustedOperators(o *Options) error {
 	}
 	return nil
 }
+
+// Revocation entry matching all users or importing accounts.
+const revokeAll = "*"
+
+// Revocations of an account JWT. They map public keys, or revokeAll, to
+// the time in seconds before which JWTs issued to them are revoked. The
+// account claims of the jwt package don't carry revocations, so they are
+// decoded from the claims payload, where newer versions of the package
+// put them.
+type accountRevocations struct {
+	ID   string `json:"jti"`
+	Nats struct {
+		Revocations map[string]int64 `json:"revocations,omitempty"`
+		Exports     []struct {
+			Subject     string           `json:"subject,omitempty"`
+			Type        jwt.ExportType   `json:"type,omitempty"`
+			Revocations map[string]int64 `json:"revocations,omitempty"`
+		} `json:"exports,omitempty"`
+	} `json:"nats"`
+}
+
+// Subject mappings of an account JWT, which like revocations are decoded
+// from the claims payload.
+type accountMappings struct {
+	ID   string `json:"jti"`
+	Nats struct {
+		Mappings map[string][]*MapDest `json:"mappings,omitempty"`
+	} `json:"nats"`
+}
+
+// Rate limits of an account JWT, also decoded from the claims payload. The
+// rates in the limits apply to all subjects, and are combined with the
+// subject specific ones.
+type accountRateLimits struct {
+	ID   string `json:"jti"`
+	Nats struct {
+		Limits struct {
+			MsgsPerSec  int64 `json:"msgs_per_sec,omitempty"`
+			BytesPerSec int64 `json:"bytes_per_sec,omitempty"`
+		} `json:"limits"`
+		RateLimits []*RateLimit `json:"rate_limits,omitempty"`
+	} `json:"nats"`
+}
+
+// Decodes the payload of an account JWT, which must have been verified.
+func decodeAccountPayload(claimJWT string, v interface{}) bool {
+	chunks := strings.Split(claimJWT, ".")
+	if len(chunks) != 3 {
+		return false
+	}
+	payload, err := base64.RawURLEncoding.DecodeString(chunks[1])
+	if err != nil {
+		return false
+	}
+	return json.Unmarshal(payload, v) == nil
+}
+
+// Decodes the revocations of an account JWT, which must have been verified.
+// Returns nil if the JWT is not the one with the given claims ID.
+func decodeAccountRevocations(claimJWT, id string) *accountRevocations {
+	revs := &accountRevocations{}
+	if !decodeAccountPayload(claimJWT, revs) || revs.ID != id {
+		return nil
+	}
+	return revs
+}
+
+// Decodes the subject mappings of an account JWT, which must have been
+// verified. Returns nil if the JWT is not the one with the given claims ID.
+func decodeAccountMappings(claimJWT, id string) *accountMappings {
+	ms := &accountMappings{}
+	if !decodeAccountPayload(claimJWT, ms) || ms.ID != id {
+		return nil
+	}
+	return ms
+}
+
+// Decodes the rate limits of an account JWT, which must have been verified.
+// Returns nil if the JWT is not the one with the given claims ID.
+func decodeAccountRateLimits(claimJWT, id string) *accountRateLimits {
+	rls := &accountRateLimits{}
+	if !decodeAccountPayload(claimJWT, rls) || rls.ID != id {
+		return nil
+	}
+	return rls
+}
+
+// Returns true if JWTs issued to the given public key at issuedAt are revoked.
+func isRevoked(revocations map[string]int64, subject string, issuedAt int64) bool {
+	if len(revocations) == 0 {
+		return false
+	}
+	if t, ok := revocations[subject]; ok && issuedAt <= t {
+		return true
+	}
+	t, ok := revocations[revokeAll]
+	return ok && issuedAt <= t
+}
@@ -18,10 +18,13 @@ import (
 	"encoding/base64"
 	"encoding/json"
 	"fmt"
+	"io/ioutil"
 	"net/http"
 	"net/http/httptest"
 	"os"
+	"path/filepath"
 	"strings"
+	"sync/atomic"
 	"testing"
 	"time"
 
@@ -370,6 +373,73 @@ func TestJWTUserPermissionClaims(t *testing.T) {
 	}
 }
 
+func TestJWTUserQueuePermissionClaims(t *testing.T) {
+	okp, _ := nkeys.FromSeed(oSeed)
+	akp, _ := nkeys.FromSeed(aSeed)
+	apub, _ := akp.PublicKey()
+	nac := jwt.NewAccountClaims(apub)
+	ajwt, err := nac.Encode(okp)
+	if err != nil {
+		t.Fatalf("Error generating account JWT: %v", err)
+	}
+
+	s := opTrustBasicSetup()
+	defer s.Shutdown()
+	buildMemAccResolver(s)
+	addAccountToMemResolver(s, apub, ajwt)
+
+	connect := func(subs ...string) (*client, string) {
+		t.Helper()
+		nkp, _ := nkeys.CreateUser()
+		pub, _ := nkp.PublicKey()
+		nuc := jwt.NewUserClaims(pub)
+		nuc.Permissions.Sub.Allow.Add(subs...)
+		nuc.Permissions.Sub.Deny.Add("foo.bar workers")
+		ujwt, err := nuc.Encode(akp)
+		if err != nil {
+			t.Fatalf("Error generating user JWT: %v", err)
+		}
+		c, cr, l := newClientForServer(s)
+		var info nonceInfo
+		json.Unmarshal([]byte(l[5:]), &info)
+		sigraw, _ := nkp.Sign([]byte(info.Nonce))
+		sig := base64.RawURLEncoding.EncodeToString(sigraw)
+		cs := fmt.Sprintf("CONNECT {\"jwt\":%q,\"sig\":\"%s\",\"verbose\":true,\"pedantic\":true}\r\nPING\r\n", ujwt, sig)
+		go c.parse([]byte(cs))
+		l, _ = cr.ReadString('\n')
+		return c, l
+	}
+
+	if _, l := connect("foo.* workers extra"); !strings.HasPrefix(l, "-ERR ") {
+		t.Fatalf("Expected an error, got: %v", l)
+	}
+
+	c, l := connect("foo.* workers", "foo.* admin.*", "bar")
+	if !strings.HasPrefix(l, "+OK") {
+		t.Fatalf("Expected an OK, got: %v", l)
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for _, test := range []struct {
+		subject string
+		queue   string
+		allowed bool
+	}{
+		{"foo.baz", "", false},
+		{"foo.baz", "workers", true},
+		{"foo.baz", "admin.1", true},
+		{"foo.baz", "others", false},
+		{"foo.bar", "workers", false},
+		{"foo.bar", "admin.1", true},
+		{"bar", "", true},
+		{"bar", "others", true},
+	} {
+		if allowed := c.canSubscribe(test.subject, test.queue); allowed != test.allowed {
+			t.Fatalf("Expected subscription to %q in queue %q allowed to be %v", test.subject, test.queue, test.allowed)
+		}
+	}
+}
+
 func TestJWTAccountExpired(t *testing.T) {
 	s := opTrustBasicSetup()
 	defer s.Shutdown()
@@ -956,6 +1026,241 @@ func TestJWTAccountImportActivationExpires(t *testing.T) {
 	checkShadow(t, 0)
 }
 
+// Adds revocations to an account JWT, the way newer versions of the jwt
+// package encode them, and signs it again.
+func addRevocations(t *testing.T, claimJWT string, kp nkeys.KeyPair, users map[string]int64, exports map[string]map[string]int64) string {
+	t.Helper()
+	return editAccountClaims(t, claimJWT, kp, func(nats map[string]interface{}) {
+		if len(users) > 0 {
+			nats["revocations"] = users
+		}
+		if exps, ok := nats["exports"].([]interface{}); ok {
+			for _, e := range exps {
+				e := e.(map[string]interface{})
+				if revs := exports[e["subject"].(string)]; revs != nil {
+					e["revocations"] = revs
+				}
+			}
+		}
+	})
+}
+
+// Edits the nats section of the claims of an account JWT, for fields the
+// jwt package does not know about, and signs it again with the given key.
+func editAccountClaims(t *testing.T, claimJWT string, kp nkeys.KeyPair, edit func(nats map[string]interface{})) string {
+	t.Helper()
+	chunks := strings.Split(claimJWT, ".")
+	payload, err := base64.RawURLEncoding.DecodeString(chunks[1])
+	if err != nil {
+		t.Fatalf("Error decoding claims: %v", err)
+	}
+	var claims map[string]interface{}
+	if err := json.Unmarshal(payload, &claims); err != nil {
+		t.Fatalf("Error decoding claims: %v", err)
+	}
+	edit(claims["nats"].(map[string]interface{}))
+	if payload, err = json.Marshal(claims); err != nil {
+		t.Fatalf("Error encoding claims: %v", err)
+	}
+	chunks[1] = base64.RawURLEncoding.EncodeToString(payload)
+	sig, err := kp.Sign([]byte(chunks[1]))
+	if err != nil {
+		t.Fatalf("Error signing claims: %v", err)
+	}
+	chunks[2] = base64.RawURLEncoding.EncodeToString(sig)
+	return strings.Join(chunks, ".")
+}
+
+func TestJWTUserRevoked(t *testing.T) {
+	s := opTrustBasicSetup()
+	defer s.Shutdown()
+	buildMemAccResolver(s)
+
+	okp, _ := nkeys.FromSeed(oSeed)
+
+	akp, _ := nkeys.CreateAccount()
+	apub, _ := akp.PublicKey()
+	ajwt, err := jwt.NewAccountClaims(apub).Encode(okp)
+	if err != nil {
+		t.Fatalf("Error generating account JWT: %v", err)
+	}
+	addAccountToMemResolver(s, apub, ajwt)
+	acc, _ := s.LookupAccount(apub)
+	if acc == nil {
+		t.Fatalf("Could not retrieve account for %q", apub)
+	}
+
+	// This user will connect again with the same JWT once revoked.
+	nkp, _ := nkeys.CreateUser()
+	upub, _ := nkp.PublicKey()
+	ujwt, err := jwt.NewUserClaims(upub).Encode(akp)
+	if err != nil {
+		t.Fatalf("Error generating user JWT: %v", err)
+	}
+	uc, _ := jwt.DecodeUserClaims(ujwt)
+	connect := func() *bufio.Reader {
+		c, cr, l := newClientForServer(s)
+		var info nonceInfo
+		json.Unmarshal([]byte(l[5:]), &info)
+		sigraw, _ := nkp.Sign([]byte(info.Nonce))
+		sig := base64.RawURLEncoding.EncodeToString(sigraw)
+		go c.parse([]byte(fmt.Sprintf("CONNECT {\"jwt\":%q,\"sig\":\"%s\"}\r\nPING\r\n", ujwt, sig)))
+		return cr
+	}
+	expectLine := func(cr *bufio.Reader, prefix string) {
+		t.Helper()
+		if l, _ := cr.ReadString('\n'); !strings.HasPrefix(l, prefix) {
+			t.Fatalf("Expected %q, got %q", prefix, l)
+		}
+	}
+	updateAccount := func(claimJWT string) {
+		t.Helper()
+		s.mu.Lock()
+		err := s.updateAccountWithClaimJWT(acc, claimJWT)
+		s.mu.Unlock()
+		if err != nil {
+			t.Fatalf("Error updating account: %v", err)
+		}
+	}
+
+	cr := connect()
+	expectLine(cr, "PONG")
+
+	oc, ocr, ocs := createClient(t, s, akp)
+	go oc.parse([]byte(ocs))
+	expectLine(ocr, "PONG")
+	if n := acc.NumLocalConnections(); n != 2 {
+		t.Fatalf("Expected 2 connections, got %v", n)
+	}
+
+	// The connected user is disconnected once revoked, other users are not.
+	updateAccount(addRevocations(t, ajwt, okp, map[string]int64{upub: uc.IssuedAt}, nil))
+	expectLine(cr, "-ERR 'User Authentication Revoked'")
+	checkFor(t, time.Second, 15*time.Millisecond, func() error {
+		if n := acc.NumLocalConnections(); n != 1 {
+			return fmt.Errorf("Expected 1 connection, got %v", n)
+		}
+		return nil
+	})
+
+	// The revoked JWT can't be used anymore.
+	cr = connect()
+	expectLine(cr, "-ERR 'Authorization Violation'")
+
+	// Revoke all JWTs issued up to the other user's.
+	oc.mu.Lock()
+	ouc, _ := jwt.DecodeUserClaims(oc.opts.JWT)
+	oc.mu.Unlock()
+	updateAccount(addRevocations(t, ajwt, okp, map[string]int64{revokeAll: ouc.IssuedAt}, nil))
+	expectLine(ocr, "-ERR 'User Authentication Revoked'")
+	checkFor(t, time.Second, 15*time.Millisecond, func() error {
+		if n := acc.NumLocalConnections(); n != 0 {
+			return fmt.Errorf("Expected no connection, got %v", n)
+		}
+		return nil
+	})
+
+	// Without revocations, the user can connect again.
+	updateAccount(ajwt)
+	cr = connect()
+	expectLine(cr, "PONG")
+}
+
+func TestJWTAccountImportActivationRevoked(t *testing.T) {
+	s := opTrustBasicSetup()
+	defer s.Shutdown()
+	buildMemAccResolver(s)
+
+	okp, _ := nkeys.FromSeed(oSeed)
+
+	// Create accounts and imports/exports.
+	fooKP, _ := nkeys.CreateAccount()
+	fooPub, _ := fooKP.PublicKey()
+	fooAC := jwt.NewAccountClaims(fooPub)
+	fooAC.Exports.Add(&jwt.Export{Subject: "foo", Type: jwt.Stream, TokenReq: true})
+	fooJWT, err := fooAC.Encode(okp)
+	if err != nil {
+		t.Fatalf("Error generating account JWT: %v", err)
+	}
+	addAccountToMemResolver(s, fooPub, fooJWT)
+	fooAcc, _ := s.LookupAccount(fooPub)
+	if fooAcc == nil {
+		t.Fatalf("Expected to retrieve the account")
+	}
+
+	barKP, _ := nkeys.CreateAccount()
+	barPub, _ := barKP.PublicKey()
+	barAC := jwt.NewAccountClaims(barPub)
+	streamImport := &jwt.Import{Account: fooPub, Subject: "foo", To: "import.", Type: jwt.Stream}
+	activation := jwt.NewActivationClaims(barPub)
+	activation.ImportSubject = "foo"
+	activation.ImportType = jwt.Stream
+	actJWT, err := activation.Encode(fooKP)
+	if err != nil {
+		t.Fatalf("Error generating activation token: %v", err)
+	}
+	streamImport.Token = actJWT
+	barAC.Imports.Add(streamImport)
+	barJWT, err := barAC.Encode(okp)
+	if err != nil {
+		t.Fatalf("Error generating account JWT: %v", err)
+	}
+	addAccountToMemResolver(s, barPub, barJWT)
+	if acc, _ := s.LookupAccount(barPub); acc == nil {
+		t.Fatalf("Expected to retrieve the account")
+	}
+
+	expectPong := func(cr *bufio.Reader) {
+		t.Helper()
+		l, _ := cr.ReadString('\n')
+		if !strings.HasPrefix(l, "PONG") {
+			t.Fatalf("Expected a PONG, got %q", l)
+		}
+	}
+
+	c, cr, cs := createClient(t, s, barKP)
+	parseAsync, quit := genAsyncParser(c)
+	defer func() { quit <- true }()
+
+	parseAsync(cs)
+	expectPong(cr)
+
+	parseAsync("SUB import.foo 1\r\nPING\r\n")
+	expectPong(cr)
+
+	checkShadow := func(t *testing.T, expected int) {
+		t.Helper()
+		checkFor(t, 3*time.Second, 15*time.Millisecond, func() error {
+			c.mu.Lock()
+			defer c.mu.Unlock()
+			sub := c.subs["1"]
+			if ls := len(sub.shadow); ls != expected {
+				return fmt.Errorf("Expected shadows to be %d, got %d", expected, ls)
+			}
+			return nil
+		})
+	}
+	checkShadow(t, 1)
+
+	// Revoking the activation removes the import.
+	act, _ := jwt.DecodeActivationClaims(actJWT)
+	revJWT := addRevocations(t, fooJWT, okp, nil, map[string]map[string]int64{"foo": {barPub: act.IssuedAt}})
+	s.mu.Lock()
+	err = s.updateAccountWithClaimJWT(fooAcc, revJWT)
+	s.mu.Unlock()
+	if err != nil {
+		t.Fatalf("Error updating account: %v", err)
+	}
+	checkShadow(t, 0)
+
+	fooAcc.mu.RLock()
+	ok := fooAcc.checkActivation(nil, streamImport, false)
+	fooAcc.mu.RUnlock()
+	if ok {
+		t.Fatalf("Expected revoked activation to be rejected")
+	}
+}
+
 func TestJWTAccountLimitsSubs(t *testing.T) {
 	s := opTrustBasicSetup()
 	defer s.Shutdown()
@@ -1584,6 +1889,515 @@ func TestAccountURLResolverTimeout(t *testing.T) {
 	}
 }
 
+func TestAccountDirResolver(t *testing.T) {
+	dir, err := ioutil.TempDir("", "resolver")
+	if err != nil {
+		t.Fatalf("Error creating dir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	dr, err := NewDirAccResolver(filepath.Join(dir, "jwts"))
+	if err != nil {
+		t.Fatalf("Error creating resolver: %v", err)
+	}
+
+	kp, _ := nkeys.FromSeed(oSeed)
+	akp, _ := nkeys.CreateAccount()
+	apub, _ := akp.PublicKey()
+	nac := jwt.NewAccountClaims(apub)
+	ajwt1, _ := nac.Encode(kp)
+
+	if _, err := dr.Fetch(apub); err != ErrMissingAccount {
+		t.Fatalf("Expected error %v, got %v", ErrMissingAccount, err)
+	}
+	if err := dr.Store(apub, ajwt1); err != nil {
+		t.Fatalf("Error on store: %v", err)
+	}
+	if j, err := dr.Fetch(apub); err != nil || j != ajwt1 {
+		t.Fatalf("Unexpected fetch result: %q - %v", j, err)
+	}
+	// Names that are not account keys are rejected.
+	for _, name := range []string{"../" + apub, "foo", _EMPTY_} {
+		if err := dr.Store(name, ajwt1); err != ErrMissingAccount {
+			t.Fatalf("Expected error %v storing %q, got %v", ErrMissingAccount, name, err)
+		}
+		if _, err := dr.Fetch(name); err != ErrMissingAccount {
+			t.Fatalf("Expected error %v fetching %q, got %v", ErrMissingAccount, name, err)
+		}
+	}
+
+	// A newer JWT replaces the stored one, but not the other way around.
+	time.Sleep(1100 * time.Millisecond)
+	nac.Limits.Subs = 10
+	ajwt2, _ := nac.Encode(kp)
+	if err := dr.Store(apub, ajwt2); err != nil {
+		t.Fatalf("Error on store: %v", err)
+	}
+	if err := dr.Store(apub, ajwt1); err != ErrAccountResolverOlderClaims {
+		t.Fatalf("Expected error %v, got %v", ErrAccountResolverOlderClaims, err)
+	}
+	if j, _ := dr.Fetch(apub); j != ajwt2 {
+		t.Fatalf("Expected newer JWT to be kept")
+	}
+
+	// The directory content is used by a new resolver.
+	dr, err = NewDirAccResolver(filepath.Join(dir, "jwts"))
+	if err != nil {
+		t.Fatalf("Error creating resolver: %v", err)
+	}
+	if j, _ := dr.Fetch(apub); j != ajwt2 {
+		t.Fatalf("Expected stored JWT to be returned")
+	}
+}
+
+func TestAccountDirResolverConfig(t *testing.T) {
+	dir, err := ioutil.TempDir("", "resolver")
+	if err != nil {
+		t.Fatalf("Error creating dir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	conf := createConfFile(t, []byte(fmt.Sprintf(`
+		resolver: {
+			type: full
+			dir: '%s'
+		}
+	`, dir)))
+	defer os.Remove(conf)
+	opts, err := ProcessConfigFile(conf)
+	if err != nil {
+		t.Fatalf("Error processing config: %v", err)
+	}
+	if dr, ok := opts.AccountResolver.(*DirAccResolver); !ok || dr.dir != dir {
+		t.Fatalf("Unexpected resolver: %#v", opts.AccountResolver)
+	}
+
+	for _, test := range []struct {
+		name     string
+		resolver string
+		err      string
+	}{
+		{"missing type", "{dir: '/tmp'}", "resolver type"},
+		{"bad type", "{type: cache, dir: '/tmp'}", "resolver type"},
+		{"missing dir", "{type: full}", "directory"},
+		{"unknown field", "{type: full, dir: '/tmp', foo: bar}", "unknown field"},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			conf := createConfFile(t, []byte("resolver: "+test.resolver))
+			defer os.Remove(conf)
+			if _, err := ProcessConfigFile(conf); err == nil || !strings.Contains(err.Error(), test.err) {
+				t.Fatalf("Expected error about %q, got %v", test.err, err)
+			}
+		})
+	}
+}
+
+func TestAccountDirResolverClaimsUpdate(t *testing.T) {
+	kp, _ := nkeys.FromSeed(oSeed)
+	opub, _ := kp.PublicKey()
+
+	// System account, known to both servers.
+	skp, _ := nkeys.CreateAccount()
+	spub, _ := skp.PublicKey()
+	sjwt, _ := jwt.NewAccountClaims(spub).Encode(kp)
+
+	createDir := func() string {
+		dir, err := ioutil.TempDir("", "resolver")
+		if err != nil {
+			t.Fatalf("Error creating dir: %v", err)
+		}
+		if err := ioutil.WriteFile(filepath.Join(dir, spub+".jwt"), []byte(sjwt), 0644); err != nil {
+			t.Fatalf("Error writing jwt: %v", err)
+		}
+		return dir
+	}
+	dirA, dirB := createDir(), createDir()
+	defer os.RemoveAll(dirA)
+	defer os.RemoveAll(dirB)
+
+	confA := createConfFile(t, []byte(fmt.Sprintf(`
+		listen: "127.0.0.1:-1"
+		trusted: %s
+		system_account: %s
+		resolver: {type: full, dir: '%s'}
+		cluster {listen: "127.0.0.1:-1"}
+	`, opub, spub, dirA)))
+	defer os.Remove(confA)
+	sa, optsA := RunServerWithConfig(confA)
+	defer sa.Shutdown()
+
+	confB := createConfFile(t, []byte(fmt.Sprintf(`
+		listen: "127.0.0.1:-1"
+		trusted: %s
+		system_account: %s
+		resolver: {type: full, dir: '%s'}
+		cluster {listen: "127.0.0.1:-1", routes: ["nats://127.0.0.1:%d"]}
+	`, opub, spub, dirB, optsA.Cluster.Port)))
+	defer os.Remove(confB)
+	sb, _ := RunServerWithConfig(confB)
+	defer sb.Shutdown()
+	checkClusterFormed(t, sa, sb)
+
+	nc := natsConnect(t, fmt.Sprintf("nats://127.0.0.1:%d", optsA.Port), createUserCreds(t, sa, skp))
+	defer nc.Close()
+
+	update := func(claimJWT string) ServerAPIResponse {
+		t.Helper()
+		msg, err := nc.Request(accClaimsReqSubj, []byte(claimJWT), time.Second)
+		if err != nil {
+			t.Fatalf("Error on request: %v", err)
+		}
+		status := &ClaimUpdateStatus{}
+		resp := ServerAPIResponse{Data: status}
+		if err := json.Unmarshal(msg.Data, &resp); err != nil {
+			t.Fatalf("Error unmarshalling response: %v", err)
+		}
+		return resp
+	}
+
+	akp, _ := nkeys.CreateAccount()
+	apub, _ := akp.PublicKey()
+	nac := jwt.NewAccountClaims(apub)
+	ajwt, _ := nac.Encode(kp)
+	resp := update(ajwt)
+	if resp.Error != _EMPTY_ || resp.Data.(*ClaimUpdateStatus).Account != apub {
+		t.Fatalf("Unexpected response: %+v", resp)
+	}
+	// Claims are stored on both servers.
+	checkFor(t, 2*time.Second, 15*time.Millisecond, func() error {
+		for _, dir := range []string{dirA, dirB} {
+			if b, err := ioutil.ReadFile(filepath.Join(dir, apub+".jwt")); err != nil || string(b) != ajwt {
+				return fmt.Errorf("JWT not stored in %q: %v", dir, err)
+			}
+		}
+		return nil
+	})
+	accA, err := sa.LookupAccount(apub)
+	if err != nil {
+		t.Fatalf("Error looking up account: %v", err)
+	}
+	accB, err := sb.LookupAccount(apub)
+	if err != nil {
+		t.Fatalf("Error looking up account: %v", err)
+	}
+
+	// Registered accounts are updated with new claims.
+	time.Sleep(1100 * time.Millisecond)
+	nac.Limits.Subs = 10
+	ajwt, _ = nac.Encode(kp)
+	if resp := update(ajwt); resp.Error != _EMPTY_ {
+		t.Fatalf("Unexpected error: %v", resp.Error)
+	}
+	checkFor(t, 2*time.Second, 15*time.Millisecond, func() error {
+		for _, acc := range []*Account{accA, accB} {
+			acc.mu.RLock()
+			msubs := acc.msubs
+			acc.mu.RUnlock()
+			if msubs != 10 {
+				return fmt.Errorf("Account not updated, max subs is %v", msubs)
+			}
+		}
+		return nil
+	})
+
+	// Claims from an untrusted operator are rejected.
+	okp, _ := nkeys.CreateOperator()
+	bjwt, _ := jwt.NewAccountClaims(apub).Encode(okp)
+	if resp := update(bjwt); resp.Error != ErrAccountClaimsNotTrusted.Error() {
+		t.Fatalf("Expected error %q, got %+v", ErrAccountClaimsNotTrusted, resp)
+	}
+	// Invalid JWT as well.
+	if resp := update("not a jwt"); resp.Error == _EMPTY_ {
+		t.Fatalf("Expected an error, got %+v", resp)
+	}
+	if j, _ := sb.AccountResolver().Fetch(apub); j != ajwt {
+		t.Fatalf("Expected JWT to not have been replaced")
+	}
+}
+
+func TestAccountDirResolverLookupFromPeers(t *testing.T) {
+	kp, _ := nkeys.FromSeed(oSeed)
+	opub, _ := kp.PublicKey()
+
+	skp, _ := nkeys.CreateAccount()
+	spub, _ := skp.PublicKey()
+	sjwt, _ := jwt.NewAccountClaims(spub).Encode(kp)
+
+	createDir := func() string {
+		dir, err := ioutil.TempDir("", "resolver")
+		if err != nil {
+			t.Fatalf("Error creating dir: %v", err)
+		}
+		if err := ioutil.WriteFile(filepath.Join(dir, spub+".jwt"), []byte(sjwt), 0644); err != nil {
+			t.Fatalf("Error writing jwt: %v", err)
+		}
+		return dir
+	}
+	dirA, dirB := createDir(), createDir()
+	defer os.RemoveAll(dirA)
+	defer os.RemoveAll(dirB)
+
+	confA := createConfFile(t, []byte(fmt.Sprintf(`
+		listen: "127.0.0.1:-1"
+		trusted: %s
+		system_account: %s
+		resolver: {type: full, dir: '%s'}
+		cluster {listen: "127.0.0.1:-1"}
+	`, opub, spub, dirA)))
+	defer os.Remove(confA)
+	sa, optsA := RunServerWithConfig(confA)
+	defer sa.Shutdown()
+
+	nc := natsConnect(t, fmt.Sprintf("nats://127.0.0.1:%d", optsA.Port), createUserCreds(t, sa, skp))
+	defer nc.Close()
+
+	// Push the claims while the second server is not running.
+	akp, _ := nkeys.CreateAccount()
+	apub, _ := akp.PublicKey()
+	ajwt, _ := jwt.NewAccountClaims(apub).Encode(kp)
+	if _, err := nc.Request(accClaimsReqSubj, []byte(ajwt), time.Second); err != nil {
+		t.Fatalf("Error on request: %v", err)
+	}
+
+	confB := createConfFile(t, []byte(fmt.Sprintf(`
+		listen: "127.0.0.1:-1"
+		trusted: %s
+		system_account: %s
+		resolver: {type: full, dir: '%s'}
+		cluster {listen: "127.0.0.1:-1", routes: ["nats://127.0.0.1:%d"]}
+	`, opub, spub, dirB, optsA.Cluster.Port)))
+	defer os.Remove(confB)
+	sb, optsB := RunServerWithConfig(confB)
+	defer sb.Shutdown()
+	checkClusterFormed(t, sa, sb)
+
+	if _, err := os.Stat(filepath.Join(dirB, apub+".jwt")); !os.IsNotExist(err) {
+		t.Fatalf("Expected JWT to not be stored yet, got %v", err)
+	}
+	// A user of the account can connect to the second server, which gets
+	// the claims from the first one and stores them.
+	ncb := natsConnect(t, fmt.Sprintf("nats://127.0.0.1:%d", optsB.Port), createUserCreds(t, sb, akp))
+	defer ncb.Close()
+	if b, err := ioutil.ReadFile(filepath.Join(dirB, apub+".jwt")); err != nil || string(b) != ajwt {
+		t.Fatalf("Expected JWT to be stored, got %v", err)
+	}
+
+	// Accounts not known to any server are still reported missing.
+	bkp, _ := nkeys.CreateAccount()
+	bpub, _ := bkp.PublicKey()
+	if _, err := sb.LookupAccount(bpub); err != ErrMissingAccount {
+		t.Fatalf("Expected %v, got %v", ErrMissingAccount, err)
+	}
+}
+
+// An account resolver counting fetches, which can be made to fail.
+type testFlakyAccResolver struct {
+	MemAccResolver
+	fetches int32
+	fail    int32
+}
+
+func (tr *testFlakyAccResolver) Fetch(name string) (string, error) {
+	atomic.AddInt32(&tr.fetches, 1)
+	if atomic.LoadInt32(&tr.fail) == 1 {
+		return _EMPTY_, fmt.Errorf("resolver unavailable")
+	}
+	return tr.MemAccResolver.Fetch(name)
+}
+
+func TestAccountCacheResolver(t *testing.T) {
+	tr := &testFlakyAccResolver{}
+	tr.Store("A", "jwt1")
+	cr := NewCacheAccResolver(tr, 100*time.Millisecond, 100*time.Millisecond, time.Hour)
+
+	checkFetch := func(name, expected string) {
+		t.Helper()
+		j, err := cr.Fetch(name)
+		if err != nil || j != expected {
+			t.Fatalf("Expected %q, got %q, %v", expected, j, err)
+		}
+	}
+	checkStats := func(expected CacheAccResolverStats) {
+		t.Helper()
+		if st := cr.Stats(); *st != expected {
+			t.Fatalf("Expected stats %+v, got %+v", expected, *st)
+		}
+	}
+
+	checkFetch("A", "jwt1")
+	checkFetch("A", "jwt1")
+	if n := atomic.LoadInt32(&tr.fetches); n != 1 {
+		t.Fatalf("Expected 1 fetch, got %v", n)
+	}
+	checkStats(CacheAccResolverStats{Hits: 1, Misses: 1, Entries: 1})
+
+	// Fetch errors are cached.
+	for i := 0; i < 2; i++ {
+		if _, err := cr.Fetch("B"); err != ErrMissingAccount {
+			t.Fatalf("Expected %v, got %v", ErrMissingAccount, err)
+		}
+	}
+	checkStats(CacheAccResolverStats{Hits: 2, Misses: 2, FetchErrors: 1, Entries: 2})
+
+	// Once expired, the claims are served while fetched again. Claims
+	// are kept if that fails.
+	tr.Store("A", "jwt2")
+	atomic.StoreInt32(&tr.fail, 1)
+	time.Sleep(150 * time.Millisecond)
+	checkFetch("A", "jwt1")
+	checkFor(t, time.Second, 15*time.Millisecond, func() error {
+		if n := atomic.LoadInt32(&tr.fetches); n != 3 {
+			return fmt.Errorf("Expected 3 fetches, got %v", n)
+		}
+		return nil
+	})
+	checkFetch("A", "jwt1")
+	if n := atomic.LoadInt32(&tr.fetches); n != 3 {
+		t.Fatalf("Expected no new fetch after a failure, got %v fetches", n)
+	}
+	checkStats(CacheAccResolverStats{Hits: 2, StaleHits: 2, Misses: 2, FetchErrors: 2, Entries: 2})
+
+	atomic.StoreInt32(&tr.fail, 0)
+	time.Sleep(150 * time.Millisecond)
+	checkFor(t, time.Second, 15*time.Millisecond, func() error {
+		if j, _ := cr.Fetch("A"); j != "jwt2" {
+			return fmt.Errorf("Expected updated claims, got %q", j)
+		}
+		return nil
+	})
+
+	// Expired fetch errors are removed.
+	cr.prune()
+	if n := cr.Stats().Entries; n != 1 {
+		t.Fatalf("Expected 1 entry, got %v", n)
+	}
+
+	// Stored claims are cached.
+	if err := cr.Store("C", "jwt3"); err != nil {
+		t.Fatalf("Error storing claims: %v", err)
+	}
+	n := atomic.LoadInt32(&tr.fetches)
+	checkFetch("C", "jwt3")
+	if atomic.LoadInt32(&tr.fetches) != n {
+		t.Fatalf("Expected stored claims to be cached")
+	}
+}
+
+func TestAccountCacheResolverRefresh(t *testing.T) {
+	s := opTrustBasicSetup()
+	defer s.Shutdown()
+	tr := &testFlakyAccResolver{}
+	cr := NewCacheAccResolver(tr, time.Hour, 0, 0)
+	s.SetAccountResolver(cr)
+
+	okp, _ := nkeys.FromSeed(oSeed)
+	newAccount := func() (nkeys.KeyPair, *jwt.AccountClaims) {
+		akp, _ := nkeys.CreateAccount()
+		apub, _ := akp.PublicKey()
+		nac := jwt.NewAccountClaims(apub)
+		ajwt, err := nac.Encode(okp)
+		if err != nil {
+			t.Fatalf("Error generating account JWT: %v", err)
+		}
+		tr.Store(apub, ajwt)
+		return akp, nac
+	}
+	akp, nac := newAccount()
+	bkp, _ := newAccount()
+
+	// Only the account with a connection is active.
+	c, br, cs := createClient(t, s, akp)
+	go c.parse([]byte(cs))
+	if l, _ := br.ReadString('\n'); !strings.HasPrefix(l, "PONG") {
+		t.Fatalf("Expected a PONG, got: %q", l)
+	}
+	bpub, _ := bkp.PublicKey()
+	if _, err := s.LookupAccount(bpub); err != nil {
+		t.Fatalf("Error looking up account: %v", err)
+	}
+
+	nac.Limits.Conn = 10
+	ajwt, err := nac.Encode(okp)
+	if err != nil {
+		t.Fatalf("Error generating account JWT: %v", err)
+	}
+	tr.Store(nac.Subject, ajwt)
+
+	fetches := atomic.LoadInt32(&tr.fetches)
+	s.refreshActiveAccounts(cr)
+	if n := atomic.LoadInt32(&tr.fetches) - fetches; n != 1 {
+		t.Fatalf("Expected only the active account to be fetched, got %v fetches", n)
+	}
+	acc, _ := s.LookupAccount(nac.Subject)
+	if mc := acc.MaxActiveConnections(); mc != 10 {
+		t.Fatalf("Expected refreshed claims to be applied, got max connections %v", mc)
+	}
+
+	// A resolver outage does not affect the account.
+	atomic.StoreInt32(&tr.fail, 1)
+	s.refreshActiveAccounts(cr)
+	if acc.claimJWT != ajwt {
+		t.Fatalf("Expected claims to be kept")
+	}
+	if j, err := cr.Fetch(nac.Subject); err != nil || j != ajwt {
+		t.Fatalf("Expected cached claims, got %q, %v", j, err)
+	}
+
+	m := string(s.Metrics())
+	for _, expected := range []string{
+		"nats_account_resolver_cache_hits_total 1\n",
+		"nats_account_resolver_fetch_errors_total 1\n",
+		"nats_account_resolver_cache_entries 2\n",
+	} {
+		if !strings.Contains(m, expected) {
+			t.Fatalf("Expected to find %q in:\n%s", expected, m)
+		}
+	}
+}
+
+func TestAccountCacheResolverConfig(t *testing.T) {
+	conf := createConfFile(t, []byte(`
+		resolver: {
+			type: mem
+			cache_ttl: "1m"
+			cache_refresh: "10s"
+		}
+	`))
+	defer os.Remove(conf)
+	opts, err := ProcessConfigFile(conf)
+	if err != nil {
+		t.Fatalf("Error processing config: %v", err)
+	}
+	cr, ok := opts.AccountResolver.(*CacheAccResolver)
+	if !ok {
+		t.Fatalf("Unexpected resolver: %#v", opts.AccountResolver)
+	}
+	if _, ok := cr.ar.(*MemAccResolver); !ok {
+		t.Fatalf("Unexpected cached resolver: %#v", cr.ar)
+	}
+	if cr.ttl != time.Minute || cr.negTTL != DEFAULT_ACCOUNT_RESOLVER_CACHE_NEGATIVE_TTL || cr.refresh != 10*time.Second {
+		t.Fatalf("Unexpected cache durations: %v, %v, %v", cr.ttl, cr.negTTL, cr.refresh)
+	}
+
+	for _, test := range []struct {
+		name     string
+		resolver string
+		err      string
+	}{
+		{"bad ttl", "{type: mem, cache_ttl: 10}", "duration"},
+		{"missing url", "{type: url, cache: true}", "url"},
+		{"full", "{type: full, dir: '/tmp', cache: true}", "can't be cached"},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			conf := createConfFile(t, []byte("resolver: "+test.resolver))
+			defer os.Remove(conf)
+			if _, err := ProcessConfigFile(conf); err == nil || !strings.Contains(err.Error(), test.err) {
+				t.Fatalf("Expected error about %q, got %v", test.err, err)
+			}
+		})
+	}
+}
+
 func TestJWTUserSigningKey(t *testing.T) {
 	s := opTrustBasicSetup()
 	defer s.Shutdown()
@@ -41,7 +41,7 @@ type leaf struct {
 	// leaf nodes. This represents all the interest we want to send to the other side.
 	smap map[string]int32
 	// We have any auth stuff here for solicited connections.
-	remote *leafNodeCfg
+	remote *leafNodeAccCfg
 }
 
 type leafNodeCfg struct {
@@ -51,6 +51,18 @@ type leafNodeCfg struct {
 	curURL *url.URL
 }
 
+// leafNodeAccCfg is a local account bound by a remote leafnode configuration.
+// Each account is solicited with its own connection, but they share the URLs,
+// TLS and reconnect settings of the configuration.
+type leafNodeAccCfg struct {
+	*leafNodeCfg
+	acc   string
+	creds string
+	// The URL this account last connected, or tried to connect, to.
+	// Protected by the configuration lock.
+	url *url.URL
+}
+
 func (c *client) isSolicitedLeafNode() bool {
 	return c.kind == LEAF && c.leaf.remote != nil
 }
@@ -58,12 +70,14 @@ func (c *client) isSolicitedLeafNode() bool {
 // This will spin up go routines to solicit the remote leaf node connections.
 func (s *Server) solicitLeafNodeRemotes(remotes []*RemoteLeafOpts) {
 	for _, r := range remotes {
-		remote := newLeafNodeCfg(r)
-		s.startGoRoutine(func() { s.connectToRemoteLeafNode(remote) })
+		for _, remote := range newLeafNodeCfg(r).accounts() {
+			remote := remote
+			s.startGoRoutine(func() { s.connectToRemoteLeafNode(remote) })
+		}
 	}
 }
 
-func (s *Server) remoteLeafNodeStillValid(remote *leafNodeCfg) bool {
+func (s *Server) remoteLeafNodeStillValid(remote *leafNodeAccCfg) bool {
 	for _, ri := range s.getOpts().LeafNode.Remotes {
 		// FIXME(dlc) - What about auth changes?
 		if urlsAreEqual(ri.URL, remote.URL) {
@@ -78,6 +92,9 @@ func validateLeafNode(o *Options) error {
 	if o.LeafNode.Port == 0 {
 		return nil
 	}
+	if err := validateLeafNodeAuthOptions(o); err != nil {
+		return err
+	}
 	if o.Gateway.Name == "" && o.Gateway.Port == 0 {
 		return nil
 	}
@@ -89,7 +106,38 @@ func validateLeafNode(o *Options) error {
 	return nil
 }
 
-func (s *Server) reConnectToRemoteLeafNode(remote *leafNodeCfg) {
+// Makes sure that the accounts leafnode users are bound to are defined.
+// In operator mode, leafnodes are bound to accounts through their user JWT.
+func validateLeafNodeAuthOptions(o *Options) error {
+	if len(o.TrustedOperators) > 0 || len(o.TrustedKeys) > 0 {
+		return nil
+	}
+	accs := []string{o.LeafNode.Account}
+	for _, u := range o.LeafNode.Users {
+		accs = append(accs, leafUserAccountName(u.Account))
+	}
+	for _, u := range o.LeafNode.Nkeys {
+		accs = append(accs, leafUserAccountName(u.Account))
+	}
+	for _, name := range accs {
+		if name == _EMPTY_ || name == globalAccountName {
+			continue
+		}
+		found := false
+		for _, acc := range o.Accounts {
+			if acc.Name == name {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return fmt.Errorf("leafnode authorization account %q is not defined", name)
+		}
+	}
+	return nil
+}
+
+func (s *Server) reConnectToRemoteLeafNode(remote *leafNodeAccCfg) {
 	delay := s.getOpts().LeafNode.ReconnectInterval
 	select {
 	case <-time.After(delay):
@@ -112,26 +160,44 @@ func newLeafNodeCfg(remote *RemoteLeafOpts) *leafNodeCfg {
 	return cfg
 }
 
-// Will pick an URL from the list of available URLs.
-func (cfg *leafNodeCfg) pickNextURL() *url.URL {
+// Returns the local accounts bound by the remote. Without a list of accounts,
+// the remote binds its local account with its credentials.
+func (cfg *leafNodeCfg) accounts() []*leafNodeAccCfg {
+	if len(cfg.Accounts) == 0 {
+		return []*leafNodeAccCfg{{leafNodeCfg: cfg, acc: cfg.LocalAccount, creds: cfg.Credentials}}
+	}
+	accs := make([]*leafNodeAccCfg, 0, len(cfg.Accounts))
+	for _, ra := range cfg.Accounts {
+		accs = append(accs, &leafNodeAccCfg{leafNodeCfg: cfg, acc: ra.LocalAccount, creds: ra.Credentials})
+	}
+	return accs
+}
+
+// Will pick an URL from the list of available URLs. The list is shared by
+// the accounts of the remote, so it is rotated only if the URL that this
+// account last used is still the current one.
+func (r *leafNodeAccCfg) pickNextURL() *url.URL {
+	cfg := r.leafNodeCfg
 	cfg.Lock()
 	defer cfg.Unlock()
 	// If the current URL is the first in the list and we have more than
 	// one URL, then move that one to end of the list.
-	if cfg.curURL != nil && len(cfg.urls) > 1 && urlsAreEqual(cfg.curURL, cfg.urls[0]) {
+	if r.url != nil && cfg.curURL != nil && len(cfg.urls) > 1 &&
+		urlsAreEqual(cfg.curURL, r.url) && urlsAreEqual(cfg.curURL, cfg.urls[0]) {
 		first := cfg.urls[0]
 		copy(cfg.urls, cfg.urls[1:])
 		cfg.urls[len(cfg.urls)-1] = first
 	}
 	cfg.curURL = cfg.urls[0]
-	return cfg.curURL
+	r.url = cfg.curURL
+	return r.url
 }
 
-// Returns the current URL
-func (cfg *leafNodeCfg) getCurrentURL() *url.URL {
-	cfg.RLock()
-	defer cfg.RUnlock()
-	return cfg.curURL
+// Returns the current URL of this account.
+func (r *leafNodeAccCfg) getCurrentURL() *url.URL {
+	r.RLock()
+	defer r.RUnlock()
+	return r.url
 }
 
 // Ensure that non-exported options (used in tests) have
@@ -149,7 +215,7 @@ func (s *Server) setLeafNodeNonExportedOptions() {
 	}
 }
 
-func (s *Server) connectToRemoteLeafNode(remote *leafNodeCfg) {
+func (s *Server) connectToRemoteLeafNode(remote *leafNodeAccCfg) {
 	defer s.grWG.Done()
 
 	if remote == nil || remote.URL == nil {
@@ -248,6 +314,7 @@ func (s *Server) leafNodeAcceptLoop(ch chan struct{}) {
 		TLSVerify:    tlsVerify,
 		MaxPayload:   s.info.MaxPayload, // TODO(dlc) - Allow override?
 		Proto:        1,                 // Fixed for now.
+		Headers:      true,
 	}
 	// If we have selected a random port...
 	if port == 0 {
@@ -300,13 +367,14 @@ var credsRe = regexp.MustCompile(`\s*(?:(?:[-]{3,}[^\n]*[-]{3,}\n)(.+)(?:\n\s*[-
 func (c *client) sendLeafConnect(tlsRequired bool) {
 	// We support basic user/pass and operator based user JWT with signatures.
 	cinfo := leafConnectInfo{
-		TLS:  tlsRequired,
-		Name: c.srv.info.ID,
+		TLS:     tlsRequired,
+		Name:    c.srv.info.ID,
+		Headers: true,
 	}
 
 	// Check for credentials first, that will take precedence..
-	if creds := c.leaf.remote.Credentials; creds != "" {
-		c.Debugf("Authenticating with credentials file %q", c.leaf.remote.Credentials)
+	if creds := c.leaf.remote.creds; creds != "" {
+		c.Debugf("Authenticating with credentials file %q", creds)
 		contents, err := ioutil.ReadFile(creds)
 		if err != nil {
 			c.Errorf("%v", err)
@@ -425,7 +493,7 @@ func (s *Server) sendAsyncLeafNodeInfo() {
 }
 
 // Called when an inbound leafnode connection is accepted or we create one for a solicited leafnode.
-func (s *Server) createLeafNode(conn net.Conn, remote *leafNodeCfg) *client {
+func (s *Server) createLeafNode(conn net.Conn, remote *leafNodeAccCfg) *client {
 	// Snapshot server options.
 	opts := s.getOpts()
 
@@ -447,13 +515,13 @@ func (s *Server) createLeafNode(conn net.Conn, remote *leafNodeCfg) *client {
 		solicited = true
 		// Users can bind to any local account, if its empty
 		// we will assume the $G account.
-		if remote.LocalAccount == "" {
-			remote.LocalAccount = globalAccountName
+		if remote.acc == "" {
+			remote.acc = globalAccountName
 		}
 		// FIXME(dlc) - Make this resolve at startup.
-		acc, err := s.LookupAccount(remote.LocalAccount)
+		acc, err := s.LookupAccount(remote.acc)
 		if err != nil {
-			c.Debugf("Can not locate local account %q for leafnode", remote.LocalAccount)
+			c.Debugf("Can not locate local account %q for leafnode", remote.acc)
 			c.closeConnection(MissingAccount)
 			return nil
 		}
@@ -464,6 +532,12 @@ func (s *Server) createLeafNode(conn net.Conn, remote *leafNodeCfg) *client {
 	// Grab server variables
 	s.mu.Lock()
 	info := s.copyLeafNodeInfo()
+	// Remember the nonce we send for signatures, etc. This
+	// needs the server lock.
+	if !solicited {
+		c.nonce = make([]byte, nonceLen)
+		s.generateNonce(c.nonce)
+	}
 	s.mu.Unlock()
 
 	// Grab lock
@@ -471,6 +545,11 @@ func (s *Server) createLeafNode(conn net.Conn, remote *leafNodeCfg) *client {
 
 	c.initClient()
 
+	// Apply the import/export permissions of the remote.
+	if solicited {
+		c.setRoutePermissions(remote.Permissions)
+	}
+
 	c.Debugf("Leafnode connection created")
 
 	if solicited {
@@ -556,9 +635,6 @@ func (s *Server) createLeafNode(conn net.Conn, remote *leafNodeCfg) *client {
 
 	} else {
 		// Send our info to the other side.
-		// Remember the nonce we sent here for signatures, etc.
-		c.nonce = make([]byte, nonceLen)
-		s.generateNonce(c.nonce)
 		info.Nonce = string(c.nonce)
 		info.CID = c.cid
 		b, _ := json.Marshal(info)
@@ -625,6 +701,13 @@ func (s *Server) createLeafNode(conn net.Conn, remote *leafNodeCfg) *client {
 	return c
 }
 
+// Sets the import/export permissions of a leafnode connection.
+func (c *client) setLeafNodePermissions(perms *RoutePermissions) {
+	c.mu.Lock()
+	c.setRoutePermissions(perms)
+	c.mu.Unlock()
+}
+
 func (c *client) processLeafnodeInfo(info *Info) {
 	c.mu.Lock()
 	defer c.mu.Unlock()
@@ -642,6 +725,7 @@ func (c *client) processLeafnodeInfo(info *Info) {
 		if info.TLSRequired && c.leaf.remote != nil {
 			c.leaf.remote.TLS = true
 		}
+		c.headers = info.Headers
 	}
 	// For both initial INFO and async INFO protocols, Possibly
 	// update our list of remote leafnode URLs we can connect to.
@@ -734,13 +818,14 @@ func (s *Server) removeLeafNodeConnection(c *client) {
 }
 
 type leafConnectInfo struct {
-	JWT  string `json:"jwt,omitempty"`
-	Sig  string `json:"sig,omitempty"`
-	User string `json:"user,omitempty"`
-	Pass string `json:"pass,omitempty"`
-	TLS  bool   `json:"tls_required"`
-	Comp bool   `json:"compression,omitempty"`
-	Name string `json:"name,omitempty"`
+	JWT     string `json:"jwt,omitempty"`
+	Sig     string `json:"sig,omitempty"`
+	User    string `json:"user,omitempty"`
+	Pass    string `json:"pass,omitempty"`
+	TLS     bool   `json:"tls_required"`
+	Comp    bool   `json:"compression,omitempty"`
+	Name    string `json:"name,omitempty"`
+	Headers bool   `json:"headers,omitempty"`
 
 	// Just used to detect wrong connection attempts.
 	Gateway string `json:"gateway,omitempty"`
@@ -775,9 +860,12 @@ func (c *client) processLeafNodeConnect(s *Server, arg []byte, lang string) erro
 	}
 
 	// Leaf Nodes do not do echo or verbose or pedantic.
+	c.mu.Lock()
 	c.opts.Verbose = false
 	c.opts.Echo = false
 	c.opts.Pedantic = false
+	c.headers = proto.Headers
+	c.mu.Unlock()
 
 	// Create and initialize the smap since we know our bound account now.
 	s.initLeafNodeSmap(c)
@@ -841,14 +929,16 @@ func (s *Server) initLeafNodeSmap(c *client) {
 	// Now walk the results and add them to our smap
 	c.mu.Lock()
 	for _, sub := range subs {
-		// We ignore ourselves here.
-		if c != sub.client {
+		// We ignore ourselves here, and subjects we do not import.
+		if c != sub.client && c.canImport(string(sub.subject)) {
 			c.leaf.smap[keyFromSub(sub)]++
 		}
 	}
 	// FIXME(dlc) - We need to update appropriately on an account claims update.
 	for _, isubj := range ims {
-		c.leaf.smap[isubj]++
+		if c.canImport(isubj) {
+			c.leaf.smap[isubj]++
+		}
 	}
 	c.mu.Unlock()
 }
@@ -893,6 +983,11 @@ func (c *client) updateSmap(sub *subscription, delta int32) {
 	key := keyFromSub(sub)
 
 	c.mu.Lock()
+	// Do not send interest for subjects we do not import.
+	if !c.canImport(string(sub.subject)) {
+		c.mu.Unlock()
+		return
+	}
 	n := c.leaf.smap[key]
 	// We will update if its a queue, if count is zero (or negative), or we were 0 and are N > 0.
 	update := sub.queue != nil || n == 0 || n+delta <= 0
@@ -1125,7 +1220,19 @@ func (c *client) processLeafMsgArgs(trace bool, arg []byte) error {
 	if trace {
 		c.traceInOp("LMSG", arg)
 	}
+	return c.processLeafArgs(arg, false)
+}
+
+// Process an inbound HMSG from a leaf node. This is an LMSG with
+// the header size preceding the total size.
+func (c *client) processLeafHeaderMsgArgs(trace bool, arg []byte) error {
+	if trace {
+		c.traceInOp("HMSG", arg)
+	}
+	return c.processLeafArgs(arg, true)
+}
 
+func (c *client) processLeafArgs(arg []byte, hdr bool) error {
 	// Unroll splitArgs to avoid runtime/heap issues
 	a := [MAX_MSG_ARGS][]byte{}
 	args := a[:0]
@@ -1148,6 +1255,12 @@ func (c *client) processLeafMsgArgs(trace bool, arg []byte) error {
 	}
 
 	c.pa.arg = arg
+	if hdr {
+		var err error
+		if args, err = c.processHeaderSizeArg(args); err != nil {
+			return fmt.Errorf("processLeafMsgArgs %v: '%s'", err, arg)
+		}
+	}
 	switch len(args) {
 	case 0, 1:
 		return fmt.Errorf("processLeafMsgArgs Parse Error: '%s'", args)
@@ -1206,9 +1319,14 @@ func (c *client) processInboundLeafMsg(msg []byte) {
 		c.traceMsg(msg)
 	}
 
-	// Check pub permissions
-	if c.perms != nil && (c.perms.pub.allow != nil || c.perms.pub.deny != nil) && !c.pubAllowed(string(c.pa.subject)) {
-		c.pubPermissionViolation(c.pa.subject)
+	// Check import permissions. Messages are dropped rather than reported
+	// since any error closes the leafnode connection. The permissions cache
+	// is also updated when sending interest, so the lock is needed.
+	c.mu.Lock()
+	allowed := c.canImport(string(c.pa.subject))
+	c.mu.Unlock()
+	if !allowed {
+		c.Debugf("Not permitted to import %q, dropping message", c.pa.subject)
 		return
 	}
 
@@ -136,3 +136,43 @@ func TestLeafNodeTLSWithCerts(t *testing.T) {
 		return nil
 	})
 }
+
+func TestLeafNodeRemoteAccountsShareURLs(t *testing.T) {
+	u1, _ := url.Parse("nats-leaf://127.0.0.1:1234")
+	u2, _ := url.Parse("nats-leaf://127.0.0.1:1235")
+	cfg := newLeafNodeCfg(&RemoteLeafOpts{
+		URL: u1,
+		Accounts: []*RemoteLeafAccount{
+			{LocalAccount: "A", Credentials: "a.creds"},
+			{LocalAccount: "B", Credentials: "b.creds"},
+		},
+	})
+	cfg.urls = append(cfg.urls, u2)
+
+	accs := cfg.accounts()
+	if len(accs) != 2 || accs[0].acc != "A" || accs[0].creds != "a.creds" || accs[1].acc != "B" {
+		t.Fatalf("Unexpected accounts: %+v", accs)
+	}
+	a, b := accs[0], accs[1]
+	check := func(r *leafNodeAccCfg, expected *url.URL) {
+		t.Helper()
+		if u := r.pickNextURL(); u != expected {
+			t.Fatalf("Expected account %q to pick %v, got %v", r.acc, expected, u)
+		}
+		if u := r.getCurrentURL(); u != expected {
+			t.Fatalf("Expected account %q current URL to be %v, got %v", r.acc, expected, u)
+		}
+	}
+	check(a, u1)
+	check(b, u1)
+	// Once an account fails, the others follow without rotating again.
+	check(a, u2)
+	check(b, u2)
+	check(b, u1)
+
+	// Without a list of accounts, the remote binds its local account.
+	accs = newLeafNodeCfg(&RemoteLeafOpts{URL: u1, LocalAccount: "C", Credentials: "c.creds"}).accounts()
+	if len(accs) != 1 || accs[0].acc != "C" || accs[0].creds != "c.creds" {
+		t.Fatalf("Unexpected accounts: %+v", accs)
+	}
+}
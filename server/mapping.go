@@ -0,0 +1,274 @@
+// Copyright 2019 The NATS Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package server
+
+import (
+	"fmt"
+	"math/rand"
+	"strconv"
+	"strings"
+	"sync/atomic"
+)
+
+// MapDest is a destination of a subject mapping, with the percentage of
+// the messages it receives.
+type MapDest struct {
+	Subject string `json:"subject"`
+	Weight  uint8  `json:"weight"`
+}
+
+// NewMapDest returns a destination of a subject mapping.
+func NewMapDest(subject string, weight uint8) *MapDest {
+	return &MapDest{subject, weight}
+}
+
+// mapping maps messages published to a subject, which may contain
+// wildcards, to destinations selected by weight.
+type mapping struct {
+	src   string
+	wc    bool
+	dests []*destination
+	// Total weight of the destinations. Messages are left untouched for
+	// the remainder up to 100.
+	total int32
+}
+
+type destination struct {
+	tr     *transform
+	weight uint8
+}
+
+// transform rewrites subjects matching a source into a destination, where
+// the wildcard tokens of the source are referenced either with $1, $2, ...
+// in order of appearance, or with the same wildcards in the same order.
+type transform struct {
+	src  string
+	dest string
+	// Destination tokens.
+	dtoks []string
+	// For each destination token, the index of the source token it is
+	// replaced with, or -1 if literal.
+	dtpi []int
+	// Index of the full wildcard in the source, or -1 if none.
+	sfwc int
+	// Whether the destination does not depend on the subject.
+	literal bool
+}
+
+// newTransform validates the source and destination subjects and returns
+// the transform between them.
+func newTransform(src, dest string) (*transform, error) {
+	if !IsValidSubject(src) {
+		return nil, fmt.Errorf("invalid mapping source subject %q", src)
+	}
+	if !IsValidSubject(dest) {
+		return nil, fmt.Errorf("invalid mapping destination subject %q", dest)
+	}
+	stoks := strings.Split(src, tsep)
+	var spwcs []int
+	sfwc := -1
+	for i, t := range stoks {
+		switch t {
+		case "*":
+			spwcs = append(spwcs, i)
+		case ">":
+			sfwc = i
+		}
+	}
+
+	tr := &transform{src: src, dest: dest, dtoks: strings.Split(dest, tsep), sfwc: sfwc}
+	tr.dtpi = make([]int, len(tr.dtoks))
+	npwcs, nrefs := 0, 0
+	for i, t := range tr.dtoks {
+		tr.dtpi[i] = -1
+		switch {
+		case t == "*":
+			if npwcs >= len(spwcs) {
+				return nil, fmt.Errorf("mapping destination %q has more wildcards than source %q", dest, src)
+			}
+			tr.dtpi[i] = spwcs[npwcs]
+			npwcs++
+		case t == ">":
+			if sfwc < 0 {
+				return nil, fmt.Errorf("mapping destination %q has a full wildcard not in source %q", dest, src)
+			}
+		case len(t) > 1 && t[0] == '$':
+			n, err := strconv.Atoi(t[1:])
+			if err != nil || n < 1 || n > len(spwcs) {
+				return nil, fmt.Errorf("mapping destination %q references an unknown wildcard of source %q", dest, src)
+			}
+			tr.dtpi[i] = spwcs[n-1]
+			nrefs++
+		}
+	}
+	if npwcs > 0 && nrefs > 0 {
+		return nil, fmt.Errorf("mapping destination %q can not mix wildcards and references", dest)
+	}
+	if npwcs > 0 && npwcs != len(spwcs) {
+		return nil, fmt.Errorf("mapping destination %q does not have as many wildcards as source %q", dest, src)
+	}
+	if sfwc >= 0 && tr.dtoks[len(tr.dtoks)-1] != ">" {
+		return nil, fmt.Errorf("mapping destination %q must end with a full wildcard like source %q", dest, src)
+	}
+	tr.literal = npwcs == 0 && nrefs == 0 && sfwc < 0
+	return tr, nil
+}
+
+// Returns the destination subject for a subject matching the source.
+func (tr *transform) transform(subject string) string {
+	if tr.literal {
+		return tr.dest
+	}
+	stoks := strings.Split(subject, tsep)
+	var b strings.Builder
+	for i, t := range tr.dtoks {
+		if i > 0 {
+			b.WriteByte(btsep)
+		}
+		switch {
+		case tr.dtpi[i] >= 0:
+			b.WriteString(stoks[tr.dtpi[i]])
+		case t == ">":
+			b.WriteString(strings.Join(stoks[tr.sfwc:], tsep))
+		default:
+			b.WriteString(t)
+		}
+	}
+	return b.String()
+}
+
+// AddMapping adds a mapping of messages published to src to dest.
+func (a *Account) AddMapping(src, dest string) error {
+	return a.AddWeightedMappings(src, NewMapDest(dest, 100))
+}
+
+// AddWeightedMappings adds a mapping of messages published to src to the
+// destinations, selected for each message according to their weights.
+// If the weights add up to less than 100, the remaining messages are not
+// mapped.
+func (a *Account) AddWeightedMappings(src string, dests ...*MapDest) error {
+	m, err := newMapping(src, dests)
+	if err != nil {
+		return err
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	for i, em := range a.mappings {
+		if em.src == src {
+			a.mappings[i] = m
+			return nil
+		}
+	}
+	a.mappings = append(a.mappings, m)
+	atomic.StoreInt32(&a.mapped, 1)
+	return nil
+}
+
+// RemoveMapping removes the mapping of src, returning true if found.
+func (a *Account) RemoveMapping(src string) bool {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	for i, m := range a.mappings {
+		if m.src == src {
+			a.mappings = append(a.mappings[:i], a.mappings[i+1:]...)
+			if len(a.mappings) == 0 {
+				atomic.StoreInt32(&a.mapped, 0)
+			}
+			return true
+		}
+	}
+	return false
+}
+
+// Replaces the mappings of the account.
+// Lock should be held.
+func (a *Account) setMappings(mappings []*mapping) {
+	a.mappings = mappings
+	if len(mappings) > 0 {
+		atomic.StoreInt32(&a.mapped, 1)
+	} else {
+		atomic.StoreInt32(&a.mapped, 0)
+	}
+}
+
+// Returns true if the account has subject mappings.
+func (a *Account) hasMappings() bool {
+	return atomic.LoadInt32(&a.mapped) == 1
+}
+
+// selectMappedSubject returns the subject a message published to the given
+// subject is mapped to, and whether it was.
+func (a *Account) selectMappedSubject(subject string) (string, bool) {
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+
+	var m *mapping
+	// Literal mappings take precedence over wildcard ones.
+	for _, em := range a.mappings {
+		if !em.wc && em.src == subject {
+			m = em
+			break
+		}
+	}
+	if m == nil {
+		for _, em := range a.mappings {
+			if em.wc && matchLiteral(subject, em.src) {
+				m = em
+				break
+			}
+		}
+	}
+	if m == nil {
+		return subject, false
+	}
+
+	d := m.dests[0]
+	if len(m.dests) > 1 || m.total < 100 {
+		d = nil
+		r := rand.Int31n(100)
+		var w int32
+		for _, ed := range m.dests {
+			if w += int32(ed.weight); r < w {
+				d = ed
+				break
+			}
+		}
+		if d == nil {
+			return subject, false
+		}
+	}
+	return d.tr.transform(subject), true
+}
+
+// Validates the destinations of a mapping and returns it.
+func newMapping(src string, dests []*MapDest) (*mapping, error) {
+	if len(dests) == 0 {
+		return nil, fmt.Errorf("mapping of %q has no destination", src)
+	}
+	m := &mapping{src: src, wc: subjectHasWildcard(src)}
+	for _, d := range dests {
+		if d.Weight == 0 || d.Weight > 100 {
+			return nil, fmt.Errorf("mapping of %q to %q has invalid weight %d", src, d.Subject, d.Weight)
+		}
+		tr, err := newTransform(src, d.Subject)
+		if err != nil {
+			return nil, err
+		}
+		if m.total += int32(d.Weight); m.total > 100 {
+			return nil, fmt.Errorf("mapping of %q has weights adding up to more than 100", src)
+		}
+		m.dests = append(m.dests, &destination{tr, d.Weight})
+	}
+	return m, nil
+}
@@ -0,0 +1,234 @@
+// Copyright 2019 The NATS Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package server
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/nats-io/jwt"
+	"github.com/nats-io/nkeys"
+)
+
+func TestSubjectTransform(t *testing.T) {
+	for _, test := range []struct {
+		src, dest, subject, expected string
+	}{
+		{"foo", "bar", "foo", "bar"},
+		{"old.orders.*", "orders.v2.$1", "old.orders.1", "orders.v2.1"},
+		{"foo.*.*", "bar.$2.$1", "foo.a.b", "bar.b.a"},
+		{"foo.*.*", "bar.*.*", "foo.a.b", "bar.a.b"},
+		{"foo.*.>", "bar.$1.>", "foo.a.b.c", "bar.a.b.c"},
+		{"foo.>", "bar.>", "foo.a.b", "bar.a.b"},
+		{"foo.*", "bar", "foo.a", "bar"},
+	} {
+		tr, err := newTransform(test.src, test.dest)
+		if err != nil {
+			t.Fatalf("Unexpected error for %q to %q: %v", test.src, test.dest, err)
+		}
+		if s := tr.transform(test.subject); s != test.expected {
+			t.Fatalf("Expected %q to be mapped to %q, got %q", test.subject, test.expected, s)
+		}
+	}
+
+	for _, test := range []struct {
+		src, dest string
+	}{
+		{"foo..bar", "bar"},
+		{"foo", "bar..baz"},
+		{"foo", "bar.$1"},
+		{"foo.*", "bar.$2"},
+		{"foo.*", "bar.$x"},
+		{"foo.*", "bar.*.*"},
+		{"foo.*.*", "bar.*"},
+		{"foo.*.*", "bar.*.$2"},
+		{"foo.*", "bar.>"},
+		{"foo.>", "bar"},
+	} {
+		if _, err := newTransform(test.src, test.dest); err == nil {
+			t.Fatalf("Expected error for %q to %q", test.src, test.dest)
+		}
+	}
+}
+
+func TestAccountMappings(t *testing.T) {
+	acc := NewAccount("A")
+	if acc.hasMappings() {
+		t.Fatal("Expected no mappings")
+	}
+	if err := acc.AddMapping("foo.*", "bar.$1"); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if err := acc.AddMapping("foo.baz", "baz"); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	check := func(subject, expected string) {
+		t.Helper()
+		s, ok := acc.selectMappedSubject(subject)
+		if ok != (s != subject) || s != expected {
+			t.Fatalf("Expected %q to be mapped to %q, got %q", subject, expected, s)
+		}
+	}
+	check("foo.bar", "bar.bar")
+	// Literal mappings take precedence.
+	check("foo.baz", "baz")
+	check("foo", "foo")
+	check("foo.bar.baz", "foo.bar.baz")
+
+	if !acc.RemoveMapping("foo.baz") || acc.RemoveMapping("foo.baz") {
+		t.Fatal("Expected mapping to be removed once")
+	}
+	check("foo.baz", "bar.baz")
+	acc.RemoveMapping("foo.*")
+	if acc.hasMappings() {
+		t.Fatal("Expected no mappings")
+	}
+
+	if err := acc.AddWeightedMappings("foo", NewMapDest("a", 60), NewMapDest("b", 50)); err == nil {
+		t.Fatal("Expected error for weights above 100")
+	}
+	if err := acc.AddWeightedMappings("foo", NewMapDest("a", 0)); err == nil {
+		t.Fatal("Expected error for weight of 0")
+	}
+
+	if err := acc.AddWeightedMappings("foo", NewMapDest("a", 50), NewMapDest("b", 30)); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	counts := map[string]int{}
+	total := 10000
+	for i := 0; i < total; i++ {
+		s, _ := acc.selectMappedSubject("foo")
+		counts[s]++
+	}
+	for s, expected := range map[string]int{"a": 50, "b": 30, "foo": 20} {
+		if pct := counts[s] * 100 / total; pct < expected-5 || pct > expected+5 {
+			t.Fatalf("Expected about %d%% of messages to %q, got %d%%", expected, s, pct)
+		}
+	}
+}
+
+func TestAccountMappingsConfigAndReload(t *testing.T) {
+	template := `
+		listen: 127.0.0.1:-1
+		accounts {
+			A {
+				users [ {user: a, password: pwd} ]
+				mappings = {
+					"old.orders.*": "%s"
+					"canary": [
+						{destination: "canary.v1", weight: 100%%}
+					]
+				}
+			}
+		}
+	`
+	conf := createConfFile(t, []byte(fmt.Sprintf(template, "orders.v2.$1")))
+	defer os.Remove(conf)
+	s, _ := RunServerWithConfig(conf)
+	defer s.Shutdown()
+
+	url := fmt.Sprintf("nats://a:pwd@%s:%d", s.getOpts().Host, s.getOpts().Port)
+	nc := natsConnect(t, url)
+	defer nc.Close()
+	sub := natsSubSync(t, nc, ">")
+	natsFlush(t, nc)
+
+	checkMsg := func(pub, expected string) {
+		t.Helper()
+		natsPub(t, nc, pub, []byte("hello"))
+		if msg := natsNexMsg(t, sub, time.Second); msg.Subject != expected {
+			t.Fatalf("Expected message on %q, got %q", expected, msg.Subject)
+		}
+	}
+	checkMsg("old.orders.1", "orders.v2.1")
+	checkMsg("canary", "canary.v1")
+	checkMsg("orders.1", "orders.1")
+
+	reloadUpdateConfig(t, s, conf, fmt.Sprintf(template, "orders.v3.$1"))
+	// The client is on the reloaded account once it has been processed.
+	checkFor(t, 2*time.Second, 15*time.Millisecond, func() error {
+		natsPub(t, nc, "old.orders.2", []byte("hello"))
+		if msg := natsNexMsg(t, sub, time.Second); msg.Subject != "orders.v3.2" {
+			return fmt.Errorf("Expected message on %q, got %q", "orders.v3.2", msg.Subject)
+		}
+		return nil
+	})
+
+	for _, mappings := range []string{
+		`"foo.*": "bar.$2"`,
+		`"foo": [{destination: "bar", weight: 80}, {destination: "baz", weight: 30}]`,
+		`"foo": {destination: "bar", weight: "abc"}`,
+		`"foo": {weight: 10}`,
+		`"foo": {destination: "bar", foo: 10}`,
+	} {
+		conf := createConfFile(t, []byte(fmt.Sprintf(`accounts { A { mappings = { %s } } }`, mappings)))
+		defer os.Remove(conf)
+		if _, err := ProcessConfigFile(conf); err == nil {
+			t.Fatalf("Expected error for mappings %s", mappings)
+		}
+	}
+}
+
+func TestJWTAccountMappings(t *testing.T) {
+	s := opTrustBasicSetup()
+	defer s.Shutdown()
+	buildMemAccResolver(s)
+
+	okp, _ := nkeys.FromSeed(oSeed)
+	akp, _ := nkeys.CreateAccount()
+	apub, _ := akp.PublicKey()
+	ajwt, err := jwt.NewAccountClaims(apub).Encode(okp)
+	if err != nil {
+		t.Fatalf("Error generating account JWT: %v", err)
+	}
+	ajwt = editAccountClaims(t, ajwt, okp, func(nats map[string]interface{}) {
+		nats["mappings"] = map[string]interface{}{
+			"old.*":  []interface{}{map[string]interface{}{"subject": "new.$1"}},
+			"split":  []interface{}{map[string]interface{}{"subject": "a", "weight": 50}, map[string]interface{}{"subject": "b", "weight": 50}},
+			"bad.*":  []interface{}{map[string]interface{}{"subject": "bad.$2"}},
+			"ignore": []interface{}{},
+		}
+	})
+	addAccountToMemResolver(s, apub, ajwt)
+	acc, _ := s.LookupAccount(apub)
+	if acc == nil {
+		t.Fatalf("Could not retrieve account for %q", apub)
+	}
+
+	if subj, _ := acc.selectMappedSubject("old.foo"); subj != "new.foo" {
+		t.Fatalf("Expected mapping to %q, got %q", "new.foo", subj)
+	}
+	if subj, _ := acc.selectMappedSubject("split"); subj != "a" && subj != "b" {
+		t.Fatalf("Expected mapping to a or b, got %q", subj)
+	}
+	acc.mu.RLock()
+	n := len(acc.mappings)
+	acc.mu.RUnlock()
+	if n != 2 {
+		t.Fatalf("Expected invalid mappings to be ignored, got %d mappings", n)
+	}
+
+	// Mappings are applied to messages of the account's clients.
+	c, cr, cs := createClient(t, s, akp)
+	go c.parse([]byte(cs + "SUB new.> 1\r\nPUB old.foo 2\r\nok\r\nPING\r\n"))
+	if l, _ := cr.ReadString('\n'); !strings.HasPrefix(l, "PONG") {
+		t.Fatalf("Expected a PONG, got %q", l)
+	}
+	if l, _ := cr.ReadString('\n'); !strings.HasPrefix(l, "MSG new.foo 1 2") {
+		t.Fatalf("Expected mapped message, got %q", l)
+	}
+}
@@ -0,0 +1,310 @@
+// Copyright 2019 The NATS Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package server
+
+import (
+	"bytes"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Metric types of the Prometheus text exposition format.
+const (
+	metricCounter = "counter"
+	metricGauge   = "gauge"
+)
+
+// Content type of the Prometheus text exposition format.
+const metricsContentType = "text/plain; version=0.0.4; charset=utf-8"
+
+// A single sample of a metric family. Labels are stored as
+// name/value pairs.
+type metricSample struct {
+	labels []string
+	value  float64
+}
+
+// Builds the text exposition of metric families.
+type metricsWriter struct {
+	bytes.Buffer
+}
+
+// Escapes a label value as required by the text exposition format.
+var metricLabelEscaper = strings.NewReplacer(`\`, `\\`, "\n", `\n`, `"`, `\"`)
+
+// write adds a metric family with its samples. Families without
+// samples are omitted.
+func (mw *metricsWriter) write(name, typ, help string, samples ...metricSample) {
+	if len(samples) == 0 {
+		return
+	}
+	mw.WriteString("# HELP " + name + " " + help + "\n")
+	mw.WriteString("# TYPE " + name + " " + typ + "\n")
+	for _, s := range samples {
+		mw.WriteString(name)
+		if len(s.labels) > 0 {
+			mw.WriteByte('{')
+			for i := 0; i+1 < len(s.labels); i += 2 {
+				if i > 0 {
+					mw.WriteByte(',')
+				}
+				mw.WriteString(s.labels[i])
+				mw.WriteString(`="`)
+				mw.WriteString(metricLabelEscaper.Replace(s.labels[i+1]))
+				mw.WriteByte('"')
+			}
+			mw.WriteByte('}')
+		}
+		mw.WriteByte(' ')
+		mw.WriteString(strconv.FormatFloat(s.value, 'g', -1, 64))
+		mw.WriteByte('\n')
+	}
+}
+
+// Traffic and interest statistics of a route, gateway or leafnode
+// connection, along with the labels identifying it.
+type connMetrics struct {
+	labels   []string
+	inMsgs   int64
+	outMsgs  int64
+	inBytes  int64
+	outBytes int64
+	subs     uint32
+	pending  int
+}
+
+// writeConns adds the metric families for the given connections. The
+// prefix is used for the family names, and kind in the help text.
+func (mw *metricsWriter) writeConns(prefix, kind string, conns []*connMetrics) {
+	if len(conns) == 0 {
+		return
+	}
+	families := []struct {
+		suffix string
+		typ    string
+		help   string
+		value  func(cm *connMetrics) float64
+	}{
+		{"_in_msgs_total", metricCounter, "Number of messages received from the " + kind + ".",
+			func(cm *connMetrics) float64 { return float64(cm.inMsgs) }},
+		{"_out_msgs_total", metricCounter, "Number of messages sent to the " + kind + ".",
+			func(cm *connMetrics) float64 { return float64(cm.outMsgs) }},
+		{"_in_bytes_total", metricCounter, "Number of bytes received from the " + kind + ".",
+			func(cm *connMetrics) float64 { return float64(cm.inBytes) }},
+		{"_out_bytes_total", metricCounter, "Number of bytes sent to the " + kind + ".",
+			func(cm *connMetrics) float64 { return float64(cm.outBytes) }},
+		{"_subscriptions", metricGauge, "Number of subscriptions registered for the " + kind + ".",
+			func(cm *connMetrics) float64 { return float64(cm.subs) }},
+		{"_pending_bytes", metricGauge, "Number of bytes pending to be sent to the " + kind + ".",
+			func(cm *connMetrics) float64 { return float64(cm.pending) }},
+	}
+	for _, f := range families {
+		samples := make([]metricSample, 0, len(conns))
+		for _, cm := range conns {
+			samples = append(samples, metricSample{cm.labels, f.value(cm)})
+		}
+		mw.write(prefix+f.suffix, f.typ, f.help, samples...)
+	}
+}
+
+// Metrics returns the server metrics in the Prometheus text
+// exposition format.
+func (s *Server) Metrics() []byte {
+	mw := &metricsWriter{}
+
+	v, _ := s.Varz(nil)
+	mw.write("nats_server_info", metricGauge, "Server information.",
+		metricSample{[]string{"server_id", v.ID, "version", v.Version, "go", v.GoVersion}, 1})
+	mw.write("nats_start_time_seconds", metricGauge, "Server start time since unix epoch in seconds.",
+		metricSample{value: float64(v.Start.UnixNano()) / 1e9})
+	mw.write("nats_cpu_percent", metricGauge, "Server process CPU usage.",
+		metricSample{value: v.CPU})
+	mw.write("nats_memory_bytes", metricGauge, "Server process resident memory size in bytes.",
+		metricSample{value: float64(v.Mem)})
+	mw.write("nats_cores", metricGauge, "Number of logical CPUs available to the server.",
+		metricSample{value: float64(v.Cores)})
+	mw.write("nats_connections", metricGauge, "Number of client connections.",
+		metricSample{value: float64(v.Connections)})
+	mw.write("nats_connections_total", metricCounter, "Number of client connections since the server started.",
+		metricSample{value: float64(v.TotalConnections)})
+	mw.write("nats_subscriptions", metricGauge, "Number of subscriptions.",
+		metricSample{value: float64(v.Subscriptions)})
+	mw.write("nats_routes", metricGauge, "Number of route connections.",
+		metricSample{value: float64(v.Routes)})
+	mw.write("nats_remotes", metricGauge, "Number of remote servers.",
+		metricSample{value: float64(v.Remotes)})
+	mw.write("nats_in_msgs_total", metricCounter, "Number of messages received.",
+		metricSample{value: float64(v.InMsgs)})
+	mw.write("nats_out_msgs_total", metricCounter, "Number of messages sent.",
+		metricSample{value: float64(v.OutMsgs)})
+	mw.write("nats_in_bytes_total", metricCounter, "Number of bytes received.",
+		metricSample{value: float64(v.InBytes)})
+	mw.write("nats_out_bytes_total", metricCounter, "Number of bytes sent.",
+		metricSample{value: float64(v.OutBytes)})
+	mw.write("nats_slow_consumers_total", metricCounter, "Number of slow consumers.",
+		metricSample{value: float64(v.SlowConsumers)})
+	mw.write("nats_duplicate_msgs_total", metricCounter, "Number of messages dropped as duplicates.",
+		metricSample{value: float64(v.DuplicateMsgs)})
+
+	paths := make([]string, 0, len(v.HTTPReqStats))
+	for path := range v.HTTPReqStats {
+		paths = append(paths, path)
+	}
+	sort.Strings(paths)
+	reqs := make([]metricSample, 0, len(paths))
+	for _, path := range paths {
+		reqs = append(reqs, metricSample{[]string{"path", path}, float64(v.HTTPReqStats[path])})
+	}
+	mw.write("nats_http_requests_total", metricCounter, "Number of requests per monitoring endpoint.", reqs...)
+
+	// Routes
+	rz, _ := s.Routez(nil)
+	conns := make([]*connMetrics, 0, len(rz.Routes))
+	for _, r := range rz.Routes {
+		conns = append(conns, &connMetrics{
+			labels:   []string{"route_id", strconv.FormatUint(r.Rid, 10), "remote_id", r.RemoteID},
+			inMsgs:   r.InMsgs,
+			outMsgs:  r.OutMsgs,
+			inBytes:  r.InBytes,
+			outBytes: r.OutBytes,
+			subs:     r.NumSubs,
+			pending:  r.Pending,
+		})
+	}
+	mw.writeConns("nats_route", "route", conns)
+
+	// Gateways
+	gz, _ := s.Gatewayz(nil)
+	conns = conns[:0]
+	addGateway := func(name, dir string, ci *ConnInfo) {
+		if ci == nil {
+			return
+		}
+		conns = append(conns, &connMetrics{
+			labels:   []string{"gateway", name, "direction", dir, "cid", strconv.FormatUint(ci.Cid, 10)},
+			inMsgs:   ci.InMsgs,
+			outMsgs:  ci.OutMsgs,
+			inBytes:  ci.InBytes,
+			outBytes: ci.OutBytes,
+			subs:     ci.NumSubs,
+			pending:  ci.Pending,
+		})
+	}
+	names := make([]string, 0, len(gz.OutboundGateways))
+	for name := range gz.OutboundGateways {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		addGateway(name, "outbound", gz.OutboundGateways[name].Connection)
+	}
+	names = names[:0]
+	for name := range gz.InboundGateways {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		for _, rgw := range gz.InboundGateways[name] {
+			addGateway(name, "inbound", rgw.Connection)
+		}
+	}
+	mw.writeConns("nats_gateway", "gateway", conns)
+
+	// Leafnodes
+	lz, _ := s.Leafz(nil)
+	sort.Slice(lz.Leafs, func(i, j int) bool { return lz.Leafs[i].Cid < lz.Leafs[j].Cid })
+	conns = conns[:0]
+	for _, l := range lz.Leafs {
+		conns = append(conns, &connMetrics{
+			labels:   []string{"cid", strconv.FormatUint(l.Cid, 10), "account", l.Account},
+			inMsgs:   l.InMsgs,
+			outMsgs:  l.OutMsgs,
+			inBytes:  l.InBytes,
+			outBytes: l.OutBytes,
+			subs:     l.NumSubs,
+			pending:  l.Pending,
+		})
+	}
+	mw.write("nats_leafnodes", metricGauge, "Number of leafnode connections.",
+		metricSample{value: float64(len(lz.Leafs))})
+	mw.writeConns("nats_leafnode", "leafnode", conns)
+
+	// Accounts
+	var accs []*AccountInfo
+	s.accounts.Range(func(_, v interface{}) bool {
+		accs = append(accs, v.(*Account).accountInfo())
+		return true
+	})
+	sort.Slice(accs, func(i, j int) bool { return accs[i].AccountName < accs[j].AccountName })
+	families := []struct {
+		name  string
+		typ   string
+		help  string
+		value func(ai *AccountInfo) float64
+	}{
+		{"nats_account_connections", metricGauge, "Number of client connections of the account on this server.",
+			func(ai *AccountInfo) float64 { return float64(ai.Conns) }},
+		{"nats_account_total_connections", metricGauge, "Number of client connections of the account in the system.",
+			func(ai *AccountInfo) float64 { return float64(ai.TotalConns) }},
+		{"nats_account_leafnodes", metricGauge, "Number of leafnode connections of the account on this server.",
+			func(ai *AccountInfo) float64 { return float64(ai.LeafNodes) }},
+		{"nats_account_subscriptions", metricGauge, "Number of subscriptions of the account.",
+			func(ai *AccountInfo) float64 { return float64(ai.Subscriptions) }},
+		{"nats_account_in_msgs_total", metricCounter, "Number of messages received from clients and leafnodes of the account.",
+			func(ai *AccountInfo) float64 { return float64(ai.Received.Msgs) }},
+		{"nats_account_out_msgs_total", metricCounter, "Number of messages sent to clients and leafnodes of the account.",
+			func(ai *AccountInfo) float64 { return float64(ai.Sent.Msgs) }},
+		{"nats_account_in_bytes_total", metricCounter, "Number of bytes received from clients and leafnodes of the account.",
+			func(ai *AccountInfo) float64 { return float64(ai.Received.Bytes) }},
+		{"nats_account_out_bytes_total", metricCounter, "Number of bytes sent to clients and leafnodes of the account.",
+			func(ai *AccountInfo) float64 { return float64(ai.Sent.Bytes) }},
+	}
+	for _, f := range families {
+		samples := make([]metricSample, 0, len(accs))
+		for _, ai := range accs {
+			samples = append(samples, metricSample{[]string{"account", ai.AccountName}, f.value(ai)})
+		}
+		mw.write(f.name, f.typ, f.help, samples...)
+	}
+
+	// Account resolver cache
+	if cr, ok := s.AccountResolver().(*CacheAccResolver); ok {
+		st := cr.Stats()
+		mw.write("nats_account_resolver_cache_hits_total", metricCounter, "Number of account fetches served from the resolver cache.",
+			metricSample{value: float64(st.Hits)})
+		mw.write("nats_account_resolver_cache_stale_hits_total", metricCounter, "Number of account fetches served from the resolver cache past their TTL.",
+			metricSample{value: float64(st.StaleHits)})
+		mw.write("nats_account_resolver_cache_misses_total", metricCounter, "Number of account fetches not found in the resolver cache.",
+			metricSample{value: float64(st.Misses)})
+		mw.write("nats_account_resolver_fetch_errors_total", metricCounter, "Number of failed fetches from the account resolver.",
+			metricSample{value: float64(st.FetchErrors)})
+		mw.write("nats_account_resolver_cache_entries", metricGauge, "Number of entries in the resolver cache.",
+			metricSample{value: float64(st.Entries)})
+	}
+
+	return mw.Bytes()
+}
+
+// HandleMetrics process HTTP requests for metrics in the Prometheus
+// text exposition format.
+func (s *Server) HandleMetrics(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	s.httpReqStats[MetricsPath]++
+	s.mu.Unlock()
+
+	w.Header().Set("Content-Type", metricsContentType)
+	w.Write(s.Metrics())
+}
@@ -0,0 +1,131 @@
+// Copyright 2019 The NATS Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package server
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestMetricsWriter(t *testing.T) {
+	mw := &metricsWriter{}
+	mw.write("empty", metricGauge, "Not written.")
+	mw.write("test_total", metricCounter, "A test counter.",
+		metricSample{value: 1},
+		metricSample{[]string{"a", "x", "b", "with \"quote\", \\ and\nnewline"}, 2.5})
+	expected := "# HELP test_total A test counter.\n" +
+		"# TYPE test_total counter\n" +
+		"test_total 1\n" +
+		"test_total{a=\"x\",b=\"with \\\"quote\\\", \\\\ and\\nnewline\"} 2.5\n"
+	if got := mw.String(); got != expected {
+		t.Fatalf("Expected\n%s\ngot\n%s", expected, got)
+	}
+}
+
+var metricsLineRe = regexp.MustCompile(`^[a-z_]+(\{([a-z_]+="[^"]*",?)+\})? [-+0-9.e]+$`)
+
+func TestMetrics(t *testing.T) {
+	oa := DefaultOptions()
+	oa.HTTPHost = "127.0.0.1"
+	oa.HTTPPort = -1
+	oa.Cluster.Host = "127.0.0.1"
+	oa.Cluster.Port = -1
+	oa.LeafNode.Host = "127.0.0.1"
+	oa.LeafNode.Port = -1
+	sa := RunServer(oa)
+	defer sa.Shutdown()
+
+	ob := nextServerOpts(oa)
+	ob.HTTPPort = -1
+	ob.LeafNode.Port = 0
+	ob.Routes = RoutesFromStr(fmt.Sprintf("nats://127.0.0.1:%d", oa.Cluster.Port))
+	sb := RunServer(ob)
+	defer sb.Shutdown()
+	checkClusterFormed(t, sa, sb)
+
+	u, _ := url.Parse(fmt.Sprintf("nats://127.0.0.1:%d", oa.LeafNode.Port))
+	ol := DefaultOptions()
+	ol.LeafNode.Remotes = []*RemoteLeafOpts{{URL: u}}
+	sl := RunServer(ol)
+	defer sl.Shutdown()
+	checkFor(t, 2*time.Second, 15*time.Millisecond, func() error {
+		if n := sa.NumLeafNodes(); n != 1 {
+			return fmt.Errorf("Expected 1 leafnode, got %v", n)
+		}
+		return nil
+	})
+
+	nc := natsConnect(t, fmt.Sprintf("nats://127.0.0.1:%d", oa.Port))
+	defer nc.Close()
+	natsSubSync(t, nc, "foo")
+	natsPub(t, nc, "foo", []byte("hello"))
+	natsFlush(t, nc)
+
+	rz, _ := sa.Routez(nil)
+	if len(rz.Routes) != 1 {
+		t.Fatalf("Expected 1 route, got %v", len(rz.Routes))
+	}
+
+	ai := sa.globalAccount().accountInfo()
+
+	murl := fmt.Sprintf("http://127.0.0.1:%d/metrics", sa.MonitorAddr().Port)
+	resp, err := http.Get(murl)
+	if err != nil {
+		t.Fatalf("Expected no error: Got %v\n", err)
+	}
+	defer resp.Body.Close()
+	if ct := resp.Header.Get("Content-Type"); ct != metricsContentType {
+		t.Fatalf("Expected content type %q, got %q", metricsContentType, ct)
+	}
+	b, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatalf("Got an error reading the body: %v\n", err)
+	}
+	body := string(b)
+
+	for _, line := range strings.Split(strings.TrimSuffix(body, "\n"), "\n") {
+		if strings.HasPrefix(line, "# HELP ") || strings.HasPrefix(line, "# TYPE ") {
+			continue
+		}
+		if !metricsLineRe.MatchString(line) {
+			t.Fatalf("Invalid sample line: %q", line)
+		}
+	}
+
+	for _, expected := range []string{
+		fmt.Sprintf("nats_server_info{server_id=%q,version=%q,", sa.ID(), VERSION),
+		"# TYPE nats_in_msgs_total counter\nnats_in_msgs_total 1\n",
+		"# TYPE nats_connections gauge\nnats_connections 1\n",
+		"nats_routes 1\n",
+		"nats_leafnodes 1\n",
+		fmt.Sprintf("nats_http_requests_total{path=%q} 1\n", MetricsPath),
+		fmt.Sprintf("nats_route_subscriptions{route_id=\"%d\",remote_id=%q} ", rz.Routes[0].Rid, sb.ID()),
+		"nats_leafnode_in_msgs_total{cid=",
+		fmt.Sprintf("nats_account_connections{account=%q} %d\n", globalAccountName, ai.Conns),
+		fmt.Sprintf("nats_account_leafnodes{account=%q} 1\n", globalAccountName),
+	} {
+		if !strings.Contains(body, expected) {
+			t.Fatalf("Expected to find %q in:\n%s", expected, body)
+		}
+	}
+	if strings.Contains(body, "nats_gateway_") {
+		t.Fatalf("Did not expect gateway metrics:\n%s", body)
+	}
+}
@@ -27,6 +27,7 @@ import (
 	"sync/atomic"
 	"time"
 
+	"github.com/nats-io/jwt"
 	"github.com/nats-io/nats-server/server/pse"
 )
 
@@ -109,8 +110,12 @@ type ConnInfo struct {
 	Version        string     `json:"version,omitempty"`
 	TLSVersion     string     `json:"tls_version,omitempty"`
 	TLSCipher      string     `json:"tls_cipher_suite,omitempty"`
+	Websocket      bool       `json:"websocket,omitempty"`
+	MQTT           bool       `json:"mqtt,omitempty"`
 	AuthorizedUser string     `json:"authorized_user,omitempty"`
 	Subs           []string   `json:"subscriptions_list,omitempty"`
+	RateDropped    int64      `json:"rate_limit_dropped,omitempty"`
+	RateDelayed    int64      `json:"rate_limit_delayed,omitempty"`
 }
 
 // DefaultConnListSize is the default size of the connection list.
@@ -369,9 +374,13 @@ func (ci *ConnInfo) fill(client *client, nc net.Conn, now time.Time) {
 	ci.OutBytes = client.outBytes
 	ci.NumSubs = uint32(len(client.subs))
 	ci.Pending = int(client.out.pb)
+	ci.RateDropped = client.rdrops
+	ci.RateDelayed = client.rdelays
 	ci.Name = client.opts.Name
 	ci.Lang = client.opts.Lang
 	ci.Version = client.opts.Version
+	ci.Websocket = client.ws
+	ci.MQTT = client.mqtt
 	// inMsgs and inBytes are updated outside of the client's lock, so
 	// we need to use atomic here.
 	ci.InMsgs = atomic.LoadInt64(&client.inMsgs)
@@ -647,6 +656,566 @@ func (s *Server) HandleRoutez(w http.ResponseWriter, r *http.Request) {
 	ResponseHandler(w, r, b)
 }
 
+// Gatewayz represents detailed information on Gateways
+type Gatewayz struct {
+	ID               string                       `json:"server_id"`
+	Now              time.Time                    `json:"now"`
+	Name             string                       `json:"name,omitempty"`
+	Host             string                       `json:"host,omitempty"`
+	Port             int                          `json:"port,omitempty"`
+	OutboundGateways map[string]*RemoteGatewayz   `json:"outbound_gateways"`
+	InboundGateways  map[string][]*RemoteGatewayz `json:"inbound_gateways"`
+}
+
+// RemoteGatewayz represents information about a connection to a remote gateway.
+type RemoteGatewayz struct {
+	IsConfigured bool               `json:"configured"`
+	URLs         []string           `json:"urls,omitempty"`
+	Connection   *ConnInfo          `json:"connection,omitempty"`
+	Accounts     []*AccountGatewayz `json:"accounts,omitempty"`
+}
+
+// AccountGatewayz represents the interest mode of an account on a gateway connection.
+type AccountGatewayz struct {
+	Name                  string `json:"name"`
+	InterestMode          string `json:"interest_mode"`
+	NoInterestCount       int    `json:"no_interest_count,omitempty"`
+	InterestOnlyThreshold int    `json:"interest_only_threshold,omitempty"`
+	TotalSubscriptions    int    `json:"num_subs,omitempty"`
+	NumQueueSubscriptions int    `json:"num_queue_subs,omitempty"`
+}
+
+// GatewayzOptions are options passed to Gatewayz
+type GatewayzOptions struct {
+	// Name will output only remote gateways with this name
+	Name string `json:"name"`
+
+	// Accounts indicates if the accounts with their interest mode should be included.
+	Accounts bool `json:"accounts"`
+
+	// AccountName limits the accounts to the one with this name (implies Accounts).
+	AccountName string `json:"account_name"`
+}
+
+// Gateway interest modes as reported by Gatewayz.
+const (
+	GatewayInterestModeOptimistic    = "Optimistic"
+	GatewayInterestModeTransitioning = "Transitioning"
+	GatewayInterestModeInterestOnly  = "Interest-Only"
+)
+
+func gatewayInterestModeString(mode byte) string {
+	switch mode {
+	case modeTransitioning:
+		return GatewayInterestModeTransitioning
+	case modeInterestOnly:
+		return GatewayInterestModeInterestOnly
+	default:
+		return GatewayInterestModeOptimistic
+	}
+}
+
+// Gatewayz returns a Gatewayz struct containing information about gateways.
+func (s *Server) Gatewayz(opts *GatewayzOptions) (*Gatewayz, error) {
+	gwz := &Gatewayz{
+		ID:               s.ID(),
+		Now:              time.Now(),
+		OutboundGateways: map[string]*RemoteGatewayz{},
+		InboundGateways:  map[string][]*RemoteGatewayz{},
+	}
+	if opts == nil {
+		opts = &GatewayzOptions{}
+	}
+	gw := s.gateway
+	if !gw.enabled {
+		return gwz, nil
+	}
+	sopts := s.getOpts()
+	gwz.Name = gw.name
+	gwz.Host = sopts.Gateway.Host
+	gwz.Port = sopts.Gateway.Port
+
+	accs := opts.Accounts || opts.AccountName != _EMPTY_
+
+	gw.RLock()
+	remotes := make(map[string]*gatewayCfg, len(gw.remotes))
+	for name, cfg := range gw.remotes {
+		if opts.Name == _EMPTY_ || opts.Name == name {
+			remotes[name] = cfg
+		}
+	}
+	outbound := make(map[string]*client, len(gw.out))
+	for name, c := range gw.out {
+		outbound[name] = c
+	}
+	inbound := make([]*client, 0, len(gw.in))
+	for _, c := range gw.in {
+		inbound = append(inbound, c)
+	}
+	gw.RUnlock()
+
+	// Remote gateways we know about, with their outbound connection, if any.
+	for name, cfg := range remotes {
+		rgw := &RemoteGatewayz{
+			IsConfigured: !cfg.isImplicit(),
+			URLs:         cfg.getURLsAsStrings(),
+		}
+		sort.Strings(rgw.URLs)
+		if c := outbound[name]; c != nil {
+			rgw.Connection = &ConnInfo{}
+			c.mu.Lock()
+			rgw.Connection.fill(c, c.nc, gwz.Now)
+			c.mu.Unlock()
+			if accs {
+				rgw.Accounts = c.outboundGatewayAccountsz(opts.AccountName)
+			}
+		}
+		gwz.OutboundGateways[name] = rgw
+	}
+
+	for _, c := range inbound {
+		c.mu.Lock()
+		name := c.gw.name
+		if opts.Name != _EMPTY_ && opts.Name != name {
+			c.mu.Unlock()
+			continue
+		}
+		rgw := &RemoteGatewayz{Connection: &ConnInfo{}}
+		rgw.Connection.fill(c, c.nc, gwz.Now)
+		if accs {
+			rgw.Accounts = c.inboundGatewayAccountsz(opts.AccountName)
+		}
+		c.mu.Unlock()
+		if cfg := remotes[name]; cfg != nil {
+			rgw.IsConfigured = !cfg.isImplicit()
+		}
+		gwz.InboundGateways[name] = append(gwz.InboundGateways[name], rgw)
+	}
+	return gwz, nil
+}
+
+// Returns the interest of the remote gateway per account for this outbound
+// connection. If accName is not empty, only this account is returned.
+func (c *client) outboundGatewayAccountsz(accName string) []*AccountGatewayz {
+	accz := func(name string, ei interface{}) *AccountGatewayz {
+		a := &AccountGatewayz{Name: name, InterestMode: GatewayInterestModeOptimistic}
+		if ei == nil {
+			return a
+		}
+		e := ei.(*outsie)
+		e.RLock()
+		a.InterestMode = gatewayInterestModeString(e.mode)
+		a.NoInterestCount = len(e.ni)
+		a.NumQueueSubscriptions = e.qsubs
+		if e.sl != nil {
+			a.TotalSubscriptions = int(e.sl.Count())
+		}
+		e.RUnlock()
+		return a
+	}
+	if accName != _EMPTY_ {
+		ei, _ := c.gw.outsim.Load(accName)
+		return []*AccountGatewayz{accz(accName, ei)}
+	}
+	var accs []*AccountGatewayz
+	c.gw.outsim.Range(func(k, v interface{}) bool {
+		accs = append(accs, accz(k.(string), v))
+		return true
+	})
+	sort.Slice(accs, func(i, j int) bool { return accs[i].Name < accs[j].Name })
+	return accs
+}
+
+// Returns the interest sent to the remote gateway per account for this
+// inbound connection. If accName is not empty, only this account is returned.
+// Lock held on entry.
+func (c *client) inboundGatewayAccountsz(accName string) []*AccountGatewayz {
+	accz := func(name string, e *insie) *AccountGatewayz {
+		a := &AccountGatewayz{Name: name, InterestMode: GatewayInterestModeOptimistic}
+		if e != nil {
+			a.InterestMode = gatewayInterestModeString(e.mode)
+			a.NoInterestCount = len(e.ni)
+		}
+		if a.InterestMode == GatewayInterestModeOptimistic {
+			a.InterestOnlyThreshold = gatewayMaxRUnsubBeforeSwitch
+		}
+		return a
+	}
+	if accName != _EMPTY_ {
+		return []*AccountGatewayz{accz(accName, c.gw.insim[accName])}
+	}
+	accs := make([]*AccountGatewayz, 0, len(c.gw.insim))
+	for name, e := range c.gw.insim {
+		accs = append(accs, accz(name, e))
+	}
+	sort.Slice(accs, func(i, j int) bool { return accs[i].Name < accs[j].Name })
+	return accs
+}
+
+// HandleGatewayz process HTTP requests for gateway information.
+func (s *Server) HandleGatewayz(w http.ResponseWriter, r *http.Request) {
+	accs, err := decodeBool(w, r, "accs")
+	if err != nil {
+		return
+	}
+	opts := &GatewayzOptions{
+		Name:        r.URL.Query().Get("gw_name"),
+		Accounts:    accs,
+		AccountName: r.URL.Query().Get("acc_name"),
+	}
+
+	s.mu.Lock()
+	s.httpReqStats[GatewayzPath]++
+	s.mu.Unlock()
+
+	// As of now, no error is ever returned.
+	gwz, _ := s.Gatewayz(opts)
+	b, err := json.MarshalIndent(gwz, "", "  ")
+	if err != nil {
+		s.Errorf("Error marshaling response to /gatewayz request: %v", err)
+	}
+
+	// Handle response
+	ResponseHandler(w, r, b)
+}
+
+// Leafz represents detailed information on leafnode connections.
+type Leafz struct {
+	ID       string      `json:"server_id"`
+	Now      time.Time   `json:"now"`
+	NumLeafs int         `json:"leafnodes"`
+	Leafs    []*LeafInfo `json:"leafs"`
+}
+
+// LeafzOptions are options passed to Leafz
+type LeafzOptions struct {
+	// Subscriptions indicates that Leafz will return a leafnode's subscriptions
+	Subscriptions bool `json:"subscriptions"`
+
+	// Account will only return leafnodes bound to this account.
+	Account string `json:"account"`
+}
+
+// LeafInfo has detailed information on each leafnode connection.
+type LeafInfo struct {
+	Cid       uint64   `json:"cid"`
+	Account   string   `json:"account"`
+	Solicited bool     `json:"solicited"`
+	URL       string   `json:"url,omitempty"`
+	IP        string   `json:"ip"`
+	Port      int      `json:"port"`
+	RTT       string   `json:"rtt,omitempty"`
+	Pending   int      `json:"pending_size"`
+	InMsgs    int64    `json:"in_msgs"`
+	OutMsgs   int64    `json:"out_msgs"`
+	InBytes   int64    `json:"in_bytes"`
+	OutBytes  int64    `json:"out_bytes"`
+	NumSubs   uint32   `json:"subscriptions"`
+	Subs      []string `json:"subscriptions_list,omitempty"`
+}
+
+// Leafz returns a Leafz struct containing information about leafnodes.
+func (s *Server) Leafz(opts *LeafzOptions) (*Leafz, error) {
+	subs := opts != nil && opts.Subscriptions
+	var acc string
+	if opts != nil {
+		acc = opts.Account
+	}
+
+	// Grab leafnodes
+	s.mu.Lock()
+	lz := &Leafz{ID: s.info.ID, Now: time.Now(), Leafs: []*LeafInfo{}}
+	lconns := make([]*client, 0, len(s.leafs))
+	for _, ln := range s.leafs {
+		lconns = append(lconns, ln)
+	}
+	s.mu.Unlock()
+
+	for _, ln := range lconns {
+		ln.mu.Lock()
+		if acc != _EMPTY_ && (ln.acc == nil || ln.acc.Name != acc) {
+			ln.mu.Unlock()
+			continue
+		}
+		li := &LeafInfo{
+			Cid:       ln.cid,
+			Solicited: ln.isSolicitedLeafNode(),
+			IP:        ln.host,
+			Port:      int(ln.port),
+			RTT:       ln.getRTT(),
+			Pending:   int(ln.out.pb),
+			InMsgs:    atomic.LoadInt64(&ln.inMsgs),
+			OutMsgs:   ln.outMsgs,
+			InBytes:   atomic.LoadInt64(&ln.inBytes),
+			OutBytes:  ln.outBytes,
+			NumSubs:   uint32(len(ln.subs)),
+		}
+		if ln.acc != nil {
+			li.Account = ln.acc.Name
+		}
+		// For solicited connections, report the URL we are connected to,
+		// without any user info.
+		if li.Solicited {
+			if u := ln.leaf.remote.getCurrentURL(); u != nil {
+				li.URL = fmt.Sprintf("%s://%s", u.Scheme, u.Host)
+			}
+		}
+		if subs && len(ln.subs) > 0 {
+			li.Subs = make([]string, 0, len(ln.subs))
+			for _, sub := range ln.subs {
+				li.Subs = append(li.Subs, string(sub.subject))
+			}
+			sort.Strings(li.Subs)
+		}
+		ln.mu.Unlock()
+		lz.Leafs = append(lz.Leafs, li)
+	}
+	lz.NumLeafs = len(lz.Leafs)
+	return lz, nil
+}
+
+// HandleLeafz process HTTP requests for leafnode information.
+func (s *Server) HandleLeafz(w http.ResponseWriter, r *http.Request) {
+	subs, err := decodeBool(w, r, "subs")
+	if err != nil {
+		return
+	}
+	opts := &LeafzOptions{
+		Subscriptions: subs,
+		Account:       r.URL.Query().Get("acc"),
+	}
+
+	s.mu.Lock()
+	s.httpReqStats[LeafzPath]++
+	s.mu.Unlock()
+
+	// As of now, no error is ever returned.
+	lz, _ := s.Leafz(opts)
+	b, err := json.MarshalIndent(lz, "", "  ")
+	if err != nil {
+		s.Errorf("Error marshaling response to /leafz request: %v", err)
+	}
+
+	// Handle response
+	ResponseHandler(w, r, b)
+}
+
+// Accountz represents detailed information on accounts.
+type Accountz struct {
+	ID            string       `json:"server_id"`
+	Now           time.Time    `json:"now"`
+	SystemAccount string       `json:"system_account,omitempty"`
+	Accounts      []string     `json:"accounts,omitempty"`
+	Account       *AccountInfo `json:"account_detail,omitempty"`
+}
+
+// AccountzOptions are options passed to Accountz
+type AccountzOptions struct {
+	// Account indicates that Accountz will return details for this account
+	// instead of the list of account names.
+	Account string `json:"account"`
+}
+
+// AccountInfo has detailed information on a given account.
+type AccountInfo struct {
+	AccountName   string        `json:"account_name"`
+	LastUpdate    time.Time     `json:"update_time,omitempty"`
+	IsSystem      bool          `json:"is_system,omitempty"`
+	Expired       bool          `json:"expired"`
+	Expires       time.Time     `json:"expires,omitempty"`
+	Issuer        string        `json:"issuer,omitempty"`
+	JWT           string        `json:"jwt,omitempty"`
+	SigningKeys   []string      `json:"signing_keys,omitempty"`
+	Imports       []ExtImport   `json:"imports,omitempty"`
+	Exports       []ExtExport   `json:"exports,omitempty"`
+	Limits        AccountLimits `json:"limits"`
+	Conns         int           `json:"client_connections"`
+	TotalConns    int           `json:"total_client_connections"`
+	LeafNodes     int           `json:"leafnode_connections"`
+	Subscriptions uint32        `json:"subscriptions"`
+	Sent          DataStats     `json:"sent"`
+	Received      DataStats     `json:"received"`
+	DuplicateMsgs int64         `json:"duplicate_msgs,omitempty"`
+}
+
+// ExtImport describes a stream or service import of an account.
+type ExtImport struct {
+	Type    string `json:"type"`
+	Subject string `json:"subject"`
+	To      string `json:"to,omitempty"`
+	Account string `json:"account"`
+	Invalid bool   `json:"invalid,omitempty"`
+}
+
+// ExtExport describes a stream or service export of an account.
+type ExtExport struct {
+	Type     string   `json:"type"`
+	Subject  string   `json:"subject"`
+	TokenReq bool     `json:"token_required,omitempty"`
+	Approved []string `json:"approved_accounts,omitempty"`
+}
+
+// AccountLimits are the limits applied to an account. A value of -1
+// means unlimited.
+type AccountLimits struct {
+	MaxPayload       int32 `json:"max_payload"`
+	MaxSubscriptions int32 `json:"max_subscriptions"`
+	MaxConnections   int32 `json:"max_connections"`
+	MaxLeafNodes     int32 `json:"max_leafnodes"`
+}
+
+const (
+	extTypeStream  = "stream"
+	extTypeService = "service"
+)
+
+// Accountz returns an Accountz struct containing either the list of
+// registered accounts or, if an account name is specified in the
+// options, the details for this account.
+func (s *Server) Accountz(opts *AccountzOptions) (*Accountz, error) {
+	s.mu.Lock()
+	az := &Accountz{ID: s.info.ID, Now: time.Now()}
+	var sacc *Account
+	if s.sys != nil {
+		sacc = s.sys.account
+	}
+	s.mu.Unlock()
+	if sacc != nil {
+		az.SystemAccount = sacc.Name
+	}
+
+	if opts == nil || opts.Account == _EMPTY_ {
+		az.Accounts = []string{}
+		s.accounts.Range(func(k, _ interface{}) bool {
+			az.Accounts = append(az.Accounts, k.(string))
+			return true
+		})
+		sort.Strings(az.Accounts)
+		return az, nil
+	}
+
+	// Only report accounts already registered, a lookup would fetch
+	// unknown accounts from the resolver.
+	v, ok := s.accounts.Load(opts.Account)
+	if !ok {
+		return nil, ErrMissingAccount
+	}
+	acc := v.(*Account)
+	az.Account = acc.accountInfo()
+	az.Account.IsSystem = acc == sacc
+	return az, nil
+}
+
+// Returns the detailed information for this account.
+func (a *Account) accountInfo() *AccountInfo {
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+
+	ai := &AccountInfo{
+		AccountName: a.Name,
+		LastUpdate:  a.updated,
+		Expired:     a.expired,
+		Issuer:      a.Issuer,
+		JWT:         a.claimJWT,
+		Limits: AccountLimits{
+			MaxPayload:       a.mpay,
+			MaxSubscriptions: a.msubs,
+			MaxConnections:   a.mconns,
+			MaxLeafNodes:     a.mleafs,
+		},
+		Conns:         a.numLocalConnections(),
+		TotalConns:    a.numLocalConnections() + int(a.nrclients),
+		LeafNodes:     a.numLocalLeafNodes(),
+		Subscriptions: a.sl.Count(),
+		Sent: DataStats{
+			Msgs:  atomic.LoadInt64(&a.outMsgs),
+			Bytes: atomic.LoadInt64(&a.outBytes),
+		},
+		Received: DataStats{
+			Msgs:  atomic.LoadInt64(&a.inMsgs),
+			Bytes: atomic.LoadInt64(&a.inBytes),
+		},
+		DuplicateMsgs: atomic.LoadInt64(&a.dupMsgs),
+	}
+	if a.claimJWT != _EMPTY_ {
+		if ac, err := jwt.DecodeAccountClaims(a.claimJWT); err == nil && ac.Expires > 0 {
+			ai.Expires = time.Unix(ac.Expires, 0).UTC()
+		}
+	}
+	if len(a.signingKeys) > 0 {
+		ai.SigningKeys = append([]string(nil), a.signingKeys...)
+	}
+	for _, si := range a.imports.streams {
+		ai.Imports = append(ai.Imports, ExtImport{
+			Type:    extTypeStream,
+			Subject: si.from,
+			To:      si.prefix,
+			Account: si.acc.Name,
+			Invalid: si.invalid,
+		})
+	}
+	for _, si := range a.imports.services {
+		ai.Imports = append(ai.Imports, ExtImport{
+			Type:    extTypeService,
+			Subject: si.from,
+			To:      si.to,
+			Account: si.acc.Name,
+			Invalid: si.invalid,
+		})
+	}
+	sort.Slice(ai.Imports, func(i, j int) bool {
+		if ai.Imports[i].Type != ai.Imports[j].Type {
+			return ai.Imports[i].Type < ai.Imports[j].Type
+		}
+		return ai.Imports[i].Subject < ai.Imports[j].Subject
+	})
+	addExports := func(typ string, m map[string]*exportAuth) {
+		for subj, ea := range m {
+			e := ExtExport{Type: typ, Subject: subj}
+			if ea != nil {
+				e.TokenReq = ea.tokenReq
+				for name := range ea.approved {
+					e.Approved = append(e.Approved, name)
+				}
+				sort.Strings(e.Approved)
+			}
+			ai.Exports = append(ai.Exports, e)
+		}
+	}
+	addExports(extTypeStream, a.exports.streams)
+	addExports(extTypeService, a.exports.services)
+	sort.Slice(ai.Exports, func(i, j int) bool {
+		if ai.Exports[i].Type != ai.Exports[j].Type {
+			return ai.Exports[i].Type < ai.Exports[j].Type
+		}
+		return ai.Exports[i].Subject < ai.Exports[j].Subject
+	})
+	return ai
+}
+
+// HandleAccountz process HTTP requests for account information.
+func (s *Server) HandleAccountz(w http.ResponseWriter, r *http.Request) {
+	opts := &AccountzOptions{Account: r.URL.Query().Get("acc")}
+
+	s.mu.Lock()
+	s.httpReqStats[AccountzPath]++
+	s.mu.Unlock()
+
+	az, err := s.Accountz(opts)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(err.Error()))
+		return
+	}
+	b, err := json.MarshalIndent(az, "", "  ")
+	if err != nil {
+		s.Errorf("Error marshaling response to /accountz request: %v", err)
+	}
+
+	// Handle response
+	ResponseHandler(w, r, b)
+}
+
 // Subsz represents detail information on current connections.
 type Subsz struct {
 	*SublistStats
@@ -878,6 +1447,7 @@ type Varz struct {
 	InBytes           int64             `json:"in_bytes"`
 	OutBytes          int64             `json:"out_bytes"`
 	SlowConsumers     int64             `json:"slow_consumers"`
+	DuplicateMsgs     int64             `json:"duplicate_msgs"`
 	Subscriptions     uint32            `json:"subscriptions"`
 	HTTPReqStats      map[string]uint64 `json:"http_req_stats"`
 	ConfigLoadTime    time.Time         `json:"config_load_time"`
@@ -979,6 +1549,10 @@ func (s *Server) HandleRoot(w http.ResponseWriter, r *http.Request) {
 	<a href=/connz>connz</a><br/>
 	<a href=/routez>routez</a><br/>
 	<a href=/subsz>subsz</a><br/>
+	<a href=/gatewayz>gatewayz</a><br/>
+	<a href=/leafz>leafz</a><br/>
+	<a href=/accountz>accountz</a><br/>
+	<a href=/metrics>metrics</a><br/>
     <br/>
     <a href=http://nats.io/documentation/server/monitoring/>help</a>
   </body>
@@ -1141,6 +1715,7 @@ func (s *Server) updateVarzRuntimeFields(v *Varz, forceUpdate bool, pcpu float64
 	v.OutMsgs = atomic.LoadInt64(&s.outMsgs)
 	v.OutBytes = atomic.LoadInt64(&s.outBytes)
 	v.SlowConsumers = atomic.LoadInt64(&s.slowConsumers)
+	v.DuplicateMsgs = atomic.LoadInt64(&s.dupMsgs)
 	// FIXME(dlc) - make this multi-account aware.
 	v.Subscriptions = s.gacc.sl.Count()
 	v.HTTPReqStats = make(map[string]uint64, len(s.httpReqStats))
@@ -1284,6 +1859,10 @@ func (reason ClosedState) String() string {
 		return "Wrong Gateway"
 	case MissingAccount:
 		return "Missing Account"
+	case Revocation:
+		return "Credentials Revoked"
+	case RateLimitExceeded:
+		return "Rate Limit Exceeded"
 	}
 	return "Unknown State"
 }
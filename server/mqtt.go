@@ -0,0 +1,1211 @@
+// Copyright 2019 The NATS Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package server
+
+import (
+	"bytes"
+	"crypto/tls"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/nats-io/nkeys"
+	"github.com/nats-io/nuid"
+)
+
+// MQTT 3.1.1 control packet types and flags.
+const (
+	mqttPacketConnect    = byte(0x10)
+	mqttPacketConnectAck = byte(0x20)
+	mqttPacketPub        = byte(0x30)
+	mqttPacketPubAck     = byte(0x40)
+	mqttPacketSub        = byte(0x80)
+	mqttPacketSubAck     = byte(0x90)
+	mqttPacketUnsub      = byte(0xa0)
+	mqttPacketUnsubAck   = byte(0xb0)
+	mqttPacketPing       = byte(0xc0)
+	mqttPacketPingResp   = byte(0xd0)
+	mqttPacketDisconnect = byte(0xe0)
+	mqttPacketMask       = byte(0xf0)
+	mqttPacketFlagMask   = byte(0x0f)
+
+	mqttProtoName  = "MQTT"
+	mqttProtoLevel = 4
+
+	mqttConnFlagReserved     = byte(0x01)
+	mqttConnFlagCleanSession = byte(0x02)
+	mqttConnFlagWill         = byte(0x04)
+	mqttConnFlagWillQoS      = byte(0x18)
+	mqttConnFlagWillRetain   = byte(0x20)
+	mqttConnFlagPassword     = byte(0x40)
+	mqttConnFlagUsername     = byte(0x80)
+
+	mqttConnAckRCAccepted           = byte(0x00)
+	mqttConnAckRCUnacceptableProto  = byte(0x01)
+	mqttConnAckRCIdentifierRejected = byte(0x02)
+	mqttConnAckRCServerUnavailable  = byte(0x03)
+	mqttConnAckRCBadUserOrPassword  = byte(0x04)
+	mqttConnAckRCNotAuthorized      = byte(0x05)
+
+	mqttPubFlagQoS = byte(0x06)
+	mqttPubFlagDup = byte(0x08)
+
+	// Flags that must be set on SUBSCRIBE and UNSUBSCRIBE packets.
+	mqttSubFlags = byte(0x02)
+
+	mqttSubAckFailure = byte(0x80)
+
+	// Time allowed for a new connection to send its CONNECT packet.
+	mqttConnectTimeout = 4 * time.Second
+)
+
+var (
+	// ErrMQTTMalformedPacket is returned when an MQTT packet can not be parsed.
+	ErrMQTTMalformedPacket = errors.New("mqtt: malformed packet")
+	// ErrMQTTUnsupportedProtocol is returned when the client is not using MQTT 3.1.1.
+	ErrMQTTUnsupportedProtocol = errors.New("mqtt: unsupported protocol version")
+	// ErrMQTTQoS2NotSupported is returned when the client publishes with QoS 2.
+	ErrMQTTQoS2NotSupported = errors.New("mqtt: QoS 2 is not supported")
+	// ErrMQTTInvalidTopic is returned when a topic can not be mapped to a subject.
+	ErrMQTTInvalidTopic = errors.New("mqtt: invalid topic")
+	// ErrMQTTKeepAliveExpired is returned when no packet is received within the keep alive.
+	ErrMQTTKeepAliveExpired = errors.New("mqtt: keep alive expired")
+	// ErrMQTTNkeyNotSupported is returned when a client tries to authenticate with an nkey.
+	ErrMQTTNkeyNotSupported = errors.New("mqtt: nkey authentication is not supported")
+)
+
+// MQTT server state.
+type srvMQTT struct {
+	listener net.Listener
+
+	// Sessions are protected by their own lock since they are accessed
+	// while the client lock may be held. They are keyed by account, user
+	// and client ID, see mqttSessionKey().
+	mu       sync.Mutex
+	sessions map[string]*mqttSession
+}
+
+// mqttSession holds the subscriptions and QoS 1 messages waiting for an
+// acknowledgment of a client ID. Sessions that are not clean outlive the
+// connection and are resumed on reconnect by the same user.
+type mqttSession struct {
+	mu      sync.Mutex
+	key     string
+	clean   bool
+	ackWait time.Duration
+	maxAP   int
+	mc      *mqttConn
+	subs    map[string]*mqttSub
+	sids    map[string]*mqttSub
+	pending map[uint16]*mqttPending
+	ppi     uint16
+	pseq    uint64
+	nsid    uint64
+	tmr     *time.Timer
+}
+
+// An MQTT subscription. Filters ending with '#' also match the parent
+// level, which requires two NATS subscriptions.
+type mqttSub struct {
+	filter   string
+	subjects []string
+	sids     []string
+	qos      byte
+}
+
+// A QoS 1 message sent to the client and not yet acknowledged.
+type mqttPending struct {
+	pid   uint16
+	seq   uint64
+	topic string
+	msg   []byte
+	sent  time.Time
+}
+
+// Acknowledgments waiting for the server to process what was sent
+// before them, which is signaled by a PONG.
+type mqttAction struct {
+	kind    byte
+	pid     uint16
+	creds   bool
+	present bool
+	codes   []byte
+	subs    []*mqttSub
+}
+
+// mqttConn wraps an MQTT connection. Reads translate MQTT packets into the
+// client protocol and writes translate the server protocol into MQTT
+// packets, so that the regular client read and write loops can be used
+// unchanged.
+type mqttConn struct {
+	net.Conn
+	srv     *Server
+	client  *client
+	ackWait time.Duration
+	maxAP   int
+	maxPay  int
+	start   time.Time
+
+	// Read state, only accessed from the read loop.
+	rbuf      []byte
+	rtmp      []byte
+	pending   []byte
+	pubAcks   []uint16
+	connected bool
+	id        string
+	clean     bool
+	hasSess   bool
+	keepAlive time.Duration
+	lastRead  time.Time
+	will      []byte
+	closeErr  error
+
+	// Write state, only accessed from the write path.
+	wbuf []byte
+
+	// Protects state shared between the read and write paths.
+	mu      sync.Mutex
+	sess    *mqttSession
+	actions []*mqttAction
+	inject  []byte
+
+	// Protects writes since packets are also sent from the read loop
+	// and the redelivery timer.
+	wmu sync.Mutex
+}
+
+func (s *Server) newMQTTConn(conn net.Conn) *mqttConn {
+	opts := s.getOpts()
+	mc := &mqttConn{
+		Conn:    conn,
+		srv:     s,
+		ackWait: opts.MQTT.AckWait,
+		maxAP:   opts.MQTT.MaxAckPending,
+		maxPay:  int(opts.MaxPayload) + MAX_CONTROL_LINE_SIZE,
+		start:   time.Now(),
+		rtmp:    make([]byte, startBufSize),
+	}
+	if mc.ackWait == 0 {
+		mc.ackWait = DEFAULT_MQTT_ACK_WAIT
+	}
+	if mc.maxAP == 0 {
+		mc.maxAP = DEFAULT_MQTT_MAX_ACK_PENDING
+	}
+	return mc
+}
+
+// Read returns the client protocol translated from the MQTT packets.
+func (mc *mqttConn) Read(p []byte) (int, error) {
+	for {
+		if len(mc.pending) > 0 {
+			n := copy(p, mc.pending)
+			mc.pending = mc.pending[n:]
+			return n, nil
+		}
+		if mc.closeErr != nil {
+			return 0, mc.closeErr
+		}
+		// Messages returned on the previous call have now been processed.
+		if len(mc.pubAcks) > 0 {
+			var out []byte
+			for _, pid := range mc.pubAcks {
+				out = mqttAppendPacketID(out, mqttPacketPubAck, pid)
+			}
+			mc.pubAcks = mc.pubAcks[:0]
+			if err := mc.write(out); err != nil {
+				return 0, err
+			}
+		}
+		// The deadline needs to be set before checking for injected
+		// protocol, see interrupt().
+		mc.Conn.SetReadDeadline(mc.readDeadline())
+		mc.mu.Lock()
+		mc.pending, mc.inject = mc.inject, nil
+		mc.mu.Unlock()
+		if len(mc.pending) > 0 {
+			continue
+		}
+		// The CONNECT has been processed, so the client is authenticated
+		// and its session can be looked up.
+		if mc.connected && !mc.hasSess {
+			mc.lookupSession()
+			continue
+		}
+		b0, body, err := mc.readPacket()
+		if err == nil {
+			mc.lastRead = time.Now()
+			err = mc.processPacket(b0, body)
+			if err == nil {
+				continue
+			}
+		} else if ne, ok := err.(net.Error); ok && ne.Timeout() {
+			dl := mc.readDeadline()
+			if dl.IsZero() || time.Now().Before(dl) {
+				// We were interrupted.
+				continue
+			}
+			err = ErrMQTTKeepAliveExpired
+		}
+		if err != io.EOF {
+			mc.srv.Debugf("MQTT connection %s closing: %v", mc.RemoteAddr(), err)
+		}
+		// Publish the will message, if any, before reporting the error.
+		mc.pending, mc.will = mc.will, nil
+		mc.closeErr = err
+	}
+}
+
+// Returns the read deadline based on the connection state.
+func (mc *mqttConn) readDeadline() time.Time {
+	if !mc.connected {
+		return mc.start.Add(mqttConnectTimeout)
+	}
+	if mc.keepAlive == 0 {
+		return time.Time{}
+	}
+	return mc.lastRead.Add(mc.keepAlive * 3 / 2)
+}
+
+// Queue client protocol to be returned by Read and wake up the read loop.
+func (mc *mqttConn) interrupt(proto []byte) {
+	mc.mu.Lock()
+	mc.inject = append(mc.inject, proto...)
+	mc.mu.Unlock()
+	mc.Conn.SetReadDeadline(time.Now())
+}
+
+// Reads a complete packet, returning the first header byte and the body.
+func (mc *mqttConn) readPacket() (byte, []byte, error) {
+	for {
+		b0, body, n, err := mqttParsePacket(mc.rbuf, mc.maxPay)
+		if err != nil {
+			return 0, nil, err
+		}
+		if n > 0 {
+			mc.rbuf = mc.rbuf[n:]
+			return b0, body, nil
+		}
+		n, err = mc.Conn.Read(mc.rtmp)
+		if n > 0 {
+			mc.rbuf = append(mc.rbuf, mc.rtmp[:n]...)
+		}
+		if err != nil {
+			return 0, nil, err
+		}
+	}
+}
+
+// Parses a packet from b. Returns a size of 0 if b does not contain a
+// complete packet.
+func mqttParsePacket(b []byte, max int) (byte, []byte, int, error) {
+	if len(b) < 2 {
+		return 0, nil, 0, nil
+	}
+	rl, mult, i := 0, 1, 1
+	for ; ; i++ {
+		if i > 4 {
+			return 0, nil, 0, ErrMQTTMalformedPacket
+		}
+		if i >= len(b) {
+			return 0, nil, 0, nil
+		}
+		rl += int(b[i]&0x7f) * mult
+		mult *= 128
+		if b[i]&0x80 == 0 {
+			break
+		}
+	}
+	if max > 0 && rl > max {
+		return 0, nil, 0, ErrMaxPayload
+	}
+	start := i + 1
+	if len(b) < start+rl {
+		return 0, nil, 0, nil
+	}
+	return b[0], b[start : start+rl], start + rl, nil
+}
+
+// Process a packet from the client, appending the resulting client
+// protocol to the pending buffer.
+func (mc *mqttConn) processPacket(b0 byte, body []byte) error {
+	ptype, flags := b0&mqttPacketMask, b0&mqttPacketFlagMask
+	r := &mqttReader{b: body}
+	if !mc.connected {
+		if ptype != mqttPacketConnect {
+			return fmt.Errorf("mqtt: expected CONNECT, got packet type %d", ptype>>4)
+		}
+		return mc.processConnect(r)
+	}
+	switch ptype {
+	case mqttPacketPub:
+		return mc.processPublish(flags, r)
+	case mqttPacketPubAck:
+		return mc.processPubAck(r)
+	case mqttPacketSub:
+		if flags != mqttSubFlags {
+			return ErrMQTTMalformedPacket
+		}
+		return mc.processSubscribe(r)
+	case mqttPacketUnsub:
+		if flags != mqttSubFlags {
+			return ErrMQTTMalformedPacket
+		}
+		return mc.processUnsubscribe(r)
+	case mqttPacketPing:
+		return mc.write([]byte{mqttPacketPingResp, 0})
+	case mqttPacketDisconnect:
+		// Clean disconnect, the will message is discarded.
+		mc.will = nil
+		return io.EOF
+	default:
+		return fmt.Errorf("mqtt: unexpected packet type %d", ptype>>4)
+	}
+}
+
+func (mc *mqttConn) processConnect(r *mqttReader) error {
+	proto, err := r.readString()
+	if err != nil {
+		return err
+	}
+	level, err := r.readByte()
+	if err != nil {
+		return err
+	}
+	if proto != mqttProtoName || level != mqttProtoLevel {
+		mc.write(mqttConnAck(false, mqttConnAckRCUnacceptableProto))
+		return ErrMQTTUnsupportedProtocol
+	}
+	flags, err := r.readByte()
+	if err != nil {
+		return err
+	}
+	if flags&mqttConnFlagReserved != 0 {
+		return ErrMQTTMalformedPacket
+	}
+	ka, err := r.readUint16()
+	if err != nil {
+		return err
+	}
+	id, err := r.readString()
+	if err != nil {
+		return err
+	}
+	clean := flags&mqttConnFlagCleanSession != 0
+	if id == _EMPTY_ {
+		if !clean {
+			mc.write(mqttConnAck(false, mqttConnAckRCIdentifierRejected))
+			return fmt.Errorf("mqtt: empty client ID requires a clean session")
+		}
+		id = nuid.Next()
+	}
+	if flags&mqttConnFlagWill != 0 {
+		topic, err := r.readString()
+		if err != nil {
+			return err
+		}
+		msg, err := r.readBytes()
+		if err != nil {
+			return err
+		}
+		if (flags&mqttConnFlagWillQoS)>>3 > 2 {
+			return ErrMQTTMalformedPacket
+		}
+		subject, err := mqttTopicToSubject(topic, false)
+		if err != nil {
+			return err
+		}
+		mc.will = mqttPubProto(subject, msg)
+	} else if flags&(mqttConnFlagWillQoS|mqttConnFlagWillRetain) != 0 {
+		return ErrMQTTMalformedPacket
+	}
+	var user, pass string
+	if flags&mqttConnFlagUsername != 0 {
+		if user, err = r.readString(); err != nil {
+			return err
+		}
+	}
+	if flags&mqttConnFlagPassword != 0 {
+		if flags&mqttConnFlagUsername == 0 {
+			return ErrMQTTMalformedPacket
+		}
+		b, err := r.readBytes()
+		if err != nil {
+			return err
+		}
+		pass = string(b)
+	}
+
+	// Map the MQTT credentials onto the regular client options.
+	co := clientOpts{
+		Echo:     true,
+		Name:     id,
+		Lang:     "mqtt",
+		Version:  "3.1.1",
+		Protocol: ClientProtoZero,
+	}
+	switch {
+	case nkeys.IsValidPublicUserKey(user):
+		// MQTT has no challenge, so proving ownership of the nkey would
+		// require the client to send its seed.
+		mc.write(mqttConnAck(false, mqttConnAckRCNotAuthorized))
+		return ErrMQTTNkeyNotSupported
+	case user != _EMPTY_ && flags&mqttConnFlagPassword == 0:
+		co.Authorization = user
+	default:
+		co.Username, co.Password = user, pass
+	}
+	cj, err := json.Marshal(co)
+	if err != nil {
+		return err
+	}
+
+	// The session is looked up once the client is authenticated, the
+	// acknowledgment is queued now in case the authentication fails.
+	mc.mu.Lock()
+	mc.actions = append(mc.actions, &mqttAction{kind: mqttPacketConnectAck, creds: user != _EMPTY_})
+	mc.mu.Unlock()
+
+	mc.connected = true
+	mc.id, mc.clean = id, clean
+	mc.keepAlive = time.Duration(ka) * time.Second
+	mc.pending = append(mc.pending, "CONNECT "...)
+	mc.pending = append(mc.pending, cj...)
+	mc.pending = append(mc.pending, _CRLF_...)
+	return nil
+}
+
+// Looks up the session of the authenticated client, which is then
+// attached when acknowledging the CONNECT.
+func (mc *mqttConn) lookupSession() {
+	c := mc.client
+	c.mu.Lock()
+	key := mqttSessionKey(c.acc, c.getAuthUser(), mc.id)
+	c.mu.Unlock()
+	sess, present := mc.srv.mqttLookupSession(key, mc.clean, mc.ackWait, mc.maxAP)
+	mc.mu.Lock()
+	mc.sess = sess
+	// The CONNECT acknowledgment is the only one queued at this point.
+	mc.actions[0].present = present
+	mc.mu.Unlock()
+	mc.hasSess = true
+	mc.pending = append(mc.pending, "PING"+_CRLF_...)
+}
+
+// Returns the key of a session. Sessions belong to a user of an account,
+// so that other users can not resume them with the same client ID.
+func mqttSessionKey(acc *Account, user, id string) string {
+	var accName string
+	if acc != nil {
+		accName = acc.Name
+	}
+	return fmt.Sprintf("%s\x00%s\x00%s", accName, user, id)
+}
+
+func (mc *mqttConn) processPublish(flags byte, r *mqttReader) error {
+	qos := (flags & mqttPubFlagQoS) >> 1
+	switch qos {
+	case 2:
+		return ErrMQTTQoS2NotSupported
+	case 3:
+		return ErrMQTTMalformedPacket
+	}
+	topic, err := r.readString()
+	if err != nil {
+		return err
+	}
+	subject, err := mqttTopicToSubject(topic, false)
+	if err != nil {
+		return err
+	}
+	if qos == 1 {
+		pid, err := r.readUint16()
+		if err != nil {
+			return err
+		}
+		if pid == 0 {
+			return ErrMQTTMalformedPacket
+		}
+		mc.pubAcks = append(mc.pubAcks, pid)
+	}
+	// Retained messages are not supported, the flag is ignored.
+	mc.pending = append(mc.pending, mqttPubProto(subject, r.rest())...)
+	return nil
+}
+
+func (mc *mqttConn) processPubAck(r *mqttReader) error {
+	pid, err := r.readUint16()
+	if err != nil {
+		return err
+	}
+	mc.mu.Lock()
+	sess := mc.sess
+	mc.mu.Unlock()
+	sess.mu.Lock()
+	delete(sess.pending, pid)
+	sess.mu.Unlock()
+	return nil
+}
+
+func (mc *mqttConn) processSubscribe(r *mqttReader) error {
+	pid, err := r.readUint16()
+	if err != nil {
+		return err
+	}
+	mc.mu.Lock()
+	sess := mc.sess
+	mc.mu.Unlock()
+
+	a := &mqttAction{kind: mqttPacketSubAck, pid: pid}
+	for r.len() > 0 {
+		filter, err := r.readString()
+		if err != nil {
+			return err
+		}
+		qos, err := r.readByte()
+		if err != nil {
+			return err
+		}
+		if qos > 2 {
+			return ErrMQTTMalformedPacket
+		}
+		// We only support up to QoS 1.
+		if qos > 1 {
+			qos = 1
+		}
+		subjects, err := mqttFilterToSubjects(filter)
+		if err != nil {
+			a.codes = append(a.codes, mqttSubAckFailure)
+			a.subs = append(a.subs, nil)
+			continue
+		}
+		sess.mu.Lock()
+		sub := sess.subs[filter]
+		if sub != nil {
+			// Existing subscriptions are replaced, which only changes the QoS.
+			sub.qos = qos
+		} else {
+			sub = &mqttSub{filter: filter, subjects: subjects, qos: qos}
+			for _, subject := range subjects {
+				sess.nsid++
+				sid := strconv.FormatUint(sess.nsid, 10)
+				sub.sids = append(sub.sids, sid)
+				sess.sids[sid] = sub
+				mc.pending = append(mc.pending, fmt.Sprintf("SUB %s %s\r\n", subject, sid)...)
+			}
+			sess.subs[filter] = sub
+		}
+		sess.mu.Unlock()
+		a.codes = append(a.codes, qos)
+		a.subs = append(a.subs, sub)
+	}
+	if len(a.codes) == 0 {
+		return ErrMQTTMalformedPacket
+	}
+	mc.addAction(a)
+	return nil
+}
+
+func (mc *mqttConn) processUnsubscribe(r *mqttReader) error {
+	pid, err := r.readUint16()
+	if err != nil {
+		return err
+	}
+	mc.mu.Lock()
+	sess := mc.sess
+	mc.mu.Unlock()
+
+	n := 0
+	for ; r.len() > 0; n++ {
+		filter, err := r.readString()
+		if err != nil {
+			return err
+		}
+		sess.mu.Lock()
+		if sub := sess.subs[filter]; sub != nil {
+			delete(sess.subs, filter)
+			for _, sid := range sub.sids {
+				delete(sess.sids, sid)
+				mc.pending = append(mc.pending, fmt.Sprintf("UNSUB %s\r\n", sid)...)
+			}
+		}
+		sess.mu.Unlock()
+	}
+	if n == 0 {
+		return ErrMQTTMalformedPacket
+	}
+	mc.addAction(&mqttAction{kind: mqttPacketUnsubAck, pid: pid})
+	return nil
+}
+
+// Queue an acknowledgment to be sent once the server has processed
+// everything so far.
+func (mc *mqttConn) addAction(a *mqttAction) {
+	mc.mu.Lock()
+	mc.actions = append(mc.actions, a)
+	mc.mu.Unlock()
+	mc.pending = append(mc.pending, "PING"+_CRLF_...)
+}
+
+// Write translates the server protocol into MQTT packets.
+func (mc *mqttConn) Write(p []byte) (int, error) {
+	mc.wbuf = append(mc.wbuf, p...)
+	var out []byte
+	for len(mc.wbuf) > 0 {
+		i := bytes.Index(mc.wbuf, []byte(_CRLF_))
+		if i < 0 {
+			break
+		}
+		line, n := mc.wbuf[:i], i+2
+		if bytes.HasPrefix(line, []byte("MSG ")) {
+			args := bytes.Fields(line[4:])
+			if len(args) < 3 {
+				return 0, fmt.Errorf("mqtt: unexpected message line %q", line)
+			}
+			size, err := strconv.Atoi(string(args[len(args)-1]))
+			if err != nil {
+				return 0, fmt.Errorf("mqtt: unexpected message line %q", line)
+			}
+			if len(mc.wbuf) < n+size+2 {
+				// Wait for the rest of the message.
+				break
+			}
+			out = mc.processMsg(out, string(args[0]), string(args[1]), mc.wbuf[n:n+size])
+			mc.wbuf = mc.wbuf[n+size+2:]
+			continue
+		}
+		switch {
+		case bytes.Equal(line, []byte("PING")):
+			mc.interrupt([]byte("PONG" + _CRLF_))
+		case bytes.Equal(line, []byte("PONG")):
+			out = mc.processPong(out)
+		case bytes.HasPrefix(line, []byte("-ERR ")):
+			out = mc.processErr(out, string(line[5:]))
+		}
+		mc.wbuf = mc.wbuf[n:]
+	}
+	if len(mc.wbuf) == 0 {
+		mc.wbuf = nil
+	}
+	if len(out) > 0 {
+		if err := mc.write(out); err != nil {
+			return 0, err
+		}
+	}
+	return len(p), nil
+}
+
+// Translate a message into a PUBLISH packet.
+func (mc *mqttConn) processMsg(out []byte, subject, sid string, msg []byte) []byte {
+	mc.mu.Lock()
+	sess := mc.sess
+	mc.mu.Unlock()
+	if sess == nil {
+		return out
+	}
+	topic := mqttSubjectToTopic(subject)
+
+	sess.mu.Lock()
+	sub := sess.sids[sid]
+	if sub == nil {
+		sess.mu.Unlock()
+		return out
+	}
+	qos, pid := sub.qos, uint16(0)
+	// If too many messages are waiting for an acknowledgment, deliver at QoS 0.
+	if qos > 0 && len(sess.pending) < sess.maxAP {
+		pid = sess.nextPacketID()
+		sess.pseq++
+		sess.pending[pid] = &mqttPending{
+			pid:   pid,
+			seq:   sess.pseq,
+			topic: topic,
+			msg:   append([]byte(nil), msg...),
+			sent:  time.Now(),
+		}
+		if sess.tmr == nil {
+			sess.tmr = time.AfterFunc(sess.ackWait, sess.redeliver)
+		}
+	} else {
+		qos = 0
+	}
+	sess.mu.Unlock()
+	return mqttAppendPublish(out, topic, qos, pid, false, msg)
+}
+
+// Process the acknowledgment at the head of the action queue.
+func (mc *mqttConn) processPong(out []byte) []byte {
+	mc.mu.Lock()
+	if len(mc.actions) == 0 {
+		mc.mu.Unlock()
+		return out
+	}
+	a := mc.actions[0]
+	mc.actions = mc.actions[1:]
+	sess := mc.sess
+	mc.mu.Unlock()
+
+	switch a.kind {
+	case mqttPacketConnectAck:
+		if prev := mc.srv.mqttAttachSession(sess, mc); prev != nil {
+			// Another connection was using this client ID.
+			prev.Conn.Close()
+		}
+		out = append(out, mqttConnAck(a.present, mqttConnAckRCAccepted)...)
+		// Resend unacknowledged messages and restore the subscriptions.
+		var subs []byte
+		sess.mu.Lock()
+		for _, pm := range sess.sortedPending() {
+			out = mqttAppendPublish(out, pm.topic, 1, pm.pid, true, pm.msg)
+			pm.sent = time.Now()
+		}
+		if len(sess.pending) > 0 && sess.tmr == nil {
+			sess.tmr = time.AfterFunc(sess.ackWait, sess.redeliver)
+		}
+		if a.present {
+			for _, sub := range sess.subs {
+				for i, sid := range sub.sids {
+					subs = append(subs, fmt.Sprintf("SUB %s %s\r\n", sub.subjects[i], sid)...)
+				}
+			}
+		}
+		sess.mu.Unlock()
+		if len(subs) > 0 {
+			mc.interrupt(subs)
+		}
+	case mqttPacketSubAck:
+		out = append(out, mqttPacketSubAck)
+		out = mqttAppendLength(out, 2+len(a.codes))
+		out = append(out, byte(a.pid>>8), byte(a.pid))
+		out = append(out, a.codes...)
+	case mqttPacketUnsubAck:
+		out = mqttAppendPacketID(out, mqttPacketUnsubAck, a.pid)
+	}
+	return out
+}
+
+// Process an error sent by the server.
+func (mc *mqttConn) processErr(out []byte, errTxt string) []byte {
+	errTxt = strings.Trim(errTxt, "'")
+	mc.mu.Lock()
+	defer mc.mu.Unlock()
+	if len(mc.actions) == 0 {
+		return out
+	}
+	a := mc.actions[0]
+	switch {
+	case a.kind == mqttPacketConnectAck:
+		// The server will close the connection after this error.
+		mc.actions = mc.actions[1:]
+		rc := mqttConnAckRCServerUnavailable
+		if strings.HasPrefix(errTxt, "Authorization Violation") || strings.HasPrefix(errTxt, "Authentication") {
+			rc = mqttConnAckRCNotAuthorized
+			if a.creds {
+				rc = mqttConnAckRCBadUserOrPassword
+			}
+		}
+		return append(out, mqttConnAck(false, rc)...)
+	case strings.HasPrefix(errTxt, "Permissions Violation for Subscription to "):
+		subject, err := strconv.Unquote(strings.TrimPrefix(errTxt, "Permissions Violation for Subscription to "))
+		if err != nil {
+			return out
+		}
+		for _, a := range mc.actions {
+			if a.kind != mqttPacketSubAck {
+				continue
+			}
+			for i, sub := range a.subs {
+				if sub == nil || a.codes[i] == mqttSubAckFailure {
+					continue
+				}
+				for _, subj := range sub.subjects {
+					if subj == subject {
+						a.codes[i] = mqttSubAckFailure
+						mc.sess.removeSub(sub)
+						return out
+					}
+				}
+			}
+		}
+	}
+	return out
+}
+
+// Write MQTT packets to the connection.
+func (mc *mqttConn) write(b []byte) error {
+	mc.wmu.Lock()
+	defer mc.wmu.Unlock()
+	_, err := mc.Conn.Write(b)
+	return err
+}
+
+// Close will detach the session from this connection.
+func (mc *mqttConn) Close() error {
+	mc.mu.Lock()
+	sess := mc.sess
+	mc.mu.Unlock()
+	if sess != nil {
+		mc.srv.mqttDetachSession(sess, mc)
+	}
+	return mc.Conn.Close()
+}
+
+// Returns the session for the key, and whether it was resumed.
+func (s *Server) mqttLookupSession(key string, clean bool, ackWait time.Duration, maxAP int) (*mqttSession, bool) {
+	s.mqtt.mu.Lock()
+	defer s.mqtt.mu.Unlock()
+	if sess := s.mqtt.sessions[key]; sess != nil && !clean && !sess.clean {
+		return sess, true
+	}
+	return &mqttSession{
+		key:     key,
+		clean:   clean,
+		ackWait: ackWait,
+		maxAP:   maxAP,
+		subs:    make(map[string]*mqttSub),
+		sids:    make(map[string]*mqttSub),
+		pending: make(map[uint16]*mqttPending),
+	}, false
+}
+
+// Registers the session and attaches the connection to it. Returns the
+// connection previously using this session, if any.
+func (s *Server) mqttAttachSession(sess *mqttSession, mc *mqttConn) *mqttConn {
+	var prev *mqttConn
+	s.mqtt.mu.Lock()
+	defer s.mqtt.mu.Unlock()
+	if old := s.mqtt.sessions[sess.key]; old != nil && old != sess {
+		old.mu.Lock()
+		prev = old.mc
+		old.mc = nil
+		old.stopTimer()
+		old.mu.Unlock()
+	}
+	if s.mqtt.sessions == nil {
+		s.mqtt.sessions = make(map[string]*mqttSession)
+	}
+	s.mqtt.sessions[sess.key] = sess
+	sess.mu.Lock()
+	if sess.mc != nil && sess.mc != mc {
+		prev = sess.mc
+	}
+	sess.mc = mc
+	sess.mu.Unlock()
+	return prev
+}
+
+// Detaches the connection from the session. Clean sessions are removed.
+func (s *Server) mqttDetachSession(sess *mqttSession, mc *mqttConn) {
+	s.mqtt.mu.Lock()
+	defer s.mqtt.mu.Unlock()
+	sess.mu.Lock()
+	defer sess.mu.Unlock()
+	if sess.mc != mc {
+		return
+	}
+	sess.mc = nil
+	sess.stopTimer()
+	if sess.clean && s.mqtt.sessions[sess.key] == sess {
+		delete(s.mqtt.sessions, sess.key)
+	}
+}
+
+// Lock should be held.
+func (sess *mqttSession) nextPacketID() uint16 {
+	for {
+		sess.ppi++
+		if sess.ppi == 0 {
+			continue
+		}
+		if _, inUse := sess.pending[sess.ppi]; !inUse {
+			return sess.ppi
+		}
+	}
+}
+
+// Returns pending messages in the order they were sent.
+// Lock should be held.
+func (sess *mqttSession) sortedPending() []*mqttPending {
+	pms := make([]*mqttPending, 0, len(sess.pending))
+	for _, pm := range sess.pending {
+		pms = append(pms, pm)
+	}
+	sort.Slice(pms, func(i, j int) bool { return pms[i].seq < pms[j].seq })
+	return pms
+}
+
+// Lock should be held.
+func (sess *mqttSession) stopTimer() {
+	if sess.tmr != nil {
+		sess.tmr.Stop()
+		sess.tmr = nil
+	}
+}
+
+func (sess *mqttSession) removeSub(sub *mqttSub) {
+	sess.mu.Lock()
+	defer sess.mu.Unlock()
+	if sess.subs[sub.filter] == sub {
+		delete(sess.subs, sub.filter)
+	}
+	for _, sid := range sub.sids {
+		delete(sess.sids, sid)
+	}
+}
+
+// Resend messages that have not been acknowledged within the ack wait.
+func (sess *mqttSession) redeliver() {
+	sess.mu.Lock()
+	sess.tmr = nil
+	mc := sess.mc
+	if mc == nil || len(sess.pending) == 0 {
+		sess.mu.Unlock()
+		return
+	}
+	var out []byte
+	now := time.Now()
+	next := sess.ackWait
+	for _, pm := range sess.sortedPending() {
+		if elapsed := now.Sub(pm.sent); elapsed >= sess.ackWait {
+			out = mqttAppendPublish(out, pm.topic, 1, pm.pid, true, pm.msg)
+			pm.sent = now
+		} else if rem := sess.ackWait - elapsed; rem < next {
+			next = rem
+		}
+	}
+	sess.tmr = time.AfterFunc(next, sess.redeliver)
+	sess.mu.Unlock()
+	if len(out) > 0 {
+		mc.write(out)
+	}
+}
+
+// Helper to read the fields of a packet.
+type mqttReader struct {
+	b   []byte
+	pos int
+}
+
+func (r *mqttReader) len() int {
+	return len(r.b) - r.pos
+}
+
+func (r *mqttReader) readByte() (byte, error) {
+	if r.len() < 1 {
+		return 0, ErrMQTTMalformedPacket
+	}
+	b := r.b[r.pos]
+	r.pos++
+	return b, nil
+}
+
+func (r *mqttReader) readUint16() (uint16, error) {
+	if r.len() < 2 {
+		return 0, ErrMQTTMalformedPacket
+	}
+	v := binary.BigEndian.Uint16(r.b[r.pos:])
+	r.pos += 2
+	return v, nil
+}
+
+func (r *mqttReader) readBytes() ([]byte, error) {
+	l, err := r.readUint16()
+	if err != nil {
+		return nil, err
+	}
+	if r.len() < int(l) {
+		return nil, ErrMQTTMalformedPacket
+	}
+	b := r.b[r.pos : r.pos+int(l)]
+	r.pos += int(l)
+	return b, nil
+}
+
+func (r *mqttReader) readString() (string, error) {
+	b, err := r.readBytes()
+	return string(b), err
+}
+
+func (r *mqttReader) rest() []byte {
+	b := r.b[r.pos:]
+	r.pos = len(r.b)
+	return b
+}
+
+// Appends the variable length encoding of the remaining length.
+func mqttAppendLength(b []byte, l int) []byte {
+	for {
+		d := byte(l % 128)
+		l /= 128
+		if l > 0 {
+			d |= 0x80
+		}
+		b = append(b, d)
+		if l == 0 {
+			return b
+		}
+	}
+}
+
+func mqttAppendPacketID(b []byte, ptype byte, pid uint16) []byte {
+	return append(b, ptype, 2, byte(pid>>8), byte(pid))
+}
+
+func mqttAppendPublish(b []byte, topic string, qos byte, pid uint16, dup bool, msg []byte) []byte {
+	b0 := mqttPacketPub | qos<<1
+	if dup {
+		b0 |= mqttPubFlagDup
+	}
+	l := 2 + len(topic) + len(msg)
+	if qos > 0 {
+		l += 2
+	}
+	b = append(b, b0)
+	b = mqttAppendLength(b, l)
+	b = append(b, byte(len(topic)>>8), byte(len(topic)))
+	b = append(b, topic...)
+	if qos > 0 {
+		b = append(b, byte(pid>>8), byte(pid))
+	}
+	return append(b, msg...)
+}
+
+func mqttConnAck(present bool, rc byte) []byte {
+	var sp byte
+	if present {
+		sp = 1
+	}
+	return []byte{mqttPacketConnectAck, 2, sp, rc}
+}
+
+func mqttPubProto(subject string, msg []byte) []byte {
+	proto := []byte(fmt.Sprintf("PUB %s %d\r\n", subject, len(msg)))
+	proto = append(proto, msg...)
+	return append(proto, _CRLF_...)
+}
+
+// Converts an MQTT topic name or filter to a NATS subject. Levels are
+// separated by '/' and may not be empty or contain characters that have
+// a meaning in NATS subjects.
+func mqttTopicToSubject(topic string, filter bool) (string, error) {
+	if topic == _EMPTY_ {
+		return _EMPTY_, ErrMQTTInvalidTopic
+	}
+	levels := strings.Split(topic, "/")
+	for i, l := range levels {
+		switch {
+		case l == "+" && filter:
+			levels[i] = string(pwc)
+		case l == "#" && filter && i == len(levels)-1:
+			levels[i] = string(fwc)
+		case l == _EMPTY_ || strings.ContainsAny(l, "+#.*> \t\r\n"):
+			return _EMPTY_, ErrMQTTInvalidTopic
+		}
+	}
+	return strings.Join(levels, tsep), nil
+}
+
+// Returns the subjects needed to match the MQTT filter. A filter such as
+// "foo/#" also matches "foo".
+func mqttFilterToSubjects(filter string) ([]string, error) {
+	subject, err := mqttTopicToSubject(filter, true)
+	if err != nil {
+		return nil, err
+	}
+	subjects := []string{subject}
+	if strings.HasSuffix(subject, tsep+string(fwc)) {
+		subjects = append(subjects, subject[:len(subject)-2])
+	}
+	return subjects, nil
+}
+
+func mqttSubjectToTopic(subject string) string {
+	return strings.Replace(subject, tsep, "/", -1)
+}
+
+// Validate the MQTT options.
+func validateMQTTOptions(o *Options) error {
+	mo := &o.MQTT
+	if mo.Port == 0 {
+		return nil
+	}
+	if mo.AckWait < 0 {
+		return fmt.Errorf("mqtt ack_wait should be positive, got %v", mo.AckWait)
+	}
+	if mo.MaxAckPending < 0 {
+		return fmt.Errorf("mqtt max_ack_pending should be positive, got %v", mo.MaxAckPending)
+	}
+	return nil
+}
+
+// startMQTT will start the listener for MQTT clients. Accepted
+// connections are handled as regular clients.
+func (s *Server) startMQTT() {
+	opts := s.getOpts()
+	mo := &opts.MQTT
+
+	port := mo.Port
+	if port == -1 {
+		port = 0
+	}
+	hp := net.JoinHostPort(mo.Host, strconv.Itoa(port))
+	l, err := net.Listen("tcp", hp)
+	if err != nil {
+		s.Fatalf("Unable to listen for MQTT connections: %v", err)
+		return
+	}
+	if mo.TLSConfig != nil {
+		l = tls.NewListener(l, mo.TLSConfig.Clone())
+	}
+	// If we have selected a random port...
+	if port == 0 {
+		// Write resolved port back to options.
+		mo.Port = l.Addr().(*net.TCPAddr).Port
+	}
+	s.Noticef("Listening for MQTT clients on %s", net.JoinHostPort(mo.Host, strconv.Itoa(mo.Port)))
+	if mo.TLSConfig != nil {
+		s.Noticef("TLS required for MQTT connections")
+	}
+
+	s.mu.Lock()
+	s.mqtt.listener = l
+	s.mu.Unlock()
+
+	go s.mqttAcceptLoop(l)
+}
+
+func (s *Server) mqttAcceptLoop(l net.Listener) {
+	tmpDelay := ACCEPT_MIN_SLEEP
+	for s.isRunning() {
+		conn, err := l.Accept()
+		if err != nil {
+			tmpDelay = s.acceptError("MQTT", err, tmpDelay)
+			continue
+		}
+		tmpDelay = ACCEPT_MIN_SLEEP
+		s.startGoRoutine(func() {
+			s.createClient(s.newMQTTConn(conn))
+			s.grWG.Done()
+		})
+	}
+	s.Debugf("MQTT accept loop exiting..")
+	s.done <- true
+}
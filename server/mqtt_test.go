@@ -0,0 +1,590 @@
+// Copyright 2019 The NATS Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package server
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/nats-io/nkeys"
+)
+
+func testMQTTOptions() *Options {
+	o := DefaultOptions()
+	o.MQTT.Host = "127.0.0.1"
+	o.MQTT.Port = -1
+	return o
+}
+
+type testMQTTClient struct {
+	t    *testing.T
+	conn net.Conn
+}
+
+type testMQTTConnInfo struct {
+	id        string
+	clean     bool
+	user      string
+	pass      string
+	willTopic string
+	willMsg   string
+	keepAlive uint16
+}
+
+func testMQTTAppendString(b []byte, s string) []byte {
+	b = append(b, byte(len(s)>>8), byte(len(s)))
+	return append(b, s...)
+}
+
+func testMQTTDial(t *testing.T, s *Server) *testMQTTClient {
+	t.Helper()
+	addr := fmt.Sprintf("%s:%d", s.getOpts().MQTT.Host, s.getOpts().MQTT.Port)
+	conn, err := net.DialTimeout("tcp", addr, 2*time.Second)
+	if err != nil {
+		t.Fatalf("Error creating mqtt connection: %v", err)
+	}
+	return &testMQTTClient{t: t, conn: conn}
+}
+
+// Connects and returns the CONNACK session present flag and return code.
+func testMQTTConnectWith(t *testing.T, s *Server, ci *testMQTTConnInfo) (*testMQTTClient, bool, byte) {
+	t.Helper()
+	mc := testMQTTDial(t, s)
+	flags := byte(0)
+	if ci.clean {
+		flags |= mqttConnFlagCleanSession
+	}
+	body := testMQTTAppendString(nil, mqttProtoName)
+	body = append(body, mqttProtoLevel, 0, byte(ci.keepAlive>>8), byte(ci.keepAlive))
+	body = testMQTTAppendString(body, ci.id)
+	if ci.willTopic != _EMPTY_ {
+		flags |= mqttConnFlagWill
+		body = testMQTTAppendString(body, ci.willTopic)
+		body = testMQTTAppendString(body, ci.willMsg)
+	}
+	if ci.user != _EMPTY_ {
+		flags |= mqttConnFlagUsername
+		body = testMQTTAppendString(body, ci.user)
+	}
+	if ci.pass != _EMPTY_ {
+		flags |= mqttConnFlagPassword
+		body = testMQTTAppendString(body, ci.pass)
+	}
+	body[len(mqttProtoName)+3] = flags
+	mc.send(mqttPacketConnect, body)
+	b0, resp := mc.read()
+	if b0 != mqttPacketConnectAck || len(resp) != 2 {
+		mc.conn.Close()
+		t.Fatalf("Expected CONNACK, got %x %v", b0, resp)
+	}
+	return mc, resp[0] == 1, resp[1]
+}
+
+func testMQTTConnect(t *testing.T, s *Server, ci *testMQTTConnInfo) *testMQTTClient {
+	t.Helper()
+	mc, _, rc := testMQTTConnectWith(t, s, ci)
+	if rc != mqttConnAckRCAccepted {
+		mc.conn.Close()
+		t.Fatalf("Expected connection to be accepted, got rc=%v", rc)
+	}
+	return mc
+}
+
+func (mc *testMQTTClient) send(b0 byte, body []byte) {
+	mc.t.Helper()
+	pkt := mqttAppendLength([]byte{b0}, len(body))
+	if _, err := mc.conn.Write(append(pkt, body...)); err != nil {
+		mc.t.Fatalf("Error sending packet: %v", err)
+	}
+}
+
+func (mc *testMQTTClient) read() (byte, []byte) {
+	mc.t.Helper()
+	mc.conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+	var buf []byte
+	tmp := make([]byte, 1)
+	for {
+		b0, body, n, err := mqttParsePacket(buf, 0)
+		if err != nil {
+			mc.t.Fatalf("Error parsing packet: %v", err)
+		}
+		if n > 0 {
+			return b0, body
+		}
+		if _, err := io.ReadFull(mc.conn, tmp); err != nil {
+			mc.t.Fatalf("Error reading packet: %v", err)
+		}
+		buf = append(buf, tmp...)
+	}
+}
+
+func (mc *testMQTTClient) expectNothing(wait time.Duration) {
+	mc.t.Helper()
+	mc.conn.SetReadDeadline(time.Now().Add(wait))
+	var b [1]byte
+	if n, err := mc.conn.Read(b[:]); n > 0 {
+		mc.t.Fatalf("Expected nothing, got %x", b[0])
+	} else if ne, ok := err.(net.Error); !ok || !ne.Timeout() {
+		mc.t.Fatalf("Expected timeout, got %v", err)
+	}
+}
+
+func (mc *testMQTTClient) subscribe(pid uint16, filters map[string]byte, order ...string) []byte {
+	mc.t.Helper()
+	body := []byte{byte(pid >> 8), byte(pid)}
+	for _, f := range order {
+		body = testMQTTAppendString(body, f)
+		body = append(body, filters[f])
+	}
+	mc.send(mqttPacketSub|mqttSubFlags, body)
+	b0, resp := mc.read()
+	if b0 != mqttPacketSubAck || binary.BigEndian.Uint16(resp) != pid {
+		mc.t.Fatalf("Expected SUBACK for %v, got %x %v", pid, b0, resp)
+	}
+	return resp[2:]
+}
+
+func (mc *testMQTTClient) publish(topic string, qos byte, pid uint16, msg string) {
+	mc.t.Helper()
+	body := testMQTTAppendString(nil, topic)
+	if qos > 0 {
+		body = append(body, byte(pid>>8), byte(pid))
+	}
+	body = append(body, msg...)
+	mc.send(mqttPacketPub|qos<<1, body)
+}
+
+// Reads a PUBLISH and returns the flags, topic, packet identifier and message.
+func (mc *testMQTTClient) readPublish() (byte, string, uint16, string) {
+	mc.t.Helper()
+	b0, body := mc.read()
+	if b0&mqttPacketMask != mqttPacketPub {
+		mc.t.Fatalf("Expected PUBLISH, got %x", b0)
+	}
+	r := &mqttReader{b: body}
+	topic, err := r.readString()
+	if err != nil {
+		mc.t.Fatalf("Error reading topic: %v", err)
+	}
+	var pid uint16
+	if b0&mqttPubFlagQoS != 0 {
+		pid, _ = r.readUint16()
+	}
+	return b0 & mqttPacketFlagMask, topic, pid, string(r.rest())
+}
+
+func TestMQTTTopicToSubject(t *testing.T) {
+	for _, test := range []struct {
+		topic   string
+		filter  bool
+		subject string
+		ok      bool
+	}{
+		{"foo", false, "foo", true},
+		{"foo/bar/baz", false, "foo.bar.baz", true},
+		{"foo/+/baz", true, "foo.*.baz", true},
+		{"foo/#", true, "foo.>", true},
+		{"#", true, ">", true},
+		{"+", true, "*", true},
+		{"foo/+", false, _EMPTY_, false},
+		{"foo/#/bar", true, _EMPTY_, false},
+		{"foo+", true, _EMPTY_, false},
+		{"/foo", false, _EMPTY_, false},
+		{"foo//bar", false, _EMPTY_, false},
+		{"foo.bar", false, _EMPTY_, false},
+		{"foo/b*r", false, _EMPTY_, false},
+		{"foo bar", false, _EMPTY_, false},
+		{_EMPTY_, false, _EMPTY_, false},
+	} {
+		t.Run(test.topic, func(t *testing.T) {
+			subject, err := mqttTopicToSubject(test.topic, test.filter)
+			if test.ok && (err != nil || subject != test.subject) {
+				t.Fatalf("Expected %q, got %q err=%v", test.subject, subject, err)
+			} else if !test.ok && err == nil {
+				t.Fatalf("Expected error, got %q", subject)
+			}
+		})
+	}
+	if subjects, _ := mqttFilterToSubjects("foo/#"); len(subjects) != 2 || subjects[1] != "foo" {
+		t.Fatalf("Unexpected subjects: %q", subjects)
+	}
+	if topic := mqttSubjectToTopic("foo.bar"); topic != "foo/bar" {
+		t.Fatalf("Unexpected topic: %q", topic)
+	}
+}
+
+func TestMQTTPubSub(t *testing.T) {
+	o := testMQTTOptions()
+	s := RunServer(o)
+	defer s.Shutdown()
+
+	mc := testMQTTConnect(t, s, &testMQTTConnInfo{id: "mqtt", clean: true})
+	defer mc.conn.Close()
+
+	// The connection is reported as an MQTT one.
+	if cz, _ := s.Connz(nil); len(cz.Conns) != 1 || !cz.Conns[0].MQTT {
+		t.Fatalf("Expected an MQTT connection, got %+v", cz.Conns)
+	}
+
+	codes := mc.subscribe(1, map[string]byte{"foo/+": 0, "bar/#": 0, "bad//topic": 0}, "foo/+", "bar/#", "bad//topic")
+	if !bytes.Equal(codes, []byte{0, 0, mqttSubAckFailure}) {
+		t.Fatalf("Unexpected return codes: %v", codes)
+	}
+
+	nc := natsConnect(t, fmt.Sprintf("nats://%s:%d", o.Host, o.Port))
+	defer nc.Close()
+	sub := natsSubSync(t, nc, "baz.>")
+	natsFlush(t, nc)
+
+	for _, subj := range []string{"foo.1", "bar", "bar.1.2", "foo.1.2"} {
+		natsPub(t, nc, subj, []byte(subj))
+	}
+	natsFlush(t, nc)
+	for _, topic := range []string{"foo/1", "bar", "bar/1/2"} {
+		flags, rtopic, _, msg := mc.readPublish()
+		if flags != 0 || rtopic != topic || msg != mqttTopicToSubjectOrEmpty(topic) {
+			t.Fatalf("Unexpected publish flags=%x topic=%q msg=%q", flags, rtopic, msg)
+		}
+	}
+
+	mc.publish("baz/1", 0, 0, "hello")
+	if msg := natsNexMsg(t, sub, time.Second); msg.Subject != "baz.1" || string(msg.Data) != "hello" {
+		t.Fatalf("Unexpected message: %q %q", msg.Subject, msg.Data)
+	}
+
+	mc.send(mqttPacketPing, nil)
+	if b0, _ := mc.read(); b0 != mqttPacketPingResp {
+		t.Fatalf("Expected PINGRESP, got %x", b0)
+	}
+
+	mc.send(mqttPacketUnsub|mqttSubFlags, testMQTTAppendString([]byte{0, 2}, "foo/+"))
+	if b0, resp := mc.read(); b0 != mqttPacketUnsubAck || binary.BigEndian.Uint16(resp) != 2 {
+		t.Fatalf("Expected UNSUBACK, got %x %v", b0, resp)
+	}
+	natsPub(t, nc, "foo.1", []byte("foo.1"))
+	natsPub(t, nc, "bar.1", []byte("bar.1"))
+	if _, topic, _, _ := mc.readPublish(); topic != "bar/1" {
+		t.Fatalf("Unexpected topic %q", topic)
+	}
+
+	// A clean disconnect should remove the client.
+	mc.send(mqttPacketDisconnect, nil)
+	checkFor(t, time.Second, 15*time.Millisecond, func() error {
+		if n := s.NumClients(); n != 1 {
+			return fmt.Errorf("Expected 1 client, got %v", n)
+		}
+		return nil
+	})
+}
+
+func mqttTopicToSubjectOrEmpty(topic string) string {
+	subject, _ := mqttTopicToSubject(topic, false)
+	return subject
+}
+
+func TestMQTTQoS1(t *testing.T) {
+	o := testMQTTOptions()
+	o.MQTT.AckWait = 100 * time.Millisecond
+	s := RunServer(o)
+	defer s.Shutdown()
+
+	mc := testMQTTConnect(t, s, &testMQTTConnInfo{id: "mqtt", clean: true})
+	defer mc.conn.Close()
+
+	// QoS 2 is downgraded to QoS 1.
+	if codes := mc.subscribe(1, map[string]byte{"foo": 2}, "foo"); !bytes.Equal(codes, []byte{1}) {
+		t.Fatalf("Unexpected return codes: %v", codes)
+	}
+
+	nc := natsConnect(t, fmt.Sprintf("nats://%s:%d", o.Host, o.Port))
+	defer nc.Close()
+	sub := natsSubSync(t, nc, "bar")
+	natsFlush(t, nc)
+
+	// Publish with QoS 1 should be acknowledged.
+	mc.publish("bar", 1, 10, "hello")
+	if b0, resp := mc.read(); b0 != mqttPacketPubAck || binary.BigEndian.Uint16(resp) != 10 {
+		t.Fatalf("Expected PUBACK, got %x %v", b0, resp)
+	}
+	natsNexMsg(t, sub, time.Second)
+
+	natsPub(t, nc, "foo", []byte("msg"))
+	flags, _, pid, msg := mc.readPublish()
+	if flags != 1<<1 || pid == 0 || msg != "msg" {
+		t.Fatalf("Unexpected publish flags=%x pid=%v msg=%q", flags, pid, msg)
+	}
+	// Not acknowledged, so should be redelivered.
+	flags, _, rpid, msg := mc.readPublish()
+	if flags != mqttPubFlagDup|1<<1 || rpid != pid || msg != "msg" {
+		t.Fatalf("Unexpected redelivery flags=%x pid=%v msg=%q", flags, rpid, msg)
+	}
+	mc.send(mqttPacketPubAck, []byte{byte(pid >> 8), byte(pid)})
+	mc.expectNothing(250 * time.Millisecond)
+}
+
+func TestMQTTPersistentSession(t *testing.T) {
+	o := testMQTTOptions()
+	s := RunServer(o)
+	defer s.Shutdown()
+
+	ci := &testMQTTConnInfo{id: "persistent"}
+	mc, present, _ := testMQTTConnectWith(t, s, ci)
+	if present {
+		t.Fatalf("Session should not be present")
+	}
+	mc.subscribe(1, map[string]byte{"foo": 1}, "foo")
+
+	nc := natsConnect(t, fmt.Sprintf("nats://%s:%d", o.Host, o.Port))
+	defer nc.Close()
+	natsPub(t, nc, "foo", []byte("msg1"))
+	_, _, pid, _ := mc.readPublish()
+
+	// Reconnect with the same client ID while still connected, the old
+	// connection should be closed.
+	mc2, present, _ := testMQTTConnectWith(t, s, ci)
+	defer mc2.conn.Close()
+	if !present {
+		t.Fatalf("Session should be present")
+	}
+	mc.conn.SetReadDeadline(time.Now().Add(time.Second))
+	if _, err := mc.conn.Read(make([]byte, 1)); err == nil {
+		t.Fatalf("Expected old connection to be closed")
+	}
+	mc.conn.Close()
+
+	// The unacknowledged message is resent.
+	flags, _, rpid, msg := mc2.readPublish()
+	if flags&mqttPubFlagDup == 0 || rpid != pid || msg != "msg1" {
+		t.Fatalf("Unexpected redelivery flags=%x pid=%v msg=%q", flags, rpid, msg)
+	}
+	mc2.send(mqttPacketPubAck, []byte{byte(pid >> 8), byte(pid)})
+
+	// And the subscription restored.
+	checkFor(t, time.Second, 15*time.Millisecond, func() error {
+		if n := s.NumSubscriptions(); n != 1 {
+			return fmt.Errorf("Expected 1 subscription, got %v", n)
+		}
+		return nil
+	})
+	natsPub(t, nc, "foo", []byte("msg2"))
+	if _, _, _, msg := mc2.readPublish(); msg != "msg2" {
+		t.Fatalf("Unexpected message %q", msg)
+	}
+	mc2.conn.Close()
+
+	// A clean session discards the state.
+	mc3, present, _ := testMQTTConnectWith(t, s, &testMQTTConnInfo{id: "persistent", clean: true})
+	defer mc3.conn.Close()
+	if present {
+		t.Fatalf("Session should not be present")
+	}
+}
+
+func TestMQTTSessionOwnership(t *testing.T) {
+	o := testMQTTOptions()
+	accA, accB := NewAccount("A"), NewAccount("B")
+	o.Accounts = []*Account{accA, accB}
+	o.Users = []*User{
+		{Username: "a", Password: "pwd", Account: accA},
+		{Username: "b", Password: "pwd", Account: accB},
+	}
+	s := RunServer(o)
+	defer s.Shutdown()
+
+	ci := &testMQTTConnInfo{id: "shared", user: "a", pass: "pwd"}
+	mc, _, _ := testMQTTConnectWith(t, s, ci)
+	defer mc.conn.Close()
+	mc.subscribe(1, map[string]byte{"foo": 1}, "foo")
+
+	nc := natsConnect(t, fmt.Sprintf("nats://a:pwd@%s:%d", o.Host, o.Port))
+	defer nc.Close()
+	natsPub(t, nc, "foo", []byte("msg1"))
+	_, _, pid, _ := mc.readPublish()
+
+	// A user of another account with the same client ID gets its own
+	// session, and does not take over the existing connection.
+	mcb, present, rc := testMQTTConnectWith(t, s, &testMQTTConnInfo{id: "shared", user: "b", pass: "pwd"})
+	defer mcb.conn.Close()
+	if rc != mqttConnAckRCAccepted || present {
+		t.Fatalf("Expected a new session, got present=%v rc=%v", present, rc)
+	}
+	mcb.expectNothing(100 * time.Millisecond)
+	if acc, _ := s.LookupAccount("B"); acc.TotalSubs() != 0 {
+		t.Fatalf("Expected no subscriptions in account B, got %d", acc.TotalSubs())
+	}
+	mc.send(mqttPacketPing, nil)
+	if b0, _ := mc.read(); b0 != mqttPacketPingResp {
+		t.Fatalf("Expected PINGRESP, got %x", b0)
+	}
+	mcb.conn.Close()
+
+	// The owner still resumes its session.
+	mc.conn.Close()
+	mc2, present, _ := testMQTTConnectWith(t, s, ci)
+	defer mc2.conn.Close()
+	if !present {
+		t.Fatalf("Session should be present")
+	}
+	if flags, _, rpid, msg := mc2.readPublish(); flags&mqttPubFlagDup == 0 || rpid != pid || msg != "msg1" {
+		t.Fatalf("Unexpected redelivery flags=%x pid=%v msg=%q", flags, rpid, msg)
+	}
+}
+
+func TestMQTTWill(t *testing.T) {
+	o := testMQTTOptions()
+	s := RunServer(o)
+	defer s.Shutdown()
+
+	nc := natsConnect(t, fmt.Sprintf("nats://%s:%d", o.Host, o.Port))
+	defer nc.Close()
+	sub := natsSubSync(t, nc, "will.>")
+	natsFlush(t, nc)
+
+	// Not sent on a clean disconnect.
+	mc := testMQTTConnect(t, s, &testMQTTConnInfo{id: "mqtt1", clean: true, willTopic: "will/1", willMsg: "bye"})
+	mc.send(mqttPacketDisconnect, nil)
+	mc.conn.Close()
+
+	mc = testMQTTConnect(t, s, &testMQTTConnInfo{id: "mqtt2", clean: true, willTopic: "will/2", willMsg: "bye"})
+	mc.conn.Close()
+	if msg := natsNexMsg(t, sub, time.Second); msg.Subject != "will.2" || string(msg.Data) != "bye" {
+		t.Fatalf("Unexpected will message: %q %q", msg.Subject, msg.Data)
+	}
+	if msg, err := sub.NextMsg(100 * time.Millisecond); err == nil {
+		t.Fatalf("Unexpected message: %q", msg.Subject)
+	}
+}
+
+func TestMQTTKeepAlive(t *testing.T) {
+	o := testMQTTOptions()
+	s := RunServer(o)
+	defer s.Shutdown()
+
+	mc := testMQTTConnect(t, s, &testMQTTConnInfo{id: "mqtt", clean: true, keepAlive: 1})
+	defer mc.conn.Close()
+	mc.conn.SetReadDeadline(time.Now().Add(3 * time.Second))
+	start := time.Now()
+	if _, err := mc.conn.Read(make([]byte, 1)); err != io.EOF {
+		t.Fatalf("Expected connection to be closed, got %v", err)
+	}
+	if dur := time.Since(start); dur < time.Second {
+		t.Fatalf("Connection closed too early: %v", dur)
+	}
+}
+
+func TestMQTTAuth(t *testing.T) {
+	kp, _ := nkeys.CreateUser()
+	pub, _ := kp.PublicKey()
+	seed, _ := kp.Seed()
+
+	o := testMQTTOptions()
+	o.Users = []*User{
+		{Username: "user", Password: "pwd"},
+		{
+			Username: "limited",
+			Password: "pwd",
+			Permissions: &Permissions{
+				Subscribe: &SubjectPermission{Allow: []string{"foo.>"}},
+			},
+		},
+	}
+	o.Nkeys = []*NkeyUser{{Nkey: pub}}
+	s := RunServer(o)
+	defer s.Shutdown()
+
+	for _, test := range []struct {
+		name string
+		user string
+		pass string
+		rc   byte
+	}{
+		{"no credentials", _EMPTY_, _EMPTY_, mqttConnAckRCNotAuthorized},
+		{"bad password", "user", "bad", mqttConnAckRCBadUserOrPassword},
+		{"user", "user", "pwd", mqttConnAckRCAccepted},
+		// Nkeys are rejected since the seed would have to be sent.
+		{"nkey", pub, string(seed), mqttConnAckRCNotAuthorized},
+		{"nkey without password", pub, _EMPTY_, mqttConnAckRCNotAuthorized},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			mc, _, rc := testMQTTConnectWith(t, s, &testMQTTConnInfo{id: "mqtt", clean: true, user: test.user, pass: test.pass})
+			defer mc.conn.Close()
+			if rc != test.rc {
+				t.Fatalf("Expected rc %v, got %v", test.rc, rc)
+			}
+		})
+	}
+
+	mc := testMQTTConnect(t, s, &testMQTTConnInfo{id: "mqtt", clean: true, user: "limited", pass: "pwd"})
+	defer mc.conn.Close()
+	codes := mc.subscribe(1, map[string]byte{"foo/bar": 1, "bar": 1, "foo/#": 0}, "foo/bar", "bar", "foo/#")
+	if !bytes.Equal(codes, []byte{1, mqttSubAckFailure, mqttSubAckFailure}) {
+		t.Fatalf("Unexpected return codes: %v", codes)
+	}
+}
+
+func TestMQTTUnsupportedProtocol(t *testing.T) {
+	o := testMQTTOptions()
+	s := RunServer(o)
+	defer s.Shutdown()
+
+	mc := testMQTTDial(t, s)
+	defer mc.conn.Close()
+	body := testMQTTAppendString(nil, "MQIsdp")
+	body = append(body, 3, mqttConnFlagCleanSession, 0, 0)
+	body = testMQTTAppendString(body, "mqtt")
+	mc.send(mqttPacketConnect, body)
+	if b0, resp := mc.read(); b0 != mqttPacketConnectAck || resp[1] != mqttConnAckRCUnacceptableProto {
+		t.Fatalf("Expected CONNACK with unacceptable protocol, got %x %v", b0, resp)
+	}
+
+	// Anything other than CONNECT first closes the connection.
+	mc2 := testMQTTDial(t, s)
+	defer mc2.conn.Close()
+	mc2.send(mqttPacketPing, nil)
+	mc2.conn.SetReadDeadline(time.Now().Add(time.Second))
+	if _, err := mc2.conn.Read(make([]byte, 1)); err != io.EOF {
+		t.Fatalf("Expected connection to be closed, got %v", err)
+	}
+}
+
+func TestMQTTConfig(t *testing.T) {
+	conf := createConfFile(t, []byte(`
+		listen: "127.0.0.1:-1"
+		mqtt {
+			listen: "127.0.0.1:-1"
+			ack_wait: "5s"
+			max_ack_pending: 100
+		}
+	`))
+	defer os.Remove(conf)
+	opts, err := ProcessConfigFile(conf)
+	if err != nil {
+		t.Fatalf("Error processing config: %v", err)
+	}
+	mo := opts.MQTT
+	if mo.Host != "127.0.0.1" || mo.Port != -1 || mo.AckWait != 5*time.Second || mo.MaxAckPending != 100 {
+		t.Fatalf("Unexpected mqtt options: %+v", mo)
+	}
+	o := testMQTTOptions()
+	o.MQTT.AckWait = -1
+	if _, err := NewServer(o); err == nil {
+		t.Fatal("Expected error for negative ack wait")
+	}
+}
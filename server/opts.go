@@ -25,6 +25,7 @@ import (
 	"net/url"
 	"os"
 	"regexp"
+	"sort"
 	"strconv"
 	"strings"
 	"time"
@@ -94,10 +95,14 @@ type LeafNodeOpts struct {
 	Port              int               `json:"port,omitempty"`
 	Username          string            `json:"-"`
 	Password          string            `json:"-"`
+	Account           string            `json:"-"`
+	Users             []*User           `json:"-"`
+	Nkeys             []*NkeyUser       `json:"-"`
 	AuthTimeout       float64           `json:"auth_timeout,omitempty"`
 	TLSConfig         *tls.Config       `json:"-"`
 	TLSTimeout        float64           `json:"tls_timeout,omitempty"`
 	TLSMap            bool              `json:"-"`
+	Permissions       *RoutePermissions `json:"-"`
 	Remotes           []*RemoteLeafOpts `json:"remotes,omitempty"`
 	Advertise         string            `json:"-"`
 	NoAdvertise       bool              `json:"-"`
@@ -112,12 +117,51 @@ type LeafNodeOpts struct {
 // NOTE: This structure is no longer used for monitoring endpoints
 // and json tags are deprecated and may be removed in the future.
 type RemoteLeafOpts struct {
-	LocalAccount string      `json:"local_account,omitempty"`
-	URL          *url.URL    `json:"url,omitempty"`
-	Credentials  string      `json:"-"`
-	TLS          bool        `json:"-"`
-	TLSConfig    *tls.Config `json:"-"`
-	TLSTimeout   float64     `json:"tls_timeout,omitempty"`
+	LocalAccount string            `json:"local_account,omitempty"`
+	URL          *url.URL          `json:"url,omitempty"`
+	Credentials  string            `json:"-"`
+	TLS          bool              `json:"-"`
+	TLSConfig    *tls.Config       `json:"-"`
+	TLSTimeout   float64           `json:"tls_timeout,omitempty"`
+	Permissions  *RoutePermissions `json:"-"`
+	// Local accounts bound with their own credentials, each with its own
+	// connection to the remote server. Replaces LocalAccount and Credentials.
+	Accounts []*RemoteLeafAccount `json:"accounts,omitempty"`
+}
+
+// RemoteLeafAccount binds a local account to the credentials used to
+// connect it to a remote server as a leaf node.
+type RemoteLeafAccount struct {
+	LocalAccount string `json:"local_account,omitempty"`
+	Credentials  string `json:"-"`
+}
+
+// WebsocketOpts are options for accepting client connections over websocket.
+type WebsocketOpts struct {
+	Host             string        `json:"addr,omitempty"`
+	Port             int           `json:"port,omitempty"`
+	TLSConfig        *tls.Config   `json:"-"`
+	Compression      bool          `json:"compression,omitempty"`
+	SameOrigin       bool          `json:"same_origin,omitempty"`
+	AllowedOrigins   []string      `json:"allowed_origins,omitempty"`
+	HandshakeTimeout time.Duration `json:"handshake_timeout,omitempty"`
+}
+
+// MQTTOpts are options for accepting MQTT 3.1.1 client connections.
+// MQTT clients authenticate with a username and password or a token,
+// users with an nkey can not connect over MQTT.
+type MQTTOpts struct {
+	Host          string        `json:"addr,omitempty"`
+	Port          int           `json:"port,omitempty"`
+	TLSConfig     *tls.Config   `json:"-"`
+	AckWait       time.Duration `json:"ack_wait,omitempty"`
+	MaxAckPending int           `json:"max_ack_pending,omitempty"`
+}
+
+// StreamsOpts are options for the persistent streams subsystem.
+type StreamsOpts struct {
+	Enabled  bool   `json:"enabled"`
+	StoreDir string `json:"store_dir,omitempty"`
 }
 
 // Options block for nats-server.
@@ -155,6 +199,9 @@ type Options struct {
 	Cluster          ClusterOpts   `json:"cluster,omitempty"`
 	Gateway          GatewayOpts   `json:"gateway,omitempty"`
 	LeafNode         LeafNodeOpts  `json:"leaf,omitempty"`
+	Streams          StreamsOpts   `json:"streams,omitempty"`
+	Websocket        WebsocketOpts `json:"websocket,omitempty"`
+	MQTT             MQTTOpts      `json:"mqtt,omitempty"`
 	ProfPort         int           `json:"-"`
 	PidFile          string        `json:"-"`
 	PortsFileDir     string        `json:"-"`
@@ -184,6 +231,9 @@ type Options struct {
 	CustomClientAuthentication Authentication `json:"-"`
 	CustomRouterAuthentication Authentication `json:"-"`
 
+	// AuthCallout delegates the authorization of clients to a service.
+	AuthCallout *AuthCallout `json:"-"`
+
 	// CheckConfig configuration file syntax test was successful and exit.
 	CheckConfig bool `json:"-"`
 
@@ -205,6 +255,26 @@ type Options struct {
 	gatewaysSolicitDelay time.Duration
 }
 
+// AuthCallout configures an external authorization service. The credentials
+// of connecting clients are sent as requests to the service, which replies
+// with a user JWT determining their account and permissions, or an error.
+type AuthCallout struct {
+	// Issuer is the public account nkey the user JWTs must be issued by.
+	Issuer string
+	// Account is the account of the service, in which requests are sent.
+	Account string
+	// Subject of the requests, DEFAULT_AUTH_CALLOUT_SUBJECT if not set.
+	Subject string
+	// AuthUsers are the users and nkeys authorized without the callout,
+	// such as the ones of the service itself.
+	AuthUsers []string
+	// Timeout of the requests, DEFAULT_AUTH_CALLOUT_TIMEOUT if not set.
+	Timeout time.Duration
+	// CacheTTL is how long the response for given credentials is reused.
+	// Responses are not cached if not set.
+	CacheTTL time.Duration
+}
+
 type netResolver interface {
 	LookupHost(ctx context.Context, host string) ([]string, error)
 }
@@ -248,6 +318,15 @@ func (o *Options) Clone() *Options {
 			clone.Gateway.Gateways[i] = g.clone()
 		}
 	}
+	if o.Websocket.TLSConfig != nil {
+		clone.Websocket.TLSConfig = o.Websocket.TLSConfig.Clone()
+	}
+	if o.Websocket.AllowedOrigins != nil {
+		clone.Websocket.AllowedOrigins = append([]string(nil), o.Websocket.AllowedOrigins...)
+	}
+	if o.MQTT.TLSConfig != nil {
+		clone.MQTT.TLSConfig = o.MQTT.TLSConfig.Clone()
+	}
 	// FIXME(dlc) - clone leaf node stuff.
 	return clone
 }
@@ -271,11 +350,13 @@ type authorization struct {
 	user  string
 	pass  string
 	token string
+	acc   string
 	// Multiple Nkeys/Users
 	nkeys              []*NkeyUser
 	users              []*User
 	timeout            float64
 	defaultPermissions *Permissions
+	callout            *AuthCallout
 }
 
 // TLSConfigOpts holds the parsed tls config information,
@@ -450,6 +531,7 @@ func (o *Options) ProcessConfigFile(configFile string) error {
 				// NKeys may have been added from Accounts parsing, so do an append here
 				o.Nkeys = append(o.Nkeys, auth.nkeys...)
 			}
+			o.AuthCallout = auth.callout
 		case "http":
 			hp, err := parseListen(v)
 			if err != nil {
@@ -489,6 +571,21 @@ func (o *Options) ProcessConfigFile(configFile string) error {
 				errors = append(errors, err)
 				continue
 			}
+		case "websocket", "ws":
+			if err := parseWebsocket(tk, o, &errors, &warnings); err != nil {
+				errors = append(errors, err)
+				continue
+			}
+		case "mqtt":
+			if err := parseMQTT(tk, o, &errors, &warnings); err != nil {
+				errors = append(errors, err)
+				continue
+			}
+		case "streams":
+			if err := parseStreams(tk, o, &errors, &warnings); err != nil {
+				errors = append(errors, err)
+				continue
+			}
 		case "logfile", "log_file":
 			o.LogFile = v.(string)
 		case "syslog":
@@ -602,6 +699,12 @@ func (o *Options) ProcessConfigFile(configFile string) error {
 		case "resolver", "account_resolver", "accounts_resolver":
 			var memResolverRe = regexp.MustCompile(`(MEM|MEMORY|mem|memory)\s*`)
 			var resolverRe = regexp.MustCompile(`(?:URL|url){1}(?:\({1}\s*"?([^\s"]*)"?\s*\){1})?\s*`)
+			if rm, ok := v.(map[string]interface{}); ok {
+				if err := parseResolver(tk, rm, o); err != nil {
+					errors = append(errors, err)
+				}
+				continue
+			}
 			str, ok := v.(string)
 			if !ok {
 				err := &configErr{tk, fmt.Sprintf("error parsing operator resolver, wrong type %T", v)}
@@ -629,7 +732,7 @@ func (o *Options) ProcessConfigFile(configFile string) error {
 				}
 			}
 			if o.AccountResolver == nil {
-				err := &configErr{tk, fmt.Sprintf("error parsing account resolver, should be MEM or URL(\"url\")")}
+				err := &configErr{tk, fmt.Sprintf("error parsing account resolver, should be MEM, URL(\"url\") or a map")}
 				errors = append(errors, err)
 			}
 		case "resolver_preload":
@@ -980,18 +1083,16 @@ func parseLeafNodes(v interface{}, opts *Options, errors *[]error, warnings *[]e
 		case "host", "net":
 			opts.LeafNode.Host = mv.(string)
 		case "authorization":
-			auth, err := parseAuthorization(tk, opts, errors, warnings)
+			auth, err := parseLeafAuthorization(tk, errors, warnings)
 			if err != nil {
 				*errors = append(*errors, err)
 				continue
 			}
-			if auth.users != nil {
-				err := &configErr{tk, fmt.Sprintf("Leafnode authorization does not allow multiple users")}
-				*errors = append(*errors, err)
-				continue
-			}
 			opts.LeafNode.Username = auth.user
 			opts.LeafNode.Password = auth.pass
+			opts.LeafNode.Account = auth.acc
+			opts.LeafNode.Users = auth.users
+			opts.LeafNode.Nkeys = auth.nkeys
 			opts.LeafNode.AuthTimeout = auth.timeout
 
 		case "remotes":
@@ -1013,11 +1114,293 @@ func parseLeafNodes(v interface{}, opts *Options, errors *[]error, warnings *[]e
 				continue
 			}
 			opts.LeafNode.TLSTimeout = tc.Timeout
+			opts.LeafNode.TLSMap = tc.Map
 		case "leafnode_advertise", "advertise":
 			opts.LeafNode.Advertise = mv.(string)
 		case "no_advertise":
 			opts.LeafNode.NoAdvertise = mv.(bool)
 			trackExplicitVal(opts, &opts.inConfig, "LeafNode.NoAdvertise", opts.LeafNode.NoAdvertise)
+		case "permissions":
+			perms, err := parseUserPermissions(mv, errors, warnings)
+			if err != nil {
+				*errors = append(*errors, err)
+				continue
+			}
+			opts.LeafNode.Permissions = newRoutePermissions(perms)
+		default:
+			if !tk.IsUsedVariable() {
+				err := &unknownConfigFieldErr{
+					field: mk,
+					configErr: configErr{
+						token: tk,
+					},
+				}
+				*errors = append(*errors, err)
+				continue
+			}
+		}
+	}
+	return nil
+}
+
+// parseResolver parses the map form of the account resolver, which is
+// used for the directory based resolver:
+//
+// resolver: {
+//   type: full
+//   dir: "/path/to/jwts"
+// }
+func parseResolver(rtk token, rm map[string]interface{}, o *Options) error {
+	var (
+		typ, dir, url        string
+		cache                bool
+		ttl, negTTL, refresh time.Duration
+	)
+	parseDuration := func(tk token, field string, v interface{}) (time.Duration, error) {
+		str, ok := v.(string)
+		if !ok {
+			return 0, &configErr{tk, fmt.Sprintf("resolver %s should be a duration, got %T", field, v)}
+		}
+		dur, err := time.ParseDuration(str)
+		if err != nil {
+			return 0, &configErr{tk, fmt.Sprintf("error parsing resolver %s: %v", field, err)}
+		}
+		return dur, nil
+	}
+	for mk, mv := range rm {
+		tk, mv := unwrapValue(mv)
+		var err error
+		switch strings.ToLower(mk) {
+		case "type":
+			str, ok := mv.(string)
+			if !ok {
+				return &configErr{tk, fmt.Sprintf("resolver type should be a string, got %T", mv)}
+			}
+			typ = strings.ToLower(str)
+		case "dir":
+			str, ok := mv.(string)
+			if !ok {
+				return &configErr{tk, fmt.Sprintf("resolver dir should be a string, got %T", mv)}
+			}
+			dir = str
+		case "url":
+			str, ok := mv.(string)
+			if !ok {
+				return &configErr{tk, fmt.Sprintf("resolver url should be a string, got %T", mv)}
+			}
+			url = str
+		case "cache":
+			b, ok := mv.(bool)
+			if !ok {
+				return &configErr{tk, fmt.Sprintf("resolver cache should be a boolean, got %T", mv)}
+			}
+			cache = b
+		case "cache_ttl":
+			ttl, err = parseDuration(tk, mk, mv)
+			cache = true
+		case "cache_negative_ttl":
+			negTTL, err = parseDuration(tk, mk, mv)
+			cache = true
+		case "cache_refresh":
+			refresh, err = parseDuration(tk, mk, mv)
+			cache = true
+		default:
+			if !tk.IsUsedVariable() {
+				return &unknownConfigFieldErr{
+					field: mk,
+					configErr: configErr{
+						token: tk,
+					},
+				}
+			}
+		}
+		if err != nil {
+			return err
+		}
+	}
+	var ar AccountResolver
+	switch typ {
+	case "full":
+		if cache {
+			return &configErr{rtk, "resolver of type \"full\" can't be cached"}
+		}
+		dr, err := NewDirAccResolver(dir)
+		if err != nil {
+			return &configErr{rtk, err.Error()}
+		}
+		ar = dr
+	case "url":
+		if url == _EMPTY_ {
+			return &configErr{rtk, "url for account resolver is missing"}
+		}
+		if _, err := parseURL(url, "account resolver"); err != nil {
+			return &configErr{rtk, err.Error()}
+		}
+		ur, err := NewURLAccResolver(url)
+		if err != nil {
+			return &configErr{rtk, err.Error()}
+		}
+		ar = ur
+	case "mem", "memory":
+		ar = &MemAccResolver{}
+	default:
+		return &configErr{rtk, fmt.Sprintf("resolver type should be \"full\", \"url\" or \"mem\", got %q", typ)}
+	}
+	if cache {
+		ar = NewCacheAccResolver(ar, ttl, negTTL, refresh)
+	}
+	o.AccountResolver = ar
+	return nil
+}
+
+func parseWebsocket(v interface{}, o *Options, errors *[]error, warnings *[]error) error {
+	tk, v := unwrapValue(v)
+	wm, ok := v.(map[string]interface{})
+	if !ok {
+		return &configErr{tk, fmt.Sprintf("Expected websocket to be a map, got %T", v)}
+	}
+	for mk, mv := range wm {
+		// Again, unwrap token value if line check is required.
+		tk, mv = unwrapValue(mv)
+		switch strings.ToLower(mk) {
+		case "listen":
+			hp, err := parseListen(mv)
+			if err != nil {
+				err := &configErr{tk, err.Error()}
+				*errors = append(*errors, err)
+				continue
+			}
+			o.Websocket.Host = hp.host
+			o.Websocket.Port = hp.port
+		case "port":
+			o.Websocket.Port = int(mv.(int64))
+		case "host", "net":
+			o.Websocket.Host = mv.(string)
+		case "tls":
+			tc, err := parseTLS(tk)
+			if err != nil {
+				*errors = append(*errors, err)
+				continue
+			}
+			if o.Websocket.TLSConfig, err = GenTLSConfig(tc); err != nil {
+				err := &configErr{tk, err.Error()}
+				*errors = append(*errors, err)
+				continue
+			}
+		case "compression":
+			o.Websocket.Compression = mv.(bool)
+		case "same_origin":
+			o.Websocket.SameOrigin = mv.(bool)
+		case "allowed_origins", "allowed_origin", "allow_origins", "allow_origin", "origins", "origin":
+			switch mv := mv.(type) {
+			case string:
+				o.Websocket.AllowedOrigins = []string{mv}
+			case []interface{}:
+				keys := make([]string, 0, len(mv))
+				for _, val := range mv {
+					tk, val = unwrapValue(val)
+					if key, ok := val.(string); ok {
+						keys = append(keys, key)
+					} else {
+						err := &configErr{tk, fmt.Sprintf("error parsing allowed origins: unsupported type in array %T", val)}
+						*errors = append(*errors, err)
+						continue
+					}
+				}
+				o.Websocket.AllowedOrigins = keys
+			default:
+				err := &configErr{tk, fmt.Sprintf("error parsing allowed origins: unsupported type %T", mv)}
+				*errors = append(*errors, err)
+			}
+		case "handshake_timeout":
+			ht := time.Duration(0)
+			switch mv := mv.(type) {
+			case int64:
+				ht = time.Duration(mv) * time.Second
+			case string:
+				var err error
+				ht, err = time.ParseDuration(mv)
+				if err != nil {
+					err := &configErr{tk, err.Error()}
+					*errors = append(*errors, err)
+					continue
+				}
+			default:
+				err := &configErr{tk, fmt.Sprintf("error parsing handshake timeout: unsupported type %T", mv)}
+				*errors = append(*errors, err)
+				continue
+			}
+			o.Websocket.HandshakeTimeout = ht
+		default:
+			if !tk.IsUsedVariable() {
+				err := &unknownConfigFieldErr{
+					field: mk,
+					configErr: configErr{
+						token: tk,
+					},
+				}
+				*errors = append(*errors, err)
+				continue
+			}
+		}
+	}
+	return nil
+}
+
+// parseMQTT will parse the mqtt block.
+func parseMQTT(v interface{}, o *Options, errors *[]error, warnings *[]error) error {
+	tk, v := unwrapValue(v)
+	mm, ok := v.(map[string]interface{})
+	if !ok {
+		return &configErr{tk, fmt.Sprintf("Expected mqtt to be a map, got %T", v)}
+	}
+	for mk, mv := range mm {
+		// Again, unwrap token value if line check is required.
+		tk, mv = unwrapValue(mv)
+		switch strings.ToLower(mk) {
+		case "listen":
+			hp, err := parseListen(mv)
+			if err != nil {
+				err := &configErr{tk, err.Error()}
+				*errors = append(*errors, err)
+				continue
+			}
+			o.MQTT.Host = hp.host
+			o.MQTT.Port = hp.port
+		case "port":
+			o.MQTT.Port = int(mv.(int64))
+		case "host", "net":
+			o.MQTT.Host = mv.(string)
+		case "tls":
+			tc, err := parseTLS(tk)
+			if err != nil {
+				*errors = append(*errors, err)
+				continue
+			}
+			if o.MQTT.TLSConfig, err = GenTLSConfig(tc); err != nil {
+				err := &configErr{tk, err.Error()}
+				*errors = append(*errors, err)
+				continue
+			}
+		case "ack_wait", "ackwait":
+			switch mv := mv.(type) {
+			case int64:
+				o.MQTT.AckWait = time.Duration(mv) * time.Second
+			case string:
+				dur, err := time.ParseDuration(mv)
+				if err != nil {
+					err := &configErr{tk, err.Error()}
+					*errors = append(*errors, err)
+					continue
+				}
+				o.MQTT.AckWait = dur
+			default:
+				err := &configErr{tk, fmt.Sprintf("error parsing ack wait: unsupported type %T", mv)}
+				*errors = append(*errors, err)
+				continue
+			}
+		case "max_ack_pending", "max_inflight":
+			o.MQTT.MaxAckPending = int(mv.(int64))
 		default:
 			if !tk.IsUsedVariable() {
 				err := &unknownConfigFieldErr{
@@ -1034,6 +1417,42 @@ func parseLeafNodes(v interface{}, opts *Options, errors *[]error, warnings *[]e
 	return nil
 }
 
+// parseStreams will parse the streams block. The presence of the block
+// enables streams unless explicitly disabled. A boolean is also accepted.
+func parseStreams(v interface{}, opts *Options, errors *[]error, warnings *[]error) error {
+	tk, v := unwrapValue(v)
+	switch vv := v.(type) {
+	case bool:
+		opts.Streams.Enabled = vv
+		return nil
+	case map[string]interface{}:
+		opts.Streams.Enabled = true
+		for mk, mv := range vv {
+			tk, mv = unwrapValue(mv)
+			switch strings.ToLower(mk) {
+			case "enabled":
+				opts.Streams.Enabled = mv.(bool)
+			case "store_dir", "store", "dir":
+				opts.Streams.StoreDir = mv.(string)
+			default:
+				if !tk.IsUsedVariable() {
+					err := &unknownConfigFieldErr{
+						field: mk,
+						configErr: configErr{
+							token: tk,
+						},
+					}
+					*errors = append(*errors, err)
+					continue
+				}
+			}
+		}
+		return nil
+	default:
+		return &configErr{tk, fmt.Sprintf("Expected map or boolean to define streams, got %T", v)}
+	}
+}
+
 func parseRemoteLeafNodes(v interface{}, errors *[]error, warnings *[]error) ([]*RemoteLeafOpts, error) {
 	tk, v := unwrapValue(v)
 	ra, ok := v.([]interface{})
@@ -1064,6 +1483,13 @@ func parseRemoteLeafNodes(v interface{}, errors *[]error, warnings *[]error) ([]
 				remote.LocalAccount = v.(string)
 			case "creds", "credentials":
 				remote.Credentials = v.(string)
+			case "accounts":
+				accs, err := parseRemoteLeafAccounts(tk, errors)
+				if err != nil {
+					*errors = append(*errors, err)
+					continue
+				}
+				remote.Accounts = accs
 			case "tls":
 				tc, err := parseTLS(tk)
 				if err != nil {
@@ -1079,6 +1505,13 @@ func parseRemoteLeafNodes(v interface{}, errors *[]error, warnings *[]error) ([]
 				// a connection (therefore behaves as a client).
 				remote.TLSConfig.RootCAs = remote.TLSConfig.ClientCAs
 				remote.TLSTimeout = tc.Timeout
+			case "permissions":
+				perms, err := parseUserPermissions(tk, errors, warnings)
+				if err != nil {
+					*errors = append(*errors, err)
+					continue
+				}
+				remote.Permissions = newRoutePermissions(perms)
 			default:
 				if !tk.IsUsedVariable() {
 					err := &unknownConfigFieldErr{
@@ -1092,42 +1525,98 @@ func parseRemoteLeafNodes(v interface{}, errors *[]error, warnings *[]error) ([]
 				}
 			}
 		}
+		if len(remote.Accounts) > 0 && (remote.LocalAccount != "" || remote.Credentials != "") {
+			*errors = append(*errors, &configErr{tk, "Remote leafnode can not have both a local account and a list of accounts"})
+			continue
+		}
 		remotes = append(remotes, remote)
 	}
 	return remotes, nil
 }
 
-// Parse TLS and returns a TLSConfig and TLSTimeout.
-// Used by cluster and gateway parsing.
-func getTLSConfig(tk token) (*tls.Config, *TLSConfigOpts, error) {
-	tc, err := parseTLS(tk)
-	if err != nil {
-		return nil, nil, err
-	}
-	config, err := GenTLSConfig(tc)
-	if err != nil {
-		err := &configErr{tk, err.Error()}
-		return nil, nil, err
-	}
-	// For clusters/gateways, we will force strict verification. We also act
-	// as both client and server, so will mirror the rootCA to the
-	// clientCA pool.
-	config.ClientAuth = tls.RequireAndVerifyClientCert
-	config.RootCAs = config.ClientCAs
-	return config, tc, nil
-}
-
-func parseGateways(v interface{}, errors *[]error, warnings *[]error) ([]*RemoteGatewayOpts, error) {
+// parseRemoteLeafAccounts parses the local accounts bound by a remote
+// leafnode, along with their credentials.
+func parseRemoteLeafAccounts(v interface{}, errors *[]error) ([]*RemoteLeafAccount, error) {
 	tk, v := unwrapValue(v)
-	// Make sure we have an array
-	ga, ok := v.([]interface{})
+	aa, ok := v.([]interface{})
 	if !ok {
-		return nil, &configErr{tk, fmt.Sprintf("Expected gateways field to be an array, got %T", v)}
+		return nil, &configErr{tk, fmt.Sprintf("Expected accounts field to be an array, got %T", v)}
 	}
-	gateways := []*RemoteGatewayOpts{}
-	for _, g := range ga {
-		tk, g = unwrapValue(g)
-		// Check its a map/struct
+	accs := make([]*RemoteLeafAccount, 0, len(aa))
+	seen := make(map[string]struct{}, len(aa))
+	for _, a := range aa {
+		tk, a = unwrapValue(a)
+		am, ok := a.(map[string]interface{})
+		if !ok {
+			*errors = append(*errors, &configErr{tk, fmt.Sprintf("Expected remote leafnode account to be a map/struct, got %v", a)})
+			continue
+		}
+		ra := &RemoteLeafAccount{}
+		for k, v := range am {
+			tk, v := unwrapValue(v)
+			switch strings.ToLower(k) {
+			case "account", "local":
+				ra.LocalAccount = v.(string)
+			case "creds", "credentials":
+				ra.Credentials = v.(string)
+			default:
+				if !tk.IsUsedVariable() {
+					err := &unknownConfigFieldErr{
+						field: k,
+						configErr: configErr{
+							token: tk,
+						},
+					}
+					*errors = append(*errors, err)
+					continue
+				}
+			}
+		}
+		if ra.LocalAccount == "" {
+			*errors = append(*errors, &configErr{tk, "Remote leafnode account requires a local account"})
+			continue
+		}
+		if _, dup := seen[ra.LocalAccount]; dup {
+			*errors = append(*errors, &configErr{tk, fmt.Sprintf("Duplicate remote leafnode account %q", ra.LocalAccount)})
+			continue
+		}
+		seen[ra.LocalAccount] = struct{}{}
+		accs = append(accs, ra)
+	}
+	return accs, nil
+}
+
+// Parse TLS and returns a TLSConfig and TLSTimeout.
+// Used by cluster and gateway parsing.
+func getTLSConfig(tk token) (*tls.Config, *TLSConfigOpts, error) {
+	tc, err := parseTLS(tk)
+	if err != nil {
+		return nil, nil, err
+	}
+	config, err := GenTLSConfig(tc)
+	if err != nil {
+		err := &configErr{tk, err.Error()}
+		return nil, nil, err
+	}
+	// For clusters/gateways, we will force strict verification. We also act
+	// as both client and server, so will mirror the rootCA to the
+	// clientCA pool.
+	config.ClientAuth = tls.RequireAndVerifyClientCert
+	config.RootCAs = config.ClientCAs
+	return config, tc, nil
+}
+
+func parseGateways(v interface{}, errors *[]error, warnings *[]error) ([]*RemoteGatewayOpts, error) {
+	tk, v := unwrapValue(v)
+	// Make sure we have an array
+	ga, ok := v.([]interface{})
+	if !ok {
+		return nil, &configErr{tk, fmt.Sprintf("Expected gateways field to be an array, got %T", v)}
+	}
+	gateways := []*RemoteGatewayOpts{}
+	for _, g := range ga {
+		tk, g = unwrapValue(g)
+		// Check its a map/struct
 		gm, ok := g.(map[string]interface{})
 		if !ok {
 			*errors = append(*errors, &configErr{tk, fmt.Sprintf("Expected gateway entry to be a map/struct, got %v", g)})
@@ -1182,12 +1671,17 @@ func parseGateways(v interface{}, errors *[]error, warnings *[]error) ([]*Remote
 // Sets cluster's permissions based on given pub/sub permissions,
 // doing the appropriate translation.
 func setClusterPermissions(opts *ClusterOpts, perms *Permissions) {
+	opts.Permissions = newRoutePermissions(perms)
+}
+
+// Returns the import/export permissions for the parsed pub/sub permissions.
+func newRoutePermissions(perms *Permissions) *RoutePermissions {
 	// Import is whether or not we will send a SUB for interest to the other side.
 	// Export is whether or not we will accept a SUB from the remote for a given subject.
 	// Both only effect interest registration.
 	// The parsing sets Import into Publish and Export into Subscribe, convert
 	// accordingly.
-	opts.Permissions = &RoutePermissions{
+	return &RoutePermissions{
 		Import: perms.Publish,
 		Export: perms.Subscribe,
 	}
@@ -1332,6 +1826,42 @@ func parseAccounts(v interface{}, opts *Options, errors *[]error, warnings *[]er
 						u.Account = acc
 					}
 					opts.Nkeys = append(opts.Nkeys, nkeys...)
+				case "mappings", "maps":
+					if err := parseAccountMappings(tk, acc, errors, warnings); err != nil {
+						*errors = append(*errors, err)
+						continue
+					}
+				case "queue_groups", "queues":
+					if err := parseQueueStrategies(tk, acc, errors, warnings); err != nil {
+						*errors = append(*errors, err)
+						continue
+					}
+				case "dedupe":
+					if err := parseAccountDedupe(tk, acc, errors, warnings); err != nil {
+						*errors = append(*errors, err)
+						continue
+					}
+				case "rate_limits", "rate_limit":
+					limits, err := parseRateLimits(tk, errors, warnings)
+					if err != nil {
+						*errors = append(*errors, err)
+						continue
+					}
+					acc.SetRateLimits(limits)
+				case "max_scheduled_msgs", "max_scheduled":
+					n, ok := mv.(int64)
+					if !ok {
+						*errors = append(*errors, &configErr{tk, fmt.Sprintf("Expected max_scheduled_msgs to be a number, got %T", mv)})
+						continue
+					}
+					acc.SetMaxScheduledMsgs(int(n))
+				case "queue_locality":
+					l, err := parseQueueLocality(tk, mv)
+					if err != nil {
+						*errors = append(*errors, err)
+						continue
+					}
+					acc.SetQueueLocality(l)
 				default:
 					if !tk.IsUsedVariable() {
 						err := &unknownConfigFieldErr{
@@ -1517,6 +2047,336 @@ func parseAccount(v map[string]interface{}, errors, warnings *[]error) (string,
 	return accountName, subject, nil
 }
 
+// Parse the subject mappings of an account.
+// e.g.
+//   mappings = {
+//     "old.orders.*": "orders.v2.$1"
+//     "foo": [{destination: "foo.v1", weight: 90%}, {destination: "foo.v2", weight: 10%}]
+//   }
+func parseAccountMappings(v interface{}, acc *Account, errors, warnings *[]error) error {
+	tk, v := unwrapValue(v)
+	mm, ok := v.(map[string]interface{})
+	if !ok {
+		return &configErr{tk, fmt.Sprintf("Expected mappings to be a map, got %T", v)}
+	}
+	// Sort for a deterministic order of overlapping mappings.
+	srcs := make([]string, 0, len(mm))
+	for src := range mm {
+		srcs = append(srcs, src)
+	}
+	sort.Strings(srcs)
+	for _, src := range srcs {
+		tk, mv := unwrapValue(mm[src])
+		var dests []*MapDest
+		switch mv := mv.(type) {
+		case string:
+			dests = append(dests, NewMapDest(mv, 100))
+		case map[string]interface{}:
+			d, err := parseMapDest(tk, mv)
+			if err != nil {
+				*errors = append(*errors, err)
+				continue
+			}
+			dests = append(dests, d)
+		case []interface{}:
+			for _, e := range mv {
+				tk, e := unwrapValue(e)
+				dm, ok := e.(map[string]interface{})
+				if !ok {
+					*errors = append(*errors, &configErr{tk, fmt.Sprintf("Expected mapping destination to be a map, got %T", e)})
+					continue
+				}
+				d, err := parseMapDest(tk, dm)
+				if err != nil {
+					*errors = append(*errors, err)
+					continue
+				}
+				dests = append(dests, d)
+			}
+		default:
+			*errors = append(*errors, &configErr{tk, fmt.Sprintf("Unknown type %T for mapping of %q", mv, src)})
+			continue
+		}
+		// A single destination without weight gets all messages.
+		if len(dests) == 1 && dests[0].Weight == 0 {
+			dests[0].Weight = 100
+		}
+		if err := acc.AddWeightedMappings(src, dests...); err != nil {
+			*errors = append(*errors, &configErr{tk, err.Error()})
+		}
+	}
+	return nil
+}
+
+// parseQueueStrategies parses the delivery strategies of the queue groups
+// of an account, given either as a policy name or as a map with the
+// policy and, for sticky delivery, the subject token to hash.
+func parseQueueStrategies(v interface{}, acc *Account, errors, warnings *[]error) error {
+	tk, v := unwrapValue(v)
+	qm, ok := v.(map[string]interface{})
+	if !ok {
+		return &configErr{tk, fmt.Sprintf("Expected queue groups to be a map, got %T", v)}
+	}
+	for queue, qv := range qm {
+		tk, qv := unwrapValue(qv)
+		qs := &QueueStrategy{}
+		switch qv := qv.(type) {
+		case string:
+			p, err := queuePolicyFromString(qv)
+			if err != nil {
+				*errors = append(*errors, &configErr{tk, err.Error()})
+				continue
+			}
+			qs.Policy = p
+		case map[string]interface{}:
+			for k, v := range qv {
+				tk, v := unwrapValue(v)
+				switch strings.ToLower(k) {
+				case "policy", "strategy":
+					name, ok := v.(string)
+					if !ok {
+						*errors = append(*errors, &configErr{tk, fmt.Sprintf("Expected queue policy to be a string, got %T", v)})
+						continue
+					}
+					p, err := queuePolicyFromString(name)
+					if err != nil {
+						*errors = append(*errors, &configErr{tk, err.Error()})
+						continue
+					}
+					qs.Policy = p
+				case "token":
+					n, ok := v.(int64)
+					if !ok || n < 0 {
+						*errors = append(*errors, &configErr{tk, fmt.Sprintf("Expected queue token to be a positive number, got %v", v)})
+						continue
+					}
+					qs.Token = int(n)
+				case "locality":
+					l, err := parseQueueLocality(tk, v)
+					if err != nil {
+						*errors = append(*errors, err)
+						continue
+					}
+					qs.Locality = l
+				default:
+					if !tk.IsUsedVariable() {
+						err := &unknownConfigFieldErr{
+							field: k,
+							configErr: configErr{
+								token: tk,
+							},
+						}
+						*errors = append(*errors, err)
+					}
+				}
+			}
+		default:
+			*errors = append(*errors, &configErr{tk, fmt.Sprintf("Unknown type %T for queue group %q", qv, queue)})
+			continue
+		}
+		if err := acc.SetQueueStrategy(queue, qs); err != nil {
+			*errors = append(*errors, &configErr{tk, err.Error()})
+		}
+	}
+	return nil
+}
+
+// parseAccountDedupe parses the dedupe window of an account, given either
+// as a duration or as a map with the window and the maximum number of
+// message IDs tracked.
+func parseAccountDedupe(v interface{}, acc *Account, errors, warnings *[]error) error {
+	tk, v := unwrapValue(v)
+	parseDuration := func(tk token, v interface{}) (time.Duration, error) {
+		str, ok := v.(string)
+		if !ok {
+			return 0, &configErr{tk, fmt.Sprintf("dedupe window should be a duration, got %T", v)}
+		}
+		dur, err := time.ParseDuration(str)
+		if err != nil {
+			return 0, &configErr{tk, fmt.Sprintf("error parsing dedupe window: %v", err)}
+		}
+		return dur, nil
+	}
+	var (
+		window time.Duration
+		maxIDs int64
+		err    error
+	)
+	switch v := v.(type) {
+	case string:
+		if window, err = parseDuration(tk, v); err != nil {
+			return err
+		}
+	case map[string]interface{}:
+		for mk, mv := range v {
+			tk, mv := unwrapValue(mv)
+			switch strings.ToLower(mk) {
+			case "window", "duplicate_window":
+				if window, err = parseDuration(tk, mv); err != nil {
+					*errors = append(*errors, err)
+				}
+			case "max_ids", "max":
+				n, ok := mv.(int64)
+				if !ok || n < 0 {
+					*errors = append(*errors, &configErr{tk, fmt.Sprintf("dedupe max_ids should be a positive number, got %v", mv)})
+					continue
+				}
+				maxIDs = n
+			default:
+				if !tk.IsUsedVariable() {
+					err := &unknownConfigFieldErr{
+						field: mk,
+						configErr: configErr{
+							token: tk,
+						},
+					}
+					*errors = append(*errors, err)
+				}
+			}
+		}
+	default:
+		return &configErr{tk, fmt.Sprintf("Expected dedupe to be a duration or a map, got %T", v)}
+	}
+	if err := acc.SetDedupe(window, int(maxIDs)); err != nil {
+		return &configErr{tk, err.Error()}
+	}
+	return nil
+}
+
+// parseRateLimits parses rate limits, given either as a single map or as
+// an array of maps with the subject, the rates and the action.
+func parseRateLimits(v interface{}, errors, warnings *[]error) ([]*RateLimit, error) {
+	tk, v := unwrapValue(v)
+	var entries []interface{}
+	switch v := v.(type) {
+	case map[string]interface{}:
+		entries = append(entries, tk)
+	case []interface{}:
+		entries = v
+	default:
+		return nil, &configErr{tk, fmt.Sprintf("Expected rate limits to be a map or an array, got %T", v)}
+	}
+	var limits []*RateLimit
+	for _, e := range entries {
+		tk, e := unwrapValue(e)
+		em, ok := e.(map[string]interface{})
+		if !ok {
+			*errors = append(*errors, &configErr{tk, fmt.Sprintf("Expected rate limit entry to be a map, got %T", e)})
+			continue
+		}
+		rl := &RateLimit{}
+		for mk, mv := range em {
+			tk, mv := unwrapValue(mv)
+			switch strings.ToLower(mk) {
+			case "subject":
+				subject, ok := mv.(string)
+				if !ok {
+					*errors = append(*errors, &configErr{tk, fmt.Sprintf("Expected rate limit subject to be a string, got %T", mv)})
+					continue
+				}
+				rl.Subject = subject
+			case "msgs_per_sec", "msgs":
+				n, ok := mv.(int64)
+				if !ok || n < 0 {
+					*errors = append(*errors, &configErr{tk, fmt.Sprintf("rate limit msgs_per_sec should be a positive number, got %v", mv)})
+					continue
+				}
+				rl.MsgsPerSec = n
+			case "bytes_per_sec", "bytes":
+				n, ok := mv.(int64)
+				if !ok || n < 0 {
+					*errors = append(*errors, &configErr{tk, fmt.Sprintf("rate limit bytes_per_sec should be a positive number, got %v", mv)})
+					continue
+				}
+				rl.BytesPerSec = n
+			case "action":
+				name, ok := mv.(string)
+				if !ok {
+					*errors = append(*errors, &configErr{tk, fmt.Sprintf("Expected rate limit action to be a string, got %T", mv)})
+					continue
+				}
+				action, err := rateLimitActionFromString(name)
+				if err != nil {
+					*errors = append(*errors, &configErr{tk, err.Error()})
+					continue
+				}
+				rl.Action = action
+			default:
+				if !tk.IsUsedVariable() {
+					err := &unknownConfigFieldErr{
+						field: mk,
+						configErr: configErr{
+							token: tk,
+						},
+					}
+					*errors = append(*errors, err)
+				}
+			}
+		}
+		if err := rl.validate(); err != nil {
+			*errors = append(*errors, &configErr{tk, err.Error()})
+			continue
+		}
+		limits = append(limits, rl)
+	}
+	return limits, nil
+}
+
+// Parse the locality of queue groups across gateways.
+func parseQueueLocality(tk token, v interface{}) (QueueLocality, error) {
+	name, ok := v.(string)
+	if !ok {
+		return QueueLocalityDefault, &configErr{tk, fmt.Sprintf("Expected queue locality to be a string, got %T", v)}
+	}
+	l, err := queueLocalityFromString(name)
+	if err != nil {
+		return QueueLocalityDefault, &configErr{tk, err.Error()}
+	}
+	return l, nil
+}
+
+// Parse a destination of a subject mapping, with a weight either as a
+// number or a percentage.
+func parseMapDest(tk token, dm map[string]interface{}) (*MapDest, error) {
+	d := &MapDest{}
+	for k, v := range dm {
+		tk, v := unwrapValue(v)
+		switch strings.ToLower(k) {
+		case "destination", "dest", "subject":
+			s, ok := v.(string)
+			if !ok {
+				return nil, &configErr{tk, fmt.Sprintf("Expected mapping destination to be a string, got %T", v)}
+			}
+			d.Subject = s
+		case "weight":
+			var w int64
+			switch v := v.(type) {
+			case int64:
+				w = v
+			case string:
+				var err error
+				if w, err = strconv.ParseInt(strings.TrimSuffix(v, "%"), 10, 64); err != nil {
+					return nil, &configErr{tk, fmt.Sprintf("Invalid mapping weight %q", v)}
+				}
+			default:
+				return nil, &configErr{tk, fmt.Sprintf("Unknown type %T for mapping weight", v)}
+			}
+			if w < 1 || w > 100 {
+				return nil, &configErr{tk, fmt.Sprintf("Invalid mapping weight %d, must be between 1 and 100", w)}
+			}
+			d.Weight = uint8(w)
+		default:
+			if !tk.IsUsedVariable() {
+				return nil, &configErr{tk, fmt.Sprintf("Unknown field %q parsing mapping destination", k)}
+			}
+		}
+	}
+	if d.Subject == _EMPTY_ {
+		return nil, &configErr{tk, "Mapping destination is missing"}
+	}
+	return d, nil
+}
+
 // Parse an import stream or service.
 // e.g.
 //   {stream: "public.>"} # No accounts means public.
@@ -1738,6 +2598,13 @@ func parseAuthorization(v interface{}, opts *Options, errors *[]error, warnings
 				continue
 			}
 			auth.defaultPermissions = permissions
+		case "auth_callout", "callout":
+			callout, err := parseAuthCallout(tk, errors)
+			if err != nil {
+				*errors = append(*errors, err)
+				continue
+			}
+			auth.callout = callout
 		default:
 			if !tk.IsUsedVariable() {
 				err := &unknownConfigFieldErr{
@@ -1764,6 +2631,75 @@ func parseAuthorization(v interface{}, opts *Options, errors *[]error, warnings
 	return auth, nil
 }
 
+// Helper function to parse the authorization callout.
+func parseAuthCallout(v interface{}, errors *[]error) (*AuthCallout, error) {
+	tk, v := unwrapValue(v)
+	cm, ok := v.(map[string]interface{})
+	if !ok {
+		return nil, &configErr{tk, fmt.Sprintf("Expected auth_callout to be a map, got %T", v)}
+	}
+	parseDuration := func(tk token, field string, v interface{}) (time.Duration, error) {
+		switch v := v.(type) {
+		case int64:
+			return time.Duration(v) * time.Second, nil
+		case string:
+			dur, err := time.ParseDuration(v)
+			if err != nil {
+				return 0, &configErr{tk, fmt.Sprintf("error parsing %s: %v", field, err)}
+			}
+			return dur, nil
+		}
+		return 0, &configErr{tk, fmt.Sprintf("error parsing %s: unsupported type %T", field, v)}
+	}
+	ac := &AuthCallout{}
+	for mk, mv := range cm {
+		tk, mv := unwrapValue(mv)
+		var err error
+		switch strings.ToLower(mk) {
+		case "issuer":
+			ac.Issuer, ok = mv.(string)
+		case "account":
+			ac.Account, ok = mv.(string)
+		case "subject":
+			ac.Subject, ok = mv.(string)
+		case "auth_users":
+			var users []interface{}
+			if users, ok = mv.([]interface{}); ok {
+				for _, u := range users {
+					_, u = unwrapValue(u)
+					str, isStr := u.(string)
+					if !isStr {
+						ok = false
+						break
+					}
+					ac.AuthUsers = append(ac.AuthUsers, str)
+				}
+			}
+		case "timeout":
+			ac.Timeout, err = parseDuration(tk, mk, mv)
+		case "cache_ttl":
+			ac.CacheTTL, err = parseDuration(tk, mk, mv)
+		default:
+			if !tk.IsUsedVariable() {
+				err = &unknownConfigFieldErr{
+					field: mk,
+					configErr: configErr{
+						token: tk,
+					},
+				}
+			}
+		}
+		if err == nil && !ok {
+			err = &configErr{tk, fmt.Sprintf("error parsing auth_callout %s: unsupported type %T", mk, mv)}
+		}
+		if err != nil {
+			*errors = append(*errors, err)
+			ok = true
+		}
+	}
+	return ac, nil
+}
+
 // Helper function to parse multiple users array with optional permissions.
 func parseUsers(mv interface{}, opts *Options, errors *[]error, warnings *[]error) ([]*NkeyUser, []*User, error) {
 	var (
@@ -1790,10 +2726,11 @@ func parseUsers(mv interface{}, opts *Options, errors *[]error, warnings *[]erro
 		}
 
 		var (
-			user  = &User{}
-			nkey  = &NkeyUser{}
-			perms *Permissions
-			err   error
+			user   = &User{}
+			nkey   = &NkeyUser{}
+			perms  *Permissions
+			limits []*RateLimit
+			err    error
 		)
 		for k, v := range um {
 			// Also needs to unwrap first
@@ -1812,6 +2749,12 @@ func parseUsers(mv interface{}, opts *Options, errors *[]error, warnings *[]erro
 					*errors = append(*errors, err)
 					continue
 				}
+			case "rate_limits", "rate_limit":
+				limits, err = parseRateLimits(tk, errors, warnings)
+				if err != nil {
+					*errors = append(*errors, err)
+					continue
+				}
 			default:
 				if !tk.IsUsedVariable() {
 					err := &unknownConfigFieldErr{
@@ -1834,6 +2777,150 @@ func parseUsers(mv interface{}, opts *Options, errors *[]error, warnings *[]erro
 				user.Permissions = perms
 			}
 		}
+		nkey.RateLimits, user.RateLimits = limits, limits
+
+		// Check to make sure we have at least an nkey or username <password> defined.
+		if nkey.Nkey == "" && user.Username == "" {
+			return nil, nil, &configErr{tk, fmt.Sprintf("User entry requires a user")}
+		} else if nkey.Nkey != "" {
+			// Make sure the nkey a proper public nkey for a user..
+			if !nkeys.IsValidPublicUserKey(nkey.Nkey) {
+				return nil, nil, &configErr{tk, fmt.Sprintf("Not a valid public nkey for a user")}
+			}
+			// If we have user or password defined here that is an error.
+			if user.Username != "" || user.Password != "" {
+				return nil, nil, &configErr{tk, fmt.Sprintf("Nkey users do not take usernames or passwords")}
+			}
+			keys = append(keys, nkey)
+		} else {
+			users = append(users, user)
+		}
+	}
+	return keys, users, nil
+}
+
+// parseLeafAuthorization parses the authorization block of leafnodes, where
+// users can be bound to an account.
+func parseLeafAuthorization(v interface{}, errors *[]error, warnings *[]error) (*authorization, error) {
+	var (
+		tk   token
+		auth = &authorization{}
+	)
+	rtk, v := unwrapValue(v)
+	am, ok := v.(map[string]interface{})
+	if !ok {
+		return nil, &configErr{rtk, fmt.Sprintf("Expected leafnode authorization to be a map/struct, got %+v", v)}
+	}
+	for mk, mv := range am {
+		tk, mv = unwrapValue(mv)
+		switch strings.ToLower(mk) {
+		case "user", "username":
+			auth.user = mv.(string)
+		case "pass", "password":
+			auth.pass = mv.(string)
+		case "account":
+			auth.acc = mv.(string)
+		case "timeout":
+			at := float64(1)
+			switch mv := mv.(type) {
+			case int64:
+				at = float64(mv)
+			case float64:
+				at = mv
+			}
+			auth.timeout = at
+		case "users":
+			nkeys, users, err := parseLeafUsers(tk, errors, warnings)
+			if err != nil {
+				*errors = append(*errors, err)
+				continue
+			}
+			auth.users = users
+			auth.nkeys = nkeys
+		default:
+			if !tk.IsUsedVariable() {
+				err := &unknownConfigFieldErr{
+					field: mk,
+					configErr: configErr{
+						token: tk,
+					},
+				}
+				*errors = append(*errors, err)
+			}
+		}
+	}
+	if auth.user != _EMPTY_ && (auth.users != nil || auth.nkeys != nil) {
+		return nil, &configErr{rtk, fmt.Sprintf("Can not have a single user/pass and a users array")}
+	}
+	return auth, nil
+}
+
+// parseLeafUsers parses the users of the leafnode authorization. Each user
+// may be bound to an account, which is only referenced by name here.
+func parseLeafUsers(mv interface{}, errors *[]error, warnings *[]error) ([]*NkeyUser, []*User, error) {
+	var (
+		tk    token
+		keys  []*NkeyUser
+		users []*User
+	)
+	tk, mv = unwrapValue(mv)
+
+	// Make sure we have an array
+	uv, ok := mv.([]interface{})
+	if !ok {
+		return nil, nil, &configErr{tk, fmt.Sprintf("Expected users field to be an array, got %v", mv)}
+	}
+	for _, u := range uv {
+		tk, u = unwrapValue(u)
+
+		// Check its a map/struct
+		um, ok := u.(map[string]interface{})
+		if !ok {
+			err := &configErr{tk, fmt.Sprintf("Expected user entry to be a map/struct, got %v", u)}
+			*errors = append(*errors, err)
+			continue
+		}
+
+		var (
+			user  = &User{}
+			nkey  = &NkeyUser{}
+			acc   *Account
+			perms *Permissions
+			err   error
+		)
+		for k, v := range um {
+			// Also needs to unwrap first
+			tk, v = unwrapValue(v)
+
+			switch strings.ToLower(k) {
+			case "nkey":
+				nkey.Nkey = v.(string)
+			case "user", "username":
+				user.Username = v.(string)
+			case "pass", "password":
+				user.Password = v.(string)
+			case "account":
+				// The account is resolved by name when the leafnode connects.
+				acc = NewAccount(v.(string))
+			case "permission", "permissions", "authorization":
+				perms, err = parseUserPermissions(tk, errors, warnings)
+				if err != nil {
+					*errors = append(*errors, err)
+					continue
+				}
+			default:
+				if !tk.IsUsedVariable() {
+					err := &unknownConfigFieldErr{
+						field: k,
+						configErr: configErr{
+							token: tk,
+						},
+					}
+					*errors = append(*errors, err)
+					continue
+				}
+			}
+		}
 
 		// Check to make sure we have at least an nkey or username <password> defined.
 		if nkey.Nkey == "" && user.Username == "" {
@@ -1847,8 +2934,12 @@ func parseUsers(mv interface{}, opts *Options, errors *[]error, warnings *[]erro
 			if user.Username != "" || user.Password != "" {
 				return nil, nil, &configErr{tk, fmt.Sprintf("Nkey users do not take usernames or passwords")}
 			}
+			nkey.Account = acc
+			nkey.Permissions = perms
 			keys = append(keys, nkey)
 		} else {
+			user.Account = acc
+			user.Permissions = perms
 			users = append(users, user)
 		}
 	}
@@ -1879,6 +2970,10 @@ func parseUserPermissions(mv interface{}, errors, warnings *[]error) (*Permissio
 				*errors = append(*errors, err)
 				continue
 			}
+			if err := checkNoQueuePermissions(perms); err != nil {
+				*errors = append(*errors, &configErr{tk, err.Error()})
+				continue
+			}
 			p.Publish = perms
 		case "sub", "subscribe", "export":
 			perms, err := parseVariablePermissions(v, errors, warnings)
@@ -1886,7 +2981,21 @@ func parseUserPermissions(mv interface{}, errors, warnings *[]error) (*Permissio
 				*errors = append(*errors, err)
 				continue
 			}
+			// Queue groups are only meaningful for client subscriptions.
+			if strings.ToLower(k) == "export" {
+				if err := checkNoQueuePermissions(perms); err != nil {
+					*errors = append(*errors, &configErr{tk, err.Error()})
+					continue
+				}
+			}
 			p.Subscribe = perms
+		case "allow_responses", "allow_response":
+			rp, err := parseAllowResponses(v, errors, warnings)
+			if err != nil {
+				*errors = append(*errors, err)
+				continue
+			}
+			p.Response = rp
 		default:
 			if !tk.IsUsedVariable() {
 				err := &configErr{tk, fmt.Sprintf("Unknown field %q parsing permissions", k)}
@@ -1897,6 +3006,60 @@ func parseUserPermissions(mv interface{}, errors, warnings *[]error) (*Permissio
 	return p, nil
 }
 
+// Helper function to parse the response permissions, either a boolean
+// to use the defaults, or a map with the max responses and expiration.
+func parseAllowResponses(v interface{}, errors, warnings *[]error) (*ResponsePermission, error) {
+	tk, v := unwrapValue(v)
+	rp := &ResponsePermission{
+		MaxMsgs: DEFAULT_ALLOW_RESPONSE_MAX_MSGS,
+		Expires: DEFAULT_ALLOW_RESPONSE_EXPIRATION,
+	}
+	switch vv := v.(type) {
+	case bool:
+		if !vv {
+			return nil, nil
+		}
+		return rp, nil
+	case map[string]interface{}:
+		for k, v := range vv {
+			tk, v := unwrapValue(v)
+			switch strings.ToLower(k) {
+			case "max", "max_msgs", "max_messages", "max_responses":
+				max, ok := v.(int64)
+				if !ok || max < 0 {
+					*errors = append(*errors, &configErr{tk, fmt.Sprintf("error parsing allow_responses %s: expected a positive integer, got %v", k, v)})
+					continue
+				}
+				rp.MaxMsgs = int(max)
+			case "expires", "expiration", "ttl":
+				var err error
+				switch vv := v.(type) {
+				case int64:
+					rp.Expires = time.Duration(vv) * time.Second
+				case string:
+					rp.Expires, err = time.ParseDuration(vv)
+				default:
+					err = fmt.Errorf("unsupported type %T", v)
+				}
+				if err != nil {
+					*errors = append(*errors, &configErr{tk, fmt.Sprintf("error parsing allow_responses %s: %v", k, err)})
+				}
+			default:
+				if !tk.IsUsedVariable() {
+					err := &configErr{tk, fmt.Sprintf("Unknown field %q parsing allow_responses", k)}
+					*errors = append(*errors, err)
+				}
+			}
+		}
+		// Without any limit the allowed replies would never be released.
+		if rp.MaxMsgs == 0 && rp.Expires <= 0 {
+			return nil, &configErr{tk, "error parsing allow_responses: max and expires can not both be unlimited"}
+		}
+		return rp, nil
+	}
+	return nil, &configErr{tk, fmt.Sprintf("Expected allow_responses to be a boolean or a map, got %T", v)}
+}
+
 // Top level parser for authorization configurations.
 func parseVariablePermissions(v interface{}, errors, warnings *[]error) (*SubjectPermission, error) {
 	switch vv := v.(type) {
@@ -1982,10 +3145,34 @@ func parseSubjectPermission(v interface{}, errors, warnings *[]error) (*SubjectP
 }
 
 // Helper function to validate subjects, etc for account permissioning.
+// A subject may be followed by a queue group name, e.g. "orders.* workers".
 func checkSubjectArray(sa []string) error {
 	for _, s := range sa {
-		if !IsValidSubject(s) {
-			return fmt.Errorf("subject %q is not a valid subject", s)
+		switch fields := strings.Fields(s); len(fields) {
+		case 1:
+			if IsValidSubject(s) {
+				continue
+			}
+		case 2:
+			if IsValidSubject(fields[0]) {
+				continue
+			}
+		}
+		return fmt.Errorf("subject %q is not a valid subject", s)
+	}
+	return nil
+}
+
+// Helper function to check that permissions do not name queue groups.
+func checkNoQueuePermissions(p *SubjectPermission) error {
+	if p == nil {
+		return nil
+	}
+	for _, sa := range [][]string{p.Allow, p.Deny} {
+		for _, s := range sa {
+			if _, queue := splitPermSubjectQueue(s); queue != _EMPTY_ {
+				return fmt.Errorf("queue group in %q is only allowed in subscribe permissions", s)
+			}
 		}
 	}
 	return nil
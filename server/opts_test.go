@@ -827,6 +827,97 @@ func TestNkeyUsersWithPermsConfig(t *testing.T) {
 	}
 }
 
+func TestAllowResponsesConfig(t *testing.T) {
+	confFileName := createConfFile(t, []byte(`
+    authorization {
+      users = [
+        {user: a, password: pwd, permissions = { allow_responses = true }}
+        {user: b, password: pwd, permissions = { allow_responses = { max: 5, expires: "1s" } }}
+        {user: c, password: pwd, permissions = { allow_responses = false }}
+      ]
+    }`))
+	defer os.Remove(confFileName)
+	opts, err := ProcessConfigFile(confFileName)
+	if err != nil {
+		t.Fatalf("Received an error reading config file: %v", err)
+	}
+	if len(opts.Users) != 3 {
+		t.Fatalf("Expected 3 users, got %d", len(opts.Users))
+	}
+	for _, u := range opts.Users {
+		rp := u.Permissions.Response
+		switch u.Username {
+		case "a":
+			if rp == nil || rp.MaxMsgs != DEFAULT_ALLOW_RESPONSE_MAX_MSGS || rp.Expires != DEFAULT_ALLOW_RESPONSE_EXPIRATION {
+				t.Fatalf("Expected default response permissions, got %+v", rp)
+			}
+		case "b":
+			if rp == nil || rp.MaxMsgs != 5 || rp.Expires != time.Second {
+				t.Fatalf("Unexpected response permissions: %+v", rp)
+			}
+		case "c":
+			if rp != nil {
+				t.Fatalf("Expected no response permissions, got %+v", rp)
+			}
+		}
+	}
+
+	confFileName = createConfFile(t, []byte(`
+    authorization {
+      users = [
+        {user: a, password: pwd, permissions = { allow_responses = { max: -1, foo: bar } }}
+      ]
+    }`))
+	defer os.Remove(confFileName)
+	if _, err := ProcessConfigFile(confFileName); err == nil ||
+		!strings.Contains(err.Error(), "expected a positive integer") || !strings.Contains(err.Error(), `Unknown field "foo"`) {
+		t.Fatalf("Expected errors parsing allow_responses, got %v", err)
+	}
+
+	confFileName = createConfFile(t, []byte(`
+    authorization {
+      users = [
+        {user: a, password: pwd, permissions = { allow_responses = { max: 0, expires: 0 } }}
+      ]
+    }`))
+	defer os.Remove(confFileName)
+	if _, err := ProcessConfigFile(confFileName); err == nil || !strings.Contains(err.Error(), "can not both be unlimited") {
+		t.Fatalf("Expected error for unlimited allow_responses, got %v", err)
+	}
+}
+
+func TestQueuePermissionsConfig(t *testing.T) {
+	confFileName := createConfFile(t, []byte(`
+    authorization {
+      users = [
+        {user: a, password: pwd, permissions = { subscribe = { allow = ["foo.* workers", "bar"], deny = "foo.bar audit.>" } }}
+      ]
+    }`))
+	defer os.Remove(confFileName)
+	opts, err := ProcessConfigFile(confFileName)
+	if err != nil {
+		t.Fatalf("Received an error reading config file: %v", err)
+	}
+	sp := opts.Users[0].Permissions.Subscribe
+	if len(sp.Allow) != 2 || sp.Allow[0] != "foo.* workers" || len(sp.Deny) != 1 || sp.Deny[0] != "foo.bar audit.>" {
+		t.Fatalf("Unexpected subscribe permissions: %+v", sp)
+	}
+
+	for _, perms := range []string{
+		`publish = "foo workers"`,
+		`subscribe = "foo workers extra"`,
+	} {
+		confFileName = createConfFile(t, []byte(fmt.Sprintf(`
+      authorization {
+        users = [ {user: a, password: pwd, permissions = { %s }} ]
+      }`, perms)))
+		defer os.Remove(confFileName)
+		if _, err := ProcessConfigFile(confFileName); err == nil {
+			t.Fatalf("Expected error for permissions %s", perms)
+		}
+	}
+}
+
 func TestBadNkeyConfig(t *testing.T) {
 	confFileName := "nkeys_bad.conf"
 	defer os.Remove(confFileName)
@@ -1758,6 +1849,173 @@ func TestParsingLeafNodesListener(t *testing.T) {
 	}
 }
 
+func TestParsingLeafNodeAuthorizationUsers(t *testing.T) {
+	content := `
+	accounts { A {}, B {} }
+	leafnodes {
+		listen: "127.0.0.1:-1"
+		authorization {
+			timeout: 2
+			users = [
+				{user: "leaf1", password: "pwd", account: "A", permissions: { publish: "foo" }}
+				{user: "CN=example.com,OU=NATS.io", account: "B"}
+				{nkey: "UDKTV7HZVYJFJN64LLMYQBUR6MTNNYCDC3LAZH4VHURW3GZLL3FULBXV"}
+			]
+		}
+	}
+	`
+	conf := createConfFile(t, []byte(content))
+	defer os.Remove(conf)
+	opts, err := ProcessConfigFile(conf)
+	if err != nil {
+		t.Fatalf("Error processing file: %v", err)
+	}
+	users := opts.LeafNode.Users
+	if len(users) != 2 || len(opts.LeafNode.Nkeys) != 1 {
+		t.Fatalf("Expected 2 users and 1 nkey, got %v and %v", users, opts.LeafNode.Nkeys)
+	}
+	if u := users[0]; u.Username != "leaf1" || u.Password != "pwd" || u.Account.Name != "A" ||
+		u.Permissions == nil || u.Permissions.Publish.Allow[0] != "foo" {
+		t.Fatalf("Unexpected user: %+v", u)
+	}
+	if u := users[1]; u.Account.Name != "B" || u.Permissions != nil {
+		t.Fatalf("Unexpected user: %+v", u)
+	}
+	if nkey := opts.LeafNode.Nkeys[0]; nkey.Account != nil {
+		t.Fatalf("Expected nkey to not be bound to an account, got %v", nkey.Account.Name)
+	}
+	if err := validateOptions(opts); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	for _, test := range []struct {
+		name string
+		auth string
+		err  string
+	}{
+		{"single user and users", `user: leaf, password: pwd, users: [{user: leaf2}]`, "single user/pass and a users array"},
+		{"unknown field", `users: [{user: leaf, foo: bar}]`, "unknown field"},
+		{"bad nkey", `users: [{nkey: "Ufoo"}]`, "Not a valid public nkey"},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			conf := createConfFile(t, []byte(fmt.Sprintf(`leafnodes { authorization { %s } }`, test.auth)))
+			defer os.Remove(conf)
+			if _, err := ProcessConfigFile(conf); err == nil || !strings.Contains(err.Error(), test.err) {
+				t.Fatalf("Expected error %q, got %v", test.err, err)
+			}
+		})
+	}
+
+	conf = createConfFile(t, []byte(`
+		leafnodes {
+			listen: "127.0.0.1:-1"
+			authorization { users: [{user: leaf, password: pwd, account: FOO}] }
+		}
+	`))
+	defer os.Remove(conf)
+	if opts, err = ProcessConfigFile(conf); err != nil {
+		t.Fatalf("Error processing file: %v", err)
+	}
+	if err := validateOptions(opts); err == nil || !strings.Contains(err.Error(), "is not defined") {
+		t.Fatalf("Expected error for unknown account, got %v", err)
+	}
+}
+
+func TestParsingLeafNodePermissions(t *testing.T) {
+	content := `
+	leafnodes {
+		listen: "127.0.0.1:-1"
+		permissions {
+			import: "foo"
+			export: { deny: "bar" }
+		}
+		remotes [
+			{
+				url: nats-leaf://127.0.0.1:2222
+				permissions {
+					import: { allow: ["baz", "bat"] }
+				}
+			}
+		]
+	}
+	`
+	conf := createConfFile(t, []byte(content))
+	defer os.Remove(conf)
+	opts, err := ProcessConfigFile(conf)
+	if err != nil {
+		t.Fatalf("Error processing file: %v", err)
+	}
+	expected := &RoutePermissions{
+		Import: &SubjectPermission{Allow: []string{"foo"}},
+		Export: &SubjectPermission{Deny: []string{"bar"}},
+	}
+	if !reflect.DeepEqual(opts.LeafNode.Permissions, expected) {
+		t.Fatalf("Expected %+v, got %+v", expected, opts.LeafNode.Permissions)
+	}
+	expected = &RoutePermissions{
+		Import: &SubjectPermission{Allow: []string{"baz", "bat"}},
+	}
+	if len(opts.LeafNode.Remotes) != 1 || !reflect.DeepEqual(opts.LeafNode.Remotes[0].Permissions, expected) {
+		t.Fatalf("Expected %+v, got %+v", expected, opts.LeafNode.Remotes[0].Permissions)
+	}
+
+	conf = createConfFile(t, []byte(`leafnodes { permissions { export: { allow: "foo bar" } } }`))
+	defer os.Remove(conf)
+	if _, err := ProcessConfigFile(conf); err == nil {
+		t.Fatal("Expected error for queue group in export permissions")
+	}
+}
+
+func TestParsingLeafNodeRemoteAccounts(t *testing.T) {
+	content := `
+	leafnodes {
+		remotes [
+			{
+				url: nats-leaf://127.0.0.1:2222
+				accounts [
+					{account: "A", credentials: "./a.creds"}
+					{local: "B", creds: "./b.creds"}
+				]
+			}
+		]
+	}
+	`
+	conf := createConfFile(t, []byte(content))
+	defer os.Remove(conf)
+	opts, err := ProcessConfigFile(conf)
+	if err != nil {
+		t.Fatalf("Error processing file: %v", err)
+	}
+	expected := []*RemoteLeafAccount{
+		{LocalAccount: "A", Credentials: "./a.creds"},
+		{LocalAccount: "B", Credentials: "./b.creds"},
+	}
+	if len(opts.LeafNode.Remotes) != 1 || !reflect.DeepEqual(opts.LeafNode.Remotes[0].Accounts, expected) {
+		t.Fatalf("Expected %+v, got %+v", expected, opts.LeafNode.Remotes)
+	}
+
+	for _, test := range []struct {
+		name   string
+		remote string
+		err    string
+	}{
+		{"local account and accounts", `account: "A", accounts: [{account: "B"}]`, "both a local account and a list of accounts"},
+		{"missing account", `accounts: [{credentials: "./a.creds"}]`, "requires a local account"},
+		{"duplicate account", `accounts: [{account: "A"}, {account: "A"}]`, "Duplicate remote leafnode account"},
+		{"unknown field", `accounts: [{account: "A", foo: "bar"}]`, "unknown field"},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			conf := createConfFile(t, []byte(fmt.Sprintf(`
+				leafnodes { remotes [ { url: nats-leaf://127.0.0.1:2222, %s } ] }
+			`, test.remote)))
+			defer os.Remove(conf)
+			if _, err := ProcessConfigFile(conf); err == nil || !strings.Contains(err.Error(), test.err) {
+				t.Fatalf("Expected error %q, got %v", test.err, err)
+			}
+		})
+	}
+}
+
 func TestParsingLeafNodeRemotes(t *testing.T) {
 	content := `
 		leafnodes {
@@ -24,8 +24,10 @@ type pubArg struct {
 	subject []byte
 	reply   []byte
 	szb     []byte
+	hdb     []byte
 	queues  [][]byte
 	size    int
+	hdr     int
 }
 
 type parserState int
@@ -64,6 +66,12 @@ const (
 	OP_PUB
 	OP_PUB_SPC
 	PUB_ARG
+	OP_H
+	OP_HP
+	OP_HPU
+	OP_HPUB
+	OP_HPUB_SPC
+	HPUB_ARG
 	OP_PI
 	OP_PIN
 	OP_PING
@@ -101,6 +109,11 @@ const (
 	OP_MSG
 	OP_MSG_SPC
 	MSG_ARG
+	OP_HM
+	OP_HMS
+	OP_HMSG
+	OP_HMSG_SPC
+	HMSG_ARG
 	OP_I
 	OP_IN
 	OP_INF
@@ -142,6 +155,8 @@ func (c *client) parse(buf []byte) error {
 			switch b {
 			case 'P', 'p':
 				c.state = OP_P
+			case 'H', 'h':
+				c.state = OP_H
 			case 'S', 's':
 				c.state = OP_S
 			case 'U', 'u':
@@ -235,6 +250,77 @@ func (c *client) parse(buf []byte) error {
 					c.argBuf = append(c.argBuf, b)
 				}
 			}
+		case OP_H:
+			switch b {
+			case 'P', 'p':
+				if c.kind != CLIENT {
+					goto parseErr
+				}
+				c.state = OP_HP
+			case 'M', 'm':
+				if c.kind == CLIENT {
+					goto parseErr
+				}
+				c.state = OP_HM
+			default:
+				goto parseErr
+			}
+		case OP_HP:
+			switch b {
+			case 'U', 'u':
+				c.state = OP_HPU
+			default:
+				goto parseErr
+			}
+		case OP_HPU:
+			switch b {
+			case 'B', 'b':
+				c.state = OP_HPUB
+			default:
+				goto parseErr
+			}
+		case OP_HPUB:
+			switch b {
+			case ' ', '\t':
+				c.state = OP_HPUB_SPC
+			default:
+				goto parseErr
+			}
+		case OP_HPUB_SPC:
+			switch b {
+			case ' ', '\t':
+				continue
+			default:
+				c.state = HPUB_ARG
+				c.as = i
+			}
+		case HPUB_ARG:
+			switch b {
+			case '\r':
+				c.drop = 1
+			case '\n':
+				var arg []byte
+				if c.argBuf != nil {
+					arg = c.argBuf
+					c.argBuf = nil
+				} else {
+					arg = buf[c.as : i-c.drop]
+				}
+				if err := c.processHeaderPub(c.trace, arg); err != nil {
+					return err
+				}
+				c.drop, c.as, c.state = 0, i+1, MSG_PAYLOAD
+				// If we don't have a saved buffer then jump ahead with
+				// the index. If this overruns what is left we fall out
+				// and process split buffer.
+				if c.msgBuf == nil {
+					i = c.as + c.pa.size - LEN_CR_LF
+				}
+			default:
+				if c.argBuf != nil {
+					c.argBuf = append(c.argBuf, b)
+				}
+			}
 		case MSG_PAYLOAD:
 			if c.msgBuf != nil {
 				// copy as much as we can to the buffer and skip ahead.
@@ -283,6 +369,7 @@ func (c *client) parse(buf []byte) error {
 			// Drop all pub args
 			c.pa.arg, c.pa.pacache, c.pa.account, c.pa.subject = nil, nil, nil, nil
 			c.pa.reply, c.pa.szb, c.pa.queues = nil, nil, nil
+			c.pa.hdr, c.pa.hdb = 0, nil
 		case OP_A:
 			switch b {
 			case '+':
@@ -703,6 +790,67 @@ func (c *client) parse(buf []byte) error {
 				}
 				c.drop, c.as, c.state = 0, i+1, MSG_PAYLOAD
 
+				// jump ahead with the index. If this overruns
+				// what is left we fall out and process split
+				// buffer.
+				i = c.as + c.pa.size - LEN_CR_LF
+			default:
+				if c.argBuf != nil {
+					c.argBuf = append(c.argBuf, b)
+				}
+			}
+		case OP_HM:
+			switch b {
+			case 'S', 's':
+				c.state = OP_HMS
+			default:
+				goto parseErr
+			}
+		case OP_HMS:
+			switch b {
+			case 'G', 'g':
+				c.state = OP_HMSG
+			default:
+				goto parseErr
+			}
+		case OP_HMSG:
+			switch b {
+			case ' ', '\t':
+				c.state = OP_HMSG_SPC
+			default:
+				goto parseErr
+			}
+		case OP_HMSG_SPC:
+			switch b {
+			case ' ', '\t':
+				continue
+			default:
+				c.state = HMSG_ARG
+				c.as = i
+			}
+		case HMSG_ARG:
+			switch b {
+			case '\r':
+				c.drop = 1
+			case '\n':
+				var arg []byte
+				if c.argBuf != nil {
+					arg = c.argBuf
+					c.argBuf = nil
+				} else {
+					arg = buf[c.as : i-c.drop]
+				}
+				var err error
+				if c.kind == ROUTER || c.kind == GATEWAY {
+					err = c.processRoutedHeaderMsgArgs(c.trace, arg)
+				} else if c.kind == LEAF {
+					err = c.processLeafHeaderMsgArgs(c.trace, arg)
+				}
+				if err != nil {
+					return err
+				}
+				c.drop, c.as, c.state = 0, i+1, MSG_PAYLOAD
+
 				// jump ahead with the index. If this overruns
 				// what is left we fall out and process split
 				// buffer.
@@ -843,8 +991,8 @@ func (c *client) parse(buf []byte) error {
 
 	// Check for split buffer scenarios for any ARG state.
 	if c.state == SUB_ARG || c.state == UNSUB_ARG || c.state == PUB_ARG ||
-		c.state == ASUB_ARG || c.state == AUSUB_ARG ||
-		c.state == MSG_ARG || c.state == MINUS_ERR_ARG ||
+		c.state == HPUB_ARG || c.state == ASUB_ARG || c.state == AUSUB_ARG ||
+		c.state == MSG_ARG || c.state == HMSG_ARG || c.state == MINUS_ERR_ARG ||
 		c.state == CONNECT_ARG || c.state == INFO_ARG {
 		// Setup a holder buffer to deal with split buffer scenario.
 		if c.argBuf == nil {
@@ -922,10 +1070,27 @@ func (c *client) clonePubArg() {
 	c.argBuf = c.scratch[:0]
 	c.argBuf = append(c.argBuf, c.pa.arg...)
 
-	// This is a routed msg
-	if c.pa.account != nil {
-		c.processRoutedMsgArgs(false, c.argBuf)
-	} else {
-		c.processPub(false, c.argBuf)
+	// Messages with headers carry the header size as an extra argument.
+	hdr := c.pa.hdb != nil
+
+	switch c.kind {
+	case ROUTER, GATEWAY:
+		if hdr {
+			c.processRoutedHeaderMsgArgs(false, c.argBuf)
+		} else {
+			c.processRoutedMsgArgs(false, c.argBuf)
+		}
+	case LEAF:
+		if hdr {
+			c.processLeafHeaderMsgArgs(false, c.argBuf)
+		} else {
+			c.processLeafMsgArgs(false, c.argBuf)
+		}
+	default:
+		if hdr {
+			c.processHeaderPub(false, c.argBuf)
+		} else {
+			c.processPub(false, c.argBuf)
+		}
 	}
 }
@@ -425,6 +425,128 @@ func TestParseRouteMsg(t *testing.T) {
 	}
 }
 
+func TestParseHeaderPub(t *testing.T) {
+	c := dummyClient()
+	c.headers = true
+
+	hpub := []byte("HPUB foo 12 17\r\nNATS/1.0\r\n\r\nhello\r")
+	err := c.parse(hpub)
+	if err != nil || c.state != MSG_END_N {
+		t.Fatalf("Unexpected: %d : %v\n", c.state, err)
+	}
+	if !bytes.Equal(c.pa.subject, []byte("foo")) {
+		t.Fatalf("Did not parse subject correctly: 'foo' vs '%s'\n", c.pa.subject)
+	}
+	if c.pa.reply != nil {
+		t.Fatalf("Did not parse reply correctly: 'nil' vs '%s'\n", c.pa.reply)
+	}
+	if c.pa.hdr != 12 {
+		t.Fatalf("Did not parse header size correctly: 12 vs %d\n", c.pa.hdr)
+	}
+	if c.pa.size != 17 {
+		t.Fatalf("Did not parse msg size correctly: 17 vs %d\n", c.pa.size)
+	}
+
+	// Clear snapshots
+	c.argBuf, c.msgBuf, c.state = nil, nil, OP_START
+
+	hpub = []byte("HPUB foo.bar INBOX.22 12 23\r\nNATS/1.0\r\n\r\nhello world\r")
+	err = c.parse(hpub)
+	if err != nil || c.state != MSG_END_N {
+		t.Fatalf("Unexpected: %d : %v\n", c.state, err)
+	}
+	if !bytes.Equal(c.pa.subject, []byte("foo.bar")) {
+		t.Fatalf("Did not parse subject correctly: 'foo.bar' vs '%s'\n", c.pa.subject)
+	}
+	if !bytes.Equal(c.pa.reply, []byte("INBOX.22")) {
+		t.Fatalf("Did not parse reply correctly: 'INBOX.22' vs '%s'\n", c.pa.reply)
+	}
+	if c.pa.hdr != 12 {
+		t.Fatalf("Did not parse header size correctly: 12 vs %d\n", c.pa.hdr)
+	}
+	if c.pa.size != 23 {
+		t.Fatalf("Did not parse msg size correctly: 23 vs %d\n", c.pa.size)
+	}
+
+	// Header size can not be bigger than the total size.
+	for _, hpub := range []string{
+		"HPUB foo 20 10\r\n",
+		"HPUB foo 10\r\n",
+		"HPUB foo bar baz 10\r\n",
+	} {
+		c := dummyClient()
+		c.headers = true
+		if err := c.parse([]byte(hpub)); err == nil {
+			t.Fatalf("Expected an error parsing %q", hpub)
+		}
+	}
+}
+
+func TestParseHeaderPubNotSupported(t *testing.T) {
+	c := dummyClient()
+	hpub := []byte("HPUB foo 12 17\r\nNATS/1.0\r\n\r\nhello\r\n")
+	if err := c.parse(hpub); err != ErrMsgHeadersNotSupported {
+		t.Fatalf("Expected error %v, got %v", ErrMsgHeadersNotSupported, err)
+	}
+	// Clients can not send HMSG.
+	c = dummyClient()
+	c.headers = true
+	if err := c.parse([]byte("HMSG foo 1 12 17\r\n")); err == nil {
+		t.Fatal("Expected an error, got none")
+	}
+}
+
+func TestParseRouteHeaderMsg(t *testing.T) {
+	c := dummyRouteClient()
+
+	hmsg := []byte("HMSG $G foo.bar + reply baz 12 23\r\nNATS/1.0\r\n\r\nhello world\r")
+	err := c.parse(hmsg)
+	if err != nil || c.state != MSG_END_N {
+		t.Fatalf("Unexpected: %d : %v\n", c.state, err)
+	}
+	if !bytes.Equal(c.pa.account, []byte("$G")) {
+		t.Fatalf("Did not parse account correctly: '$G' vs '%s'\n", c.pa.account)
+	}
+	if !bytes.Equal(c.pa.subject, []byte("foo.bar")) {
+		t.Fatalf("Did not parse subject correctly: 'foo.bar' vs '%s'\n", c.pa.subject)
+	}
+	if !bytes.Equal(c.pa.reply, []byte("reply")) {
+		t.Fatalf("Did not parse reply correctly: 'reply' vs '%s'\n", c.pa.reply)
+	}
+	if len(c.pa.queues) != 1 || !bytes.Equal(c.pa.queues[0], []byte("baz")) {
+		t.Fatalf("Did not parse queues correctly: %q\n", c.pa.queues)
+	}
+	if c.pa.hdr != 12 {
+		t.Fatalf("Did not parse header size correctly: 12 vs %d\n", c.pa.hdr)
+	}
+	if c.pa.size != 23 {
+		t.Fatalf("Did not parse msg size correctly: 23 vs %d\n", c.pa.size)
+	}
+
+	// Split buffer
+	c = dummyRouteClient()
+	c.mcl = MAX_CONTROL_LINE_SIZE
+	hmsg = []byte("HMSG $G foo 12 17\r\nNATS/1.0\r\n\r\nhello\r\n")
+	if err := c.parse(hmsg[:10]); err != nil || c.state != HMSG_ARG {
+		t.Fatalf("Unexpected: %d : %v\n", c.state, err)
+	}
+	if err := c.parse(hmsg[10:25]); err != nil || c.state != MSG_PAYLOAD {
+		t.Fatalf("Unexpected: %d : %v\n", c.state, err)
+	}
+	if c.pa.hdr != 12 || c.pa.size != 17 {
+		t.Fatalf("Unexpected header or msg size: %d %d\n", c.pa.hdr, c.pa.size)
+	}
+	if err := c.parse(hmsg[25 : len(hmsg)-1]); err != nil || c.state != MSG_END_N {
+		t.Fatalf("Unexpected: %d : %v\n", c.state, err)
+	}
+	if !bytes.Equal(c.pa.subject, []byte("foo")) {
+		t.Fatalf("Did not parse subject correctly: 'foo' vs '%s'\n", c.pa.subject)
+	}
+	if c.pa.hdr != 12 || c.pa.size != 17 {
+		t.Fatalf("Unexpected header or msg size: %d %d\n", c.pa.hdr, c.pa.size)
+	}
+}
+
 func TestParseMsgSpace(t *testing.T) {
 	c := dummyRouteClient()
 
@@ -0,0 +1,428 @@
+// Copyright 2019 The NATS Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package server
+
+import (
+	"fmt"
+	"strings"
+	"sync/atomic"
+)
+
+// QueuePolicy is the policy used to select the member of a queue group
+// a message is delivered to.
+type QueuePolicy int
+
+const (
+	// QueueRandom selects a member at random. This is the default.
+	QueueRandom QueuePolicy = iota
+	// QueueWeighted selects a member at random in proportion to the weight
+	// it subscribed with.
+	QueueWeighted
+	// QueueSticky selects a member by hashing a token of the subject, so
+	// that messages with the same token go to the same member, whatever the
+	// server of the cluster they are published on, for as long as the group
+	// does not change.
+	QueueSticky
+	// QueueLeastPending selects the member with the least outbound bytes
+	// pending.
+	QueueLeastPending
+)
+
+var queuePolicyNames = map[QueuePolicy]string{
+	QueueRandom:       "random",
+	QueueWeighted:     "weighted",
+	QueueSticky:       "sticky",
+	QueueLeastPending: "least_pending",
+}
+
+func (p QueuePolicy) String() string {
+	if name, ok := queuePolicyNames[p]; ok {
+		return name
+	}
+	return "unknown"
+}
+
+// Returns the queue policy with the given name.
+func queuePolicyFromString(name string) (QueuePolicy, error) {
+	for p, pn := range queuePolicyNames {
+		if strings.EqualFold(name, pn) {
+			return p, nil
+		}
+	}
+	return QueueRandom, fmt.Errorf("unknown queue policy %q", name)
+}
+
+// QueueLocality is the policy used to decide whether messages are sent
+// to the members of a queue group in other clusters, across gateways.
+type QueueLocality int
+
+const (
+	// QueueLocalityDefault uses the locality of the account, or
+	// QueueLocalityNearest if not set.
+	QueueLocalityDefault QueueLocality = iota
+	// QueueLocalityNearest delivers to members of the local cluster, or,
+	// if there are none, to the cluster with the lowest gateway RTT.
+	QueueLocalityNearest
+	// QueueLocalityPreferLocal delivers to members of the local cluster, or,
+	// if there are none, to any of the clusters with members.
+	QueueLocalityPreferLocal
+	// QueueLocalityLocalOnly never delivers to other clusters.
+	QueueLocalityLocalOnly
+)
+
+var queueLocalityNames = map[QueueLocality]string{
+	QueueLocalityDefault:     "default",
+	QueueLocalityNearest:     "nearest",
+	QueueLocalityPreferLocal: "prefer_local",
+	QueueLocalityLocalOnly:   "local_only",
+}
+
+func (l QueueLocality) String() string {
+	if name, ok := queueLocalityNames[l]; ok {
+		return name
+	}
+	return "unknown"
+}
+
+// Returns the queue locality with the given name.
+func queueLocalityFromString(name string) (QueueLocality, error) {
+	for l, ln := range queueLocalityNames {
+		if strings.EqualFold(name, ln) {
+			return l, nil
+		}
+	}
+	return QueueLocalityDefault, fmt.Errorf("unknown queue locality %q", name)
+}
+
+// QueueStrategy configures how messages are delivered to a queue group.
+type QueueStrategy struct {
+	Policy QueuePolicy
+	// For sticky delivery, the 1-based index of the subject token that is
+	// hashed, or 0 for the whole subject.
+	Token int
+	// Overrides the queue locality of the account for this group.
+	Locality QueueLocality
+}
+
+// SetQueueStrategy sets the delivery strategy of the queue group with the
+// given name. A nil strategy restores the default random delivery.
+func (a *Account) SetQueueStrategy(queue string, qs *QueueStrategy) error {
+	if qs != nil {
+		if _, ok := queuePolicyNames[qs.Policy]; !ok {
+			return fmt.Errorf("invalid policy %d for queue group %q", qs.Policy, queue)
+		}
+		if qs.Token < 0 {
+			return fmt.Errorf("invalid token %d for queue group %q", qs.Token, queue)
+		}
+		if _, ok := queueLocalityNames[qs.Locality]; !ok {
+			return fmt.Errorf("invalid locality %d for queue group %q", qs.Locality, queue)
+		}
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if qs == nil {
+		delete(a.qstrats, queue)
+	} else {
+		if a.qstrats == nil {
+			a.qstrats = make(map[string]*QueueStrategy)
+		}
+		a.qstrats[queue] = qs
+	}
+	a.setQueueStrategies(a.qstrats)
+	return nil
+}
+
+// Replaces the queue strategies of the account.
+// Lock should be held.
+func (a *Account) setQueueStrategies(qstrats map[string]*QueueStrategy) {
+	a.qstrats = qstrats
+	if len(qstrats) > 0 {
+		atomic.StoreInt32(&a.qstrat, 1)
+	} else {
+		atomic.StoreInt32(&a.qstrat, 0)
+	}
+}
+
+// Returns true if the account has queue strategies.
+func (a *Account) hasQueueStrategies() bool {
+	return atomic.LoadInt32(&a.qstrat) == 1
+}
+
+// Returns the strategy of the given queue group, or nil for the default.
+func (a *Account) queueStrategy(queue []byte) *QueueStrategy {
+	a.mu.RLock()
+	qs := a.qstrats[string(queue)]
+	a.mu.RUnlock()
+	return qs
+}
+
+// SetQueueLocality sets the queue locality of all queue groups of the
+// account that do not have their own.
+func (a *Account) SetQueueLocality(l QueueLocality) error {
+	if _, ok := queueLocalityNames[l]; !ok {
+		return fmt.Errorf("invalid queue locality %d", l)
+	}
+	a.mu.Lock()
+	a.qlocality = l
+	a.mu.Unlock()
+	return nil
+}
+
+// Returns the locality of the given queue group, never
+// QueueLocalityDefault.
+func (a *Account) queueLocality(queue []byte) QueueLocality {
+	a.mu.RLock()
+	l := a.qlocality
+	if qs := a.qstrats[string(queue)]; qs != nil && qs.Locality != QueueLocalityDefault {
+		l = qs.Locality
+	}
+	a.mu.RUnlock()
+	if l == QueueLocalityDefault {
+		return QueueLocalityNearest
+	}
+	return l
+}
+
+// Returns the weight a queue subscription is accounted for with in the
+// route subscription map, so that remote servers honor it. Only weights
+// of client subscriptions are propagated.
+func (sub *subscription) queueWeight() int32 {
+	if sub.queue != nil && sub.qw > 1 && sub.client != nil && sub.client.kind == CLIENT {
+		return sub.qw
+	}
+	return 1
+}
+
+// Returns true if the queue subscription is only to be delivered to if no
+// local one can be. This mirrors the preference in processMsgResults.
+func (c *client) skipRemoteQSub(sub *subscription) bool {
+	kind := sub.client.kind
+	if kind != ROUTER && kind != LEAF {
+		return false
+	}
+	return c.kind == ROUTER || c.kind == LEAF || (c.kind == CLIENT && kind == LEAF)
+}
+
+// Returns the index of the member of a queue group to try first for a
+// message, according to the strategy of the group. Remote members are
+// ignored when local ones are preferred.
+func (c *client) queueStartIndex(acc *Account, qsubs []*subscription, subject []byte) int {
+	if acc == nil || !acc.hasQueueStrategies() {
+		return c.in.prand.Intn(len(qsubs))
+	}
+	var qs *QueueStrategy
+	for _, sub := range qsubs {
+		if sub != nil {
+			qs = acc.queueStrategy(sub.queue)
+			break
+		}
+	}
+	if qs == nil {
+		return c.in.prand.Intn(len(qsubs))
+	}
+
+	index := -1
+	switch qs.Policy {
+	case QueueWeighted:
+		index = c.weightedQueueIndex(qsubs)
+	case QueueSticky:
+		index = c.stickyQueueIndex(qsubs, subjectToken(subject, qs.Token))
+	case QueueLeastPending:
+		index = c.leastPendingQueueIndex(qsubs)
+	}
+	if index < 0 {
+		return c.in.prand.Intn(len(qsubs))
+	}
+	return index
+}
+
+// Selects a member at random in proportion to its weight. Remote
+// members are already repeated in proportion to their weight.
+func (c *client) weightedQueueIndex(qsubs []*subscription) int {
+	var total int32
+	for _, sub := range qsubs {
+		if sub != nil && !c.skipRemoteQSub(sub) {
+			total += queueSelectionWeight(sub)
+		}
+	}
+	if total == 0 {
+		return -1
+	}
+	r := c.in.prand.Int31n(total)
+	for i, sub := range qsubs {
+		if sub == nil || c.skipRemoteQSub(sub) {
+			continue
+		}
+		if r -= queueSelectionWeight(sub); r < 0 {
+			return i
+		}
+	}
+	return -1
+}
+
+// Returns the weight of a member for weighted selection.
+func queueSelectionWeight(sub *subscription) int32 {
+	if sub.client.kind != ROUTER && sub.qw > 1 {
+		return sub.qw
+	}
+	return 1
+}
+
+// Selects a member using rendezvous hashing of the key, so that only the
+// keys of a member that leaves the group are moved to other members.
+// The server owning the key is selected first, hashing on server IDs and
+// the number of members each server has, which is the same on all servers
+// of the cluster. The owner then selects one of its local members, also
+// when the message is forwarded to it, so that a key goes to the same
+// member whatever the server the message is published on.
+func (c *client) stickyQueueIndex(qsubs []*subscription, key []byte) int {
+	hk := fnv64a(fnvOffset64, key)
+	hl := fnv64aString(hk, c.serverID())
+	index, found := -1, false
+	k, lk := uint64(0), uint64(0)
+	var best uint64
+	for i, sub := range qsubs {
+		if sub == nil {
+			continue
+		}
+		if sub.client.kind != ROUTER {
+			// Local members are accounted for with the weight that
+			// other servers see them with.
+			for w := sub.queueWeight(); w > 0; w-- {
+				if h := mix64(hl ^ lk); !found || h > best {
+					index, best, found = -1, h, true
+				}
+				lk++
+			}
+			continue
+		}
+		// Remote members are repeated in the results, tell them apart.
+		if i > 0 && sub == qsubs[i-1] {
+			k++
+		} else {
+			k = 0
+		}
+		if c.skipRemoteQSub(sub) {
+			continue
+		}
+		if h := mix64(fnv64aString(hk, sub.client.route.remoteID) ^ k); !found || h > best {
+			index, best, found = i, h, true
+		}
+	}
+	if index >= 0 || !found {
+		return index
+	}
+	return c.stickyLocalQueueIndex(qsubs, hk)
+}
+
+// Selects the local member for a key owned by this server.
+func (c *client) stickyLocalQueueIndex(qsubs []*subscription, hk uint64) int {
+	index := -1
+	var best uint64
+	for i, sub := range qsubs {
+		if sub == nil || sub.client.kind == ROUTER || c.skipRemoteQSub(sub) {
+			continue
+		}
+		h := mix64(fnv64a(hk, sub.sid) ^ sub.client.cid<<16)
+		if index < 0 || h > best {
+			index, best = i, h
+		}
+	}
+	return index
+}
+
+// Returns the ID of the server of the client, which identifies the server
+// to the other servers of the cluster.
+func (c *client) serverID() string {
+	if c.srv == nil {
+		return _EMPTY_
+	}
+	return c.srv.info.ID
+}
+
+// Selects the member with the least outbound bytes pending. Ties are
+// broken starting at a random index.
+func (c *client) leastPendingQueueIndex(qsubs []*subscription) int {
+	index := -1
+	var least int32
+	start := c.in.prand.Intn(len(qsubs))
+	for i := 0; i < len(qsubs); i++ {
+		si := (start + i) % len(qsubs)
+		sub := qsubs[si]
+		if sub == nil || c.skipRemoteQSub(sub) {
+			continue
+		}
+		sub.client.mu.Lock()
+		pb := sub.client.out.pb
+		sub.client.mu.Unlock()
+		if index < 0 || pb < least {
+			index, least = si, pb
+		}
+	}
+	return index
+}
+
+// Returns the 1-based n-th token of the subject, or the whole subject if
+// n is 0 or out of range.
+func subjectToken(subject []byte, n int) []byte {
+	if n <= 0 {
+		return subject
+	}
+	start := 0
+	for i := 0; i < len(subject); i++ {
+		if subject[i] == btsep {
+			if n--; n == 0 {
+				return subject[start:i]
+			}
+			start = i + 1
+		}
+	}
+	if n == 1 {
+		return subject[start:]
+	}
+	return subject
+}
+
+const (
+	fnvOffset64 = 14695981039346656037
+	fnvPrime64  = 1099511628211
+)
+
+// FNV-1a hash of b, starting from h, without allocations.
+func fnv64a(h uint64, b []byte) uint64 {
+	for _, c := range b {
+		h ^= uint64(c)
+		h *= fnvPrime64
+	}
+	return h
+}
+
+// Same as fnv64a for a string.
+func fnv64aString(h uint64, s string) uint64 {
+	for i := 0; i < len(s); i++ {
+		h ^= uint64(s[i])
+		h *= fnvPrime64
+	}
+	return h
+}
+
+// Finalizer of splitmix64, to spread the bits of a hash.
+func mix64(h uint64) uint64 {
+	h ^= h >> 30
+	h *= 0xbf58476d1ce4e5b9
+	h ^= h >> 27
+	h *= 0x94d049bb133111eb
+	h ^= h >> 31
+	return h
+}
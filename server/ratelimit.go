@@ -0,0 +1,337 @@
+// Copyright 2019 The NATS Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// RateLimitAction is what is done with a message published above a rate
+// limit.
+type RateLimitAction int
+
+const (
+	// RateLimitDrop drops the message. This is the default.
+	RateLimitDrop RateLimitAction = iota
+	// RateLimitDelay delays the publisher until the message is within the
+	// limit, which applies backpressure to the connection. Messages that
+	// would be delayed for longer than maxRateLimitDelay are dropped.
+	RateLimitDelay
+	// RateLimitDisconnect closes the connection of the publisher.
+	RateLimitDisconnect
+)
+
+// Maximum time a publisher is delayed by a rate limit.
+const maxRateLimitDelay = time.Second
+
+var rateLimitActionNames = map[RateLimitAction]string{
+	RateLimitDrop:       "drop",
+	RateLimitDelay:      "delay",
+	RateLimitDisconnect: "disconnect",
+}
+
+func (a RateLimitAction) String() string {
+	if name, ok := rateLimitActionNames[a]; ok {
+		return name
+	}
+	return "unknown"
+}
+
+// Returns the rate limit action with the given name.
+func rateLimitActionFromString(name string) (RateLimitAction, error) {
+	for a, an := range rateLimitActionNames {
+		if strings.EqualFold(name, an) {
+			return a, nil
+		}
+	}
+	return RateLimitDrop, fmt.Errorf("unknown rate limit action %q", name)
+}
+
+// MarshalJSON marshals the action as its name.
+func (a RateLimitAction) MarshalJSON() ([]byte, error) {
+	return json.Marshal(a.String())
+}
+
+// UnmarshalJSON unmarshals the action from its name.
+func (a *RateLimitAction) UnmarshalJSON(b []byte) error {
+	var name string
+	if err := json.Unmarshal(b, &name); err != nil {
+		return err
+	}
+	action, err := rateLimitActionFromString(name)
+	if err != nil {
+		return err
+	}
+	*a = action
+	return nil
+}
+
+// RateLimit limits the rate of the messages published on subjects matching
+// a pattern, or on all subjects if not set. A rate of 0 is unlimited. Up to
+// a second worth of messages and bytes can be published in a burst.
+type RateLimit struct {
+	Subject     string          `json:"subject,omitempty"`
+	MsgsPerSec  int64           `json:"msgs_per_sec,omitempty"`
+	BytesPerSec int64           `json:"bytes_per_sec,omitempty"`
+	Action      RateLimitAction `json:"action,omitempty"`
+}
+
+// Validates the rate limit.
+func (rl *RateLimit) validate() error {
+	if rl.Subject != _EMPTY_ && !IsValidSubject(rl.Subject) {
+		return fmt.Errorf("invalid rate limit subject %q", rl.Subject)
+	}
+	if rl.MsgsPerSec < 0 || rl.BytesPerSec < 0 {
+		return fmt.Errorf("invalid negative rate limit")
+	}
+	if _, ok := rateLimitActionNames[rl.Action]; !ok {
+		return fmt.Errorf("invalid rate limit action %d", rl.Action)
+	}
+	return nil
+}
+
+// tokenBucket holds up to a second worth of tokens, refilled at the rate.
+type tokenBucket struct {
+	rate   float64
+	tokens float64
+	last   int64
+}
+
+func newTokenBucket(rate int64, now int64) tokenBucket {
+	return tokenBucket{rate: float64(rate), tokens: float64(rate), last: now}
+}
+
+// Refills the bucket for the time elapsed and returns the tokens missing
+// to take n of them, 0 if there are enough.
+func (b *tokenBucket) missing(n float64, now int64) float64 {
+	if b.rate == 0 {
+		return 0
+	}
+	if now > b.last {
+		b.tokens += b.rate * float64(now-b.last) / float64(time.Second)
+		if b.tokens > b.rate {
+			b.tokens = b.rate
+		}
+		b.last = now
+	}
+	if b.tokens >= n {
+		return 0
+	}
+	return n - b.tokens
+}
+
+// Returns how long it takes to get the tokens missing.
+func (b *tokenBucket) wait(missing float64) time.Duration {
+	return time.Duration(missing / b.rate * float64(time.Second))
+}
+
+// Takes n tokens, possibly going into debt.
+func (b *tokenBucket) take(n float64) {
+	if b.rate > 0 {
+		b.tokens -= n
+	}
+}
+
+// rateLimiter enforces a set of rate limits, for a client or for all the
+// clients of an account.
+type rateLimiter struct {
+	mu     sync.Mutex
+	limits []*rateLimitState
+}
+
+type rateLimitState struct {
+	*RateLimit
+	wc    bool
+	msgs  tokenBucket
+	bytes tokenBucket
+}
+
+// Returns a rate limiter for the limits, or nil if there are none.
+func newRateLimiter(limits []*RateLimit) *rateLimiter {
+	if len(limits) == 0 {
+		return nil
+	}
+	now := time.Now().UnixNano()
+	rl := &rateLimiter{}
+	for _, l := range limits {
+		rl.limits = append(rl.limits, &rateLimitState{
+			RateLimit: l,
+			wc:        subjectHasWildcard(l.Subject),
+			msgs:      newTokenBucket(l.MsgsPerSec, now),
+			bytes:     newTokenBucket(l.BytesPerSec, now),
+		})
+	}
+	return rl
+}
+
+// Checks a message against the limits matching its subject. Returns
+// whether the message is within the limits, and otherwise the action of
+// the limit exceeded. Delays are only returned if no other limit is
+// exceeded, along with how long to wait, and the message is accounted for
+// as if it was published after the delay. Messages that would need to
+// wait longer than maxRateLimitDelay are dropped instead, and not
+// accounted for, so that the delays of shared limits stay bounded.
+func (rl *rateLimiter) check(subject string, size int, now int64) (bool, RateLimitAction, time.Duration) {
+	rl.mu.Lock()
+	defer rl.mu.Unlock()
+
+	var (
+		delay   time.Duration
+		matched [8]*rateLimitState
+		states  = matched[:0]
+	)
+	for _, l := range rl.limits {
+		switch {
+		case l.Subject == _EMPTY_:
+		case l.wc && matchLiteral(subject, l.Subject):
+		case !l.wc && subject == l.Subject:
+		default:
+			continue
+		}
+		mm, mb := l.msgs.missing(1, now), l.bytes.missing(float64(size), now)
+		if mm > 0 || mb > 0 {
+			if l.Action != RateLimitDelay {
+				return false, l.Action, 0
+			}
+			if mm > 0 {
+				if w := l.msgs.wait(mm); w > delay {
+					delay = w
+				}
+			}
+			if mb > 0 {
+				if w := l.bytes.wait(mb); w > delay {
+					delay = w
+				}
+			}
+		}
+		states = append(states, l)
+	}
+	if delay > maxRateLimitDelay {
+		return false, RateLimitDrop, 0
+	}
+	for _, l := range states {
+		l.msgs.take(1)
+		l.bytes.take(float64(size))
+	}
+	if delay > 0 {
+		return false, RateLimitDelay, delay
+	}
+	return true, RateLimitDrop, 0
+}
+
+// SetRateLimits sets the rate limits shared by all the clients of the
+// account, replacing any previous ones.
+func (a *Account) SetRateLimits(limits []*RateLimit) error {
+	for _, l := range limits {
+		if err := l.validate(); err != nil {
+			return err
+		}
+	}
+	a.mu.Lock()
+	a.setRateLimiter(newRateLimiter(limits))
+	a.mu.Unlock()
+	return nil
+}
+
+// Replaces the rate limiter of the account.
+// Lock should be held.
+func (a *Account) setRateLimiter(rl *rateLimiter) {
+	a.rlim = rl
+	if rl != nil {
+		atomic.StoreInt32(&a.rlimited, 1)
+	} else {
+		atomic.StoreInt32(&a.rlimited, 0)
+	}
+}
+
+// Returns the rate limiter of the account, if any.
+func (a *Account) rateLimiter() *rateLimiter {
+	if atomic.LoadInt32(&a.rlimited) == 0 {
+		return nil
+	}
+	a.mu.RLock()
+	rl := a.rlim
+	a.mu.RUnlock()
+	return rl
+}
+
+// Checks the message being processed against the rate limits of the client
+// and of its account. Returns false if the message must not be processed
+// further, after applying the action of the limit exceeded. Lock should
+// not be held.
+func (c *client) checkRateLimits(acc *Account) bool {
+	crl, arl := c.rlim, acc.rateLimiter()
+	if crl == nil && arl == nil {
+		return true
+	}
+	subject, size := string(c.pa.subject), c.pa.size
+	for _, rl := range [2]*rateLimiter{crl, arl} {
+		if rl == nil {
+			continue
+		}
+		ok, action, delay := rl.check(subject, size, time.Now().UnixNano())
+		if ok {
+			continue
+		}
+		switch action {
+		case RateLimitDelay:
+			if !c.rateLimitWait(delay) {
+				return false
+			}
+		case RateLimitDisconnect:
+			c.Noticef("Rate limit exceeded on %q, closing connection", subject)
+			c.sendErr("Rate Limit Exceeded")
+			c.closeConnection(RateLimitExceeded)
+			return false
+		default:
+			c.mu.Lock()
+			c.rdrops++
+			c.mu.Unlock()
+			c.Debugf("Rate limit exceeded on %q, dropping message", subject)
+			return false
+		}
+	}
+	return true
+}
+
+// Waits for the delay imposed by a rate limit. Returns false if the
+// connection was closed or the server shut down in the meantime.
+func (c *client) rateLimitWait(delay time.Duration) bool {
+	c.mu.Lock()
+	if c.nc == nil {
+		c.mu.Unlock()
+		return false
+	}
+	c.rdelays++
+	if c.rdch == nil {
+		c.rdch = make(chan struct{})
+	}
+	closed := c.rdch
+	c.mu.Unlock()
+
+	t := time.NewTimer(delay)
+	defer t.Stop()
+	select {
+	case <-t.C:
+		return true
+	case <-closed:
+	case <-c.srv.quitCh:
+	}
+	return false
+}
@@ -173,6 +173,19 @@ func (c *client) processRoutedMsgArgs(trace bool, arg []byte) error {
 	if trace {
 		c.traceInOp("RMSG", arg)
 	}
+	return c.processRoutedArgs(arg, false)
+}
+
+// Process an inbound HMSG specification from the remote route.
+// This is an RMSG with the header size preceding the total size.
+func (c *client) processRoutedHeaderMsgArgs(trace bool, arg []byte) error {
+	if trace {
+		c.traceInOp("HMSG", arg)
+	}
+	return c.processRoutedArgs(arg, true)
+}
+
+func (c *client) processRoutedArgs(arg []byte, hdr bool) error {
 	// Unroll splitArgs to avoid runtime/heap issues
 	a := [MAX_MSG_ARGS][]byte{}
 	args := a[:0]
@@ -195,6 +208,12 @@ func (c *client) processRoutedMsgArgs(trace bool, arg []byte) error {
 	}
 
 	c.pa.arg = arg
+	if hdr {
+		var err error
+		if args, err = c.processHeaderSizeArg(args); err != nil {
+			return fmt.Errorf("processRoutedMsgArgs %v: '%s'", err, arg)
+		}
+	}
 	switch len(args) {
 	case 0, 1, 2:
 		return fmt.Errorf("processRoutedMsgArgs Parse Error: '%s'", args)
@@ -409,6 +428,7 @@ func (c *client) processRouteInfo(info *Info) {
 	c.route.authRequired = info.AuthRequired
 	c.route.tlsRequired = info.TLSRequired
 	c.route.gatewayURL = info.GatewayURL
+	c.headers = info.Headers
 	// When sent through route INFO, if the field is set, it should be of size 1.
 	if len(info.LeafNodeURLs) == 1 {
 		c.route.leafnodeURL = info.LeafNodeURLs[0]
@@ -622,25 +642,25 @@ func (s *Server) forwardNewRouteInfoToKnownServers(info *Info) {
 }
 
 // canImport is whether or not we will send a SUB for interest to the other side.
-// This is for ROUTER connections only.
+// This is for ROUTER and LEAF connections only.
 // Lock is held on entry.
 func (c *client) canImport(subject string) bool {
 	// Use pubAllowed() since this checks Publish permissions which
 	// is what Import maps to.
-	return c.pubAllowed(subject)
+	return c.pubAllowedFullCheck(subject, false)
 }
 
 // canExport is whether or not we will accept a SUB from the remote for a given subject.
-// This is for ROUTER connections only.
+// This is for ROUTER and LEAF connections only.
 // Lock is held on entry
 func (c *client) canExport(subject string) bool {
 	// Use canSubscribe() since this checks Subscribe permissions which
 	// is what Export maps to.
-	return c.canSubscribe(subject)
+	return c.canSubscribe(subject, _EMPTY_)
 }
 
 // Initialize or reset cluster's permissions.
-// This is for ROUTER connections only.
+// This is for ROUTER and LEAF connections only.
 // Client lock is held on entry
 func (c *client) setRoutePermissions(perms *RoutePermissions) {
 	// Reset if some were set
@@ -1451,6 +1471,7 @@ func (s *Server) routeAcceptLoop(ch chan struct{}) {
 		MaxPayload:   s.info.MaxPayload,
 		Proto:        proto,
 		GatewayURL:   s.getGatewayURL(),
+		Headers:      true,
 	}
 	// Set this if only if advertise is not disabled
 	if !opts.Cluster.NoAdvertise {
@@ -0,0 +1,420 @@
+// Copyright 2019 The NATS Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/nats-io/nuid"
+)
+
+const (
+	// DeliverAtHdr is the header with the time, in RFC 3339 format, at
+	// which a published message is to be delivered.
+	DeliverAtHdr = "Nats-Deliver-At"
+	// DeliverAfterHdr is the header with the duration after which a
+	// published message is to be delivered, such as "10s" or "1h30m".
+	// It is ignored if DeliverAtHdr is also set.
+	DeliverAfterHdr = "Nats-Deliver-After"
+
+	// Number of slots of the timer wheel.
+	schedWheelSlots = 1024
+)
+
+var (
+	// ErrInvalidSchedule is returned when the delivery time of a message
+	// can not be parsed.
+	ErrInvalidSchedule = errors.New("invalid delivery time")
+	// ErrTooManyScheduledMsgs is returned when an account has reached its
+	// maximum number of scheduled messages.
+	ErrTooManyScheduledMsgs = errors.New("maximum scheduled messages exceeded")
+	// ErrDuplicateScheduledMsg is returned when a message is scheduled with
+	// the ID of a message already scheduled in the account.
+	ErrDuplicateScheduledMsg = errors.New("duplicate scheduled message")
+)
+
+// ScheduledMsgInfo describes a message waiting to be delivered.
+type ScheduledMsgInfo struct {
+	ID        string    `json:"id"`
+	Subject   string    `json:"subject"`
+	Reply     string    `json:"reply,omitempty"`
+	Size      int       `json:"size"`
+	Scheduled time.Time `json:"scheduled"`
+	DeliverAt time.Time `json:"deliver_at"`
+}
+
+// ScheduledMsgs is the response to the requests listing or canceling the
+// scheduled messages of an account.
+type ScheduledMsgs struct {
+	Account  string              `json:"account"`
+	Msgs     []*ScheduledMsgInfo `json:"messages,omitempty"`
+	Canceled []string            `json:"canceled,omitempty"`
+}
+
+// ScheduledMsgsCancel is the request to cancel scheduled messages.
+type ScheduledMsgsCancel struct {
+	IDs []string `json:"ids"`
+}
+
+// msgScheduler holds the messages published to be delivered later. They
+// are kept in memory, in a timer wheel that is only turning while there
+// are scheduled messages, and are lost if the server stops. When due, the
+// messages are published by an internal client of their account, as if
+// published by a client at that time.
+type msgScheduler struct {
+	mu      sync.Mutex
+	srv     *Server
+	tick    time.Duration
+	slots   [schedWheelSlots][]*schedMsg
+	pos     int
+	last    time.Time
+	running bool
+	msgs    map[string]map[string]*schedMsg
+	clients map[string]*client
+}
+
+type schedMsg struct {
+	ScheduledMsgInfo
+	acc    *Account
+	hdr    int
+	msg    []byte
+	slot   int
+	rounds int
+}
+
+func newMsgScheduler(s *Server, tick time.Duration) *msgScheduler {
+	return &msgScheduler{
+		srv:     s,
+		tick:    tick,
+		msgs:    make(map[string]map[string]*schedMsg),
+		clients: make(map[string]*client),
+	}
+}
+
+// Returns the message scheduler, creating it if needed.
+func (s *Server) scheduler() *msgScheduler {
+	s.mu.Lock()
+	if s.sched == nil {
+		s.sched = newMsgScheduler(s, DEFAULT_SCHEDULE_TICK)
+	}
+	ms := s.sched
+	s.mu.Unlock()
+	return ms
+}
+
+// SetMaxScheduledMsgs sets the maximum number of messages that can be
+// scheduled for later delivery in the account on this server. A negative
+// value means no limit, and 0 disables scheduled delivery, in which case
+// messages are delivered when published.
+func (a *Account) SetMaxScheduledMsgs(max int) {
+	a.mu.Lock()
+	a.msched = int32(max)
+	a.mu.Unlock()
+}
+
+// Returns the time at which the message, given its headers, is to be
+// delivered, or the zero time if it is not scheduled.
+func msgDeliveryTime(hdr []byte, now time.Time) (time.Time, error) {
+	if v := getHeader(DeliverAtHdr, hdr); len(v) > 0 {
+		at, err := time.Parse(time.RFC3339Nano, string(v))
+		if err != nil {
+			return time.Time{}, ErrInvalidSchedule
+		}
+		return at, nil
+	}
+	if v := getHeader(DeliverAfterHdr, hdr); len(v) > 0 {
+		d, err := time.ParseDuration(string(v))
+		if err != nil || d < 0 {
+			return time.Time{}, ErrInvalidSchedule
+		}
+		return now.Add(d), nil
+	}
+	return time.Time{}, nil
+}
+
+// Checks if the message being processed, which has headers, is to be
+// delivered later and if so schedules it. Returns true if the message
+// must not be processed further.
+func (c *client) scheduleMsg(msg []byte) bool {
+	c.acc.mu.RLock()
+	disabled := c.acc.msched == 0
+	c.acc.mu.RUnlock()
+	if disabled {
+		return false
+	}
+	now := time.Now()
+	at, err := msgDeliveryTime(msg[:c.pa.hdr], now)
+	if err != nil {
+		c.sendErr("Invalid Delivery Time")
+		c.Debugf("Invalid delivery time for message on %q", c.pa.subject)
+		return true
+	}
+	if !at.After(now) {
+		return false
+	}
+	id := string(getHeader(MsgIdHdr, msg[:c.pa.hdr]))
+	sm := &schedMsg{
+		ScheduledMsgInfo: ScheduledMsgInfo{
+			ID:        id,
+			Subject:   string(c.pa.subject),
+			Reply:     string(c.pa.reply),
+			Size:      c.pa.size,
+			Scheduled: now.UTC(),
+			DeliverAt: at.UTC(),
+		},
+		acc: c.acc,
+		hdr: c.pa.hdr,
+		// Without the CR_LF.
+		msg: append([]byte(nil), msg[:len(msg)-LEN_CR_LF]...),
+	}
+	switch err := c.srv.scheduler().add(sm, now); err {
+	case nil:
+		c.Debugf("Scheduled message %q on %q for %v", sm.ID, sm.Subject, sm.DeliverAt)
+	case ErrDuplicateScheduledMsg:
+		c.Debugf("Dropping duplicate scheduled message %q on %q", id, sm.Subject)
+	case ErrTooManyScheduledMsgs:
+		c.sendErr("Maximum Scheduled Messages Exceeded")
+		c.Debugf("Maximum scheduled messages exceeded for account %q", c.acc.Name)
+	}
+	return true
+}
+
+// Adds a message to the wheel, at the slot of the tick it is due.
+func (ms *msgScheduler) add(sm *schedMsg, now time.Time) error {
+	acc := sm.acc
+	acc.mu.RLock()
+	max := int(acc.msched)
+	acc.mu.RUnlock()
+
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+
+	msgs := ms.msgs[acc.Name]
+	if max >= 0 && len(msgs) >= max {
+		return ErrTooManyScheduledMsgs
+	}
+	if sm.ID == _EMPTY_ {
+		sm.ID = nuid.Next()
+	} else if msgs[sm.ID] != nil {
+		return ErrDuplicateScheduledMsg
+	}
+	if msgs == nil {
+		msgs = make(map[string]*schedMsg)
+		ms.msgs[acc.Name] = msgs
+	}
+	msgs[sm.ID] = sm
+
+	if !ms.running {
+		ms.running = true
+		ms.last = now
+		ms.srv.startGoRoutine(ms.run)
+	}
+	// Count the ticks from the last one, so that the message is never
+	// delivered early.
+	ticks := int((sm.DeliverAt.Sub(ms.last) + ms.tick - 1) / ms.tick)
+	if ticks < 1 {
+		ticks = 1
+	}
+	sm.slot = (ms.pos + ticks) % schedWheelSlots
+	sm.rounds = (ticks - 1) / schedWheelSlots
+	ms.slots[sm.slot] = append(ms.slots[sm.slot], sm)
+	return nil
+}
+
+// Removes a message from the wheel.
+// Lock should be held.
+func (ms *msgScheduler) remove(sm *schedMsg) {
+	msgs := ms.msgs[sm.acc.Name]
+	delete(msgs, sm.ID)
+	if len(msgs) == 0 {
+		delete(ms.msgs, sm.acc.Name)
+	}
+	slot := ms.slots[sm.slot]
+	for i, osm := range slot {
+		if osm == sm {
+			last := len(slot) - 1
+			slot[i] = slot[last]
+			slot[last] = nil
+			ms.slots[sm.slot] = slot[:last]
+			break
+		}
+	}
+}
+
+// Turns the wheel while there are scheduled messages, delivering the
+// messages in the slot of each tick that are due.
+func (ms *msgScheduler) run() {
+	s := ms.srv
+	defer s.grWG.Done()
+
+	t := time.NewTicker(ms.tick)
+	defer t.Stop()
+	for {
+		select {
+		case <-t.C:
+		case <-s.quitCh:
+			return
+		}
+		ms.mu.Lock()
+		ms.pos = (ms.pos + 1) % schedWheelSlots
+		ms.last = ms.last.Add(ms.tick)
+		var due []*schedMsg
+		for _, sm := range ms.slots[ms.pos] {
+			if sm.rounds == 0 {
+				due = append(due, sm)
+			} else {
+				sm.rounds--
+			}
+		}
+		for _, sm := range due {
+			ms.remove(sm)
+		}
+		ms.mu.Unlock()
+
+		sort.Slice(due, func(i, j int) bool { return due[i].DeliverAt.Before(due[j].DeliverAt) })
+		for _, sm := range due {
+			ms.deliver(sm)
+		}
+
+		// Stop turning once there is nothing left to deliver.
+		ms.mu.Lock()
+		if len(ms.msgs) == 0 {
+			ms.running = false
+			ms.mu.Unlock()
+			return
+		}
+		ms.mu.Unlock()
+	}
+}
+
+// Publishes a message that is due with the internal client of its
+// account. Only called from the wheel's go routine.
+func (ms *msgScheduler) deliver(sm *schedMsg) {
+	c := ms.clients[sm.acc.Name]
+	if c == nil || c.acc != sm.acc {
+		c = &client{srv: ms.srv, kind: SYSTEM, opts: internalOpts, msubs: -1, mpay: -1, start: time.Now(), last: time.Now()}
+		c.initClient()
+		if err := c.registerWithAccount(sm.acc); err != nil {
+			ms.srv.Errorf("Error delivering scheduled message %q: %v", sm.ID, err)
+			return
+		}
+		ms.clients[sm.acc.Name] = c
+	}
+	c.pa.subject = []byte(sm.Subject)
+	c.pa.reply = []byte(sm.Reply)
+	c.pa.size = len(sm.msg)
+	c.pa.szb = []byte(strconv.Itoa(len(sm.msg)))
+	c.pa.hdr = sm.hdr
+	c.pa.hdb = []byte(strconv.Itoa(sm.hdr))
+	c.processInboundClientMsg(append(sm.msg, _CRLF_...))
+	c.pa.hdr, c.pa.hdb = 0, nil
+	c.flushClients(0)
+}
+
+// Returns the messages scheduled in the account, by delivery time.
+func (ms *msgScheduler) list(acc string) []*ScheduledMsgInfo {
+	ms.mu.Lock()
+	var infos []*ScheduledMsgInfo
+	for _, sm := range ms.msgs[acc] {
+		info := sm.ScheduledMsgInfo
+		infos = append(infos, &info)
+	}
+	ms.mu.Unlock()
+	sort.Slice(infos, func(i, j int) bool {
+		if infos[i].DeliverAt.Equal(infos[j].DeliverAt) {
+			return infos[i].ID < infos[j].ID
+		}
+		return infos[i].DeliverAt.Before(infos[j].DeliverAt)
+	})
+	return infos
+}
+
+// Cancels the messages with the given IDs scheduled in the account and
+// returns the IDs of those that were found.
+func (ms *msgScheduler) cancel(acc string, ids []string) []string {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+	var canceled []string
+	for _, id := range ids {
+		if sm := ms.msgs[acc][id]; sm != nil {
+			ms.remove(sm)
+			canceled = append(canceled, id)
+		}
+	}
+	return canceled
+}
+
+// ScheduledMsgs returns the messages waiting to be delivered in the
+// account on this server.
+func (s *Server) ScheduledMsgs(acc *Account) []*ScheduledMsgInfo {
+	return s.scheduler().list(acc.Name)
+}
+
+// CancelScheduledMsgs cancels the delivery of the messages with the given
+// IDs in the account, and returns the IDs of those that were canceled.
+func (s *Server) CancelScheduledMsgs(acc *Account, ids ...string) []string {
+	return s.scheduler().cancel(acc.Name, ids)
+}
+
+// schedReq is a request to list the scheduled messages of an account.
+func (s *Server) schedReq(sub *subscription, subject, reply string, msg []byte) {
+	s.schedAPIReq(subject, reply, msg, false)
+}
+
+// schedCancelReq is a request to cancel scheduled messages of an account.
+func (s *Server) schedCancelReq(sub *subscription, subject, reply string, msg []byte) {
+	s.schedAPIReq(subject, reply, msg, true)
+}
+
+// Responds to requests on the scheduled messages of an account, if the
+// account is known to this server.
+func (s *Server) schedAPIReq(subject, reply string, msg []byte, cancel bool) {
+	if !s.eventsRunning() || reply == _EMPTY_ {
+		return
+	}
+	toks := strings.Split(subject, tsep)
+	if len(toks) < accReqTokens {
+		s.sys.client.Errorf("Received scheduled messages request on wrong subject: %q", subject)
+		return
+	}
+	name := toks[accReqAccIndex]
+	if _, ok := s.accounts.Load(name); !ok {
+		return
+	}
+	response := &ServerAPIResponse{Server: &ServerInfo{}}
+	sched := &ScheduledMsgs{Account: name}
+	if cancel {
+		req := &ScheduledMsgsCancel{}
+		if err := json.Unmarshal(msg, req); err != nil {
+			response.Error = fmt.Sprintf("invalid request: %v", err)
+		} else {
+			sched.Canceled = s.scheduler().cancel(name, req.IDs)
+		}
+	} else {
+		sched.Msgs = s.scheduler().list(name)
+	}
+	if response.Error == _EMPTY_ {
+		response.Data = sched
+	}
+	s.mu.Lock()
+	s.sendInternalMsg(reply, _EMPTY_, response.Server, response)
+	s.mu.Unlock()
+}
@@ -0,0 +1,258 @@
+// Copyright 2019 The NATS Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package server
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/nats-io/nats.go"
+)
+
+func TestMsgDeliveryTime(t *testing.T) {
+	now := time.Now()
+	at := now.Add(time.Hour).UTC().Truncate(time.Second)
+	for _, test := range []struct {
+		hdr      string
+		expected time.Time
+		err      bool
+	}{
+		{"NATS/1.0\r\n\r\n", time.Time{}, false},
+		{fmt.Sprintf("NATS/1.0\r\n%s: %s\r\n\r\n", DeliverAtHdr, at.Format(time.RFC3339)), at, false},
+		{fmt.Sprintf("NATS/1.0\r\n%s: 90s\r\n\r\n", DeliverAfterHdr), now.Add(90 * time.Second), false},
+		// The delivery time takes precedence.
+		{fmt.Sprintf("NATS/1.0\r\n%s: 1s\r\n%s: %s\r\n\r\n", DeliverAfterHdr, DeliverAtHdr, at.Format(time.RFC3339)), at, false},
+		{fmt.Sprintf("NATS/1.0\r\n%s: tomorrow\r\n\r\n", DeliverAtHdr), time.Time{}, true},
+		{fmt.Sprintf("NATS/1.0\r\n%s: -1s\r\n\r\n", DeliverAfterHdr), time.Time{}, true},
+	} {
+		dt, err := msgDeliveryTime([]byte(test.hdr), now)
+		if test.err != (err != nil) {
+			t.Fatalf("Unexpected error for %q: %v", test.hdr, err)
+		}
+		if !dt.Equal(test.expected) {
+			t.Fatalf("Expected %v for %q, got %v", test.expected, test.hdr, dt)
+		}
+	}
+}
+
+// Returns an HPUB protocol for a message with the given headers.
+func hpubProto(subject, payload string, hdrs ...string) string {
+	hdr := "NATS/1.0\r\n"
+	for i := 0; i+1 < len(hdrs); i += 2 {
+		hdr += fmt.Sprintf("%s: %s\r\n", hdrs[i], hdrs[i+1])
+	}
+	hdr += "\r\n"
+	return fmt.Sprintf("HPUB %s %d %d\r\n%s%s\r\n", subject, len(hdr), len(hdr)+len(payload), hdr, payload)
+}
+
+// Reads protocol lines until a PONG, and returns the lines read before it.
+func readUntilPong(t *testing.T, cr *bufio.Reader) []string {
+	t.Helper()
+	var lines []string
+	for {
+		l, err := cr.ReadString('\n')
+		if err != nil {
+			t.Fatalf("Error reading: %v", err)
+		}
+		if strings.HasPrefix(l, "PONG") {
+			return lines
+		}
+		lines = append(lines, l)
+	}
+}
+
+func TestScheduledDelivery(t *testing.T) {
+	s := RunServer(DefaultOptions())
+	defer s.Shutdown()
+
+	acc := s.globalAccount()
+	c, cr, _ := newClientForServer(s)
+	defer c.nc.Close()
+	ch := make(chan string, 10)
+	c.parse([]byte("CONNECT {\"headers\":true,\"verbose\":false}\r\nSUB foo 1\r\n"))
+
+	start := time.Now()
+	pubs := hpubProto("foo", "later", DeliverAfterHdr, "300ms") +
+		hpubProto("foo", "never", DeliverAfterHdr, "1h", MsgIdHdr, "cancel-me") +
+		hpubProto("foo", "dup", DeliverAfterHdr, "1h", MsgIdHdr, "cancel-me") +
+		hpubProto("foo", "now", DeliverAfterHdr, "0s") +
+		hpubProto("foo", "bad", DeliverAfterHdr, "soon")
+	done := make(chan struct{})
+	go func() {
+		c.parse([]byte(pubs + "PING\r\n"))
+		close(done)
+	}()
+	lines := readUntilPong(t, cr)
+	<-done
+	if n := len(lines); n != 6 || !strings.HasPrefix(lines[0], "HMSG foo 1") || lines[n-2] != "now\r\n" || !strings.Contains(lines[n-1], "Invalid Delivery Time") {
+		t.Fatalf("Unexpected protocols: %q", lines)
+	}
+
+	msgs := s.ScheduledMsgs(acc)
+	if len(msgs) != 2 || msgs[0].Subject != "foo" || msgs[1].ID != "cancel-me" || msgs[0].DeliverAt.After(msgs[1].DeliverAt) {
+		t.Fatalf("Unexpected scheduled messages: %+v", msgs)
+	}
+	if canceled := s.CancelScheduledMsgs(acc, "cancel-me", "unknown"); len(canceled) != 1 || canceled[0] != "cancel-me" {
+		t.Fatalf("Unexpected canceled messages: %v", canceled)
+	}
+
+	go func() {
+		for {
+			l, err := cr.ReadString('\n')
+			if err != nil {
+				return
+			}
+			ch <- l
+		}
+	}()
+	select {
+	case l := <-ch:
+		if !strings.HasPrefix(l, "HMSG foo 1") {
+			t.Fatalf("Unexpected protocol: %q", l)
+		}
+		if elapsed := time.Since(start); elapsed < 300*time.Millisecond {
+			t.Fatalf("Message delivered too early, after %v", elapsed)
+		}
+		// The headers are delivered as published.
+		for _, expected := range []string{"NATS/1.0\r\n", DeliverAfterHdr + ": 300ms\r\n", "\r\n", "later\r\n"} {
+			if l := <-ch; l != expected {
+				t.Fatalf("Expected %q, got %q", expected, l)
+			}
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("Scheduled message not delivered")
+	}
+	if msgs := s.ScheduledMsgs(acc); len(msgs) != 0 {
+		t.Fatalf("Expected no scheduled messages, got %+v", msgs)
+	}
+
+	// The number of scheduled messages is limited.
+	acc.SetMaxScheduledMsgs(1)
+	c.parse([]byte(hpubProto("foo", "1", DeliverAfterHdr, "1h") + hpubProto("foo", "2", DeliverAfterHdr, "1h")))
+	if l := <-ch; !strings.Contains(l, "Maximum Scheduled Messages Exceeded") {
+		t.Fatalf("Expected limit error, got %q", l)
+	}
+	// With scheduled delivery disabled, messages are delivered right away.
+	acc.SetMaxScheduledMsgs(0)
+	c.parse([]byte(hpubProto("foo", "3", DeliverAfterHdr, "1h") + "PING\r\n"))
+	if l := <-ch; !strings.HasPrefix(l, "HMSG foo 1") {
+		t.Fatalf("Expected message to be delivered, got %q", l)
+	}
+	for _, expected := range []string{"NATS/1.0\r\n", DeliverAfterHdr + ": 1h\r\n", "\r\n", "3\r\n", "PONG\r\n"} {
+		if l := <-ch; l != expected {
+			t.Fatalf("Expected %q, got %q", expected, l)
+		}
+	}
+	if msgs := s.ScheduledMsgs(acc); len(msgs) != 1 {
+		t.Fatalf("Expected 1 scheduled message, got %+v", msgs)
+	}
+}
+
+func TestScheduledMsgsSysRequests(t *testing.T) {
+	conf := createConfFile(t, []byte(`
+		listen: "127.0.0.1:-1"
+		accounts {
+			SYS { users [{user: sys, password: sys}] }
+			A { users [{user: a, password: a}] }
+		}
+		system_account: SYS
+	`))
+	defer os.Remove(conf)
+	s, opts := RunServerWithConfig(conf)
+	defer s.Shutdown()
+
+	c, cr, _ := newClientForServer(s)
+	defer c.nc.Close()
+	go c.parse([]byte("CONNECT {\"user\":\"a\",\"pass\":\"a\",\"headers\":true,\"verbose\":false}\r\n" +
+		hpubProto("foo", "1", DeliverAfterHdr, "1h", MsgIdHdr, "1") +
+		hpubProto("foo", "2", DeliverAfterHdr, "2h", MsgIdHdr, "2") + "PING\r\n"))
+	if lines := readUntilPong(t, cr); len(lines) != 0 {
+		t.Fatalf("Unexpected protocols: %q", lines)
+	}
+
+	nc, err := nats.Connect(fmt.Sprintf("nats://sys:sys@%s:%d", opts.Host, opts.Port))
+	if err != nil {
+		t.Fatalf("Error on connect: %v", err)
+	}
+	defer nc.Close()
+	request := func(subject string, req []byte) *ScheduledMsgs {
+		t.Helper()
+		msg, err := nc.Request(fmt.Sprintf(subject, "A"), req, time.Second)
+		if err != nil {
+			t.Fatalf("Error on request: %v", err)
+		}
+		sched := &ScheduledMsgs{}
+		m := ServerAPIResponse{Data: sched}
+		if err := json.Unmarshal(msg.Data, &m); err != nil {
+			t.Fatalf("Error unmarshalling response: %v", err)
+		}
+		if m.Server == nil || m.Error != _EMPTY_ || sched.Account != "A" {
+			t.Fatalf("Unexpected response: %+v", m)
+		}
+		return sched
+	}
+	sched := request(accSchedReqSubj, nil)
+	if len(sched.Msgs) != 2 || sched.Msgs[0].ID != "1" || sched.Msgs[1].ID != "2" {
+		t.Fatalf("Unexpected scheduled messages: %+v", sched.Msgs)
+	}
+	sched = request(accSchedCancelReqSubj, []byte(`{"ids":["2","3"]}`))
+	if len(sched.Canceled) != 1 || sched.Canceled[0] != "2" {
+		t.Fatalf("Unexpected canceled messages: %+v", sched.Canceled)
+	}
+	sched = request(accSchedReqSubj, nil)
+	if len(sched.Msgs) != 1 || sched.Msgs[0].ID != "1" {
+		t.Fatalf("Unexpected scheduled messages: %+v", sched.Msgs)
+	}
+
+	msg, err := nc.Request(fmt.Sprintf(accSchedCancelReqSubj, "A"), []byte("{bad"), time.Second)
+	if err != nil {
+		t.Fatalf("Error on request: %v", err)
+	}
+	m := ServerAPIResponse{}
+	if err := json.Unmarshal(msg.Data, &m); err != nil || !strings.Contains(m.Error, "invalid request") {
+		t.Fatalf("Expected error response, got %+v (%v)", m, err)
+	}
+}
+
+func TestMaxScheduledMsgsConfig(t *testing.T) {
+	conf := createConfFile(t, []byte(`
+		accounts {
+			A { max_scheduled_msgs: 10 }
+			B { max_scheduled_msgs: -1 }
+			C {}
+		}
+	`))
+	defer os.Remove(conf)
+	opts, err := ProcessConfigFile(conf)
+	if err != nil {
+		t.Fatalf("Error processing config: %v", err)
+	}
+	for _, acc := range opts.Accounts {
+		expected := map[string]int32{"A": 10, "B": -1, "C": DEFAULT_MAX_SCHEDULED_MSGS}[acc.Name]
+		if acc.msched != expected {
+			t.Fatalf("Expected %d maximum scheduled messages for %q, got %d", expected, acc.Name, acc.msched)
+		}
+	}
+
+	conf = createConfFile(t, []byte(`accounts { A { max_scheduled_msgs: "10" } }`))
+	defer os.Remove(conf)
+	if _, err := ProcessConfigFile(conf); err == nil || !strings.Contains(err.Error(), "Expected max_scheduled_msgs to be a number") {
+		t.Fatalf("Expected error for max_scheduled_msgs, got %v", err)
+	}
+}
@@ -61,6 +61,7 @@ type Info struct {
 	TLSRequired       bool     `json:"tls_required,omitempty"`
 	TLSVerify         bool     `json:"tls_verify,omitempty"`
 	MaxPayload        int32    `json:"max_payload"`
+	Headers           bool     `json:"headers,omitempty"`
 	IP                string   `json:"ip,omitempty"`
 	CID               uint64   `json:"client_id,omitempty"`
 	Nonce             string   `json:"nonce,omitempty"`
@@ -126,6 +127,21 @@ type Server struct {
 		dialTimeout time.Duration
 	}
 
+	// For persistent streams
+	streams *streamManager
+
+	// For messages scheduled for later delivery
+	sched *msgScheduler
+
+	// For the authorization callout
+	authCallout *authCallout
+
+	// For websocket clients
+	websocket srvWebsocket
+
+	// For MQTT clients
+	mqtt srvMQTT
+
 	quitCh chan struct{}
 
 	// Tracking Go routines
@@ -184,6 +200,7 @@ type stats struct {
 	inBytes       int64
 	outBytes      int64
 	slowConsumers int64
+	dupMsgs       int64
 }
 
 // New will setup a new server struct after parsing the options.
@@ -226,6 +243,7 @@ func NewServer(opts *Options) (*Server, error) {
 		TLSRequired:  tlsReq,
 		TLSVerify:    verify,
 		MaxPayload:   opts.MaxPayload,
+		Headers:      true,
 	}
 
 	now := time.Now()
@@ -323,6 +341,20 @@ func validateOptions(o *Options) error {
 	if err := validateLeafNode(o); err != nil {
 		return err
 	}
+	// Check that websocket is properly configured. Returns no error
+	// if there is no websocket listener defined.
+	if err := validateWebsocketOptions(o); err != nil {
+		return err
+	}
+	// Check that MQTT is properly configured. Returns no error
+	// if there is no MQTT listener defined.
+	if err := validateMQTTOptions(o); err != nil {
+		return err
+	}
+	// Check that the authorization callout is properly configured.
+	if err := validateAuthCallout(o); err != nil {
+		return err
+	}
 	// Check that gateway is properly configured. Returns no error
 	// if there is no gateway defined.
 	return validateGatewayOptions(o)
@@ -408,7 +440,11 @@ func (s *Server) configureAccounts() error {
 	if opts.AccountResolver != nil {
 		s.accResolver = opts.AccountResolver
 		if len(opts.resolverPreloads) > 0 {
-			if _, ok := s.accResolver.(*MemAccResolver); !ok {
+			ar := s.accResolver
+			if cr, ok := ar.(*CacheAccResolver); ok {
+				ar = cr.ar
+			}
+			if _, ok := ar.(*MemAccResolver); !ok {
 				return fmt.Errorf("resolver preloads only available for MemAccResolver")
 			}
 			for k, v := range opts.resolverPreloads {
@@ -634,8 +670,7 @@ func (s *Server) SetSystemAccount(accName string) error {
 		s.mu.Unlock()
 		return err
 	}
-	acc := s.buildInternalAccount(ac)
-	acc.claimJWT = jwt
+	acc := s.buildInternalAccount(ac, jwt)
 	s.registerAccount(acc)
 	s.mu.Unlock()
 
@@ -758,6 +793,7 @@ func (s *Server) registerAccount(acc *Account) {
 	acc.mu.Unlock()
 	s.accounts.Store(acc.Name, acc)
 	s.enableAccountTracking(acc)
+	s.enableAccountStreams(acc)
 }
 
 // lookupAccount is a function to return the account structure
@@ -841,6 +877,10 @@ func (s *Server) fetchRawAccountClaims(name string) (string, error) {
 	s.mu.Unlock()
 	start := time.Now()
 	claimJWT, err := accResolver.Fetch(name)
+	// Claims missing from our directory may be known to other servers.
+	if _, ok := accResolver.(*DirAccResolver); ok && err == ErrMissingAccount {
+		claimJWT, err = s.lookupAccountClaims(name)
+	}
 	fetchTime := time.Since(start)
 	s.mu.Lock()
 	if fetchTime > time.Second {
@@ -898,8 +938,7 @@ func (s *Server) fetchAccount(name string) (*Account, error) {
 			}
 			return acc, nil
 		}
-		acc := s.buildInternalAccount(accClaims)
-		acc.claimJWT = claimJWT
+		acc := s.buildInternalAccount(accClaims, claimJWT)
 		s.registerAccount(acc)
 		return acc, nil
 	}
@@ -967,6 +1006,27 @@ func (s *Server) Start() {
 		}
 	}
 
+	// Setup the authorization callout before clients connect.
+	if opts.AuthCallout != nil {
+		if err := s.startAuthCallout(opts.AuthCallout); err != nil {
+			s.Fatalf("Can't start authorization callout: %v", err)
+			return
+		}
+	}
+
+	// Keep the claims of active accounts up to date if they are cached.
+	if cr, ok := s.AccountResolver().(*CacheAccResolver); ok {
+		s.startAccountResolverRefresh(cr)
+	}
+
+	// Start up streams if needed.
+	if opts.Streams.Enabled {
+		if err := s.EnableStreams(); err != nil {
+			s.Fatalf("Can't start streams: %v", err)
+			return
+		}
+	}
+
 	// Start up gateway if needed. Do this before starting the routes, because
 	// we want to resolve the gateway host:port so that this information can
 	// be sent to other routes.
@@ -989,6 +1049,16 @@ func (s *Server) Start() {
 		s.solicitLeafNodeRemotes(opts.LeafNode.Remotes)
 	}
 
+	// Start up the websocket listener if needed.
+	if opts.Websocket.Port != 0 {
+		s.startWebsocketServer()
+	}
+
+	// Start up the MQTT listener if needed.
+	if opts.MQTT.Port != 0 {
+		s.startMQTT()
+	}
+
 	// The Routing routine needs to wait for the client listen
 	// port to be opened and potential ephemeral port selected.
 	clientListenReady := make(chan struct{})
@@ -1022,6 +1092,9 @@ func (s *Server) Shutdown() {
 	// eventing items associated with accounts.
 	s.shutdownEventing()
 
+	// Stop streams and persist consumer state.
+	s.shutdownStreams()
+
 	s.mu.Lock()
 	// Prevent issues with multiple calls.
 	if s.shutdown {
@@ -1080,6 +1153,21 @@ func (s *Server) Shutdown() {
 		s.leafNodeListener = nil
 	}
 
+	// Kick websocket server
+	if s.websocket.listener != nil {
+		doneExpected++
+		s.websocket.listener.Close()
+		s.websocket.listener = nil
+		s.websocket.server = nil
+	}
+
+	// Kick MQTT accept loop
+	if s.mqtt.listener != nil {
+		doneExpected++
+		s.mqtt.listener.Close()
+		s.mqtt.listener = nil
+	}
+
 	// Kick route AcceptLoop()
 	if s.routeListener != nil {
 		doneExpected++
@@ -1332,12 +1420,16 @@ func (s *Server) StartMonitoring() error {
 
 // HTTP endpoints
 const (
-	RootPath    = "/"
-	VarzPath    = "/varz"
-	ConnzPath   = "/connz"
-	RoutezPath  = "/routez"
-	SubszPath   = "/subsz"
-	StackszPath = "/stacksz"
+	RootPath     = "/"
+	VarzPath     = "/varz"
+	ConnzPath    = "/connz"
+	RoutezPath   = "/routez"
+	SubszPath    = "/subsz"
+	StackszPath  = "/stacksz"
+	GatewayzPath = "/gatewayz"
+	LeafzPath    = "/leafz"
+	AccountzPath = "/accountz"
+	MetricsPath  = "/metrics"
 )
 
 // Start the monitoring server
@@ -1347,11 +1439,15 @@ func (s *Server) startMonitoring(secure bool) error {
 
 	// Used to track HTTP requests
 	s.httpReqStats = map[string]uint64{
-		RootPath:   0,
-		VarzPath:   0,
-		ConnzPath:  0,
-		RoutezPath: 0,
-		SubszPath:  0,
+		RootPath:     0,
+		VarzPath:     0,
+		ConnzPath:    0,
+		RoutezPath:   0,
+		SubszPath:    0,
+		GatewayzPath: 0,
+		LeafzPath:    0,
+		AccountzPath: 0,
+		MetricsPath:  0,
 	}
 
 	var (
@@ -1406,6 +1502,14 @@ func (s *Server) startMonitoring(secure bool) error {
 	mux.HandleFunc("/subscriptionsz", s.HandleSubsz)
 	// Stacksz
 	mux.HandleFunc(StackszPath, s.HandleStacksz)
+	// Gatewayz
+	mux.HandleFunc(GatewayzPath, s.HandleGatewayz)
+	// Leafz
+	mux.HandleFunc(LeafzPath, s.HandleLeafz)
+	// Accountz
+	mux.HandleFunc(AccountzPath, s.HandleAccountz)
+	// Metrics
+	mux.HandleFunc(MetricsPath, s.HandleMetrics)
 
 	// Do not set a WriteTimeout because it could cause cURL/browser
 	// to return empty response or unable to display page if the
@@ -1475,11 +1579,24 @@ func (s *Server) createClient(conn net.Conn) *client {
 
 	c := &client{srv: s, nc: conn, opts: defaultOpts, mpay: maxPay, msubs: maxSubs, start: now, last: now}
 
+	// Websocket and MQTT connections have already done TLS, if any,
+	// on upgrade or accept.
+	_, isWS := conn.(*wsConn)
+	mc, isMQTT := conn.(*mqttConn)
+	c.ws = isWS
+	c.mqtt = isMQTT
+	if isMQTT {
+		mc.client = c
+	}
+
 	c.registerWithAccount(s.globalAccount())
 
 	// Grab JSON info string
 	s.mu.Lock()
 	info := s.copyInfo()
+	if isWS || isMQTT {
+		info.TLSRequired = false
+	}
 	c.nonce = []byte(info.Nonce)
 	s.totalClients++
 	s.mu.Unlock()
@@ -0,0 +1,950 @@
+// Copyright 2019 The NATS Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Request/reply API for streams and consumers. All subjects are
+// relative to the account of the requestor.
+const (
+	StreamCreateT         = "$STREAM.API.STREAM.CREATE.%s"
+	StreamInfoT           = "$STREAM.API.STREAM.INFO.%s"
+	StreamDeleteT         = "$STREAM.API.STREAM.DELETE.%s"
+	StreamPurgeT          = "$STREAM.API.STREAM.PURGE.%s"
+	StreamListSubj        = "$STREAM.API.STREAM.LIST"
+	StreamConsumerCreateT = "$STREAM.API.CONSUMER.CREATE.%s"
+	StreamConsumerInfoT   = "$STREAM.API.CONSUMER.INFO.%s.%s"
+	StreamConsumerDeleteT = "$STREAM.API.CONSUMER.DELETE.%s.%s"
+	StreamConsumerListT   = "$STREAM.API.CONSUMER.LIST.%s"
+	StreamConsumerNextT   = "$STREAM.API.CONSUMER.MSG.NEXT.%s.%s"
+
+	// Ack subject for a delivered message. The tokens are the stream,
+	// the consumer, the delivery count, the stream sequence and the
+	// consumer sequence.
+	streamAckT = "$STREAM.ACK.%s.%s.%d.%d.%d"
+
+	streamReservedPrefix = "$STREAM."
+	streamAPISubj        = "$STREAM.API.>"
+	streamAckSubj        = "$STREAM.ACK.>"
+
+	streamAckTokens = 7
+
+	// Header added to delivered messages with the original subject.
+	streamSubjectHdrLine = "Stream-Subject: %s\r\n"
+	streamSubjectHdr     = "NATS/1.0\r\n" + streamSubjectHdrLine + "\r\n"
+
+	// Name of the file holding stream and consumer configuration.
+	streamMetaFile = "meta.json"
+	// Name of the directory holding consumers of a stream.
+	streamConsumersDir = "consumers"
+)
+
+var (
+	// ErrStreamsNotEnabled is returned when streams are not enabled on the server.
+	ErrStreamsNotEnabled = errors.New("streams not enabled")
+	// ErrStreamNotFound is returned when a stream does not exist.
+	ErrStreamNotFound = errors.New("stream not found")
+	// ErrStreamExists is returned when creating a stream that already exists
+	// with a different configuration.
+	ErrStreamExists = errors.New("stream name already in use")
+	// ErrStreamNameInvalid is returned when a stream or consumer name is invalid.
+	ErrStreamNameInvalid = errors.New("stream and consumer names can not contain whitespace, '.', '*' or '>'")
+	// ErrStreamSubjectOverlap is returned when stream subjects overlap
+	// those of another stream or a consumer deliver subject.
+	ErrStreamSubjectOverlap = errors.New("subjects overlap with an existing stream or consumer")
+)
+
+// StreamConfig determines the subjects captured by a stream and its
+// retention limits. A zero limit means unlimited.
+type StreamConfig struct {
+	Name     string        `json:"name"`
+	Subjects []string      `json:"subjects,omitempty"`
+	MaxMsgs  int64         `json:"max_msgs,omitempty"`
+	MaxBytes int64         `json:"max_bytes,omitempty"`
+	MaxAge   time.Duration `json:"max_age,omitempty"`
+}
+
+// StreamState is the state of the messages held by a stream.
+type StreamState struct {
+	Msgs      uint64    `json:"messages"`
+	Bytes     uint64    `json:"bytes"`
+	FirstSeq  uint64    `json:"first_seq"`
+	FirstTime time.Time `json:"first_ts"`
+	LastSeq   uint64    `json:"last_seq"`
+	LastTime  time.Time `json:"last_ts"`
+	Consumers int       `json:"consumer_count"`
+}
+
+// StreamInfo is returned by stream create and info requests.
+type StreamInfo struct {
+	Config  StreamConfig `json:"config"`
+	Created time.Time    `json:"created"`
+	State   StreamState  `json:"state"`
+}
+
+// StreamAPIResponse is the reply to all requests on the stream API.
+type StreamAPIResponse struct {
+	Error     string        `json:"error,omitempty"`
+	Stream    *StreamInfo   `json:"stream,omitempty"`
+	Consumer  *ConsumerInfo `json:"consumer,omitempty"`
+	Streams   []string      `json:"streams,omitempty"`
+	Consumers []string      `json:"consumers,omitempty"`
+	Purged    uint64        `json:"purged,omitempty"`
+}
+
+// StreamPubAck is sent to the reply subject of a message captured by a stream.
+type StreamPubAck struct {
+	Stream string `json:"stream"`
+	Seq    uint64 `json:"seq,omitempty"`
+	Error  string `json:"error,omitempty"`
+}
+
+// Persisted configuration of a stream.
+type streamMeta struct {
+	Config  StreamConfig `json:"config"`
+	Created time.Time    `json:"created"`
+}
+
+// streamManager holds the streams for all accounts on this server
+// and serializes all messages sent by streams and consumers.
+type streamManager struct {
+	mu       sync.Mutex
+	srv      *Server
+	storeDir string
+	accounts map[string]*accountStreams
+	qmu      sync.Mutex
+	queue    []*streamPubMsg
+	qch      chan struct{}
+	quit     chan struct{}
+	wg       sync.WaitGroup
+}
+
+// Used to queue messages to be sent by the internal client of an account.
+type streamPubMsg struct {
+	c     *client
+	subj  string
+	reply string
+	hdr   []byte
+	msg   []byte
+}
+
+// accountStreams holds the streams of a given account along with the
+// internal client used to subscribe and publish in that account.
+type accountStreams struct {
+	mu      sync.Mutex
+	sm      *streamManager
+	acc     *Account
+	client  *client
+	sid     uint64
+	streams map[string]*Stream
+	dir     string
+}
+
+// Stream is a named set of subjects whose messages are persisted.
+type Stream struct {
+	mu        sync.Mutex
+	as        *accountStreams
+	config    StreamConfig
+	created   time.Time
+	store     *fileStore
+	subs      []*subscription
+	consumers map[string]*Consumer
+	closed    bool
+}
+
+// EnableStreams will start the streams subsystem, restoring any
+// streams and consumers found in the store directory.
+func (s *Server) EnableStreams() error {
+	opts := s.getOpts()
+	dir := opts.Streams.StoreDir
+	if dir == _EMPTY_ {
+		// Not the temporary directory, which may be cleaned up by the system.
+		dir = DEFAULT_STREAMS_STORE_DIR
+	}
+	// Resolve it now so the location is clear from the logs.
+	if adir, err := filepath.Abs(dir); err == nil {
+		dir = adir
+	}
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return fmt.Errorf("could not create streams store directory %q: %v", dir, err)
+	}
+
+	s.mu.Lock()
+	if s.streams != nil {
+		s.mu.Unlock()
+		return nil
+	}
+	sm := &streamManager{
+		srv:      s,
+		storeDir: dir,
+		accounts: make(map[string]*accountStreams),
+		qch:      make(chan struct{}, 1),
+		quit:     make(chan struct{}),
+	}
+	s.streams = sm
+	s.mu.Unlock()
+
+	s.Noticef("Starting streams, store directory %q", dir)
+
+	sm.wg.Add(1)
+	go sm.internalSendLoop()
+
+	// Enable all accounts that we know about.
+	var accounts []*Account
+	s.accounts.Range(func(k, v interface{}) bool {
+		accounts = append(accounts, v.(*Account))
+		return true
+	})
+	for _, acc := range accounts {
+		if _, err := sm.enableAccount(acc); err != nil {
+			s.Errorf("Error enabling streams for account %q: %v", acc.Name, err)
+		}
+	}
+	return nil
+}
+
+// StreamsEnabled reports if streams are enabled on this server.
+func (s *Server) StreamsEnabled() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.streams != nil
+}
+
+// Stop all streams and consumers and the internal send loop.
+func (s *Server) shutdownStreams() {
+	s.mu.Lock()
+	sm := s.streams
+	s.streams = nil
+	s.mu.Unlock()
+	if sm == nil {
+		return
+	}
+	sm.mu.Lock()
+	var all []*accountStreams
+	for _, as := range sm.accounts {
+		all = append(all, as)
+	}
+	sm.mu.Unlock()
+	for _, as := range all {
+		as.mu.Lock()
+		for _, mset := range as.streams {
+			mset.stop(false)
+		}
+		as.mu.Unlock()
+	}
+	close(sm.quit)
+	sm.wg.Wait()
+}
+
+// Called when an account is registered after streams have been enabled.
+// Lock should be held on entry.
+func (s *Server) enableAccountStreams(acc *Account) {
+	sm := s.streams
+	if sm == nil {
+		return
+	}
+	// The internal client registration takes the server lock.
+	go func() {
+		if _, err := sm.enableAccount(acc); err != nil {
+			s.Errorf("Error enabling streams for account %q: %v", acc.Name, err)
+		}
+	}()
+}
+
+// Lookup the streams for a given account.
+func (s *Server) lookupAccountStreams(acc *Account) (*accountStreams, error) {
+	s.mu.Lock()
+	sm := s.streams
+	s.mu.Unlock()
+	if sm == nil {
+		return nil, ErrStreamsNotEnabled
+	}
+	return sm.enableAccount(acc)
+}
+
+// AddStream will create a stream in the given account.
+func (s *Server) AddStream(acc *Account, config *StreamConfig) (*Stream, error) {
+	as, err := s.lookupAccountStreams(acc)
+	if err != nil {
+		return nil, err
+	}
+	return as.addStream(config, time.Now().UTC())
+}
+
+// LookupStream returns the stream with the given name in the account.
+func (s *Server) LookupStream(acc *Account, name string) (*Stream, error) {
+	as, err := s.lookupAccountStreams(acc)
+	if err != nil {
+		return nil, err
+	}
+	return as.lookupStream(name)
+}
+
+// enableAccount sets up the internal client and API subscriptions for the
+// account and restores any streams from the store directory.
+func (sm *streamManager) enableAccount(acc *Account) (*accountStreams, error) {
+	sm.mu.Lock()
+	if as := sm.accounts[acc.Name]; as != nil {
+		sm.mu.Unlock()
+		return as, nil
+	}
+	s := sm.srv
+	c := &client{srv: s, kind: SYSTEM, opts: internalOpts, msubs: -1, mpay: -1, start: time.Now(), last: time.Now()}
+	c.initClient()
+	c.echo = false
+	// Keep the headers of captured messages so they are stored and replayed.
+	c.headers = true
+	as := &accountStreams{
+		sm:      sm,
+		acc:     acc,
+		client:  c,
+		streams: make(map[string]*Stream),
+		dir:     filepath.Join(sm.storeDir, acc.Name),
+	}
+	sm.accounts[acc.Name] = as
+	sm.mu.Unlock()
+
+	if err := c.registerWithAccount(acc); err != nil {
+		return nil, err
+	}
+	if _, err := as.subscribe(streamAPISubj, as.processAPIRequest); err != nil {
+		return nil, err
+	}
+	if _, err := as.subscribe(streamAckSubj, as.processAck); err != nil {
+		return nil, err
+	}
+	as.restore()
+	return as, nil
+}
+
+// Create an internal subscription in the account.
+func (as *accountStreams) subscribe(subject string, cb msgHandler) (*subscription, error) {
+	return as.subscribeInternal(subject, cb, nil)
+}
+
+// Create an internal subscription in the account whose callback receives
+// the message headers.
+func (as *accountStreams) subscribeWithHeaders(subject string, cb msgHdrHandler) (*subscription, error) {
+	return as.subscribeInternal(subject, nil, cb)
+}
+
+func (as *accountStreams) subscribeInternal(subject string, cb msgHandler, hcb msgHdrHandler) (*subscription, error) {
+	c := as.client
+	// Use a different namespace than the system client so that messages
+	// received before the callback is set are not misrouted.
+	sid := "S" + strconv.FormatUint(atomic.AddUint64(&as.sid, 1), 10)
+	if err := c.processSub([]byte(subject + " " + sid)); err != nil {
+		return nil, err
+	}
+	c.mu.Lock()
+	sub := c.subs[sid]
+	if sub != nil {
+		sub.icb, sub.hicb = cb, hcb
+	}
+	c.mu.Unlock()
+	if sub == nil {
+		return nil, fmt.Errorf("could not subscribe to %q", subject)
+	}
+	return sub, nil
+}
+
+// Remove an internal subscription.
+func (as *accountStreams) unsubscribe(sub *subscription) {
+	if sub == nil {
+		return
+	}
+	as.client.processUnsub(sub.sid)
+}
+
+// Queue a message to be sent by the internal client of the account.
+func (as *accountStreams) sendInternalMsg(subj, reply string, hdr, msg []byte) {
+	sm := as.sm
+	sm.qmu.Lock()
+	sm.queue = append(sm.queue, &streamPubMsg{as.client, subj, reply, hdr, msg})
+	sm.qmu.Unlock()
+	select {
+	case sm.qch <- struct{}{}:
+	default:
+	}
+}
+
+// Marshal and queue a response.
+func (as *accountStreams) sendResponse(reply string, v interface{}) {
+	if reply == _EMPTY_ {
+		return
+	}
+	b, _ := json.Marshal(v)
+	as.sendInternalMsg(reply, _EMPTY_, nil, b)
+}
+
+// internalSendLoop will send all messages queued by streams and
+// consumers. Messages are queued without blocking since they may be
+// produced while processing an inbound message.
+func (sm *streamManager) internalSendLoop() {
+	defer sm.wg.Done()
+	for {
+		select {
+		case <-sm.qch:
+		case <-sm.quit:
+			return
+		}
+		sm.qmu.Lock()
+		queue := sm.queue
+		sm.queue = nil
+		sm.qmu.Unlock()
+
+		for _, pm := range queue {
+			c := pm.c
+			msg := make([]byte, 0, len(pm.hdr)+len(pm.msg)+LEN_CR_LF)
+			msg = append(msg, pm.hdr...)
+			msg = append(msg, pm.msg...)
+			c.pa.subject = []byte(pm.subj)
+			c.pa.reply = []byte(pm.reply)
+			c.pa.size = len(msg)
+			c.pa.szb = []byte(strconv.Itoa(len(msg)))
+			if len(pm.hdr) > 0 {
+				c.pa.hdr = len(pm.hdr)
+				c.pa.hdb = []byte(strconv.Itoa(len(pm.hdr)))
+			}
+			msg = append(msg, _CRLF_...)
+			c.processInboundClientMsg(msg)
+			c.pa.hdr, c.pa.hdb = 0, nil
+			c.flushClients(0)
+		}
+	}
+}
+
+// Check that a stream or consumer name is a single valid token.
+func validStreamName(name string) bool {
+	if name == _EMPTY_ {
+		return false
+	}
+	return !strings.ContainsAny(name, " \t\r\n.*>")
+}
+
+// Checks and sets defaults for a stream configuration.
+func checkStreamConfig(config *StreamConfig) (StreamConfig, error) {
+	cfg := *config
+	if !validStreamName(cfg.Name) {
+		return cfg, ErrStreamNameInvalid
+	}
+	if len(cfg.Subjects) == 0 {
+		cfg.Subjects = []string{cfg.Name}
+	}
+	for i, subj := range cfg.Subjects {
+		if !IsValidSubject(subj) {
+			return cfg, fmt.Errorf("invalid subject %q", subj)
+		}
+		if strings.HasPrefix(subj, streamReservedPrefix) {
+			return cfg, fmt.Errorf("subject %q is reserved", subj)
+		}
+		for _, osubj := range cfg.Subjects[:i] {
+			if subjectsCollide(subj, osubj) {
+				return cfg, fmt.Errorf("duplicate or overlapping subjects %q and %q", osubj, subj)
+			}
+		}
+	}
+	if cfg.MaxMsgs < 0 || cfg.MaxBytes < 0 || cfg.MaxAge < 0 {
+		return cfg, fmt.Errorf("stream limits can not be negative")
+	}
+	return cfg, nil
+}
+
+// Checks if the subject collides with any stream subjects or any
+// consumer deliver subjects in the account.
+// Lock should be held.
+func (as *accountStreams) subjectInUse(subj string, skip *Stream) bool {
+	for _, mset := range as.streams {
+		if mset == skip {
+			continue
+		}
+		mset.mu.Lock()
+		for _, ssubj := range mset.config.Subjects {
+			if subjectsCollide(subj, ssubj) {
+				mset.mu.Unlock()
+				return true
+			}
+		}
+		for _, o := range mset.consumers {
+			if ds := o.config.DeliverSubject; ds != _EMPTY_ && subjectsCollide(subj, ds) {
+				mset.mu.Unlock()
+				return true
+			}
+		}
+		mset.mu.Unlock()
+	}
+	return false
+}
+
+// addStream creates a new stream. Creating a stream that already
+// exists with the same configuration is not an error.
+func (as *accountStreams) addStream(config *StreamConfig, created time.Time) (*Stream, error) {
+	if config == nil {
+		return nil, fmt.Errorf("stream configuration missing")
+	}
+	cfg, err := checkStreamConfig(config)
+	if err != nil {
+		return nil, err
+	}
+
+	as.mu.Lock()
+	defer as.mu.Unlock()
+	if mset := as.streams[cfg.Name]; mset != nil {
+		if mset.sameConfig(&cfg) {
+			return mset, nil
+		}
+		return nil, ErrStreamExists
+	}
+	for _, subj := range cfg.Subjects {
+		if as.subjectInUse(subj, nil) {
+			return nil, ErrStreamSubjectOverlap
+		}
+	}
+
+	dir := filepath.Join(as.dir, cfg.Name)
+	fs, err := newFileStore(dir, cfg)
+	if err != nil {
+		return nil, err
+	}
+	mset := &Stream{
+		as:        as,
+		config:    cfg,
+		created:   created,
+		store:     fs,
+		consumers: make(map[string]*Consumer),
+	}
+	if err := writeStreamMeta(dir, &streamMeta{Config: cfg, Created: created}); err != nil {
+		fs.delete()
+		return nil, err
+	}
+	for _, subj := range cfg.Subjects {
+		sub, err := as.subscribeWithHeaders(subj, mset.processInboundMsg)
+		if err != nil {
+			mset.unsubscribeAll()
+			fs.delete()
+			return nil, err
+		}
+		mset.subs = append(mset.subs, sub)
+	}
+	as.streams[cfg.Name] = mset
+	return mset, nil
+}
+
+// lookupStream returns the stream with the given name.
+func (as *accountStreams) lookupStream(name string) (*Stream, error) {
+	as.mu.Lock()
+	mset := as.streams[name]
+	as.mu.Unlock()
+	if mset == nil {
+		return nil, ErrStreamNotFound
+	}
+	return mset, nil
+}
+
+// Returns the sorted names of all streams in the account.
+func (as *accountStreams) streamNames() []string {
+	as.mu.Lock()
+	names := make([]string, 0, len(as.streams))
+	for name := range as.streams {
+		names = append(names, name)
+	}
+	as.mu.Unlock()
+	sort.Strings(names)
+	return names
+}
+
+// Restore all streams and consumers from the account's store directory.
+func (as *accountStreams) restore() {
+	s := as.sm.srv
+	fis, _ := ioutil.ReadDir(as.dir)
+	for _, fi := range fis {
+		if !fi.IsDir() {
+			continue
+		}
+		dir := filepath.Join(as.dir, fi.Name())
+		var meta streamMeta
+		if err := readStreamMeta(dir, &meta); err != nil {
+			s.Warnf("Could not restore stream from %q: %v", dir, err)
+			continue
+		}
+		mset, err := as.addStream(&meta.Config, meta.Created)
+		if err != nil {
+			s.Warnf("Could not restore stream %q: %v", meta.Config.Name, err)
+			continue
+		}
+		mset.restoreConsumers()
+		s.Debugf("Restored stream %q in account %q", meta.Config.Name, as.acc.Name)
+	}
+}
+
+func writeStreamMeta(dir string, v interface{}) error {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+	b, err := json.MarshalIndent(v, _EMPTY_, "  ")
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(filepath.Join(dir, streamMetaFile), b, 0644)
+}
+
+func readStreamMeta(dir string, v interface{}) error {
+	b, err := ioutil.ReadFile(filepath.Join(dir, streamMetaFile))
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(b, v)
+}
+
+// Name returns the name of the stream.
+func (mset *Stream) Name() string {
+	mset.mu.Lock()
+	defer mset.mu.Unlock()
+	return mset.config.Name
+}
+
+// Config returns the configuration of the stream.
+func (mset *Stream) Config() StreamConfig {
+	mset.mu.Lock()
+	defer mset.mu.Unlock()
+	return mset.config
+}
+
+// State returns the current state of the stream.
+func (mset *Stream) State() StreamState {
+	state := mset.store.state()
+	mset.mu.Lock()
+	state.Consumers = len(mset.consumers)
+	mset.mu.Unlock()
+	return state
+}
+
+// Info returns the configuration and state of the stream.
+func (mset *Stream) Info() *StreamInfo {
+	mset.mu.Lock()
+	info := &StreamInfo{Config: mset.config, Created: mset.created}
+	mset.mu.Unlock()
+	info.State = mset.State()
+	return info
+}
+
+// Purge will remove all messages from the stream.
+func (mset *Stream) Purge() (uint64, error) {
+	purged, err := mset.store.purge()
+	if err != nil {
+		return 0, err
+	}
+	mset.mu.Lock()
+	for _, o := range mset.consumers {
+		o.purged()
+	}
+	mset.mu.Unlock()
+	return purged, nil
+}
+
+// Delete will remove the stream, its consumers and all stored messages.
+func (mset *Stream) Delete() error {
+	as := mset.as
+	as.mu.Lock()
+	if as.streams[mset.config.Name] == mset {
+		delete(as.streams, mset.config.Name)
+	}
+	as.mu.Unlock()
+	return mset.stop(true)
+}
+
+// Stop the stream, and remove all files if requested.
+func (mset *Stream) stop(remove bool) error {
+	mset.mu.Lock()
+	if mset.closed {
+		mset.mu.Unlock()
+		return nil
+	}
+	mset.closed = true
+	consumers := make([]*Consumer, 0, len(mset.consumers))
+	for _, o := range mset.consumers {
+		consumers = append(consumers, o)
+	}
+	mset.mu.Unlock()
+
+	mset.unsubscribeAll()
+	for _, o := range consumers {
+		o.stop(false)
+	}
+	if remove {
+		return mset.store.delete()
+	}
+	return mset.store.stop()
+}
+
+// Lock should NOT be held.
+func (mset *Stream) unsubscribeAll() {
+	mset.mu.Lock()
+	subs := mset.subs
+	mset.subs = nil
+	mset.mu.Unlock()
+	for _, sub := range subs {
+		mset.as.unsubscribe(sub)
+	}
+}
+
+// Lock should be held.
+func (mset *Stream) sameConfig(cfg *StreamConfig) bool {
+	mc := &mset.config
+	if mc.MaxMsgs != cfg.MaxMsgs || mc.MaxBytes != cfg.MaxBytes || mc.MaxAge != cfg.MaxAge {
+		return false
+	}
+	if len(mc.Subjects) != len(cfg.Subjects) {
+		return false
+	}
+	for i, subj := range mc.Subjects {
+		if cfg.Subjects[i] != subj {
+			return false
+		}
+	}
+	return true
+}
+
+// processInboundMsg is the internal callback for messages captured by the
+// stream. The message is stored and consumers are signaled.
+func (mset *Stream) processInboundMsg(sub *subscription, subject, reply string, hdr, msg []byte) {
+	// Never capture our own API or ack traffic.
+	if strings.HasPrefix(subject, streamReservedPrefix) {
+		return
+	}
+	mset.mu.Lock()
+	name := mset.config.Name
+	closed := mset.closed
+	mset.mu.Unlock()
+	if closed {
+		return
+	}
+
+	seq, err := mset.store.storeMsg(subject, hdr, msg)
+	if reply != _EMPTY_ && !strings.HasPrefix(reply, streamReservedPrefix) {
+		pa := &StreamPubAck{Stream: name, Seq: seq}
+		if err != nil {
+			pa.Error = err.Error()
+		}
+		mset.as.sendResponse(reply, pa)
+	}
+	if err != nil {
+		mset.as.sm.srv.Warnf("Error storing message for stream %q: %v", name, err)
+		return
+	}
+	mset.signalConsumers()
+}
+
+// Wake up all consumers since new messages are available.
+func (mset *Stream) signalConsumers() {
+	mset.mu.Lock()
+	for _, o := range mset.consumers {
+		o.signal()
+	}
+	mset.mu.Unlock()
+}
+
+// processAPIRequest handles all requests on the stream API subjects.
+func (as *accountStreams) processAPIRequest(sub *subscription, subject, reply string, msg []byte) {
+	tokens := strings.Split(subject, tsep)
+	if len(tokens) < 4 {
+		return
+	}
+	var resp StreamAPIResponse
+	var err error
+
+	switch tokens[2] {
+	case "STREAM":
+		err = as.processStreamRequest(tokens[3:], msg, &resp)
+	case "CONSUMER":
+		if tokens[3] == "MSG" {
+			// Pull requests reply with messages, not a response.
+			if err = as.processNextMsgRequest(tokens[4:], reply, msg); err == nil {
+				return
+			}
+		} else {
+			err = as.processConsumerRequest(tokens[3:], msg, &resp)
+		}
+	default:
+		err = fmt.Errorf("unknown stream API request %q", subject)
+	}
+	if err != nil {
+		resp = StreamAPIResponse{Error: err.Error()}
+	}
+	as.sendResponse(reply, &resp)
+}
+
+// Handles $STREAM.API.STREAM.<OP>[.<name>] requests.
+func (as *accountStreams) processStreamRequest(tokens []string, msg []byte, resp *StreamAPIResponse) error {
+	op := tokens[0]
+	if op == "LIST" {
+		resp.Streams = as.streamNames()
+		return nil
+	}
+	if len(tokens) != 2 {
+		return fmt.Errorf("stream name required for %s request", op)
+	}
+	name := tokens[1]
+	switch op {
+	case "CREATE":
+		var cfg StreamConfig
+		if len(msg) > 0 {
+			if err := json.Unmarshal(msg, &cfg); err != nil {
+				return fmt.Errorf("invalid stream configuration: %v", err)
+			}
+		}
+		if cfg.Name == _EMPTY_ {
+			cfg.Name = name
+		} else if cfg.Name != name {
+			return fmt.Errorf("stream name in subject does not match request")
+		}
+		mset, err := as.addStream(&cfg, time.Now().UTC())
+		if err != nil {
+			return err
+		}
+		resp.Stream = mset.Info()
+	case "INFO":
+		mset, err := as.lookupStream(name)
+		if err != nil {
+			return err
+		}
+		resp.Stream = mset.Info()
+	case "DELETE":
+		mset, err := as.lookupStream(name)
+		if err != nil {
+			return err
+		}
+		if err := mset.Delete(); err != nil {
+			return err
+		}
+	case "PURGE":
+		mset, err := as.lookupStream(name)
+		if err != nil {
+			return err
+		}
+		purged, err := mset.Purge()
+		if err != nil {
+			return err
+		}
+		resp.Purged = purged
+		resp.Stream = mset.Info()
+	default:
+		return fmt.Errorf("unknown stream request %q", op)
+	}
+	return nil
+}
+
+// Handles $STREAM.API.CONSUMER.<OP>.<stream>[.<consumer>] requests.
+func (as *accountStreams) processConsumerRequest(tokens []string, msg []byte, resp *StreamAPIResponse) error {
+	if len(tokens) < 2 {
+		return fmt.Errorf("stream name required for consumer request")
+	}
+	op := tokens[0]
+	mset, err := as.lookupStream(tokens[1])
+	if err != nil {
+		return err
+	}
+	switch op {
+	case "CREATE":
+		var cfg ConsumerConfig
+		if err := json.Unmarshal(msg, &cfg); err != nil {
+			return fmt.Errorf("invalid consumer configuration: %v", err)
+		}
+		o, err := mset.AddConsumer(&cfg)
+		if err != nil {
+			return err
+		}
+		resp.Consumer = o.Info()
+		return nil
+	case "LIST":
+		resp.Consumers = mset.consumerNames()
+		return nil
+	}
+	if len(tokens) != 3 {
+		return fmt.Errorf("consumer name required for %s request", op)
+	}
+	o, err := mset.LookupConsumer(tokens[2])
+	if err != nil {
+		return err
+	}
+	switch op {
+	case "INFO":
+		resp.Consumer = o.Info()
+	case "DELETE":
+		return o.Delete()
+	default:
+		return fmt.Errorf("unknown consumer request %q", op)
+	}
+	return nil
+}
+
+// Handles $STREAM.API.CONSUMER.MSG.NEXT.<stream>.<consumer> requests.
+// The optional payload is the number of messages requested.
+func (as *accountStreams) processNextMsgRequest(tokens []string, reply string, msg []byte) error {
+	if len(tokens) != 3 || tokens[0] != "NEXT" {
+		return fmt.Errorf("invalid next message request")
+	}
+	if reply == _EMPTY_ {
+		return fmt.Errorf("reply subject required for next message request")
+	}
+	mset, err := as.lookupStream(tokens[1])
+	if err != nil {
+		return err
+	}
+	o, err := mset.LookupConsumer(tokens[2])
+	if err != nil {
+		return err
+	}
+	batch := 1
+	if len(msg) > 0 {
+		if batch = parseSize(msg); batch <= 0 {
+			return fmt.Errorf("invalid batch size %q", msg)
+		}
+	}
+	return o.processNextMsgRequest(reply, batch)
+}
+
+// processAck handles acknowledgements on $STREAM.ACK.<stream>.<consumer>.<dc>.<sseq>.<dseq>.
+func (as *accountStreams) processAck(sub *subscription, subject, reply string, msg []byte) {
+	tokens := strings.Split(subject, tsep)
+	if len(tokens) != streamAckTokens {
+		return
+	}
+	mset, err := as.lookupStream(tokens[2])
+	if err != nil {
+		return
+	}
+	o, err := mset.LookupConsumer(tokens[3])
+	if err != nil {
+		return
+	}
+	sseq, err := strconv.ParseUint(tokens[5], 10, 64)
+	if err != nil {
+		return
+	}
+	o.processAck(sseq, msg)
+}
@@ -0,0 +1,490 @@
+// Copyright 2019 The NATS Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/nats-io/nats.go"
+)
+
+func runStreamsServer(t *testing.T, dir string) (*Server, *Options) {
+	t.Helper()
+	opts := DefaultOptions()
+	opts.Cluster.Port = 0
+	opts.Streams.Enabled = true
+	opts.Streams.StoreDir = dir
+	return RunServer(opts), opts
+}
+
+func streamAPIRequest(t *testing.T, nc *nats.Conn, subj string, req interface{}) *StreamAPIResponse {
+	t.Helper()
+	var data []byte
+	if req != nil {
+		data, _ = json.Marshal(req)
+	}
+	msg, err := nc.Request(subj, data, 2*time.Second)
+	if err != nil {
+		t.Fatalf("Error on request %q: %v", subj, err)
+	}
+	var resp StreamAPIResponse
+	if err := json.Unmarshal(msg.Data, &resp); err != nil {
+		t.Fatalf("Error decoding response %q: %v", msg.Data, err)
+	}
+	return &resp
+}
+
+func checkStreamAck(t *testing.T, m *nats.Msg, stream string, seq uint64) {
+	t.Helper()
+	var pa StreamPubAck
+	if err := json.Unmarshal(m.Data, &pa); err != nil {
+		t.Fatalf("Error decoding pub ack %q: %v", m.Data, err)
+	}
+	if pa.Stream != stream || pa.Seq != seq || pa.Error != "" {
+		t.Fatalf("Unexpected pub ack: %+v", pa)
+	}
+}
+
+func TestStreamCreateAndPublish(t *testing.T) {
+	dir := createStoreDir(t)
+	defer os.RemoveAll(dir)
+	s, opts := runStreamsServer(t, dir)
+	defer s.Shutdown()
+
+	nc := natsConnect(t, fmt.Sprintf("nats://%s:%d", opts.Host, opts.Port))
+	defer nc.Close()
+
+	resp := streamAPIRequest(t, nc, fmt.Sprintf(StreamCreateT, "ORDERS"),
+		&StreamConfig{Subjects: []string{"orders.*"}})
+	if resp.Error != "" {
+		t.Fatalf("Unexpected error: %v", resp.Error)
+	}
+	if resp.Stream == nil || resp.Stream.Config.Name != "ORDERS" {
+		t.Fatalf("Unexpected response: %+v", resp)
+	}
+
+	for i := 1; i <= 5; i++ {
+		m, err := nc.Request("orders.new", []byte("order"), time.Second)
+		if err != nil {
+			t.Fatalf("Error on request: %v", err)
+		}
+		checkStreamAck(t, m, "ORDERS", uint64(i))
+	}
+	// Messages without a reply are stored too.
+	natsPub(t, nc, "orders.old", []byte("order"))
+	natsPub(t, nc, "other", []byte("order"))
+	natsFlush(t, nc)
+
+	checkFor(t, time.Second, 15*time.Millisecond, func() error {
+		resp := streamAPIRequest(t, nc, fmt.Sprintf(StreamInfoT, "ORDERS"), nil)
+		if resp.Stream == nil || resp.Stream.State.Msgs != 6 || resp.Stream.State.LastSeq != 6 {
+			return fmt.Errorf("Unexpected stream info: %+v", resp.Stream)
+		}
+		return nil
+	})
+
+	// Overlapping subjects are rejected.
+	resp = streamAPIRequest(t, nc, fmt.Sprintf(StreamCreateT, "ALL"), &StreamConfig{Subjects: []string{">"}})
+	if !strings.Contains(resp.Error, "overlap") {
+		t.Fatalf("Expected overlap error, got %+v", resp)
+	}
+	resp = streamAPIRequest(t, nc, fmt.Sprintf(StreamCreateT, "bad"), &StreamConfig{Name: "bad.name"})
+	if resp.Error == "" {
+		t.Fatalf("Expected an error for invalid name")
+	}
+
+	resp = streamAPIRequest(t, nc, StreamListSubj, nil)
+	if len(resp.Streams) != 1 || resp.Streams[0] != "ORDERS" {
+		t.Fatalf("Unexpected stream list: %+v", resp)
+	}
+	resp = streamAPIRequest(t, nc, fmt.Sprintf(StreamPurgeT, "ORDERS"), nil)
+	if resp.Purged != 6 || resp.Stream.State.Msgs != 0 {
+		t.Fatalf("Unexpected purge response: %+v", resp)
+	}
+	resp = streamAPIRequest(t, nc, fmt.Sprintf(StreamDeleteT, "ORDERS"), nil)
+	if resp.Error != "" {
+		t.Fatalf("Unexpected error: %v", resp.Error)
+	}
+	resp = streamAPIRequest(t, nc, fmt.Sprintf(StreamInfoT, "ORDERS"), nil)
+	if resp.Error != ErrStreamNotFound.Error() {
+		t.Fatalf("Expected not found error, got %+v", resp)
+	}
+}
+
+func TestStreamPushConsumerAckAndRedelivery(t *testing.T) {
+	dir := createStoreDir(t)
+	defer os.RemoveAll(dir)
+	s, opts := runStreamsServer(t, dir)
+	defer s.Shutdown()
+
+	nc := natsConnect(t, fmt.Sprintf("nats://%s:%d", opts.Host, opts.Port))
+	defer nc.Close()
+
+	streamAPIRequest(t, nc, fmt.Sprintf(StreamCreateT, "FOO"), &StreamConfig{Subjects: []string{"foo"}})
+	for i := 0; i < 3; i++ {
+		nc.Request("foo", []byte(fmt.Sprintf("msg-%d", i)), time.Second)
+	}
+
+	sub := natsSubSync(t, nc, "deliver")
+	natsFlush(t, nc)
+	resp := streamAPIRequest(t, nc, fmt.Sprintf(StreamConsumerCreateT, "FOO"), &ConsumerConfig{
+		Durable:        "dlc",
+		DeliverSubject: "deliver",
+		AckWait:        250 * time.Millisecond,
+	})
+	if resp.Error != "" {
+		t.Fatalf("Unexpected error: %v", resp.Error)
+	}
+
+	for i := 0; i < 3; i++ {
+		m := natsNexMsg(t, sub, time.Second)
+		if string(m.Data) != fmt.Sprintf("msg-%d", i) {
+			t.Fatalf("Unexpected message %q", m.Data)
+		}
+		expected := fmt.Sprintf(streamAckT, "FOO", "dlc", 1, i+1, i+1)
+		if m.Reply != expected {
+			t.Fatalf("Expected reply %q, got %q", expected, m.Reply)
+		}
+		// Do not ack the second message.
+		if i != 1 {
+			natsPub(t, nc, m.Reply, nil)
+		}
+	}
+
+	// The second one should be redelivered.
+	m := natsNexMsg(t, sub, time.Second)
+	if string(m.Data) != "msg-1" {
+		t.Fatalf("Unexpected message %q", m.Data)
+	}
+	if expected := fmt.Sprintf(streamAckT, "FOO", "dlc", 2, 2, 4); m.Reply != expected {
+		t.Fatalf("Expected reply %q, got %q", expected, m.Reply)
+	}
+	natsPub(t, nc, m.Reply, []byte(streamAckAck))
+	natsFlush(t, nc)
+
+	// New messages are pushed as they are stored.
+	nc.Request("foo", []byte("msg-3"), time.Second)
+	m = natsNexMsg(t, sub, time.Second)
+	if string(m.Data) != "msg-3" {
+		t.Fatalf("Unexpected message %q", m.Data)
+	}
+	// A nak triggers an immediate redelivery.
+	natsPub(t, nc, m.Reply, []byte(streamAckNak))
+	m = natsNexMsg(t, sub, 100*time.Millisecond)
+	if string(m.Data) != "msg-3" {
+		t.Fatalf("Unexpected message %q", m.Data)
+	}
+	natsPub(t, nc, m.Reply, nil)
+	natsFlush(t, nc)
+
+	checkFor(t, time.Second, 15*time.Millisecond, func() error {
+		resp := streamAPIRequest(t, nc, fmt.Sprintf(StreamConsumerInfoT, "FOO", "dlc"), nil)
+		ci := resp.Consumer
+		if ci == nil || ci.NumPending != 0 || ci.AckFloor.StreamSeq != 4 || ci.Delivered.ConsumerSeq != 6 {
+			return fmt.Errorf("Unexpected consumer info: %+v", ci)
+		}
+		return nil
+	})
+
+	// Deliver subjects can not be captured by a stream.
+	resp = streamAPIRequest(t, nc, fmt.Sprintf(StreamCreateT, "DELIVER"), &StreamConfig{Subjects: []string{"deliver"}})
+	if resp.Error != ErrStreamSubjectOverlap.Error() {
+		t.Fatalf("Expected overlap error, got %+v", resp)
+	}
+}
+
+func TestStreamPullConsumer(t *testing.T) {
+	dir := createStoreDir(t)
+	defer os.RemoveAll(dir)
+	s, opts := runStreamsServer(t, dir)
+	defer s.Shutdown()
+
+	nc := natsConnect(t, fmt.Sprintf("nats://%s:%d", opts.Host, opts.Port))
+	defer nc.Close()
+
+	streamAPIRequest(t, nc, fmt.Sprintf(StreamCreateT, "WQ"), &StreamConfig{Subjects: []string{"wq.>"}})
+	for i := 0; i < 10; i++ {
+		nc.Request(fmt.Sprintf("wq.%d", i%2), []byte(fmt.Sprintf("job-%d", i)), time.Second)
+	}
+	resp := streamAPIRequest(t, nc, fmt.Sprintf(StreamConsumerCreateT, "WQ"), &ConsumerConfig{
+		Durable:       "worker",
+		FilterSubject: "wq.1",
+		AckPolicy:     AckAll,
+	})
+	if resp.Error != "" {
+		t.Fatalf("Unexpected error: %v", resp.Error)
+	}
+
+	inbox := nats.NewInbox()
+	sub := natsSubSync(t, nc, inbox)
+	natsPubReq(t, nc, fmt.Sprintf(StreamConsumerNextT, "WQ", "worker"), inbox, []byte("3"))
+
+	var last *nats.Msg
+	for i := 0; i < 3; i++ {
+		last = natsNexMsg(t, sub, time.Second)
+		if expected := fmt.Sprintf("job-%d", 2*i+1); string(last.Data) != expected {
+			t.Fatalf("Expected %q, got %q", expected, last.Data)
+		}
+	}
+	if m, err := sub.NextMsg(50 * time.Millisecond); err == nil {
+		t.Fatalf("Unexpected message %q", m.Data)
+	}
+	// Ack all with the last one.
+	natsPub(t, nc, last.Reply, nil)
+	natsFlush(t, nc)
+
+	// Waiting request is served once a message is available.
+	natsPubReq(t, nc, fmt.Sprintf(StreamConsumerNextT, "WQ", "worker"), inbox, nil)
+	natsPub(t, nc, natsNexMsg(t, sub, time.Second).Reply, nil)
+	natsPubReq(t, nc, fmt.Sprintf(StreamConsumerNextT, "WQ", "worker"), inbox, nil)
+	natsPub(t, nc, natsNexMsg(t, sub, time.Second).Reply, nil)
+	natsPubReq(t, nc, fmt.Sprintf(StreamConsumerNextT, "WQ", "worker"), inbox, nil)
+	if m, err := sub.NextMsg(50 * time.Millisecond); err == nil {
+		t.Fatalf("Unexpected message %q", m.Data)
+	}
+	nc.Request("wq.1", []byte("job-10"), time.Second)
+	if m := natsNexMsg(t, sub, time.Second); string(m.Data) != "job-10" {
+		t.Fatalf("Unexpected message %q", m.Data)
+	}
+
+	// Pull requests are rejected for push consumers.
+	streamAPIRequest(t, nc, fmt.Sprintf(StreamConsumerCreateT, "WQ"), &ConsumerConfig{
+		Durable:        "push",
+		DeliverSubject: "push.deliver",
+	})
+	resp = streamAPIRequest(t, nc, fmt.Sprintf(StreamConsumerNextT, "WQ", "push"), nil)
+	if resp.Error != ErrConsumerNotPull.Error() {
+		t.Fatalf("Expected not pull error, got %+v", resp)
+	}
+	resp = streamAPIRequest(t, nc, fmt.Sprintf(StreamConsumerListT, "WQ"), nil)
+	if len(resp.Consumers) != 2 || resp.Consumers[0] != "push" || resp.Consumers[1] != "worker" {
+		t.Fatalf("Unexpected consumer list: %+v", resp)
+	}
+}
+
+func TestStreamConsumerReplay(t *testing.T) {
+	dir := createStoreDir(t)
+	defer os.RemoveAll(dir)
+	s, opts := runStreamsServer(t, dir)
+	defer s.Shutdown()
+
+	nc := natsConnect(t, fmt.Sprintf("nats://%s:%d", opts.Host, opts.Port))
+	defer nc.Close()
+
+	streamAPIRequest(t, nc, fmt.Sprintf(StreamCreateT, "R"), &StreamConfig{Subjects: []string{"r"}})
+	for i := 1; i <= 5; i++ {
+		nc.Request("r", []byte(fmt.Sprintf("%d", i)), time.Second)
+	}
+	start := time.Now()
+	time.Sleep(10 * time.Millisecond)
+	for i := 6; i <= 8; i++ {
+		nc.Request("r", []byte(fmt.Sprintf("%d", i)), time.Second)
+	}
+
+	check := func(cfg *ConsumerConfig, first string, count int) {
+		t.Helper()
+		sub := natsSubSync(t, nc, cfg.DeliverSubject)
+		defer sub.Unsubscribe()
+		natsFlush(t, nc)
+		resp := streamAPIRequest(t, nc, fmt.Sprintf(StreamConsumerCreateT, "R"), cfg)
+		if resp.Error != "" {
+			t.Fatalf("Unexpected error: %v", resp.Error)
+		}
+		m := natsNexMsg(t, sub, time.Second)
+		if string(m.Data) != first {
+			t.Fatalf("Expected first message %q, got %q", first, m.Data)
+		}
+		for i := 1; i < count; i++ {
+			natsNexMsg(t, sub, time.Second)
+		}
+		if m, err := sub.NextMsg(50 * time.Millisecond); err == nil {
+			t.Fatalf("Unexpected message %q", m.Data)
+		}
+	}
+	check(&ConsumerConfig{Durable: "seq", DeliverSubject: "d.seq", DeliverPolicy: DeliverByStartSequence, OptStartSeq: 3, AckPolicy: AckNone}, "3", 6)
+	check(&ConsumerConfig{Durable: "time", DeliverSubject: "d.time", DeliverPolicy: DeliverByStartTime, OptStartTime: &start, AckPolicy: AckNone}, "6", 3)
+	check(&ConsumerConfig{Durable: "last", DeliverSubject: "d.last", DeliverPolicy: DeliverLast, AckPolicy: AckNone}, "8", 1)
+
+	resp := streamAPIRequest(t, nc, fmt.Sprintf(StreamConsumerCreateT, "R"), &ConsumerConfig{
+		Durable:       "bad",
+		DeliverPolicy: DeliverByStartSequence,
+	})
+	if resp.Error == "" {
+		t.Fatalf("Expected error for missing start sequence")
+	}
+}
+
+func TestStreamMsgHeaders(t *testing.T) {
+	dir := createStoreDir(t)
+	defer os.RemoveAll(dir)
+	s, opts := runStreamsServer(t, dir)
+	defer s.Shutdown()
+
+	nc := natsConnect(t, fmt.Sprintf("nats://%s:%d", opts.Host, opts.Port))
+	defer nc.Close()
+	streamAPIRequest(t, nc, fmt.Sprintf(StreamCreateT, "H"), &StreamConfig{Subjects: []string{"h"}})
+
+	c, cr, _ := newClientForServer(s)
+	defer c.nc.Close()
+	done := make(chan struct{})
+	go func() {
+		c.parse([]byte("CONNECT {\"headers\":true,\"verbose\":false}\r\nSUB d.h 1\r\n" +
+			hpubProto("h", "hello", "X-Id", "22") + "PUB h 5\r\nplain\r\nPING\r\n"))
+		close(done)
+	}()
+	if lines := readUntilPong(t, cr); len(lines) != 0 {
+		t.Fatalf("Unexpected protocols: %q", lines)
+	}
+	<-done
+	checkFor(t, time.Second, 15*time.Millisecond, func() error {
+		resp := streamAPIRequest(t, nc, fmt.Sprintf(StreamInfoT, "H"), nil)
+		if resp.Stream == nil || resp.Stream.State.Msgs != 2 {
+			return fmt.Errorf("Unexpected stream info: %+v", resp.Stream)
+		}
+		return nil
+	})
+
+	resp := streamAPIRequest(t, nc, fmt.Sprintf(StreamConsumerCreateT, "H"),
+		&ConsumerConfig{Durable: "d", DeliverSubject: "d.h", AckPolicy: AckNone})
+	if resp.Error != "" {
+		t.Fatalf("Unexpected error: %v", resp.Error)
+	}
+	// The headers are replayed along with the subject the message was stored under.
+	for _, expected := range []string{
+		"HMSG d.h 1", "NATS/1.0\r\n", "X-Id: 22\r\n", "Stream-Subject: h\r\n", "\r\n", "hello\r\n",
+		"HMSG d.h 1", "NATS/1.0\r\n", "Stream-Subject: h\r\n", "\r\n", "plain\r\n",
+	} {
+		l, err := cr.ReadString('\n')
+		if err != nil {
+			t.Fatalf("Error reading: %v", err)
+		}
+		if !strings.HasPrefix(l, expected) {
+			t.Fatalf("Expected %q, got %q", expected, l)
+		}
+	}
+}
+
+func TestStreamRestoreOnRestart(t *testing.T) {
+	dir := createStoreDir(t)
+	defer os.RemoveAll(dir)
+	s, opts := runStreamsServer(t, dir)
+	defer s.Shutdown()
+
+	nc := natsConnect(t, fmt.Sprintf("nats://%s:%d", opts.Host, opts.Port))
+	defer nc.Close()
+
+	streamAPIRequest(t, nc, fmt.Sprintf(StreamCreateT, "P"), &StreamConfig{Subjects: []string{"p.*"}, MaxMsgs: 8})
+	for i := 0; i < 10; i++ {
+		nc.Request("p.x", []byte(fmt.Sprintf("%d", i)), time.Second)
+	}
+	streamAPIRequest(t, nc, fmt.Sprintf(StreamConsumerCreateT, "P"), &ConsumerConfig{Durable: "c"})
+
+	inbox := nats.NewInbox()
+	sub := natsSubSync(t, nc, inbox)
+	natsPubReq(t, nc, fmt.Sprintf(StreamConsumerNextT, "P", "c"), inbox, []byte("2"))
+	for i := 0; i < 2; i++ {
+		natsPub(t, nc, natsNexMsg(t, sub, time.Second).Reply, nil)
+	}
+	natsFlush(t, nc)
+	checkFor(t, time.Second, 15*time.Millisecond, func() error {
+		resp := streamAPIRequest(t, nc, fmt.Sprintf(StreamConsumerInfoT, "P", "c"), nil)
+		if resp.Consumer.AckFloor.StreamSeq != 4 {
+			return fmt.Errorf("Unexpected consumer info: %+v", resp.Consumer)
+		}
+		return nil
+	})
+	nc.Close()
+	s.Shutdown()
+
+	s, opts = runStreamsServer(t, dir)
+	defer s.Shutdown()
+	nc = natsConnect(t, fmt.Sprintf("nats://%s:%d", opts.Host, opts.Port))
+	defer nc.Close()
+
+	resp := streamAPIRequest(t, nc, fmt.Sprintf(StreamInfoT, "P"), nil)
+	if resp.Stream == nil {
+		t.Fatalf("Stream was not restored: %+v", resp)
+	}
+	if st := resp.Stream.State; st.Msgs != 8 || st.FirstSeq != 3 || st.LastSeq != 10 || st.Consumers != 1 {
+		t.Fatalf("Unexpected restored state: %+v", st)
+	}
+	if resp.Stream.Config.MaxMsgs != 8 {
+		t.Fatalf("Unexpected restored config: %+v", resp.Stream.Config)
+	}
+	// The consumer picks up where it left off.
+	sub = natsSubSync(t, nc, inbox)
+	natsPubReq(t, nc, fmt.Sprintf(StreamConsumerNextT, "P", "c"), inbox, nil)
+	if m := natsNexMsg(t, sub, time.Second); string(m.Data) != "4" {
+		t.Fatalf("Expected message %q, got %q", "4", m.Data)
+	}
+}
+
+func TestStreamsConfig(t *testing.T) {
+	conf := createConfFile(t, []byte(`
+		listen: "127.0.0.1:-1"
+		streams {
+			store_dir: "/tmp/streams"
+		}
+	`))
+	defer os.Remove(conf)
+	opts, err := ProcessConfigFile(conf)
+	if err != nil {
+		t.Fatalf("Error processing config file: %v", err)
+	}
+	if !opts.Streams.Enabled || opts.Streams.StoreDir != "/tmp/streams" {
+		t.Fatalf("Unexpected streams options: %+v", opts.Streams)
+	}
+
+	conf2 := createConfFile(t, []byte(`
+		streams: true
+	`))
+	defer os.Remove(conf2)
+	if opts, err = ProcessConfigFile(conf2); err != nil {
+		t.Fatalf("Error processing config file: %v", err)
+	}
+	if !opts.Streams.Enabled || opts.Streams.StoreDir != "" {
+		t.Fatalf("Unexpected streams options: %+v", opts.Streams)
+	}
+}
+
+func TestStreamsDefaultStoreDir(t *testing.T) {
+	dir := createStoreDir(t)
+	defer os.RemoveAll(dir)
+	cwd, err := os.Getwd()
+	if err != nil {
+		t.Fatalf("Error getting working directory: %v", err)
+	}
+	if err := os.Chdir(dir); err != nil {
+		t.Fatalf("Error changing directory: %v", err)
+	}
+	defer os.Chdir(cwd)
+
+	s, _ := runStreamsServer(t, "")
+	defer s.Shutdown()
+	// The default store directory is relative to the working directory.
+	wd, _ := os.Getwd()
+	expected := filepath.Join(wd, DEFAULT_STREAMS_STORE_DIR)
+	if sdir := s.streams.storeDir; sdir != expected {
+		t.Fatalf("Expected store directory %q, got %q", expected, sdir)
+	}
+	if _, err := os.Stat(expected); err != nil {
+		t.Fatalf("Store directory not created: %v", err)
+	}
+}
@@ -883,6 +883,26 @@ func isSubsetMatch(tokens []string, test string) bool {
 	return len(tokens) == len(tts)
 }
 
+// subjectsCollide determines if two subjects, both possibly containing
+// wildcards, could match a common literal subject.
+func subjectsCollide(subj1, subj2 string) bool {
+	toks1 := strings.Split(subj1, tsep)
+	toks2 := strings.Split(subj2, tsep)
+	for i := 0; i < len(toks1) && i < len(toks2); i++ {
+		t1, t2 := toks1[i], toks2[i]
+		if t1 == ">" || t2 == ">" {
+			return true
+		}
+		if t1 == "*" || t2 == "*" {
+			continue
+		}
+		if t1 != t2 {
+			return false
+		}
+	}
+	return len(toks1) == len(toks2)
+}
+
 // matchLiteral is used to test literal subjects, those that do not have any
 // wildcards, with a target subject. This is used in the cache layer.
 func matchLiteral(literal, subject string) bool {
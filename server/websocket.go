@@ -0,0 +1,578 @@
+// Copyright 2019 The NATS Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package server
+
+import (
+	"bufio"
+	"bytes"
+	"compress/flate"
+	"crypto/sha1"
+	"crypto/tls"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Websocket opcodes and frame bits, see RFC 6455.
+const (
+	wsContinuationFrame = 0
+	wsTextFrame         = 1
+	wsBinaryFrame       = 2
+	wsCloseFrame        = 8
+	wsPingFrame         = 9
+	wsPongFrame         = 10
+
+	wsFinalBit = 1 << 7
+	wsRsv1Bit  = 1 << 6
+	wsMaskBit  = 1 << 7
+
+	wsMaxControlPayloadSize = 125
+
+	wsCloseStatusNormalClosure   = 1000
+	wsCloseStatusProtocolError   = 1002
+	wsCloseStatusMessageTooBig   = 1009
+	wsCloseStatusInvalidPayload  = 1007
+	wsCloseStatusNoStatusReceive = 1005
+
+	// Payloads smaller than this are not worth compressing.
+	wsCompressThreshold = 64
+
+	wsGUID             = "258EAFA5-E914-47DA-95CA-C5AB0DC85B11"
+	wsPMCExtension     = "permessage-deflate"
+	wsPMCResponseParam = "permessage-deflate; server_no_context_takeover; client_no_context_takeover"
+)
+
+// Appended to a compressed payload to properly terminate the deflate stream.
+var wsCompressLastBlock = []byte{0x00, 0x00, 0xff, 0xff, 0x01, 0x00, 0x00, 0xff, 0xff}
+
+var (
+	// ErrWebsocketUnmasked is returned when a client sends an unmasked frame.
+	ErrWebsocketUnmasked = errors.New("websocket: client frame not masked")
+	// ErrWebsocketBadFrame is returned when a frame can not be processed.
+	ErrWebsocketBadFrame = errors.New("websocket: invalid frame")
+)
+
+// Websocket server state.
+type srvWebsocket struct {
+	listener       net.Listener
+	server         *http.Server
+	tls            bool
+	compression    bool
+	sameOrigin     bool
+	allowedOrigins map[string]*wsAllowedOrigin
+}
+
+type wsAllowedOrigin struct {
+	scheme string
+	port   string
+}
+
+// wsConn wraps an upgraded connection. Reads return the unframed client
+// protocol and writes are sent as binary frames, so that the regular
+// client read and write loops can be used unchanged.
+type wsConn struct {
+	net.Conn
+	br       *bufio.Reader
+	compress bool
+	maxPay   int
+
+	// Read state, only accessed from the read loop.
+	rem     int
+	mask    [4]byte
+	mpos    int
+	fin     bool
+	fc      bool
+	inMsg   bool
+	cbuf    []byte
+	pending []byte
+
+	// Protects writes since control frames are sent from the read loop.
+	wmu    sync.Mutex
+	closed bool
+}
+
+// Read will return the payload of data frames and handle control frames.
+func (ws *wsConn) Read(p []byte) (int, error) {
+	for {
+		if len(ws.pending) > 0 {
+			n := copy(p, ws.pending)
+			ws.pending = ws.pending[n:]
+			return n, nil
+		}
+		if ws.rem > 0 {
+			if !ws.fc {
+				if len(p) > ws.rem {
+					p = p[:ws.rem]
+				}
+				n, err := ws.br.Read(p)
+				ws.unmask(p[:n])
+				ws.rem -= n
+				if n > 0 {
+					return n, nil
+				}
+				return 0, err
+			}
+			// Compressed payloads are accumulated until the final frame.
+			start := len(ws.cbuf)
+			if ws.maxPay > 0 && start+ws.rem > ws.maxPay {
+				ws.sendClose(wsCloseStatusMessageTooBig, "message too big")
+				return 0, ErrMaxPayload
+			}
+			ws.cbuf = append(ws.cbuf, make([]byte, ws.rem)...)
+			if _, err := io.ReadFull(ws.br, ws.cbuf[start:]); err != nil {
+				return 0, err
+			}
+			ws.unmask(ws.cbuf[start:])
+			ws.rem = 0
+		}
+		if ws.fc && ws.fin {
+			buf, err := ws.decompress(ws.cbuf)
+			ws.cbuf, ws.fc = nil, false
+			if err != nil {
+				ws.sendClose(wsCloseStatusInvalidPayload, err.Error())
+				return 0, err
+			}
+			ws.pending = buf
+			continue
+		}
+		if err := ws.readFrameHeader(); err != nil {
+			return 0, err
+		}
+	}
+}
+
+// Reads the next frame header. Control frames are processed inline.
+func (ws *wsConn) readFrameHeader() error {
+	var hdr [14]byte
+	if _, err := io.ReadFull(ws.br, hdr[:2]); err != nil {
+		return err
+	}
+	b0, b1 := hdr[0], hdr[1]
+	op := int(b0 & 0xf)
+	if b1&wsMaskBit == 0 {
+		ws.sendClose(wsCloseStatusProtocolError, "frame not masked")
+		return ErrWebsocketUnmasked
+	}
+	plen := uint64(b1 & 0x7f)
+	switch plen {
+	case 126:
+		if _, err := io.ReadFull(ws.br, hdr[2:4]); err != nil {
+			return err
+		}
+		plen = uint64(binary.BigEndian.Uint16(hdr[2:4]))
+	case 127:
+		if _, err := io.ReadFull(ws.br, hdr[2:10]); err != nil {
+			return err
+		}
+		plen = binary.BigEndian.Uint64(hdr[2:10])
+	}
+	if _, err := io.ReadFull(ws.br, ws.mask[:]); err != nil {
+		return err
+	}
+	ws.mpos = 0
+
+	switch op {
+	case wsCloseFrame, wsPingFrame, wsPongFrame:
+		if plen > wsMaxControlPayloadSize || b0&wsFinalBit == 0 {
+			ws.sendClose(wsCloseStatusProtocolError, "invalid control frame")
+			return ErrWebsocketBadFrame
+		}
+		payload := make([]byte, plen)
+		if _, err := io.ReadFull(ws.br, payload); err != nil {
+			return err
+		}
+		ws.unmask(payload)
+		switch op {
+		case wsCloseFrame:
+			status := wsCloseStatusNoStatusReceive
+			if len(payload) >= 2 {
+				status = int(binary.BigEndian.Uint16(payload[:2]))
+			}
+			if status == wsCloseStatusNoStatusReceive {
+				status = wsCloseStatusNormalClosure
+			}
+			ws.sendClose(status, _EMPTY_)
+			return io.EOF
+		case wsPingFrame:
+			ws.writeFrame(wsPongFrame, false, payload)
+		}
+		return nil
+	case wsTextFrame, wsBinaryFrame:
+		if ws.inMsg {
+			ws.sendClose(wsCloseStatusProtocolError, "expected continuation frame")
+			return ErrWebsocketBadFrame
+		}
+		ws.fc = b0&wsRsv1Bit != 0
+		if ws.fc && !ws.compress {
+			ws.sendClose(wsCloseStatusProtocolError, "compression not negotiated")
+			return ErrWebsocketBadFrame
+		}
+	case wsContinuationFrame:
+		if !ws.inMsg {
+			ws.sendClose(wsCloseStatusProtocolError, "unexpected continuation frame")
+			return ErrWebsocketBadFrame
+		}
+	default:
+		ws.sendClose(wsCloseStatusProtocolError, fmt.Sprintf("unknown opcode %d", op))
+		return ErrWebsocketBadFrame
+	}
+	ws.fin = b0&wsFinalBit != 0
+	ws.inMsg = !ws.fin
+	if plen > uint64(1<<31-1) {
+		ws.sendClose(wsCloseStatusMessageTooBig, "frame too big")
+		return ErrWebsocketBadFrame
+	}
+	ws.rem = int(plen)
+	return nil
+}
+
+// Unmask the payload in place, keeping track of the mask position
+// across partial reads.
+func (ws *wsConn) unmask(b []byte) {
+	for i := range b {
+		b[i] ^= ws.mask[ws.mpos&3]
+		ws.mpos++
+	}
+}
+
+func (ws *wsConn) decompress(b []byte) ([]byte, error) {
+	r := flate.NewReader(io.MultiReader(bytes.NewReader(b), bytes.NewReader(wsCompressLastBlock)))
+	defer r.Close()
+	if ws.maxPay > 0 {
+		// Allow for the control line on top of the max payload.
+		lr := &io.LimitedReader{R: r, N: int64(ws.maxPay) + MAX_CONTROL_LINE_SIZE + 1}
+		buf, err := ioutil.ReadAll(lr)
+		if err == nil && lr.N <= 0 {
+			return nil, ErrMaxPayload
+		}
+		return buf, err
+	}
+	return ioutil.ReadAll(r)
+}
+
+// Write sends the buffer as a single binary frame.
+func (ws *wsConn) Write(p []byte) (int, error) {
+	if err := ws.writeFrame(wsBinaryFrame, ws.compress && len(p) >= wsCompressThreshold, p); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+// Write a frame. Server frames are never masked.
+func (ws *wsConn) writeFrame(op int, compress bool, payload []byte) error {
+	b0 := byte(wsFinalBit | op)
+	if compress {
+		var buf bytes.Buffer
+		fw, _ := flate.NewWriter(&buf, flate.BestSpeed)
+		fw.Write(payload)
+		fw.Flush()
+		payload = bytes.TrimSuffix(buf.Bytes(), wsCompressLastBlock[:4])
+		b0 |= wsRsv1Bit
+	}
+	var hdr [10]byte
+	hdr[0] = b0
+	hl := 2
+	switch pl := len(payload); {
+	case pl <= 125:
+		hdr[1] = byte(pl)
+	case pl <= 65535:
+		hdr[1] = 126
+		binary.BigEndian.PutUint16(hdr[2:], uint16(pl))
+		hl = 4
+	default:
+		hdr[1] = 127
+		binary.BigEndian.PutUint64(hdr[2:], uint64(pl))
+		hl = 10
+	}
+	frame := make([]byte, 0, hl+len(payload))
+	frame = append(frame, hdr[:hl]...)
+	frame = append(frame, payload...)
+
+	ws.wmu.Lock()
+	defer ws.wmu.Unlock()
+	if ws.closed {
+		return io.ErrClosedPipe
+	}
+	_, err := ws.Conn.Write(frame)
+	return err
+}
+
+// Send a close frame, no more frames will be sent after that.
+func (ws *wsConn) sendClose(status int, reason string) {
+	if len(reason) > wsMaxControlPayloadSize-2 {
+		reason = reason[:wsMaxControlPayloadSize-2]
+	}
+	payload := make([]byte, 2+len(reason))
+	binary.BigEndian.PutUint16(payload, uint16(status))
+	copy(payload[2:], reason)
+	ws.writeFrame(wsCloseFrame, false, payload)
+	ws.wmu.Lock()
+	ws.closed = true
+	ws.wmu.Unlock()
+}
+
+// Returns the websocket accept key for the given client key.
+func wsAcceptKey(key string) string {
+	h := sha1.New()
+	h.Write([]byte(key))
+	h.Write([]byte(wsGUID))
+	return base64.StdEncoding.EncodeToString(h.Sum(nil))
+}
+
+// Checks if a comma separated header contains the given token.
+func wsHeaderContains(header http.Header, name, token string) bool {
+	for _, v := range header[http.CanonicalHeaderKey(name)] {
+		for _, t := range strings.Split(v, ",") {
+			// Extensions may have parameters after a ';'.
+			if i := strings.IndexByte(t, ';'); i >= 0 {
+				t = t[:i]
+			}
+			if strings.EqualFold(strings.TrimSpace(t), token) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// Split a host into host and port, using the default port of the scheme.
+func wsHostPort(scheme, host string) (string, string) {
+	h, p, err := net.SplitHostPort(host)
+	if err != nil {
+		h = host
+		if scheme == "https" || scheme == "wss" {
+			p = "443"
+		} else {
+			p = "80"
+		}
+	}
+	return strings.ToLower(h), p
+}
+
+// Check the origin of the request against the configured restrictions.
+// Requests without an Origin header are not coming from a browser and
+// are always accepted.
+func (s *Server) wsCheckOrigin(r *http.Request) error {
+	origin := r.Header.Get("Origin")
+	if origin == _EMPTY_ {
+		return nil
+	}
+	s.mu.Lock()
+	sameOrigin := s.websocket.sameOrigin
+	allowed := s.websocket.allowedOrigins
+	secure := s.websocket.tls
+	s.mu.Unlock()
+	if !sameOrigin && len(allowed) == 0 {
+		return nil
+	}
+	u, err := url.ParseRequestURI(origin)
+	if err != nil {
+		return fmt.Errorf("invalid origin %q", origin)
+	}
+	oh, op := wsHostPort(u.Scheme, u.Host)
+	if sameOrigin {
+		scheme := "http"
+		if secure {
+			scheme = "https"
+		}
+		rh, rp := wsHostPort(scheme, r.Host)
+		if oh != rh || op != rp {
+			return fmt.Errorf("origin %q not allowed", origin)
+		}
+	}
+	if len(allowed) > 0 {
+		ao := allowed[oh]
+		if ao == nil || ao.scheme != u.Scheme || ao.port != op {
+			return fmt.Errorf("origin %q not allowed", origin)
+		}
+	}
+	return nil
+}
+
+// wsUpgrade validates the websocket handshake, hijacks the connection and
+// returns the wrapped connection.
+func (s *Server) wsUpgrade(w http.ResponseWriter, r *http.Request) (*wsConn, error) {
+	wsErr := func(status int, reason string) (*wsConn, error) {
+		http.Error(w, http.StatusText(status)+": "+reason, status)
+		return nil, fmt.Errorf("websocket handshake error: %s", reason)
+	}
+	if r.Method != http.MethodGet {
+		return wsErr(http.StatusMethodNotAllowed, "request method must be GET")
+	}
+	if r.Host == _EMPTY_ {
+		return wsErr(http.StatusBadRequest, "'Host' missing in request")
+	}
+	if !wsHeaderContains(r.Header, "Upgrade", "websocket") {
+		return wsErr(http.StatusBadRequest, "invalid value for header 'Upgrade'")
+	}
+	if !wsHeaderContains(r.Header, "Connection", "Upgrade") {
+		return wsErr(http.StatusBadRequest, "invalid value for header 'Connection'")
+	}
+	key := r.Header.Get("Sec-Websocket-Key")
+	if key == _EMPTY_ {
+		return wsErr(http.StatusBadRequest, "key missing")
+	}
+	if r.Header.Get("Sec-Websocket-Version") != "13" {
+		return wsErr(http.StatusBadRequest, "invalid version")
+	}
+	if err := s.wsCheckOrigin(r); err != nil {
+		return wsErr(http.StatusForbidden, err.Error())
+	}
+	s.mu.Lock()
+	compress := s.websocket.compression && wsHeaderContains(r.Header, "Sec-Websocket-Extensions", wsPMCExtension)
+	s.mu.Unlock()
+
+	h, ok := w.(http.Hijacker)
+	if !ok {
+		return wsErr(http.StatusInternalServerError, "connection can not be hijacked")
+	}
+	conn, brw, err := h.Hijack()
+	if err != nil {
+		if conn != nil {
+			conn.Close()
+		}
+		return nil, err
+	}
+	// Clear deadlines set by the HTTP server.
+	conn.SetDeadline(time.Time{})
+
+	var resp bytes.Buffer
+	resp.WriteString("HTTP/1.1 101 Switching Protocols\r\nUpgrade: websocket\r\nConnection: Upgrade\r\nSec-WebSocket-Accept: ")
+	resp.WriteString(wsAcceptKey(key))
+	resp.WriteString(_CRLF_)
+	if compress {
+		resp.WriteString("Sec-WebSocket-Extensions: " + wsPMCResponseParam + _CRLF_)
+	}
+	resp.WriteString(_CRLF_)
+	if _, err := conn.Write(resp.Bytes()); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return &wsConn{
+		Conn:     conn,
+		br:       brw.Reader,
+		compress: compress,
+		maxPay:   int(s.getOpts().MaxPayload) + MAX_CONTROL_LINE_SIZE,
+	}, nil
+}
+
+// Validate the websocket options and setup the allowed origins.
+func validateWebsocketOptions(o *Options) error {
+	wo := &o.Websocket
+	if wo.Port == 0 {
+		return nil
+	}
+	for _, ao := range wo.AllowedOrigins {
+		u, err := url.ParseRequestURI(ao)
+		if err != nil || u.Host == _EMPTY_ {
+			return fmt.Errorf("unable to parse allowed origin %q", ao)
+		}
+	}
+	return nil
+}
+
+// startWebsocketServer will start the HTTP server accepting websocket
+// upgrades. Upgraded connections are then handled as regular clients.
+func (s *Server) startWebsocketServer() {
+	opts := s.getOpts()
+	wo := &opts.Websocket
+
+	port := wo.Port
+	if port == -1 {
+		port = 0
+	}
+	hp := net.JoinHostPort(wo.Host, strconv.Itoa(port))
+	l, err := net.Listen("tcp", hp)
+	if err != nil {
+		s.Fatalf("Unable to listen for websocket connections: %v", err)
+		return
+	}
+	proto := "ws"
+	if wo.TLSConfig != nil {
+		proto = "wss"
+		config := wo.TLSConfig.Clone()
+		l = tls.NewListener(l, config)
+	}
+	// If we have selected a random port...
+	if port == 0 {
+		// Write resolved port back to options.
+		wo.Port = l.Addr().(*net.TCPAddr).Port
+	}
+	s.Noticef("Listening for websocket clients on %s://%s",
+		proto, net.JoinHostPort(wo.Host, strconv.Itoa(wo.Port)))
+
+	origins := make(map[string]*wsAllowedOrigin)
+	for _, ao := range wo.AllowedOrigins {
+		u, _ := url.ParseRequestURI(ao)
+		h, p := wsHostPort(u.Scheme, u.Host)
+		origins[h] = &wsAllowedOrigin{scheme: u.Scheme, port: p}
+	}
+
+	hto := wo.HandshakeTimeout
+	if hto == 0 {
+		hto = DEFAULT_WEBSOCKET_HANDSHAKE_TIMEOUT
+	}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+		ws, err := s.wsUpgrade(w, r)
+		if err != nil {
+			s.Errorf("%v", err)
+			return
+		}
+		s.createClient(ws)
+	})
+	hs := &http.Server{
+		Addr:        hp,
+		Handler:     mux,
+		ReadTimeout: hto,
+		ErrorLog:    log.New(&wsCaptureHTTPServerLog{s}, _EMPTY_, 0),
+	}
+
+	s.mu.Lock()
+	s.websocket.listener = l
+	s.websocket.server = hs
+	s.websocket.tls = wo.TLSConfig != nil
+	s.websocket.compression = wo.Compression
+	s.websocket.sameOrigin = wo.SameOrigin
+	s.websocket.allowedOrigins = origins
+	s.mu.Unlock()
+
+	go func() {
+		if err := hs.Serve(l); err != http.ErrServerClosed {
+			s.Debugf("Websocket server stopped: %v", err)
+		}
+		s.done <- true
+	}()
+}
+
+// Used to route errors of the HTTP server to our logger.
+type wsCaptureHTTPServerLog struct {
+	s *Server
+}
+
+func (cl *wsCaptureHTTPServerLog) Write(p []byte) (int, error) {
+	cl.s.Debugf("Websocket: %s", strings.TrimSpace(string(p)))
+	return len(p), nil
+}
@@ -0,0 +1,392 @@
+// Copyright 2019 The NATS Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package server
+
+import (
+	"bufio"
+	"bytes"
+	"compress/flate"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+func testWSOptions() *Options {
+	o := DefaultOptions()
+	o.Websocket.Host = "127.0.0.1"
+	o.Websocket.Port = -1
+	return o
+}
+
+type testWSClient struct {
+	t        *testing.T
+	conn     net.Conn
+	br       *bufio.Reader
+	compress bool
+}
+
+// Performs the websocket handshake with the given extra headers and
+// returns the HTTP response.
+func testWSHandshake(t *testing.T, s *Server, hdrs map[string]string) (*testWSClient, *http.Response) {
+	t.Helper()
+	addr := fmt.Sprintf("%s:%d", s.getOpts().Websocket.Host, s.getOpts().Websocket.Port)
+	conn, err := net.DialTimeout("tcp", addr, 2*time.Second)
+	if err != nil {
+		t.Fatalf("Error creating ws connection: %v", err)
+	}
+	req, _ := http.NewRequest("GET", "http://"+addr, nil)
+	req.Header.Set("Upgrade", "websocket")
+	req.Header.Set("Connection", "Upgrade")
+	req.Header.Set("Sec-WebSocket-Key", "dGhlIHNhbXBsZSBub25jZQ==")
+	req.Header.Set("Sec-WebSocket-Version", "13")
+	for k, v := range hdrs {
+		if v == _EMPTY_ {
+			req.Header.Del(k)
+		} else {
+			req.Header.Set(k, v)
+		}
+	}
+	if err := req.Write(conn); err != nil {
+		conn.Close()
+		t.Fatalf("Error sending handshake: %v", err)
+	}
+	conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+	br := bufio.NewReader(conn)
+	resp, err := http.ReadResponse(br, req)
+	if err != nil {
+		conn.Close()
+		t.Fatalf("Error reading handshake response: %v", err)
+	}
+	wc := &testWSClient{t: t, conn: conn, br: br}
+	wc.compress = strings.Contains(resp.Header.Get("Sec-WebSocket-Extensions"), wsPMCExtension)
+	return wc, resp
+}
+
+func testWSCreateClient(t *testing.T, s *Server, hdrs map[string]string) *testWSClient {
+	t.Helper()
+	wc, resp := testWSHandshake(t, s, hdrs)
+	if resp.StatusCode != http.StatusSwitchingProtocols {
+		wc.conn.Close()
+		t.Fatalf("Expected 101, got %v", resp.Status)
+	}
+	if ak := resp.Header.Get("Sec-WebSocket-Accept"); ak != "s3pPLMBiTxaQ9kYGzzhZRbK+xOo=" {
+		wc.conn.Close()
+		t.Fatalf("Unexpected accept key: %q", ak)
+	}
+	return wc
+}
+
+// Sends a masked frame, compressed if requested.
+func (wc *testWSClient) sendFrame(op int, compress bool, payload []byte) {
+	wc.t.Helper()
+	b0 := byte(op) | wsFinalBit
+	if compress {
+		b0 |= wsRsv1Bit
+		var buf bytes.Buffer
+		fw, _ := flate.NewWriter(&buf, flate.BestSpeed)
+		fw.Write(payload)
+		fw.Flush()
+		payload = bytes.TrimSuffix(buf.Bytes(), wsCompressLastBlock[:4])
+	}
+	hdr := []byte{b0, 0}
+	switch l := len(payload); {
+	case l <= 125:
+		hdr[1] = byte(l)
+	case l < 65536:
+		hdr[1] = 126
+		hdr = append(hdr, 0, 0)
+		binary.BigEndian.PutUint16(hdr[2:], uint16(l))
+	default:
+		hdr[1] = 127
+		hdr = append(hdr, 0, 0, 0, 0, 0, 0, 0, 0)
+		binary.BigEndian.PutUint64(hdr[2:], uint64(l))
+	}
+	hdr[1] |= wsMaskBit
+	key := []byte{1, 2, 3, 4}
+	hdr = append(hdr, key...)
+	masked := make([]byte, len(payload))
+	for i := range payload {
+		masked[i] = payload[i] ^ key[i&3]
+	}
+	if _, err := wc.conn.Write(append(hdr, masked...)); err != nil {
+		wc.t.Fatalf("Error sending frame: %v", err)
+	}
+}
+
+func (wc *testWSClient) send(proto string) {
+	wc.t.Helper()
+	wc.sendFrame(wsBinaryFrame, false, []byte(proto))
+}
+
+// Reads a single frame and returns its opcode and uncompressed payload.
+func (wc *testWSClient) readFrame() (int, []byte) {
+	wc.t.Helper()
+	wc.conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+	var hdr [2]byte
+	if _, err := io.ReadFull(wc.br, hdr[:]); err != nil {
+		wc.t.Fatalf("Error reading frame: %v", err)
+	}
+	if hdr[1]&wsMaskBit != 0 {
+		wc.t.Fatalf("Server frames should not be masked")
+	}
+	l := int(hdr[1] & 0x7f)
+	switch l {
+	case 126:
+		var b [2]byte
+		io.ReadFull(wc.br, b[:])
+		l = int(binary.BigEndian.Uint16(b[:]))
+	case 127:
+		var b [8]byte
+		io.ReadFull(wc.br, b[:])
+		l = int(binary.BigEndian.Uint64(b[:]))
+	}
+	payload := make([]byte, l)
+	if _, err := io.ReadFull(wc.br, payload); err != nil {
+		wc.t.Fatalf("Error reading payload: %v", err)
+	}
+	if hdr[0]&wsRsv1Bit != 0 {
+		fr := flate.NewReader(bytes.NewReader(append(payload, wsCompressLastBlock...)))
+		var err error
+		payload, err = ioutil.ReadAll(fr)
+		if err != nil {
+			wc.t.Fatalf("Error decompressing payload: %v", err)
+		}
+	}
+	return int(hdr[0] & 0xf), payload
+}
+
+// Reads frames until the accumulated payload contains expected.
+func (wc *testWSClient) expect(expected string) string {
+	wc.t.Helper()
+	var buf []byte
+	for !bytes.Contains(buf, []byte(expected)) {
+		op, payload := wc.readFrame()
+		if op != wsBinaryFrame {
+			wc.t.Fatalf("Unexpected frame opcode %v", op)
+		}
+		buf = append(buf, payload...)
+	}
+	return string(buf)
+}
+
+func TestWebsocketHandshakeErrors(t *testing.T) {
+	o := testWSOptions()
+	s := RunServer(o)
+	defer s.Shutdown()
+
+	for _, test := range []struct {
+		name   string
+		hdrs   map[string]string
+		status int
+	}{
+		{"no upgrade", map[string]string{"Upgrade": ""}, http.StatusBadRequest},
+		{"bad connection", map[string]string{"Connection": "keep-alive"}, http.StatusBadRequest},
+		{"no key", map[string]string{"Sec-WebSocket-Key": ""}, http.StatusBadRequest},
+		{"bad version", map[string]string{"Sec-WebSocket-Version": "12"}, http.StatusBadRequest},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			wc, resp := testWSHandshake(t, s, test.hdrs)
+			defer wc.conn.Close()
+			if resp.StatusCode != test.status {
+				t.Fatalf("Expected status %v, got %v", test.status, resp.Status)
+			}
+		})
+	}
+	if n := s.NumClients(); n != 0 {
+		t.Fatalf("Expected no client, got %v", n)
+	}
+}
+
+func TestWebsocketPubSub(t *testing.T) {
+	o := testWSOptions()
+	s := RunServer(o)
+	defer s.Shutdown()
+
+	wc := testWSCreateClient(t, s, nil)
+	defer wc.conn.Close()
+
+	info := wc.expect("\r\n")
+	if !strings.HasPrefix(info, "INFO ") {
+		t.Fatalf("Expected INFO, got %q", info)
+	}
+	wc.send("CONNECT {\"verbose\":false}\r\nSUB foo 1\r\nPING\r\n")
+	wc.expect("PONG\r\n")
+
+	// The connection is reported as a websocket one.
+	if cz, _ := s.Connz(nil); len(cz.Conns) != 1 || !cz.Conns[0].Websocket {
+		t.Fatalf("Expected a websocket connection, got %+v", cz.Conns)
+	}
+
+	nc := natsConnect(t, fmt.Sprintf("nats://%s:%d", o.Host, o.Port))
+	defer nc.Close()
+	sub := natsSubSync(t, nc, "bar")
+	natsFlush(t, nc)
+
+	// Message from a regular client to the websocket client.
+	natsPub(t, nc, "foo", []byte("from nats"))
+	if msg := wc.expect("from nats\r\n"); !strings.HasPrefix(msg, "MSG foo 1 9\r\n") {
+		t.Fatalf("Unexpected message: %q", msg)
+	}
+
+	// Message from the websocket client split across frames.
+	wc.send("PUB bar 14\r\nfrom ")
+	wc.send("websocket\r\n")
+	if msg := natsNexMsg(t, sub, time.Second); string(msg.Data) != "from websocket" {
+		t.Fatalf("Unexpected message: %q", msg.Data)
+	}
+
+	// Control frames should be handled by the server.
+	wc.sendFrame(wsPingFrame, false, []byte("ping"))
+	if op, payload := wc.readFrame(); op != wsPongFrame || string(payload) != "ping" {
+		t.Fatalf("Expected pong, got op=%v payload=%q", op, payload)
+	}
+	wc.sendFrame(wsCloseFrame, false, []byte{0x03, 0xe8})
+	if op, _ := wc.readFrame(); op != wsCloseFrame {
+		t.Fatalf("Expected close frame, got op=%v", op)
+	}
+	checkFor(t, time.Second, 15*time.Millisecond, func() error {
+		if n := s.NumClients(); n != 1 {
+			return fmt.Errorf("Expected 1 client, got %v", n)
+		}
+		return nil
+	})
+}
+
+func TestWebsocketUnmaskedFrame(t *testing.T) {
+	o := testWSOptions()
+	s := RunServer(o)
+	defer s.Shutdown()
+
+	wc := testWSCreateClient(t, s, nil)
+	defer wc.conn.Close()
+	wc.expect("\r\n")
+
+	wc.conn.Write([]byte{wsBinaryFrame | wsFinalBit, 6, 'P', 'I', 'N', 'G', '\r', '\n'})
+	if op, _ := wc.readFrame(); op != wsCloseFrame {
+		t.Fatalf("Expected close frame, got op=%v", op)
+	}
+	checkFor(t, time.Second, 15*time.Millisecond, func() error {
+		if n := s.NumClients(); n != 0 {
+			return fmt.Errorf("Expected no client, got %v", n)
+		}
+		return nil
+	})
+}
+
+func TestWebsocketCompression(t *testing.T) {
+	o := testWSOptions()
+	o.Websocket.Compression = true
+	s := RunServer(o)
+	defer s.Shutdown()
+
+	// Compression is only used if the client asks for it.
+	wc := testWSCreateClient(t, s, nil)
+	wc.conn.Close()
+	if wc.compress {
+		t.Fatalf("Compression should not have been negotiated")
+	}
+
+	wc = testWSCreateClient(t, s, map[string]string{"Sec-WebSocket-Extensions": "permessage-deflate; client_max_window_bits"})
+	defer wc.conn.Close()
+	if !wc.compress {
+		t.Fatalf("Compression should have been negotiated")
+	}
+	wc.expect("\r\n")
+
+	payload := strings.Repeat("A", 1024)
+	proto := fmt.Sprintf("CONNECT {\"verbose\":false}\r\nSUB foo 1\r\nPUB foo %d\r\n%s\r\nPING\r\n", len(payload), payload)
+	wc.sendFrame(wsBinaryFrame, true, []byte(proto))
+	msg := wc.expect("PONG\r\n")
+	if !strings.Contains(msg, fmt.Sprintf("MSG foo 1 %d\r\n%s\r\n", len(payload), payload)) {
+		t.Fatalf("Unexpected protocol: %q", msg)
+	}
+}
+
+func TestWebsocketOrigin(t *testing.T) {
+	for _, test := range []struct {
+		name       string
+		sameOrigin bool
+		allowed    []string
+		origin     string
+		ok         bool
+	}{
+		{"no restriction", false, nil, "http://example.com", true},
+		{"no origin header", true, nil, "", true},
+		{"same origin", true, nil, "http://127.0.0.1:%d", true},
+		{"not same origin", true, nil, "http://example.com:%d", false},
+		{"allowed", false, []string{"http://example.com", "https://nats.io:4443"}, "https://nats.io:4443", true},
+		{"allowed default port", false, []string{"http://example.com"}, "http://example.com:80", true},
+		{"allowed bad scheme", false, []string{"http://example.com"}, "https://example.com", false},
+		{"allowed bad port", false, []string{"https://nats.io:4443"}, "https://nats.io", false},
+		{"not allowed", false, []string{"http://example.com"}, "http://other.com", false},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			o := testWSOptions()
+			o.Websocket.SameOrigin = test.sameOrigin
+			o.Websocket.AllowedOrigins = test.allowed
+			s := RunServer(o)
+			defer s.Shutdown()
+
+			origin := test.origin
+			if strings.Contains(origin, "%d") {
+				origin = fmt.Sprintf(origin, s.getOpts().Websocket.Port)
+			}
+			wc, resp := testWSHandshake(t, s, map[string]string{"Origin": origin})
+			defer wc.conn.Close()
+			if test.ok && resp.StatusCode != http.StatusSwitchingProtocols {
+				t.Fatalf("Expected origin %q to be accepted, got %v", origin, resp.Status)
+			} else if !test.ok && resp.StatusCode != http.StatusForbidden {
+				t.Fatalf("Expected origin %q to be rejected, got %v", origin, resp.Status)
+			}
+		})
+	}
+}
+
+func TestWebsocketValidateOptions(t *testing.T) {
+	o := testWSOptions()
+	o.Websocket.AllowedOrigins = []string{"not-a-url"}
+	if _, err := NewServer(o); err == nil || !strings.Contains(err.Error(), "allowed origin") {
+		t.Fatalf("Expected error about allowed origin, got %v", err)
+	}
+}
+
+func TestWebsocketConfig(t *testing.T) {
+	conf := createConfFile(t, []byte(`
+		listen: "127.0.0.1:-1"
+		websocket {
+			listen: "127.0.0.1:-1"
+			compression: true
+			same_origin: true
+			allowed_origins: ["http://example.com", "https://nats.io"]
+			handshake_timeout: "5s"
+		}
+	`))
+	defer os.Remove(conf)
+	opts, err := ProcessConfigFile(conf)
+	if err != nil {
+		t.Fatalf("Error processing config: %v", err)
+	}
+	wo := opts.Websocket
+	if wo.Host != "127.0.0.1" || wo.Port != -1 || !wo.Compression || !wo.SameOrigin ||
+		len(wo.AllowedOrigins) != 2 || wo.HandshakeTimeout != 5*time.Second {
+		t.Fatalf("Unexpected websocket options: %+v", wo)
+	}
+}
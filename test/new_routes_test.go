@@ -18,6 +18,7 @@ import (
 	"fmt"
 	"net"
 	"net/url"
+	"regexp"
 	"runtime"
 	"testing"
 	"time"
@@ -796,6 +797,67 @@ func TestNewRouteProcessRoutedMsgs(t *testing.T) {
 	checkMsg(t, matches[0], "foo", "1", "reply", "2", "ok")
 }
 
+func TestNewRouteProcessRoutedHeaderMsgs(t *testing.T) {
+	s, opts := runNewRouteServer(t)
+	defer s.Shutdown()
+
+	rc := createRouteConn(t, opts.Cluster.Host, opts.Cluster.Port)
+	defer rc.Close()
+
+	routeID := "RTEST_NEW:56"
+	routeSend, routeExpect := setupRouteEx(t, rc, opts, routeID)
+
+	info := checkInfoMsg(t, rc)
+	if !info.Headers {
+		t.Fatalf("Expected route INFO to advertise headers support")
+	}
+	info.ID = routeID
+	b, err := json.Marshal(info)
+	if err != nil {
+		t.Fatalf("Could not marshal test route info: %v", err)
+	}
+	routeSend(fmt.Sprintf("INFO %s\r\nPING\r\n", b))
+	routeExpect(pongRe)
+
+	// Client that supports headers.
+	hc := createClientConn(t, opts.Host, opts.Port)
+	defer hc.Close()
+	checkInfoMsg(t, hc)
+	sendProto(t, hc, "CONNECT {\"verbose\":false,\"headers\":true}\r\n")
+	hsend, hexpect := sendCommand(t, hc), expectCommand(t, hc)
+	hsend("SUB foo 1\r\nPING\r\n")
+	hexpect(pongRe)
+	routeExpect(rsubRe)
+
+	// Client that does not.
+	c := createClientConn(t, opts.Host, opts.Port)
+	defer c.Close()
+	send, expect := setupConn(t, c)
+	send("SUB foo 2\r\nPING\r\n")
+	expect(pongRe)
+
+	routeSend("HMSG $G foo reply 12 14\r\nNATS/1.0\r\n\r\nok\r\nPING\r\n")
+	routeExpect(pongRe)
+
+	hmsg := "HMSG foo 1 reply 12 14\r\nNATS/1.0\r\n\r\nok\r\n"
+	if buf := hexpect(regexp.MustCompile("HMSG")); string(buf) != hmsg {
+		t.Fatalf("Expected %q, got %q", hmsg, buf)
+	}
+	matches := expectMsgsCommand(t, expect)(1)
+	checkMsg(t, matches[0], "foo", "2", "reply", "2", "ok")
+
+	// Now have the route express interest and make sure headers
+	// published by the client are sent over the route.
+	routeSend("RS+ $G bar\r\nPING\r\n")
+	routeExpect(pongRe)
+	hsend("HPUB bar 12 14\r\nNATS/1.0\r\n\r\nok\r\nPING\r\n")
+	hexpect(pongRe)
+	rhmsg := "HMSG $G bar 12 14\r\nNATS/1.0\r\n\r\nok\r\n"
+	if buf := routeExpect(regexp.MustCompile("HMSG")); string(buf) != rhmsg {
+		t.Fatalf("Expected %q, got %q", rhmsg, buf)
+	}
+}
+
 func TestNewRouteQueueSubsDistribution(t *testing.T) {
 	srvA, srvB, optsA, optsB := runServers(t)
 	defer srvA.Shutdown()
@@ -373,6 +373,49 @@ func TestRouteQueueSemantics(t *testing.T) {
 	checkMsg(t, matches[0], "foo", "", "", "2", "ok")
 }
 
+func TestRouteQueueSubWeights(t *testing.T) {
+	s, opts := runRouteServer(t)
+	defer s.Shutdown()
+
+	client := createClientConn(t, opts.Host, opts.Port)
+	defer client.Close()
+	checkInfoMsg(t, client)
+	cs := fmt.Sprintf("CONNECT {\"verbose\":false,\"pedantic\":false,\"protocol\":%d,\"queue_weights\":true}\r\n", server.ClientProtoInfo)
+	sendProto(t, client, cs)
+	clientSend, clientExpect := sendCommand(t, client), expectCommand(t, client)
+
+	route := createRouteConn(t, opts.Cluster.Host, opts.Cluster.Port)
+	defer route.Close()
+
+	expectAuthRequired(t, route)
+	routeSend, routeExpect := setupRouteEx(t, route, opts, "ROUTER:xyz")
+	routeSend("INFO {\"server_id\":\"ROUTER:xyz\"}\r\n")
+	routeSend("PING\r\n")
+	routeExpect(pongRe)
+
+	// The weights of the queue subscribers are sent to the route.
+	checkWeight := func(proto, expected string) {
+		t.Helper()
+		clientSend(proto + "PING\r\n")
+		clientExpect(pongRe)
+		matches := rsubRe.FindAllSubmatch(routeExpect(rsubRe), -1)
+		if w := string(matches[len(matches)-1][4]); w != expected {
+			t.Fatalf("Expected queue weight %q, got %q", expected, w)
+		}
+	}
+	checkWeight("SUB foo bar 1 5\r\n", "5")
+	checkWeight("SUB foo bar 2 2\r\n", "7")
+	checkWeight("SUB foo bar 3\r\n", "8")
+	checkWeight("UNSUB 1\r\n", "3")
+
+	// All weights are removed when the client goes away.
+	client.Close()
+	routeExpect(runsubRe)
+	if n := s.NumSubscriptions(); n != 0 {
+		t.Fatalf("Expected no subscriptions, got %d", n)
+	}
+}
+
 func TestSolicitRouteReconnect(t *testing.T) {
 	s, opts := runRouteServer(t)
 	defer s.Shutdown()
@@ -14,8 +14,12 @@
 package test
 
 import (
+	"fmt"
+	"os"
+	"reflect"
 	"regexp"
 	"testing"
+	"time"
 )
 
 const DefaultPass = "foo"
@@ -221,3 +225,150 @@ func TestUserAuthorizationProto(t *testing.T) {
 	sendProto(t, c, "SUB SYS.bar 5\r\n")
 	expectResult(t, c, errRe)
 }
+
+func TestUserAuthorizationAllowResponses(t *testing.T) {
+	conf := createConfFile(t, []byte(`
+		listen: 127.0.0.1:-1
+		authorization {
+			users [
+				{user: requestor, password: pass}
+				{user: service, password: pass, permissions: {
+					subscribe: "svc.>"
+					allow_responses: true
+				}}
+				{user: multi, password: pass, permissions: {
+					subscribe: "svc.>"
+					allow_responses: {max: 2, expires: "50ms"}
+				}}
+			]
+		}
+	`))
+	defer os.Remove(conf)
+	srv, opts := RunServerWithConfig(conf)
+	defer srv.Shutdown()
+
+	rc := createClientConn(t, opts.Host, opts.Port)
+	defer rc.Close()
+	rsend, rexpect := setupConnWithUserPass(t, rc, "requestor", "pass")
+	rsend("SUB resp.> 1\r\nPING\r\n")
+	rexpect(pongRe)
+
+	for _, user := range []string{"service", "multi"} {
+		c := createClientConn(t, opts.Host, opts.Port)
+		defer c.Close()
+		send, expect := setupConnWithUserPass(t, c, user, "pass")
+		send("SUB svc.> 1\r\nPING\r\n")
+		expect(pongRe)
+
+		// Publishing is denied except for the reply subjects received.
+		send("PUB foo 2\r\nok\r\n")
+		expect(permErrRe)
+		send("PUB resp.1 2\r\nok\r\n")
+		expect(permErrRe)
+
+		rsend(fmt.Sprintf("PUB svc.%s resp.1 2\r\nok\r\n", user))
+		expect(msgRe)
+		send("PUB resp.1 2\r\nok\r\nPING\r\n")
+		expect(pongRe)
+		rexpect(msgRe)
+
+		if user == "service" {
+			// Only one response is allowed by default.
+			send("PUB resp.1 2\r\nok\r\n")
+			expect(permErrRe)
+			continue
+		}
+		send("PUB resp.1 2\r\nok\r\nPING\r\n")
+		expect(pongRe)
+		rexpect(msgRe)
+		send("PUB resp.1 2\r\nok\r\n")
+		expect(permErrRe)
+
+		// Responses are not allowed once expired.
+		rsend("PUB svc.multi resp.2 2\r\nok\r\n")
+		expect(msgRe)
+		time.Sleep(100 * time.Millisecond)
+		send("PUB resp.2 2\r\nok\r\n")
+		expect(permErrRe)
+	}
+}
+
+func TestUserAuthorizationQueueGroups(t *testing.T) {
+	conf := createConfFile(t, []byte(`
+		listen: 127.0.0.1:-1
+		authorization {
+			users [
+				{user: worker, password: pass, permissions: {
+					subscribe: {
+						allow: ["orders.* workers", "orders.* audit.*", "_INBOX.>"]
+						deny: "orders.secret audit.*"
+					}
+				}}
+			]
+		}
+	`))
+	defer os.Remove(conf)
+	srv, opts := RunServerWithConfig(conf)
+	defer srv.Shutdown()
+
+	c := createClientConn(t, opts.Host, opts.Port)
+	defer c.Close()
+	send, expect := setupConnWithUserPass(t, c, "worker", "pass")
+	send("PING\r\n")
+	expect(pongRe)
+
+	// Only the allowed queue groups can be used for orders.
+	send("SUB orders.new 1\r\n")
+	expect(permErrRe)
+	send("SUB orders.new thieves 2\r\n")
+	expect(permErrRe)
+	send("SUB orders.secret audit.1 3\r\n")
+	expect(permErrRe)
+
+	send("SUB orders.new workers 4\r\nSUB orders.new audit.1 5\r\nSUB orders.secret workers 6\r\n")
+	send("SUB _INBOX.foo 7\r\nSUB _INBOX.bar any 8\r\nPING\r\n")
+	expect(pongRe)
+}
+
+func TestUserAuthorizationQueueGroupDenyWildcard(t *testing.T) {
+	conf := createConfFile(t, []byte(`
+		listen: 127.0.0.1:-1
+		authorization {
+			users [
+				{user: worker, password: pass, permissions: {
+					subscribe: { deny: "orders.x workers" }
+				}}
+				{user: pub, password: pass}
+			]
+		}
+	`))
+	defer os.Remove(conf)
+	srv, opts := RunServerWithConfig(conf)
+	defer srv.Shutdown()
+
+	c := createClientConn(t, opts.Host, opts.Port)
+	defer c.Close()
+	send, expect := setupConnWithUserPass(t, c, "worker", "pass")
+	// The wildcard queue subscription is allowed, but messages on the
+	// denied subject are not delivered to it.
+	send("SUB orders.* workers 1\r\nSUB orders.* others 2\r\nSUB orders.* 3\r\nPING\r\n")
+	expect(pongRe)
+
+	pc := createClientConn(t, opts.Host, opts.Port)
+	defer pc.Close()
+	pubSend, pubExpect := setupConnWithUserPass(t, pc, "pub", "pass")
+	pubSend("PUB orders.x 2\r\nok\r\nPUB orders.y 2\r\nok\r\nPING\r\n")
+	pubExpect(pongRe)
+
+	matches := expectMsgsCommand(t, expect)(5)
+	send("PING\r\n")
+	expect(pongRe)
+	received := make(map[string]int)
+	for _, m := range matches {
+		received[string(m[subIndex])+" "+string(m[sidIndex])]++
+	}
+	expected := map[string]int{"orders.x 2": 1, "orders.x 3": 1, "orders.y 1": 1, "orders.y 2": 1, "orders.y 3": 1}
+	if !reflect.DeepEqual(received, expected) {
+		t.Fatalf("Expected messages %v, got %v", expected, received)
+	}
+}